@@ -1,16 +1,31 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"liuproxy_go/internal/core/accesslog"
+	"liuproxy_go/internal/core/conntrack"
 	"liuproxy_go/internal/core/dispatcher"
+	"liuproxy_go/internal/core/dns"
+	"liuproxy_go/internal/core/fakeip"
 	"liuproxy_go/internal/core/gateway"
+	"liuproxy_go/internal/core/guard"
 	"liuproxy_go/internal/core/health"
+	"liuproxy_go/internal/core/limiter"
+	"liuproxy_go/internal/core/monitor"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/core/shaper"
+	"liuproxy_go/internal/service/exporter"
 	"liuproxy_go/internal/service/web"
 	"liuproxy_go/internal/shared/config"
 	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
 	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/traffic"
 	"liuproxy_go/internal/tunnel"
+	"liuproxy_go/internal/xray_core/transport/internet"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -51,6 +66,17 @@ type AppServer struct {
 
 	dispatcher        types.Dispatcher
 	gateway           *gateway.Gateway
+	transparent       *gateway.TransparentGateway
+	dnsServer         *dns.Server
+	fakeIP            *fakeip.Pool
+	resolver          *resolver.Resolver
+	limiter           *limiter.Limiter
+	guard             *guard.Guard
+	shaper            *shaper.Shaper
+	meter             *traffic.Meter
+	conntrack         *conntrack.Tracker
+	monitor           *monitor.Hub
+	accessLog         *accesslog.Logger
 	healthChecker     *health.Checker
 	healthCheckTicker *time.Ticker // NEW
 
@@ -85,14 +111,75 @@ func New(cfg *types.Config, iniPath, serversPath string, _ []*types.ServerProfil
 
 	// 创建 Dispatcher，并注入初始配置
 	initialSettings := sm.Get()
-	disp := dispatcher.New(initialSettings.Gateway, s, s) // Pass AppServer as both StateProvider and FailureReporter
+	// 按 "logging" 模块设置日志级别、格式和输出，之后的修改立即生效
+	logModule := logger.NewModule(cfg.LogConf)
+	if err := logModule.Apply(initialSettings.Logging); err != nil {
+		logger.Error().Err(err).Msg("Failed to apply logging settings, keeping the defaults.")
+	}
+	sm.Register("logging", logModule)
+	// 共享解析器供路由、直连和 VLESS 服务器地址使用，via 后端通过 AppServer 查找
+	s.resolver = resolver.New(initialSettings.Resolver, s)
+	sm.Register("resolver", s.resolver)
+	// 隧道服务器自身的地址总是直连解析，避免经隧道解析隧道地址
+	internet.RegisterDomainResolver(func(ctx context.Context, domain string) ([]net.IP, error) {
+		addrs, err := s.resolver.LookupIPDirect(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.AsSlice())
+		}
+		return ips, nil
+	})
+	// 连接上限由两个网关和 Dispatcher 共享，全局上限默认取 liuproxy.ini 的 maxConnections
+	s.limiter = limiter.New(initialSettings.Limits, cfg.CommonConf.MaxConnections)
+	sm.Register("limits", s.limiter)
+	disp := dispatcher.New(initialSettings.Gateway, s, s, s.resolver, s.limiter) // Pass AppServer as both StateProvider and FailureReporter
 
 	// 将 Dispatcher 注册为相关模块的订阅者
 	sm.Register("gateway", disp)
 	sm.Register("routing", disp)
 
 	s.dispatcher = disp
-	s.gateway = gateway.New(cfg.LocalConf.UnifiedPort, disp, s)
+	// Fake-IP 映射表由 DNS 服务器写入，由各个网关读取，保存在 settings.json 旁边以便重启后还原
+	s.fakeIP = fakeip.New(initialSettings.DNS, filepath.Join(configDir, "fakeip.json"))
+	sm.Register("dns", s.fakeIP)
+	// 握手保护只作用于统一网关的公网入口，透明代理没有握手阶段
+	s.guard = guard.New(initialSettings.Security)
+	sm.Register("security", s.guard)
+	// 带宽限速由两个网关共享，同一客户端、用户或服务器的令牌桶跨网关合并计算
+	s.shaper = shaper.New(initialSettings.Bandwidth, s)
+	sm.Register("bandwidth", s.shaper)
+	// 连接跟踪同样由两个网关共享，替换服务器实例时据此排空经旧实例建立的连接
+	// 连接事件、健康变化和吞吐量采样经 monitor 推送给 Web UI 的 Monitor 页面
+	s.meter = traffic.New()
+	s.monitor = monitor.New(s.meter)
+	// 每个结束的连接由 conntrack 写入访问日志
+	s.accessLog = accesslog.New(initialSettings.AccessLog)
+	sm.Register("access_log", s.accessLog)
+	s.conntrack = conntrack.New(initialSettings.Timeouts, s.monitor, s.accessLog)
+	sm.Register("timeouts", s.conntrack)
+	gatewayDeps := gateway.Deps{
+		FakeIP:   s.fakeIP,
+		Resolver: s.resolver,
+		Limiter:  s.limiter,
+		Guard:    s.guard,
+		Shaper:   s.shaper,
+		Meter:    s.meter,
+		Tracker:  s.conntrack,
+	}
+	s.gateway = gateway.New(cfg.LocalConf.UnifiedPort, initialSettings, disp, s, gatewayDeps)
+	sm.Register("gateway", s.gateway)
+	sm.Register("auth", s.gateway)
+	sm.Register("inbounds", s.gateway)
+	if cfg.TransparentConf.TransparentPort > 0 {
+		s.transparent = gateway.NewTransparent(cfg.TransparentConf, disp, s, gatewayDeps)
+	}
+	s.dnsServer = dns.New(initialSettings.DNS, disp, s, s.fakeIP)
+	sm.Register("dns", s.dnsServer)
+	// 服务器健康、连接数和流量在抓取 /metrics 时从当前状态读取
+	metrics.Default.Register(s.collectMetrics)
 
 	// 注意：完整的启动逻辑（加载配置、管理实例、首次重载）将在后续步骤中添加到此处
 	// 按照V9方案，此处暂时不执行 s.ReloadStrategy()
@@ -113,28 +200,58 @@ func (s *AppServer) Run() {
 	s.waitGroup.Add(1)
 	go s.healthCheckLoop()
 
-	if s.cfg.LocalConf.UnifiedPort > 0 {
-		s.waitGroup.Add(1)
-		go func() {
-			defer s.waitGroup.Done()
-			if err := s.gateway.Start(); err != nil {
-				logger.Fatal().Err(err).Msg("Gateway failed to start")
-			}
-		}()
-	} else {
-		logger.Warn().Msg("Gateway is disabled.")
+	// 未配置 inbounds 且 unified_port 为 0 时，网关自行记录 "Gateway is disabled."
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+		if err := s.gateway.Start(); err != nil {
+			logger.Fatal().Err(err).Msg("Gateway failed to start")
+		}
+	}()
+
+	if s.transparent != nil {
+		if err := s.transparent.Start(); err != nil {
+			logger.Fatal().Err(err).Msg("Transparent gateway failed to start")
+		}
+	}
+	s.fakeIP.Start()
+	s.monitor.Start()
+	// DNS 是辅助功能，监听失败 (如 53 端口被占用) 不影响代理本身
+	if err := s.dnsServer.Start(); err != nil {
+		logger.Error().Err(err).Msg("DNS server failed to start")
 	}
 	web.StartServer(&s.waitGroup, s.cfg, s.serversPath, s.settingsManager, s)
+	exporter.StartServer(&s.waitGroup, s.cfg.MetricsConf)
 	s.Wait()
 }
 
 // Stop gracefully shuts down the server.
+// Both gateways stop accepting and drain their connections in parallel, waiting at most the
+// configured drain timeout, before the strategy instances those connections use are closed.
 func (s *AppServer) Stop() {
 	s.stopOnce.Do(func() {
 		logger.Info().Msg("Stopping server...")
 		if s.healthCheckTicker != nil {
 			s.healthCheckTicker.Stop()
 		}
+
+		var drainGroup sync.WaitGroup
+		if s.gateway != nil {
+			drainGroup.Add(1)
+			go func() {
+				defer drainGroup.Done()
+				s.gateway.Close()
+			}()
+		}
+		if s.transparent != nil {
+			drainGroup.Add(1)
+			go func() {
+				defer drainGroup.Done()
+				s.transparent.Close()
+			}()
+		}
+		drainGroup.Wait()
+
 		s.configLock.Lock()
 		defer s.configLock.Unlock()
 
@@ -148,9 +265,14 @@ func (s *AppServer) Stop() {
 			}
 		}
 
-		if s.gateway != nil {
-			s.gateway.Close()
+		if s.dnsServer != nil {
+			s.dnsServer.Close()
 		}
+		if s.fakeIP != nil {
+			s.fakeIP.Close()
+		}
+		s.monitor.Close()
+		s.accessLog.Close()
 		logger.Info().Msg("All strategies stopped.")
 	})
 }
@@ -403,12 +525,14 @@ func (s *AppServer) UpdateServerProfile(id string, updatedProfile *types.ServerP
 	state.Profile = updatedProfile
 
 	// If the instance is active, it needs to be updated or restarted.
-	// The simplest robust way is to shut down the old one. manageInstances will recreate it.
+	// manageInstances creates a replacement right away so new connections use the new profile,
+	// while the old instance is drained in the background and closed once its connections end.
 	if state.Profile.Active && state.Instance != nil {
-		logger.Info().Str("id", id).Msg("Closing active instance for profile update.")
-		state.Instance.CloseTunnel()
+		logger.Info().Str("id", id).Msg("Replacing active instance for profile update.")
+		oldInstance := state.Instance
 		state.Instance = nil
 		s.manageInstances() // Re-create with new profile data
+		go s.drainInstance(id, oldInstance)
 	}
 
 	go func() {
@@ -419,6 +543,16 @@ func (s *AppServer) UpdateServerProfile(id string, updatedProfile *types.ServerP
 	return nil
 }
 
+// drainInstance waits for the connections tunnelled through a replaced instance to finish,
+// force-closing them after the drain timeout (a zero timeout waits indefinitely), and then closes the instance.
+func (s *AppServer) drainInstance(id string, instance types.TunnelStrategy) {
+	if forced := s.conntrack.DrainBackend(instance, s.conntrack.DrainTimeout()); forced > 0 {
+		logger.Warn().Str("id", id).Int("connections", forced).Msg("Drain timed out, closed remaining connections of the replaced instance.")
+	}
+	logger.Info().Str("id", id).Msg("Closing replaced instance.")
+	instance.CloseTunnel()
+}
+
 // DeleteServerProfile removes a server from the configState (A-Zone).
 func (s *AppServer) DeleteServerProfile(id string) error {
 	s.configLock.Lock()
@@ -518,14 +652,21 @@ func (s *AppServer) triggerSingleHealthCheck(serverID string) {
 	}
 
 	oldHealth := state.Health
+	start := time.Now()
 	err := state.Instance.CheckHealth()
 	newHealth := types.StatusUp
+	result := "success"
 	if err != nil {
 		newHealth = types.StatusDown
+		result = "failure"
 	}
+	metrics.HealthCheckSeconds.Observe(time.Since(start).Seconds(), serverID, result)
 
 	state.Health = newHealth
 	s.configLock.Unlock()
+	if newHealth != oldHealth {
+		s.monitor.Publish(&types.MonitorEvent{Type: monitor.EventHealth, ServerID: serverID, Health: newHealth})
+	}
 
 	// If health status has changed, trigger a reload to publish the change.
 	if newHealth != oldHealth {
@@ -559,6 +700,121 @@ func (s *AppServer) GetRecentClientIPs() []string {
 	return []string{}
 }
 
+// GetConnectionStats implements the ServerController interface.
+func (s *AppServer) GetConnectionStats() *types.ConnectionStats {
+	return s.limiter.Stats()
+}
+
+// GetTrafficStats implements the ServerController interface.
+func (s *AppServer) GetTrafficStats() *types.TrafficReport {
+	return s.meter.Stats()
+}
+
+// GetBans implements the ServerController interface.
+func (s *AppServer) GetBans() []*types.BanInfo {
+	return s.guard.Bans()
+}
+
+// Unban implements the ServerController interface.
+func (s *AppServer) Unban(ip string) bool {
+	return s.guard.Unban(ip)
+}
+
+// GetConnections implements the ServerController interface.
+func (s *AppServer) GetConnections() []*types.ConnectionInfo {
+	return s.conntrack.Connections()
+}
+
+// CloseConnection implements the ServerController interface.
+func (s *AppServer) CloseConnection(id string) bool {
+	if !s.conntrack.CloseConnection(id) {
+		return false
+	}
+	logger.Info().Str("trace_id", id).Msg("Connection closed on request.")
+	return true
+}
+
+// CloseClientConnections implements the ServerController interface.
+func (s *AppServer) CloseClientConnections(ip string) int {
+	closed := s.conntrack.CloseClient(ip)
+	logger.Info().Str("client_ip", ip).Int("connections", closed).Msg("Client connections closed on request.")
+	return closed
+}
+
+// CloseServerConnections implements the ServerController interface.
+func (s *AppServer) CloseServerConnections(serverID string) int {
+	closed := s.conntrack.CloseServer(serverID)
+	logger.Info().Str("server_id", serverID).Int("connections", closed).Msg("Server connections closed on request.")
+	return closed
+}
+
+// SubscribeMonitor implements the ServerController interface.
+func (s *AppServer) SubscribeMonitor(filter monitor.Filter) (<-chan *types.MonitorEvent, func()) {
+	return s.monitor.Subscribe(filter)
+}
+
+// collectMetrics 输出每个服务器的健康、延迟、网关连接数和流量，以及网关的总连接数。
+func (s *AppServer) collectMetrics(w *metrics.Writer) {
+	states := s.GetServerStates()
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	remarks := func(id string) string {
+		if state, ok := states[id]; ok {
+			return state.Profile.Remarks
+		}
+		return id // DIRECT
+	}
+	labels := func(id string) []metrics.Label {
+		return []metrics.Label{{Name: "server_id", Value: id}, {Name: "remarks", Value: remarks(id)}}
+	}
+
+	w.Header("liuproxy_server_up", "Whether an active server passed its last health check (1) or not (0).", "gauge")
+	for _, id := range ids {
+		if state := states[id]; state.Profile.Active {
+			up := 0.0
+			if state.Health == types.StatusUp {
+				up = 1
+			}
+			w.Sample("liuproxy_server_up", labels(id), up)
+		}
+	}
+	w.Header("liuproxy_server_latency_seconds", "Latency measured by the last successful health check.", "gauge")
+	for _, id := range ids {
+		if state := states[id]; state.Profile.Active && state.Metrics != nil && state.Metrics.Latency >= 0 {
+			w.Sample("liuproxy_server_latency_seconds", labels(id), float64(state.Metrics.Latency)/1000)
+		}
+	}
+
+	connStats := s.limiter.Stats()
+	w.Header("liuproxy_server_active_connections", "Connections currently open through a server by the gateways.", "gauge")
+	for _, id := range ids {
+		if states[id].Profile.Active {
+			w.Sample("liuproxy_server_active_connections", labels(id), float64(connStats.Backends[id]))
+		}
+	}
+
+	traffic := s.meter.Stats()
+	backendIDs := make([]string, 0, len(traffic.Backends))
+	for id := range traffic.Backends {
+		backendIDs = append(backendIDs, id)
+	}
+	sort.Strings(backendIDs)
+	w.Header("liuproxy_server_bytes_total", "Bytes relayed through a server, \"DIRECT\" for direct connections.", "counter")
+	for _, id := range backendIDs {
+		stats := traffic.Backends[id]
+		w.Sample("liuproxy_server_bytes_total", append(labels(id), metrics.Label{Name: "direction", Value: "up"}), float64(stats.Upload))
+		w.Sample("liuproxy_server_bytes_total", append(labels(id), metrics.Label{Name: "direction", Value: "down"}), float64(stats.Download))
+	}
+
+	w.Header("liuproxy_gateway_active_connections", "Inbound connections currently handled by the gateways.", "gauge")
+	w.Sample("liuproxy_gateway_active_connections", nil, float64(s.conntrack.Active()))
+	w.Header("liuproxy_gateway_rejected_connections_total", "Connections rejected by connection limits.", "counter")
+	w.Sample("liuproxy_gateway_rejected_connections_total", nil, float64(connStats.Rejected))
+}
+
 func (s *AppServer) Wait() {
 	s.waitGroup.Wait()
 }
@@ -616,6 +872,7 @@ func (s *AppServer) runHealthChecks() {
 			if state.Health != newHealth {
 				state.Health = newHealth
 				stateChanged = true
+				s.monitor.Publish(&types.MonitorEvent{Type: monitor.EventHealth, ServerID: id, Health: newHealth})
 				logger.Info().Str("server", state.Profile.Remarks).Interface("new_status", newHealth).Msg("Health status changed.")
 			}
 			// Always update metrics
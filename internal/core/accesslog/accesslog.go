@@ -0,0 +1,147 @@
+// Package accesslog 为网关转发的每个结束的连接、HTTP keep-alive 连接上的每个请求和每个 UDP 流写一条结构化的访问记录。
+// 访问日志写入独立的轮转文件，每行一个 JSON 对象，不受调试日志级别的影响。
+package accesslog
+
+import (
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Logger 写访问日志，实现了 settings.ConfigurableModule 接口。所有方法都可以在 nil 上调用。
+type Logger struct {
+	mu   sync.RWMutex
+	cfg  settings.AccessLogSettings
+	file *logger.RotatingFile // 未启用时为 nil
+	out  zerolog.Logger
+}
+
+// New 创建访问日志，cfg 为 "access_log" 模块的初始配置。文件无法打开时记录错误并保持禁用。
+func New(cfg *settings.AccessLogSettings) *Logger {
+	l := &Logger{}
+	if err := l.apply(cfg); err != nil {
+		logger.Error().Err(err).Msg("AccessLog: Failed to open access log, it stays disabled.")
+	}
+	return l
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。
+func (l *Logger) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "access_log" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.AccessLogSettings)
+	if !ok {
+		return fmt.Errorf("accesslog: received incorrect settings type for access_log module")
+	}
+	if err := l.apply(cfg); err != nil {
+		return err
+	}
+	logger.Info().
+		Bool("enabled", cfg.Enabled).
+		Str("file", cfg.File).
+		Float64("sample_rate", cfg.SampleRate).
+		Bool("omit_target", cfg.OmitTarget).
+		Msg("AccessLog: Settings have been reloaded.")
+	return nil
+}
+
+// apply 按 cfg 打开、重新打开或关闭访问日志文件。路径和轮转参数不变时继续使用当前文件。
+func (l *Logger) apply(cfg *settings.AccessLogSettings) error {
+	if cfg == nil {
+		cfg = &settings.AccessLogSettings{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.file
+	if !cfg.Enabled || cfg.File == "" {
+		if cfg.Enabled {
+			logger.Warn().Msg("AccessLog: Enabled without a file, access log stays disabled.")
+		}
+		l.cfg, l.file = *cfg, nil
+	} else if old == nil || cfg.File != l.cfg.File || cfg.MaxSize != l.cfg.MaxSize ||
+		cfg.MaxAge != l.cfg.MaxAge || cfg.MaxBackups != l.cfg.MaxBackups {
+		file, err := logger.OpenRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("accesslog: %w", err)
+		}
+		l.cfg, l.file = *cfg, file
+		l.out = zerolog.New(file)
+	} else {
+		l.cfg = *cfg
+	}
+	if old != nil && old != l.file {
+		old.Close()
+	}
+	return nil
+}
+
+// Log 为结束的连接 info 写一条记录，按 sample_rate 抽样。
+func (l *Logger) Log(info *types.ConnectionInfo) {
+	if l == nil {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.file == nil {
+		return
+	}
+	if rate := l.cfg.SampleRate; rate > 0 && rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	// 不带级别的事件不受全局日志级别的影响
+	ev := l.out.Log().
+		Str("time", time.Now().UTC().Format(time.RFC3339Nano)).
+		Str("id", info.ID).
+		Str("client", info.Client).
+		Str("user", info.User).
+		Str("inbound", info.Inbound).
+		Str("protocol", info.Protocol)
+	if !l.cfg.OmitTarget {
+		ev = ev.Str("target", info.Target).Str("sniffed_host", info.SniffedHost)
+	}
+	ev = ev.Str("rule", info.Rule)
+	if priority, ok := rulePriority(info.Rule); ok {
+		ev = ev.Int("priority", priority)
+	}
+	ev.Str("server_id", info.ServerID).
+		Uint64("bytes_up", info.Upload).
+		Uint64("bytes_down", info.Download).
+		Int64("duration_ms", int64(info.Age*1000)).
+		Str("close_reason", info.CloseReason).
+		Send()
+}
+
+// Close 关闭访问日志文件。
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// rulePriority 从 "优先级:序号:类型:目标" 格式的规则中取出优先级，
+// 规则为 "inbound"、"sticky" 或 "balancer" 等非规则决策时返回 false。
+func rulePriority(rule string) (int, bool) {
+	prefix, _, found := strings.Cut(rule, ":")
+	if !found {
+		return 0, false
+	}
+	priority, err := strconv.Atoi(prefix)
+	return priority, err == nil
+}
@@ -0,0 +1,67 @@
+package conntrack
+
+import (
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"sync"
+)
+
+// trackedConn 是被跟踪的入站连接，每次成功的读写都刷新条目的活动时间。
+type trackedConn struct {
+	net.Conn
+	entry *Entry
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.entry.touch()
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.entry.touch()
+	}
+	return n, err
+}
+
+// CloseWrite 转发给底层连接，relay 依赖它通知客户端数据已发送完毕。
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// SetLinger 转发给底层连接，"reject-tcp-reset" 依赖它以 RST 关闭连接。
+func (c *trackedConn) SetLinger(sec int) error {
+	if lc, ok := c.Conn.(interface{ SetLinger(sec int) error }); ok {
+		return lc.SetLinger(sec)
+	}
+	return nil
+}
+
+// upstreamConn 是关联到条目的上游连接，关闭时解除关联。
+type upstreamConn struct {
+	net.Conn
+	entry     *Entry
+	backend   types.TunnelStrategy
+	closeOnce sync.Once
+}
+
+func (c *upstreamConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.entry.detach(c) })
+	return err
+}
+
+// CloseWrite 转发给底层连接，relay 依赖它通知上游数据已发送完毕。
+func (c *upstreamConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+package conntrack
+
+import (
+	"context"
+	"liuproxy_go/internal/core/monitor"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State 是被跟踪连接所处的阶段，决定适用哪个空闲超时。
+type State int32
+
+const (
+	StateHandshake   State = iota // 代理握手、路由和建立上游连接
+	StateEstablished              // 双向转发数据
+	StateHalfClosed               // 一个方向已结束，等待另一方向结束
+)
+
+func (s State) String() string {
+	switch s {
+	case StateEstablished:
+		return "established"
+	case StateHalfClosed:
+		return "half_closed"
+	default:
+		return "handshake"
+	}
+}
+
+// 连接关闭的原因，见于访问日志和监控的 close 事件。
+const (
+	CloseCompleted   = "completed"    // 开始转发数据之后由任一方结束
+	CloseHandshake   = "handshake"    // 在握手阶段结束，例如客户端断开或握手失败
+	CloseRejected    = "rejected"     // 被路由到 REJECT
+	CloseNoRoute     = "no_route"     // 没有可用的路由
+	CloseDialError   = "dial_error"   // 建立上游连接失败
+	CloseIdleTimeout = "idle_timeout" // 超过当前阶段的空闲超时
+	CloseMaxLifetime = "max_lifetime" // 超过最长存活时间
+	CloseKilled      = "killed"       // 经连接表手动关闭
+	CloseDrained     = "drained"      // 服务器实例被替换后排空超时
+	CloseShutdown    = "shutdown"     // 停止服务
+)
+
+type entryKey struct{}
+
+func withEntry(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, e)
+}
+
+// FromContext 返回 ctx 所属连接的条目，ctx 不是由 Tracker.Open 派生时返回 nil。
+// Entry 的方法都可以在 nil 上调用。
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(entryKey{}).(*Entry)
+	return e
+}
+
+// Entry 是一个被跟踪的入站连接。入站连接上的任何读写都算作活动，
+// 关闭条目会关闭入站连接和所有关联的上游连接。
+type Entry struct {
+	tracker *Tracker
+	id      string // 连接的 trace_id
+	start   time.Time
+	cfg     *config // 建立连接时的超时配置
+	conn    *trackedConn
+	cancel  context.CancelFunc
+
+	state        atomic.Int32
+	stateChanged chan struct{}
+	lastActive   atomic.Int64 // UnixNano
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	closed    bool
+	// closeReason 是关闭的原因，关闭时仍为空则按连接的状态推断
+	closeReason string
+	upstreams   map[*upstreamConn]struct{}
+	// 以下字段是连接在连接表中的描述，由网关在处理连接的过程中逐步填写
+	inbound, protocol, target string
+	user, sniffedHost, rule   string
+	serverID, upstream        string
+	session                   *traffic.Session
+	// opened 表示已发布过 open 事件，只有这样的连接才发布 route 和 close 事件
+	opened bool
+	// recordedAt 及其后的流量是上一条访问记录结束的位置，HTTP keep-alive 连接上的请求逐个记录
+	recordedAt               time.Time
+	recordedUp, recordedDown uint64
+	// routeRecorded 表示最近一次路由决策已写入访问记录，关闭时不再重复记录
+	routeRecorded bool
+}
+
+// ID 返回连接的 trace_id。
+func (e *Entry) ID() string {
+	if e == nil {
+		return ""
+	}
+	return e.id
+}
+
+// Conn 返回记录活动的入站连接。
+func (e *Entry) Conn() net.Conn {
+	return e.conn
+}
+
+// State 返回连接当前所处的阶段。
+func (e *Entry) State() State {
+	if e == nil {
+		return StateHandshake
+	}
+	return State(e.state.Load())
+}
+
+// SetState 使连接进入新的阶段，空闲计时按新阶段的超时重新判断。阶段只能前进。
+func (e *Entry) SetState(state State) {
+	if e == nil {
+		return
+	}
+	for {
+		current := e.state.Load()
+		if int32(state) <= current {
+			return
+		}
+		if e.state.CompareAndSwap(current, int32(state)) {
+			break
+		}
+	}
+	select {
+	case e.stateChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Touch 记录一次不经过入站连接的活动，例如 SOCKS5 UDP 关联中转发的数据报。
+func (e *Entry) Touch() {
+	if e != nil {
+		e.touch()
+	}
+}
+
+func (e *Entry) touch() {
+	e.lastActive.Store(time.Now().UnixNano())
+}
+
+// SetInbound 记录接收连接的入口名称，以及统计连接流量的 session。
+func (e *Entry) SetInbound(inbound string, session *traffic.Session) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.inbound, e.session = inbound, session
+	e.mu.Unlock()
+}
+
+// SetTarget 记录嗅探出的入站协议和目标，并发布 open 事件。目标确定之前失败的连接不出现在监控中。
+func (e *Entry) SetTarget(protocol, target string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.protocol, e.target = protocol, target
+	e.opened = true
+	e.mu.Unlock()
+	e.publish(monitor.EventOpen)
+}
+
+// SetRoute 记录路由决策: Dispatcher 填写过的 rc 和选中的服务器 ID ("DIRECT" / "REJECT" 表示虚拟策略)。
+// HTTP keep-alive 连接上的请求逐个路由，连接表显示最近一次的决策。
+func (e *Entry) SetRoute(rc *types.RoutingContext, target, serverID string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if rc != nil {
+		e.user, e.sniffedHost, e.rule = rc.User, rc.SniffedHost, rc.Rule
+	}
+	e.target, e.serverID = target, serverID
+	e.routeRecorded = false
+	e.mu.Unlock()
+	e.publish(monitor.EventRoute)
+}
+
+// LogRequest 为 HTTP keep-alive 连接上已完成的请求写一条访问记录，内容为最近一次路由决策，
+// 流量和时长从上一条记录之后算起。连接关闭时只记录之后的部分，已记录的请求不会重复出现。
+func (e *Entry) LogRequest() {
+	if e == nil {
+		return
+	}
+	info := e.Info()
+	e.mu.Lock()
+	e.routeRecorded = true
+	e.advanceRecord(info)
+	e.mu.Unlock()
+	info.CloseReason = CloseCompleted
+	e.tracker.access.Log(info)
+}
+
+// advanceRecord 把 info 的开始时间、时长和流量改为上一条访问记录之后的部分，并把记录的位置移到现在。调用方需持有 mu。
+func (e *Entry) advanceRecord(info *types.ConnectionInfo) {
+	now := time.Now()
+	up, down := info.Upload, info.Download
+	info.Start = e.recordedAt
+	info.Age = now.Sub(e.recordedAt).Seconds()
+	info.Upload -= e.recordedUp
+	info.Download -= e.recordedDown
+	e.recordedAt, e.recordedUp, e.recordedDown = now, up, down
+}
+
+// SetCloseReason 记录连接即将关闭的原因，多次调用时以最后一次为准，连接关闭后不再修改。
+func (e *Entry) SetCloseReason(reason string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.closed {
+		e.closeReason = reason
+	}
+	e.mu.Unlock()
+}
+
+// CloseWithReason 以 reason 关闭条目，条目已关闭时不修改原因。
+func (e *Entry) CloseWithReason(reason string) {
+	e.SetCloseReason(reason)
+	e.Close()
+}
+
+// publish 向监控中心发布连接的事件，没有订阅者时不构造事件。
+func (e *Entry) publish(eventType string) {
+	if !e.tracker.monitor.Enabled() {
+		return
+	}
+	e.mu.Lock()
+	opened := e.opened
+	e.mu.Unlock()
+	if opened {
+		e.tracker.monitor.Publish(&types.MonitorEvent{Type: eventType, Connection: e.Info()})
+	}
+}
+
+// Info 返回连接当前的描述。
+func (e *Entry) Info() *types.ConnectionInfo {
+	e.mu.Lock()
+	info := &types.ConnectionInfo{
+		ID:          e.id,
+		Client:      e.conn.RemoteAddr().String(),
+		Inbound:     e.inbound,
+		Protocol:    e.protocol,
+		User:        e.user,
+		Target:      e.target,
+		SniffedHost: e.sniffedHost,
+		Rule:        e.rule,
+		ServerID:    e.serverID,
+		Upstream:    e.upstream,
+		State:       e.State().String(),
+		Start:       e.start,
+		Age:         time.Since(e.start).Seconds(),
+	}
+	if e.closed {
+		info.CloseReason = e.closeReason
+	}
+	session := e.session
+	e.mu.Unlock()
+	if session != nil {
+		info.TrafficStats = session.Stats()
+	}
+	return info
+}
+
+// Attach 将经 backend 建立的上游连接关联到条目，条目关闭时该连接一并关闭；返回的连接被关闭时解除关联。
+// backend 为 nil 表示网关直连。e 为 nil 时原样返回 conn。
+func (e *Entry) Attach(conn net.Conn, backend types.TunnelStrategy) net.Conn {
+	if e == nil {
+		return conn
+	}
+	up := &upstreamConn{Conn: conn, entry: e, backend: backend}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		conn.Close()
+		return conn
+	}
+	e.upstreams[up] = struct{}{}
+	if addr := conn.RemoteAddr(); addr != nil {
+		e.upstream = addr.String()
+	}
+	e.mu.Unlock()
+	if backend != nil {
+		e.tracker.acquireBackend(backend)
+	}
+	return up
+}
+
+func (e *Entry) detach(up *upstreamConn) {
+	e.mu.Lock()
+	_, ok := e.upstreams[up]
+	delete(e.upstreams, up)
+	e.mu.Unlock()
+	if ok && up.backend != nil {
+		e.tracker.releaseBackend(up.backend)
+	}
+}
+
+// routedTo 报告连接最近一次的路由决策是否选中了 serverID。
+func (e *Entry) routedTo(serverID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.serverID == serverID
+}
+
+// uses 报告连接是否有经 backend 建立的上游连接。
+func (e *Entry) uses(backend types.TunnelStrategy) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for up := range e.upstreams {
+		if up.backend == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 关闭入站连接和所有关联的上游连接并停止跟踪，可以重复调用。
+func (e *Entry) Close() {
+	if e == nil {
+		return
+	}
+	e.closeOnce.Do(func() {
+		e.mu.Lock()
+		e.closed = true
+		if e.closeReason == "" {
+			switch {
+			case e.serverID == "REJECT":
+				e.closeReason = CloseRejected
+			case e.State() == StateHandshake:
+				e.closeReason = CloseHandshake
+			default:
+				e.closeReason = CloseCompleted
+			}
+		}
+		opened := e.opened
+		upstreams := make([]*upstreamConn, 0, len(e.upstreams))
+		for up := range e.upstreams {
+			upstreams = append(upstreams, up)
+		}
+		e.mu.Unlock()
+
+		e.cancel()
+		e.conn.Close()
+		for _, up := range upstreams {
+			up.Close()
+		}
+		e.tracker.remove(e)
+		// 目标确定之前失败的连接 (如被封禁或超出连接上限) 不写入访问日志
+		if opened {
+			info := e.Info()
+			e.tracker.monitor.Publish(&types.MonitorEvent{Type: monitor.EventClose, Connection: info})
+			record := *info
+			e.mu.Lock()
+			recorded := e.routeRecorded
+			e.advanceRecord(&record)
+			e.mu.Unlock()
+			if !recorded {
+				e.tracker.access.Log(&record)
+			}
+		}
+	})
+}
+
+// watch 在连接的生命周期内执行空闲超时和最长存活时间，ctx 被取消时关闭连接。
+func (e *Entry) watch(ctx context.Context) {
+	var lifetime <-chan time.Time
+	if e.cfg.maxLifetime > 0 {
+		timer := time.NewTimer(e.cfg.maxLifetime)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+	idleTimer := time.NewTimer(time.Hour)
+	defer idleTimer.Stop()
+
+	for {
+		state := e.State()
+		var idle <-chan time.Time
+		if timeout := e.cfg.idleTimeout(state); timeout > 0 {
+			remaining := timeout - time.Since(time.Unix(0, e.lastActive.Load()))
+			if remaining <= 0 {
+				logger.Ctx(ctx, "").Debug().
+					Str("client_addr", e.conn.RemoteAddr().String()).
+					Str("state", state.String()).
+					Dur("timeout", timeout).
+					Msg("Conntrack: Closing idle connection.")
+				e.CloseWithReason(CloseIdleTimeout)
+				return
+			}
+			idleTimer.Reset(remaining)
+			idle = idleTimer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			// 条目自身关闭时 ctx 也被取消，此时原因已确定，不会被改为 shutdown
+			e.CloseWithReason(CloseShutdown)
+			return
+		case <-lifetime:
+			logger.Ctx(ctx, "").Debug().
+				Str("client_addr", e.conn.RemoteAddr().String()).
+				Dur("max_lifetime", e.cfg.maxLifetime).
+				Msg("Conntrack: Closing connection that reached its maximum lifetime.")
+			e.CloseWithReason(CloseMaxLifetime)
+			return
+		case <-idle:
+		case <-e.stateChanged:
+		}
+	}
+}
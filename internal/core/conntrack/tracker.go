@@ -0,0 +1,293 @@
+package conntrack
+
+import (
+	"context"
+	"fmt"
+	"liuproxy_go/internal/core/accesslog"
+	"liuproxy_go/internal/core/monitor"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 未配置 "timeouts" 模块时使用的默认值。
+const (
+	defaultHandshakeTimeout   = 30 * time.Second
+	defaultEstablishedTimeout = 0 // 长时间空闲的会话 (SSH、IMAP IDLE、WebSocket) 默认不被关闭
+	defaultHalfClosedTimeout  = 30 * time.Second
+	defaultDrainTimeout       = 10 * time.Second
+)
+
+// config 是解析后的 "timeouts" 模块配置，超时为 0 表示不限制。
+type config struct {
+	handshake   time.Duration
+	established time.Duration
+	halfClosed  time.Duration
+	maxLifetime time.Duration
+	drain       time.Duration
+}
+
+// idleTimeout 返回连接处于 state 时的空闲超时。
+func (c *config) idleTimeout(state State) time.Duration {
+	switch state {
+	case StateEstablished:
+		return c.established
+	case StateHalfClosed:
+		return c.halfClosed
+	default:
+		return c.handshake
+	}
+}
+
+// Tracker 跟踪网关正在处理的每个入站连接及其上游连接。它按连接所处的阶段执行空闲超时和最长存活时间，
+// 并在替换服务器实例时排空经该实例建立的连接。Gateway 和 TransparentGateway 共享同一个 Tracker。
+type Tracker struct {
+	cfg     atomic.Pointer[config]
+	monitor *monitor.Hub
+	access  *accesslog.Logger
+
+	mu      sync.Mutex
+	entries map[*Entry]struct{}
+	// backends 记录每个策略实例上的上游连接数，drained 中的通道在计数归零时关闭
+	backends map[types.TunnelStrategy]int
+	drained  map[types.TunnelStrategy]chan struct{}
+}
+
+// New 创建连接跟踪器，cfg 为 "timeouts" 模块的初始配置，为 nil 时使用默认值。
+// 连接的打开、路由和关闭事件发布到 hub，结束的连接写入访问日志 access，为 nil 时分别不发布和不记录。
+func New(cfg *settings.TimeoutsSettings, hub *monitor.Hub, access *accesslog.Logger) *Tracker {
+	t := &Tracker{
+		monitor:  hub,
+		access:   access,
+		entries:  make(map[*Entry]struct{}),
+		backends: make(map[types.TunnelStrategy]int),
+		drained:  make(map[types.TunnelStrategy]chan struct{}),
+	}
+	t.applySettings(cfg)
+	return t
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。新的超时只对之后建立的连接生效。
+func (t *Tracker) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "timeouts" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.TimeoutsSettings)
+	if !ok {
+		return fmt.Errorf("conntrack: received incorrect settings type for timeouts module")
+	}
+	t.applySettings(cfg)
+	c := t.cfg.Load()
+	logger.Info().
+		Dur("handshake", c.handshake).
+		Dur("established", c.established).
+		Dur("half_closed", c.halfClosed).
+		Dur("max_lifetime", c.maxLifetime).
+		Dur("drain", c.drain).
+		Msg("Conntrack: Timeouts have been reloaded.")
+	return nil
+}
+
+func (t *Tracker) applySettings(cfg *settings.TimeoutsSettings) {
+	if cfg == nil {
+		t.cfg.Store(&config{
+			handshake:   defaultHandshakeTimeout,
+			established: defaultEstablishedTimeout,
+			halfClosed:  defaultHalfClosedTimeout,
+			drain:       defaultDrainTimeout,
+		})
+		return
+	}
+	seconds := func(n int) time.Duration { return time.Duration(max(n, 0)) * time.Second }
+	t.cfg.Store(&config{
+		handshake:   seconds(cfg.Handshake),
+		established: seconds(cfg.Established),
+		halfClosed:  seconds(cfg.HalfClosed),
+		maxLifetime: seconds(cfg.MaxLifetime),
+		drain:       seconds(cfg.Drain),
+	})
+}
+
+// DrainTimeout 返回关闭时等待现有连接结束的最长时间，为 0 表示一直等待。
+func (t *Tracker) DrainTimeout() time.Duration {
+	return t.cfg.Load().drain
+}
+
+// Open 开始跟踪入站连接 conn，id 是它在连接表中的标识 (trace_id)。返回它的条目和派生出的 context。之后应使用 Entry.Conn 读写连接以记录活动。
+// 条目因超时、排空或 ctx 被取消而关闭时，入站连接及其上游连接都会被关闭，返回的 context 随之取消。
+// 调用方处理完连接后必须调用 Entry.Close。
+func (t *Tracker) Open(ctx context.Context, id string, conn net.Conn) (*Entry, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	e := &Entry{
+		tracker:      t,
+		id:           id,
+		start:        time.Now(),
+		cfg:          t.cfg.Load(),
+		cancel:       cancel,
+		stateChanged: make(chan struct{}, 1),
+		upstreams:    make(map[*upstreamConn]struct{}),
+	}
+	e.recordedAt = e.start
+	e.conn = &trackedConn{Conn: conn, entry: e}
+	e.touch()
+	ctx = withEntry(ctx, e)
+
+	t.mu.Lock()
+	t.entries[e] = struct{}{}
+	t.mu.Unlock()
+
+	go e.watch(ctx)
+	return e, ctx
+}
+
+// Active 返回当前跟踪的入站连接数。
+func (t *Tracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+// Connections 返回所有被跟踪连接的描述，按建立时间排序。
+func (t *Tracker) Connections() []*types.ConnectionInfo {
+	entries := t.snapshot()
+	infos := make([]*types.ConnectionInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, e.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Start.Before(infos[j].Start) })
+	return infos
+}
+
+// CloseConnection 关闭 trace_id 为 id 的连接，返回是否找到该连接。
+func (t *Tracker) CloseConnection(id string) bool {
+	for _, e := range t.snapshot() {
+		if e.id == id {
+			e.CloseWithReason(CloseKilled)
+			return true
+		}
+	}
+	return false
+}
+
+// CloseClient 关闭来自客户端 IP ip 的所有连接，返回关闭的连接数。
+func (t *Tracker) CloseClient(ip string) int {
+	return t.closeMatching(func(e *Entry) bool { return hostOf(e.conn.RemoteAddr()) == normalizeIP(ip) })
+}
+
+// CloseServer 关闭最近一次路由到服务器 serverID 的所有连接，返回关闭的连接数。
+func (t *Tracker) CloseServer(serverID string) int {
+	return t.closeMatching(func(e *Entry) bool { return e.routedTo(serverID) })
+}
+
+func (t *Tracker) closeMatching(match func(e *Entry) bool) int {
+	closed := 0
+	for _, e := range t.snapshot() {
+		if match(e) {
+			e.CloseWithReason(CloseKilled)
+			closed++
+		}
+	}
+	return closed
+}
+
+// snapshot 返回当前被跟踪的条目，调用方可以在不持有 mu 的情况下访问它们。
+func (t *Tracker) snapshot() []*Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]*Entry, 0, len(t.entries))
+	for e := range t.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// hostOf 返回地址中的 IP，IPv4 映射的 IPv6 地址还原为 IPv4。
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return normalizeIP(host)
+}
+
+func normalizeIP(host string) string {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+// DrainBackend 等待经 backend 建立的上游连接全部结束，最多等待 timeout，之后强制关闭仍在使用它的连接。
+// timeout 为 0 时一直等待，不强制关闭。返回被强制关闭的入站连接数。用于在关闭被替换的服务器实例之前让现有连接自然结束。
+func (t *Tracker) DrainBackend(backend types.TunnelStrategy, timeout time.Duration) int {
+	t.mu.Lock()
+	if t.backends[backend] == 0 {
+		t.mu.Unlock()
+		return 0
+	}
+	done, ok := t.drained[backend]
+	if !ok {
+		done = make(chan struct{})
+		t.drained[backend] = done
+	}
+	t.mu.Unlock()
+
+	if timeout <= 0 {
+		<-done
+		return 0
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+	}
+
+	t.mu.Lock()
+	var remaining []*Entry
+	for e := range t.entries {
+		if e.uses(backend) {
+			remaining = append(remaining, e)
+		}
+	}
+	t.mu.Unlock()
+	for _, e := range remaining {
+		e.CloseWithReason(CloseDrained)
+	}
+	return len(remaining)
+}
+
+func (t *Tracker) remove(e *Entry) {
+	t.mu.Lock()
+	delete(t.entries, e)
+	t.mu.Unlock()
+}
+
+func (t *Tracker) acquireBackend(backend types.TunnelStrategy) {
+	t.mu.Lock()
+	t.backends[backend]++
+	t.mu.Unlock()
+}
+
+func (t *Tracker) releaseBackend(backend types.TunnelStrategy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.backends[backend]--; t.backends[backend] > 0 {
+		return
+	}
+	delete(t.backends, backend)
+	if done, ok := t.drained[backend]; ok {
+		close(done)
+		delete(t.drained, backend)
+	}
+}
@@ -0,0 +1,221 @@
+package conntrack
+
+import (
+	"context"
+	"io"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeBackend 只用作上游连接所属的策略实例，不会被调用。
+type fakeBackend struct{ types.TunnelStrategy }
+
+func newTestTracker(cfg config) *Tracker {
+	t := New(nil, nil, nil)
+	t.cfg.Store(&cfg)
+	return t
+}
+
+// openEntry 在 net.Pipe 的一端上打开条目，返回条目、它的 context 和客户端一端。
+func openEntry(t *testing.T, tracker *Tracker) (*Entry, context.Context, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	e, ctx := tracker.Open(context.Background(), "test", server)
+	t.Cleanup(func() {
+		e.Close()
+		client.Close()
+	})
+	return e, ctx, client
+}
+
+// attachUpstream 把一个经 backend 建立的上游连接关联到条目，返回关联后的连接和上游一端。
+func attachUpstream(e *Entry, backend types.TunnelStrategy) (net.Conn, net.Conn) {
+	local, remote := net.Pipe()
+	return e.Attach(local, backend), remote
+}
+
+// waitClosed 等待条目在 d 内关闭并返回关闭原因，未关闭时返回空字符串。
+func waitClosed(e *Entry, ctx context.Context, d time.Duration) string {
+	select {
+	case <-ctx.Done():
+		e.Close() // 等待 closeOnce 完成，关闭原因已确定
+		return e.Info().CloseReason
+	case <-time.After(d):
+		return ""
+	}
+}
+
+// isClosed 报告 conn 的对端是否已关闭。
+func isClosed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF || err == io.ErrClosedPipe
+}
+
+func TestEntryIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config
+		state State
+		want  string // 为空表示连接保持打开
+	}{
+		{"handshake", config{handshake: 50 * time.Millisecond}, StateHandshake, CloseIdleTimeout},
+		{"established without timeout", config{handshake: 50 * time.Millisecond}, StateEstablished, ""},
+		{"established", config{handshake: time.Hour, established: 50 * time.Millisecond}, StateEstablished, CloseIdleTimeout},
+		{"half closed", config{handshake: time.Hour, halfClosed: 50 * time.Millisecond}, StateHalfClosed, CloseIdleTimeout},
+		{"no timeouts", config{}, StateHandshake, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTestTracker(tt.cfg)
+			start := time.Now()
+			e, ctx, _ := openEntry(t, tracker)
+			e.SetState(tt.state)
+
+			got := waitClosed(e, ctx, 300*time.Millisecond)
+			if got != tt.want {
+				t.Fatalf("close reason = %q, want %q", got, tt.want)
+			}
+			if got != "" && time.Since(start) < 50*time.Millisecond {
+				t.Errorf("closed after %v, before the idle timeout", time.Since(start))
+			}
+			if got != "" && tracker.Active() != 0 {
+				t.Errorf("Active() = %d after close, want 0", tracker.Active())
+			}
+		})
+	}
+}
+
+// 入站连接上的读写推迟空闲超时，活动停止后连接按超时关闭。
+func TestEntryActivityResetsIdleTimer(t *testing.T) {
+	tracker := newTestTracker(config{handshake: 100 * time.Millisecond})
+	e, ctx, client := openEntry(t, tracker)
+	go io.Copy(io.Discard, e.Conn())
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.Write([]byte{0}); err != nil {
+			t.Fatalf("write %d failed: %v", i, err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("connection closed while active: %q", e.Info().CloseReason)
+	}
+	if got := waitClosed(e, ctx, time.Second); got != CloseIdleTimeout {
+		t.Errorf("close reason after activity stopped = %q, want %q", got, CloseIdleTimeout)
+	}
+}
+
+// 到达最长存活时间的连接即使仍有活动也被关闭，关联的上游连接一并关闭。
+func TestEntryMaxLifetime(t *testing.T) {
+	tracker := newTestTracker(config{maxLifetime: 100 * time.Millisecond})
+	start := time.Now()
+	e, ctx, client := openEntry(t, tracker)
+	e.SetState(StateEstablished)
+	_, remote := attachUpstream(e, &fakeBackend{})
+	go io.Copy(io.Discard, e.Conn())
+	go func() {
+		for {
+			if _, err := client.Write([]byte{0}); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	if got := waitClosed(e, ctx, time.Second); got != CloseMaxLifetime {
+		t.Fatalf("close reason = %q, want %q", got, CloseMaxLifetime)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("closed after %v, before the maximum lifetime", elapsed)
+	}
+	if !isClosed(remote) {
+		t.Errorf("upstream connection should be closed with the entry")
+	}
+}
+
+func TestDrainBackend(t *testing.T) {
+	t.Run("upstreams finish before the timeout", func(t *testing.T) {
+		tracker := newTestTracker(config{})
+		backend := &fakeBackend{}
+		e, ctx, _ := openEntry(t, tracker)
+		upstream, _ := attachUpstream(e, backend)
+		time.AfterFunc(50*time.Millisecond, func() { upstream.Close() })
+
+		start := time.Now()
+		if n := tracker.DrainBackend(backend, 5*time.Second); n != 0 {
+			t.Errorf("DrainBackend() = %d, want 0", n)
+		}
+		if elapsed := time.Since(start); elapsed >= 5*time.Second {
+			t.Errorf("drain took %v, should end when the upstream closes", elapsed)
+		}
+		if ctx.Err() != nil {
+			t.Errorf("inbound connection should stay open after its upstream finished")
+		}
+	})
+
+	t.Run("timeout closes remaining connections", func(t *testing.T) {
+		tracker := newTestTracker(config{})
+		backend, other := &fakeBackend{}, &fakeBackend{}
+		e, ctx, _ := openEntry(t, tracker)
+		_, remote := attachUpstream(e, backend)
+		kept, keptCtx, _ := openEntry(t, tracker)
+		attachUpstream(kept, other)
+
+		if n := tracker.DrainBackend(backend, 50*time.Millisecond); n != 1 {
+			t.Errorf("DrainBackend() = %d, want 1", n)
+		}
+		if got := waitClosed(e, ctx, time.Second); got != CloseDrained {
+			t.Errorf("close reason = %q, want %q", got, CloseDrained)
+		}
+		if !isClosed(remote) {
+			t.Errorf("drained upstream connection should be closed")
+		}
+		if keptCtx.Err() != nil {
+			t.Errorf("connection through another backend should not be drained")
+		}
+		// 被强制关闭的连接已归还计数，再次排空立即返回
+		if n := tracker.DrainBackend(backend, time.Hour); n != 0 {
+			t.Errorf("DrainBackend() after closing = %d, want 0", n)
+		}
+	})
+
+	t.Run("zero timeout waits", func(t *testing.T) {
+		tracker := newTestTracker(config{})
+		backend := &fakeBackend{}
+		e, ctx, _ := openEntry(t, tracker)
+		upstream, _ := attachUpstream(e, backend)
+
+		done := make(chan int, 1)
+		go func() { done <- tracker.DrainBackend(backend, 0) }()
+		select {
+		case n := <-done:
+			t.Fatalf("DrainBackend() with no timeout returned %d while the upstream is in use", n)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("DrainBackend() with no timeout closed the connection: %q", e.Info().CloseReason)
+		}
+
+		upstream.Close()
+		select {
+		case n := <-done:
+			if n != 0 {
+				t.Errorf("DrainBackend() = %d, want 0", n)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("DrainBackend() did not return after the upstream closed")
+		}
+	})
+
+	t.Run("unused backend", func(t *testing.T) {
+		tracker := newTestTracker(config{})
+		e, _, _ := openEntry(t, tracker)
+		attachUpstream(e, &fakeBackend{})
+		if n := tracker.DrainBackend(&fakeBackend{}, 0); n != 0 {
+			t.Errorf("DrainBackend() = %d, want 0", n)
+		}
+	})
+}
@@ -3,7 +3,8 @@ package dispatcher
 import (
 	"context"
 	"fmt"
-	"github.com/rs/zerolog/log"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
 	"liuproxy_go/internal/shared/settings"
 	"liuproxy_go/internal/shared/types"
 	"math"
@@ -15,16 +16,20 @@ import (
 	"sync/atomic"
 )
 
+// log 是 Dispatcher 的 logger，它的级别可以通过 "logging" 模块单独设置。
+var log = logger.Component(logger.ComponentDispatcher)
+
 // RouteInfo 存储了路由决策所需的目标信息。
+// 策略实例在 Dispatch 时从实时状态中查找，避免持有已被替换的旧实例。
 type RouteInfo struct {
-	ServerID   string
-	TargetAddr string // "127.0.0.1:port" or "DIRECT" / "REJECT"
+	ServerID string // 后端服务器 ID，或 "DIRECT" / "REJECT"
 }
 
 // processedRule 将解析后的路由信息与原始规则绑定，并用于排序。
 type processedRule struct {
 	rule  *settings.Rule
 	route *RouteInfo
+	label string // 规则在 RoutingContext.Rule 和流量统计中的名称
 }
 
 // --- Load Balancer Strategy Pattern ---
@@ -35,14 +40,28 @@ type LoadBalancer interface {
 }
 
 // LeastConnectionsBalancer selects the backend with the minimum number of active connections.
-type LeastConnectionsBalancer struct{}
+// With a BackendLoad, live gateway counts are used and saturated backends are skipped;
+// otherwise the periodically refreshed Metrics are used.
+type LeastConnectionsBalancer struct {
+	load types.BackendLoad
+}
 
 func (b *LeastConnectionsBalancer) Select(serverStates map[string]*types.ServerState) (*types.ServerState, error) {
 	var bestServer *types.ServerState
 	minConnections := int64(math.MaxInt64)
 
-	for _, state := range serverStates {
+	for id, state := range serverStates {
 		if state.Profile.Active && state.Health == types.StatusUp {
+			if b.load != nil {
+				if state.Instance == nil || b.load.BackendSaturated(id) {
+					continue
+				}
+				if connections := b.load.BackendConnections(id); connections < minConnections {
+					minConnections = connections
+					bestServer = state
+				}
+				continue
+			}
 			if state.Instance != nil && state.Metrics != nil {
 				if state.Metrics.ActiveConnections < minConnections {
 					minConnections = state.Metrics.ActiveConnections
@@ -58,20 +77,21 @@ func (b *LeastConnectionsBalancer) Select(serverStates map[string]*types.ServerS
 	return bestServer, nil
 }
 
-// RoundRobinBalancer selects backends in a sequential order.
+// RoundRobinBalancer selects backends in a sequential order, skipping saturated backends.
 type RoundRobinBalancer struct {
 	next uint32
+	load types.BackendLoad
 }
 
-func NewRoundRobinBalancer() *RoundRobinBalancer {
-	return &RoundRobinBalancer{}
+func NewRoundRobinBalancer(load types.BackendLoad) *RoundRobinBalancer {
+	return &RoundRobinBalancer{load: load}
 }
 
 func (b *RoundRobinBalancer) Select(serverStates map[string]*types.ServerState) (*types.ServerState, error) {
 	// On-the-fly creation of the available server list ensures it's always up-to-date.
 	availableIDs := make([]string, 0)
 	for id, state := range serverStates {
-		if state.Profile.Active && state.Health == types.StatusUp {
+		if state.Profile.Active && state.Health == types.StatusUp && (b.load == nil || !b.load.BackendSaturated(id)) {
 			availableIDs = append(availableIDs, id)
 		}
 	}
@@ -94,6 +114,8 @@ func (b *RoundRobinBalancer) Select(serverStates map[string]*types.ServerState)
 type Dispatcher struct {
 	stateProvider   types.StateProvider
 	failureReporter types.FailureReporter
+	resolver        types.Resolver
+	load            types.BackendLoad
 	strategyMutex   sync.RWMutex
 
 	// 使用一个单一的、预排序的规则列表
@@ -105,15 +127,20 @@ type Dispatcher struct {
 	loadBalancer atomic.Value
 }
 
-// New 创建一个新的 Dispatcher 实例。
+// New 创建一个新的 Dispatcher 实例。resolver 用于解析 dest_ip 规则的域名目标，为 nil 时域名目标不匹配 dest_ip 规则。
+// load 提供后端的实时连接数，已达到连接上限的后端不会被选中；为 nil 时不检查后端上限。
 func New(
 	initialGatewaySettings *settings.GatewaySettings,
 	stateProvider types.StateProvider,
 	failureReporter types.FailureReporter,
+	resolver types.Resolver,
+	load types.BackendLoad,
 ) *Dispatcher {
 	d := &Dispatcher{
 		stateProvider:   stateProvider,
 		failureReporter: failureReporter,
+		resolver:        resolver,
+		load:            load,
 		sortedRules:     make([]*processedRule, 0),
 	}
 
@@ -136,11 +163,11 @@ func (d *Dispatcher) updateLoadBalancer(strategy string) {
 	var lb LoadBalancer
 	switch strategy {
 	case "round_robin":
-		lb = NewRoundRobinBalancer()
+		lb = NewRoundRobinBalancer(d.load)
 	case "least_connections":
 		fallthrough
 	default:
-		lb = &LeastConnectionsBalancer{}
+		lb = &LeastConnectionsBalancer{load: d.load}
 	}
 	d.loadBalancer.Store(lb)
 }
@@ -190,22 +217,53 @@ func (d *Dispatcher) Stop() {
 	d.getStickyManager().Stop()
 }
 
-// Dispatch 是路由决策的核心入口。
-func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target string) (string, string, error) {
+// Dispatch 是路由决策的核心入口。每次决策按规则和目标计入 metrics。
+func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target string) (types.TunnelStrategy, string, error) {
+	// 没有 RoutingContext 的调用方同样需要知道决策来自哪条规则
+	rc := types.RoutingContextFrom(ctx)
+	if rc == nil {
+		rc = &types.RoutingContext{}
+		ctx = types.WithRoutingContext(ctx, rc)
+	}
+	backend, serverID, err := d.dispatch(ctx, source, target)
+	if err != nil {
+		metrics.DispatchErrors.Inc()
+		return nil, "", err
+	}
+	metrics.DispatchDecisions.Inc(rc.Rule, serverID)
+	return backend, serverID, nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, source net.Addr, target string) (types.TunnelStrategy, string, error) {
 	clientIPStr, _, _ := net.SplitHostPort(source.String())
 	targetHost, _, _ := net.SplitHostPort(target)
 	clientIP, err := netip.ParseAddr(clientIPStr)
 	if err != nil {
-		return "", "", fmt.Errorf("invalid source IP: %s", clientIPStr)
+		return nil, "", fmt.Errorf("invalid source IP: %s", clientIPStr)
 	}
 
 	// 从 stateProvider 实时获取当前状态
 	serverStates := d.stateProvider.GetServerStates()
 
+	rc := types.RoutingContextFrom(ctx)
+	if rc != nil && rc.Target != "" {
+		return d.dispatchForcedTarget(ctx, rc, serverStates)
+	}
+
+	// routeHost 用于 domain 规则和粘性会话；目标为 IP 且嗅探到域名时使用嗅探结果
+	routeHost := targetHost
+	if rc != nil && rc.SniffedHost != "" {
+		routeHost = rc.SniffedHost
+	}
+
 	d.strategyMutex.RLock()
 	rules := d.sortedRules
 	d.strategyMutex.RUnlock()
 
+	// targetIP 在遇到第一条 dest_ip 规则时才解析，每次 Dispatch 最多解析一次
+	var targetIP netip.Addr
+	targetResolved := false
+
 	// 1. 遍历排序后的规则列表进行匹配
 	for _, pRule := range rules {
 		rule := pRule.rule
@@ -216,7 +274,7 @@ func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target strin
 
 		switch rule.Type {
 		case string(settings.RuleTypeDomain):
-			domainLower := strings.ToLower(targetHost)
+			domainLower := strings.ToLower(routeHost)
 			for _, pattern := range rule.Value {
 				pLower := strings.ToLower(pattern)
 				// 规则以 '.' 开头 (e.g., .baidu.com), 仅匹配子域名
@@ -243,22 +301,31 @@ func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target strin
 					break
 				}
 			}
-		case string(settings.RuleTypeDestIP):
-			var targetIP netip.Addr
-			var parseErr error
-
-			if targetIP, parseErr = netip.ParseAddr(targetHost); parseErr != nil {
-				ips, lookupErr := net.LookupIP(targetHost)
-				if lookupErr == nil {
-					for _, ip := range ips {
-						addr, ok := netip.AddrFromSlice(ip)
-						if ok {
-							targetIP = addr
-							break // Use the first resolved IP
-						}
+		case string(settings.RuleTypeUser):
+			if rc != nil && rc.User != "" {
+				for _, user := range rule.Value {
+					if user == rc.User {
+						matched = true
+						matchedValue = user
+						break
 					}
 				}
 			}
+		case string(settings.RuleTypeInbound):
+			if rc != nil && rc.Inbound != "" {
+				for _, name := range rule.Value {
+					if name == rc.Inbound {
+						matched = true
+						matchedValue = name
+						break
+					}
+				}
+			}
+		case string(settings.RuleTypeDestIP):
+			if !targetResolved {
+				targetIP = d.resolveTargetIP(ctx, targetHost)
+				targetResolved = true
+			}
 
 			if targetIP.IsValid() {
 				for _, cidr := range rule.Value {
@@ -273,70 +340,147 @@ func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target strin
 		}
 
 		if matched {
-			log.Ctx(ctx).Debug().
+			logger.Ctx(ctx, logger.ComponentDispatcher).Debug().
 				Int("priority", rule.Priority).
 				Str("type", rule.Type).
 				Str("value", matchedValue).
-				Str("target", route.TargetAddr).
+				Str("target", route.ServerID).
 				Msg("Dispatcher: Matched routing rule.")
 
-			// 处理虚拟策略或返回后端地址
-			if route.TargetAddr == "DIRECT" || route.TargetAddr == "REJECT" {
-				return route.TargetAddr, route.ServerID, nil
+			if rule.OverrideDestination && rc != nil && rc.SniffedHost != "" {
+				rc.OverrideDestination = true
+			}
+			if route.ServerID == "REJECT" && rc != nil {
+				rc.RejectMode = rule.RejectMode
+			}
+			if rc != nil {
+				rc.Rule = pRule.label
+			}
+
+			// 处理虚拟策略或返回后端实例
+			if route.ServerID == "DIRECT" || route.ServerID == "REJECT" {
+				return nil, route.ServerID, nil
 			}
 
-			if serverState, ok := serverStates[route.ServerID]; !ok || !serverState.Profile.Active || serverState.Health != types.StatusUp {
-				log.Ctx(ctx).Warn().Str("target_id", route.ServerID).Msg("Dispatcher: Matched rule's backend is not active or healthy. Continuing search...")
+			serverState, ok := serverStates[route.ServerID]
+			if !ok || !serverState.Profile.Active || serverState.Health != types.StatusUp || serverState.Instance == nil {
+				logger.Ctx(ctx, logger.ComponentDispatcher).Warn().Str("target_id", route.ServerID).Msg("Dispatcher: Matched rule's backend is not active or healthy. Continuing search...")
 				continue // 后端不健康，继续匹配下一条规则
 			}
-			return route.TargetAddr, route.ServerID, nil
+			if d.backendSaturated(route.ServerID) {
+				logger.Ctx(ctx, logger.ComponentDispatcher).Warn().Str("target_id", route.ServerID).Msg("Dispatcher: Matched rule's backend is at its connection limit. Continuing search...")
+				continue
+			}
+			return serverState.Instance, route.ServerID, nil
 		}
 	}
 
 	sm := d.getStickyManager()
-	sm_ShouldApply := sm.ShouldApply(targetHost)
+	sm_ShouldApply := sm.ShouldApply(routeHost)
 	if sm_ShouldApply {
-		stickyKey := clientIPStr + ":" + targetHost
+		stickyKey := clientIPStr + ":" + routeHost
 		if record := sm.Get(stickyKey, serverStates); record != nil {
-			if serverState, ok := serverStates[record.ServerID]; ok && serverState.Instance != nil {
-				// The instance listener info is now inside the ServerState
-				listenerInfo := serverState.Instance.GetListenerInfo()
-				backendAddr := fmt.Sprintf("%s:%d", listenerInfo.Address, listenerInfo.Port)
-				log.Ctx(ctx).Debug().
+			if serverState, ok := serverStates[record.ServerID]; ok && serverState.Instance != nil && !d.backendSaturated(record.ServerID) {
+				logger.Ctx(ctx, logger.ComponentDispatcher).Debug().
 					Str("client_ip", clientIPStr).
-					Str("target_host", targetHost).
+					Str("target_host", routeHost).
 					Str("matched_by", "Sticky Session").
-					Str("decision", backendAddr).
 					Str("server_id", record.ServerID).
-					Msg("Dispatcher: Sticky route dispatched using live port.")
-				return backendAddr, record.ServerID, nil
+					Msg("Dispatcher: Sticky route dispatched using live instance.")
+				setRoutedBy(rc, "sticky")
+				metrics.StickyHits.Inc()
+				return serverState.Instance, record.ServerID, nil
 			}
-			log.Ctx(ctx).Debug().
+			logger.Ctx(ctx, logger.ComponentDispatcher).Debug().
 				Str("server_id", record.ServerID).
-				Msg("Dispatcher: Sticky session record found but server is no longer active. Falling back to load balancer.")
+				Msg("Dispatcher: Sticky session record found but server is no longer active or is at its connection limit. Falling back to load balancer.")
 		}
 	}
 
+	if sm_ShouldApply {
+		metrics.StickyMisses.Inc()
+	}
+
 	// 2. 执行负载均衡
-	chosenAddr, chosenServerID, err := d.GetBackendForLoadBalancing(serverStates)
+	chosenInstance, chosenServerID, err := d.GetBackendForLoadBalancing(serverStates)
 	if err != nil {
-		log.Ctx(ctx).Warn().Err(err).Msg("Dispatcher: Load Balancer found no healthy backends.")
-		return "", "", fmt.Errorf("no route matched for target '%s' and no healthy backends available", target)
+		logger.Ctx(ctx, logger.ComponentDispatcher).Warn().Err(err).Msg("Dispatcher: Load Balancer found no healthy backends.")
+		return nil, "", fmt.Errorf("no route matched for target '%s' and no healthy backends available", target)
 	}
 
 	// 3. 如果需要，将新选择的后端存入粘性缓存
 	if sm_ShouldApply {
-		stickyKey := clientIPStr + ":" + targetHost
+		stickyKey := clientIPStr + ":" + routeHost
 		sm.Set(stickyKey, chosenServerID)
 	}
 
-	log.Ctx(ctx).Debug().
+	logger.Ctx(ctx, logger.ComponentDispatcher).Debug().
 		Str("client_ip", clientIPStr).
-		Str("target_host", targetHost).
+		Str("target_host", routeHost).
 		Str("matched_by", "Load Balancer").
-		Str("decision", chosenAddr).
+		Str("server_id", chosenServerID).
 		Msg("Dispatcher: Load balanced route dispatched.")
-	return chosenAddr, chosenServerID, nil
+	setRoutedBy(rc, "balancer")
+	return chosenInstance, chosenServerID, nil
+}
+
+// ruleLabel 返回规则在 RoutingContext.Rule 和流量统计中的名称，格式为 "优先级:序号:类型:目标"。
+// 序号是规则在路由配置中的位置 (从 1 开始)，区分只有匹配值不同的规则。
+func ruleLabel(index int, rule *settings.Rule) string {
+	return fmt.Sprintf("%d:%d:%s:%s", rule.Priority, index+1, rule.Type, rule.Target)
+}
+
+// setRoutedBy 在 rc 中记录没有规则匹配时做出决策的方式，rc 可以为 nil。
+func setRoutedBy(rc *types.RoutingContext, how string) {
+	if rc != nil {
+		rc.Rule = how
+	}
+}
+
+// backendSaturated 报告后端是否已达到连接上限。
+func (d *Dispatcher) backendSaturated(serverID string) bool {
+	return d.load != nil && d.load.BackendSaturated(serverID)
+}
+
+// resolveTargetIP 返回用于 dest_ip 规则的目标地址。目标为域名时取解析结果中的第一个地址，解析失败时返回无效地址。
+func (d *Dispatcher) resolveTargetIP(ctx context.Context, host string) netip.Addr {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap()
+	}
+	if d.resolver == nil {
+		return netip.Addr{}
+	}
+	addrs, err := d.resolver.LookupIP(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		logger.Ctx(ctx, logger.ComponentDispatcher).Debug().Err(err).Str("target_host", host).Msg("Dispatcher: Could not resolve target for dest_ip rules.")
+		return netip.Addr{}
+	}
+	return addrs[0]
+}
+
+// dispatchForcedTarget 处理入口配置了强制目标的连接，跳过路由规则、粘性会话和负载均衡。
+// 强制目标不可用时直接返回错误，而不是回退到其他后端。
+func (d *Dispatcher) dispatchForcedTarget(ctx context.Context, rc *types.RoutingContext, serverStates map[string]*types.ServerState) (types.TunnelStrategy, string, error) {
+	rc.Rule = "inbound"
+	if rc.Target == "DIRECT" || rc.Target == "REJECT" {
+		logger.Ctx(ctx, logger.ComponentDispatcher).Debug().Str("inbound", rc.Inbound).Str("target", rc.Target).Msg("Dispatcher: Inbound forced target dispatched.")
+		return nil, rc.Target, nil
+	}
+
+	for id, state := range serverStates {
+		if state.Profile.Remarks != rc.Target {
+			continue
+		}
+		if !state.Profile.Active || state.Health != types.StatusUp || state.Instance == nil {
+			return nil, "", fmt.Errorf("forced target '%s' of inbound '%s' is not active or healthy", rc.Target, rc.Inbound)
+		}
+		if d.backendSaturated(id) {
+			return nil, "", fmt.Errorf("forced target '%s' of inbound '%s' is at its connection limit", rc.Target, rc.Inbound)
+		}
+		logger.Ctx(ctx, logger.ComponentDispatcher).Debug().Str("inbound", rc.Inbound).Str("server_id", id).Msg("Dispatcher: Inbound forced target dispatched.")
+		return state.Instance, id, nil
+	}
+	return nil, "", fmt.Errorf("forced target '%s' of inbound '%s' not found", rc.Target, rc.Inbound)
 }
 
 // updateRoutingTables 根据最新的路由配置和服务器状态，重建内部路由表。
@@ -349,11 +493,10 @@ func (d *Dispatcher) updateRoutingTables(cfg *settings.RoutingSettings) {
 	serverStates := d.stateProvider.GetServerStates()
 	allProcessedRules := make([]*processedRule, 0, len(cfg.Rules))
 
-	for _, rule := range cfg.Rules {
+	for i, rule := range cfg.Rules {
 		routeInfo := &RouteInfo{}
 		if rule.Target == "DIRECT" || rule.Target == "REJECT" {
 			routeInfo.ServerID = rule.Target
-			routeInfo.TargetAddr = rule.Target
 		} else {
 			var targetState *types.ServerState
 			for _, state := range serverStates {
@@ -370,14 +513,7 @@ func (d *Dispatcher) updateRoutingTables(cfg *settings.RoutingSettings) {
 
 			// A static route target must be active and healthy to be included
 			if targetState.Profile.Active && targetState.Health == types.StatusUp && targetState.Instance != nil {
-				li := targetState.Instance.GetListenerInfo()
-				if li != nil {
-					routeInfo.ServerID = targetState.Profile.ID
-					routeInfo.TargetAddr = fmt.Sprintf("%s:%d", li.Address, li.Port)
-				} else {
-					log.Warn().Str("target_remarks", rule.Target).Msg("Routing rule target is active but has no listener info, skipping rule.")
-					continue
-				}
+				routeInfo.ServerID = targetState.Profile.ID
 			} else {
 				log.Warn().Str("target_remarks", rule.Target).Msg("Routing rule target is not active or not healthy, skipping rule.")
 				continue
@@ -387,6 +523,7 @@ func (d *Dispatcher) updateRoutingTables(cfg *settings.RoutingSettings) {
 		allProcessedRules = append(allProcessedRules, &processedRule{
 			rule:  rule,
 			route: routeInfo,
+			label: ruleLabel(i, rule),
 		})
 	}
 
@@ -422,22 +559,20 @@ func (d *Dispatcher) ReportSuccess(serverID string) {
 }
 
 // GetBackendForLoadBalancing 从健康的激活策略池中选择一个后端。
-func (d *Dispatcher) GetBackendForLoadBalancing(serverStates map[string]*types.ServerState) (string, string, error) {
+func (d *Dispatcher) GetBackendForLoadBalancing(serverStates map[string]*types.ServerState) (types.TunnelStrategy, string, error) {
 	// 修正: 直接将 atomic.Value 的值断言为接口类型 `LoadBalancer`
 	// 而不是错误的 `*LoadBalancer` (指向接口的指针)
 	lb := d.loadBalancer.Load().(LoadBalancer)
 	chosenServer, err := lb.Select(serverStates)
 	if err != nil {
-		return "", "", err
+		return nil, "", err
 	}
 
 	if chosenServer.Instance == nil {
-		return "", "", fmt.Errorf("load balancer selected an inactive server instance for ID %s", chosenServer.Profile.ID)
+		return nil, "", fmt.Errorf("load balancer selected an inactive server instance for ID %s", chosenServer.Profile.ID)
 	}
 
-	li := chosenServer.Instance.GetListenerInfo()
-	chosenAddr := fmt.Sprintf("%s:%d", li.Address, li.Port)
-	return chosenAddr, chosenServer.Profile.ID, nil
+	return chosenServer.Instance, chosenServer.Profile.ID, nil
 }
 
 // GetRecentClientIPs 从粘性会话管理器中获取最近活跃的客户端IP列表。
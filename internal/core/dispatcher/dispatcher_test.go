@@ -21,6 +21,10 @@ func (m *MockTunnelStrategy) GetListenerInfo() *types.ListenerInfo            {
 func (m *MockTunnelStrategy) GetMetrics() *types.Metrics                      { return m.Metrics }
 func (m *MockTunnelStrategy) UpdateServer(profile *types.ServerProfile) error { return nil }
 func (m *MockTunnelStrategy) CheckHealth() error                              { return nil }
+func (m *MockTunnelStrategy) SupportsUDP() bool                               { return false }
+func (m *MockTunnelStrategy) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	return nil, nil
+}
 
 // mockStateProvider now only needs to implement GetServerStates.
 type mockStateProvider struct {
@@ -56,7 +60,7 @@ func setupTestDispatcher(
 	gatewaySettings *settings.GatewaySettings,
 	routingRules *settings.RoutingSettings,
 ) *Dispatcher {
-	d := New(gatewaySettings, stateProvider, failureReporter)
+	d := New(gatewaySettings, stateProvider, failureReporter, nil, nil)
 
 	if routingRules == nil {
 		routingRules = &settings.RoutingSettings{
@@ -98,7 +102,7 @@ func TestDispatch_LoadBalancing_AllHealthy(t *testing.T) {
 	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, nil)
 
 	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
-	backendAddr, serverID, err := dispatcher.Dispatch(context.Background(), sourceAddr, "www.google.com:443")
+	backend, serverID, err := dispatcher.Dispatch(context.Background(), sourceAddr, "www.google.com:443")
 
 	if err != nil {
 		t.Fatalf("Dispatch() returned an error: %v", err)
@@ -106,8 +110,8 @@ func TestDispatch_LoadBalancing_AllHealthy(t *testing.T) {
 	if serverID != "server1" {
 		t.Errorf("Expected serverID to be 'server1', but got '%s'", serverID)
 	}
-	if backendAddr != "127.0.0.1:1001" {
-		t.Errorf("Expected backendAddr to be '127.0.0.1:1001', but got '%s'", backendAddr)
+	if backend != stateProvider.serverStates["server1"].Instance {
+		t.Errorf("Expected backend to be the instance of 'server1', but got %v", backend)
 	}
 }
 
@@ -142,6 +146,63 @@ func TestDispatch_LoadBalancing_OneUnhealthy(t *testing.T) {
 	}
 }
 
+// mockBackendLoad implements types.BackendLoad with fixed counts and a shared limit.
+type mockBackendLoad struct {
+	connections map[string]int64
+	limit       int64
+}
+
+func (m *mockBackendLoad) BackendConnections(serverID string) int64 { return m.connections[serverID] }
+func (m *mockBackendLoad) BackendSaturated(serverID string) bool {
+	return m.connections[serverID] >= m.limit
+}
+
+func TestDispatch_LoadBalancing_SaturatedBackend(t *testing.T) {
+	stateProvider := &mockStateProvider{
+		serverStates: map[string]*types.ServerState{
+			"server1": {
+				Profile:  &types.ServerProfile{ID: "server1", Remarks: "S1", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusUp,
+				Metrics:  &types.Metrics{ActiveConnections: 0},
+			},
+			"server2": {
+				Profile:  &types.ServerProfile{ID: "server2", Remarks: "S2", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusUp,
+				Metrics:  &types.Metrics{ActiveConnections: 0},
+			},
+		},
+	}
+	// 实时连接数优先于 Metrics，server1 已满时应跳过
+	load := &mockBackendLoad{connections: map[string]int64{"server1": 2, "server2": 1}, limit: 2}
+	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
+	rules := &settings.RoutingSettings{Rules: []*settings.Rule{{Type: "domain", Value: []string{"pinned.com"}, Target: "S1"}}}
+
+	for _, strategy := range []string{"least_connections", "round_robin"} {
+		d := New(&settings.GatewaySettings{StickySessionMode: "disabled", LoadBalancerStrategy: strategy}, stateProvider, &mockFailureReporter{}, nil, load)
+		if err := d.OnSettingsUpdate("routing", rules); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			_, serverID, err := d.Dispatch(context.Background(), sourceAddr, "www.google.com:443")
+			if err != nil || serverID != "server2" {
+				t.Errorf("%s: expected saturated server1 to be skipped, got id=%s, err=%v", strategy, serverID, err)
+			}
+		}
+		// 规则指向已满的后端时继续匹配，最终回退到负载均衡
+		if _, serverID, err := d.Dispatch(context.Background(), sourceAddr, "pinned.com:443"); err != nil || serverID != "server2" {
+			t.Errorf("%s: expected rule to saturated S1 to fall through, got id=%s, err=%v", strategy, serverID, err)
+		}
+	}
+
+	load.connections["server2"] = 2
+	d := New(&settings.GatewaySettings{StickySessionMode: "disabled"}, stateProvider, &mockFailureReporter{}, nil, load)
+	if _, serverID, err := d.Dispatch(context.Background(), sourceAddr, "www.google.com:443"); err == nil {
+		t.Errorf("Expected error when all backends are saturated, got id=%s", serverID)
+	}
+}
+
 func TestDispatch_StickySession_Global(t *testing.T) {
 	stateProvider := &mockStateProvider{
 		serverStates: map[string]*types.ServerState{
@@ -189,19 +250,191 @@ func TestDispatch_Routing_VirtualStrategies(t *testing.T) {
 		Rules: []*settings.Rule{
 			{Type: "domain", Value: []string{"ads.com"}, Target: "REJECT"},
 			{Type: "domain", Value: []string{"local.dev"}, Target: "DIRECT"},
+			{Type: "domain", Value: []string{"tracker.com"}, Target: "REJECT", RejectMode: "reject-drop"},
+		},
+	}
+	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, routingRules)
+	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
+
+	backend, id, err := dispatcher.Dispatch(context.Background(), sourceAddr, "ads.com:443")
+	if err != nil || backend != nil || id != "REJECT" {
+		t.Errorf("Expected REJECT rule to match, got backend=%v, id=%s, err=%v", backend, id, err)
+	}
+
+	backend, id, err = dispatcher.Dispatch(context.Background(), sourceAddr, "local.dev:80")
+	if err != nil || backend != nil || id != "DIRECT" {
+		t.Errorf("Expected DIRECT rule to match, got backend=%v, id=%s, err=%v", backend, id, err)
+	}
+
+	// 拒绝方式经 RoutingContext 传回网关
+	rc := &types.RoutingContext{}
+	_, id, err = dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, "tracker.com:443")
+	if err != nil || id != "REJECT" || rc.RejectMode != "reject-drop" {
+		t.Errorf("Expected REJECT rule with reject-drop mode, got id=%s, mode=%q, err=%v", id, rc.RejectMode, err)
+	}
+}
+
+// 只有匹配值不同的规则在统计中有不同的名称。
+func TestDispatch_RuleLabels(t *testing.T) {
+	stateProvider := &mockStateProvider{
+		serverStates: make(map[string]*types.ServerState),
+	}
+	gatewaySettings := &settings.GatewaySettings{StickySessionMode: "disabled"}
+	routingRules := &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Priority: 20, Type: "domain", Value: []string{"ads.com"}, Target: "REJECT"},
+			{Priority: 10, Type: "domain", Value: []string{"local.dev"}, Target: "DIRECT"},
+			{Priority: 20, Type: "domain", Value: []string{"tracker.com"}, Target: "REJECT"},
 		},
 	}
 	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, routingRules)
 	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
 
-	addr, id, err := dispatcher.Dispatch(context.Background(), sourceAddr, "ads.com:443")
-	if err != nil || addr != "REJECT" || id != "REJECT" {
-		t.Errorf("Expected REJECT rule to match, got addr=%s, id=%s, err=%v", addr, id, err)
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"ads.com:443", "20:1:domain:REJECT"},
+		{"local.dev:80", "10:2:domain:DIRECT"},
+		{"tracker.com:443", "20:3:domain:REJECT"},
+	}
+	for _, tt := range tests {
+		rc := &types.RoutingContext{}
+		if _, _, err := dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, tt.target); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", tt.target, err)
+		}
+		if rc.Rule != tt.want {
+			t.Errorf("Dispatch(%s) recorded rule %q, want %q", tt.target, rc.Rule, tt.want)
+		}
+	}
+}
+
+func TestDispatch_Routing_UserRule(t *testing.T) {
+	stateProvider := &mockStateProvider{
+		serverStates: map[string]*types.ServerState{
+			"server1": {
+				Profile:  &types.ServerProfile{ID: "server1", Remarks: "S1", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusUp,
+				Metrics:  &types.Metrics{ActiveConnections: 1},
+			},
+		},
+	}
+	gatewaySettings := &settings.GatewaySettings{StickySessionMode: "disabled"}
+	routingRules := &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Type: "user", Value: []string{"alice"}, Target: "DIRECT"},
+		},
+	}
+	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, routingRules)
+	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
+
+	rc := &types.RoutingContext{User: "alice"}
+	_, id, err := dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, "www.google.com:443")
+	if err != nil || id != "DIRECT" {
+		t.Errorf("Expected user rule to match for alice, got id=%s, err=%v", id, err)
+	}
+	if rc.Rule != "0:1:user:DIRECT" {
+		t.Errorf("Expected the matched rule to be recorded, got %q", rc.Rule)
+	}
+
+	rc = &types.RoutingContext{User: "bob"}
+	_, id, err = dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, "www.google.com:443")
+	if err != nil || id != "server1" {
+		t.Errorf("Expected bob to fall through to load balancing, got id=%s, err=%v", id, err)
+	}
+	if rc.Rule != "balancer" {
+		t.Errorf("Expected load balancing to be recorded, got %q", rc.Rule)
+	}
+
+	_, id, err = dispatcher.Dispatch(context.Background(), sourceAddr, "www.google.com:443")
+	if err != nil || id != "server1" {
+		t.Errorf("Expected unauthenticated request to fall through to load balancing, got id=%s, err=%v", id, err)
+	}
+}
+
+func TestDispatch_Routing_InboundRuleAndForcedTarget(t *testing.T) {
+	stateProvider := &mockStateProvider{
+		serverStates: map[string]*types.ServerState{
+			"server1": {
+				Profile:  &types.ServerProfile{ID: "server1", Remarks: "S1", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusUp,
+				Metrics:  &types.Metrics{ActiveConnections: 1},
+			},
+			"server2": {
+				Profile:  &types.ServerProfile{ID: "server2", Remarks: "S2", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusDown,
+				Metrics:  &types.Metrics{ActiveConnections: 0},
+			},
+		},
+	}
+	gatewaySettings := &settings.GatewaySettings{StickySessionMode: "disabled"}
+	routingRules := &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Type: "inbound", Value: []string{"lan"}, Target: "REJECT"},
+		},
+	}
+	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, routingRules)
+	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
+
+	ctx := types.WithRoutingContext(context.Background(), &types.RoutingContext{Inbound: "lan"})
+	_, id, err := dispatcher.Dispatch(ctx, sourceAddr, "www.google.com:443")
+	if err != nil || id != "REJECT" {
+		t.Errorf("Expected inbound rule to match for lan, got id=%s, err=%v", id, err)
+	}
+
+	// 强制目标跳过路由规则
+	ctx = types.WithRoutingContext(context.Background(), &types.RoutingContext{Inbound: "lan", Target: "S1"})
+	_, id, err = dispatcher.Dispatch(ctx, sourceAddr, "www.google.com:443")
+	if err != nil || id != "server1" {
+		t.Errorf("Expected forced target S1, got id=%s, err=%v", id, err)
+	}
+
+	// 强制目标不可用时不回退到其他服务器
+	ctx = types.WithRoutingContext(context.Background(), &types.RoutingContext{Inbound: "via-s2", Target: "S2"})
+	if _, id, err = dispatcher.Dispatch(ctx, sourceAddr, "www.google.com:443"); err == nil {
+		t.Errorf("Expected error for unhealthy forced target, got id=%s", id)
+	}
+}
+
+func TestDispatch_Routing_SniffedHost(t *testing.T) {
+	stateProvider := &mockStateProvider{
+		serverStates: map[string]*types.ServerState{
+			"server1": {
+				Profile:  &types.ServerProfile{ID: "server1", Remarks: "S1", Active: true},
+				Instance: &MockTunnelStrategy{},
+				Health:   types.StatusUp,
+				Metrics:  &types.Metrics{ActiveConnections: 1},
+			},
+		},
+	}
+	gatewaySettings := &settings.GatewaySettings{StickySessionMode: "disabled"}
+	routingRules := &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Priority: 1, Type: "domain", Value: []string{"google.com"}, Target: "DIRECT", OverrideDestination: true},
+			{Priority: 2, Type: "domain", Value: []string{"baidu.com"}, Target: "DIRECT"},
+		},
+	}
+	dispatcher := setupTestDispatcher(stateProvider, &mockFailureReporter{}, gatewaySettings, routingRules)
+	sourceAddr, _ := net.ResolveTCPAddr("tcp", "192.168.1.10:12345")
+
+	rc := &types.RoutingContext{SniffedHost: "www.google.com"}
+	_, id, err := dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, "142.250.1.1:443")
+	if err != nil || id != "DIRECT" || !rc.OverrideDestination {
+		t.Errorf("Expected sniffed host to match domain rule with override, got id=%s, override=%v, err=%v", id, rc.OverrideDestination, err)
+	}
+
+	rc = &types.RoutingContext{SniffedHost: "www.baidu.com"}
+	_, id, err = dispatcher.Dispatch(types.WithRoutingContext(context.Background(), rc), sourceAddr, "110.242.68.1:443")
+	if err != nil || id != "DIRECT" || rc.OverrideDestination {
+		t.Errorf("Expected sniffed host to match domain rule without override, got id=%s, override=%v, err=%v", id, rc.OverrideDestination, err)
 	}
 
-	addr, id, err = dispatcher.Dispatch(context.Background(), sourceAddr, "local.dev:80")
-	if err != nil || addr != "DIRECT" || id != "DIRECT" {
-		t.Errorf("Expected DIRECT rule to match, got addr=%s, id=%s, err=%v", addr, id, err)
+	_, id, err = dispatcher.Dispatch(context.Background(), sourceAddr, "142.250.1.1:443")
+	if err != nil || id != "server1" {
+		t.Errorf("Expected IP target without sniffed host to fall through to load balancing, got id=%s, err=%v", id, err)
 	}
 }
 
@@ -1,7 +1,6 @@
 package dispatcher
 
 import (
-	"github.com/rs/zerolog/log"
 	"liuproxy_go/internal/shared/settings"
 	"liuproxy_go/internal/shared/types"
 	"net"
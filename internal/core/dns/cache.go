@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheSize 是未配置 cache_size 时最多缓存的应答数。
+	defaultCacheSize = 4096
+	// negativeCacheTTL 是没有任何资源记录的应答 (如 NXDOMAIN) 的缓存时间。
+	negativeCacheTTL = 60 * time.Second
+	// maxCacheTTL 限制单条应答的缓存时间，避免上游给出过长的 TTL。
+	maxCacheTTL = time.Hour
+)
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// answerCache 按 (域名, 类型, 类) 缓存上游应答，过期时间取应答中最小的 TTL。
+type answerCache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]*cacheEntry
+	maxEntries int
+}
+
+func newAnswerCache(maxEntries int) *answerCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheSize
+	}
+	return &answerCache{entries: make(map[cacheKey]*cacheEntry), maxEntries: maxEntries}
+}
+
+// get 返回未过期的缓存应答。返回的是副本，TTL 已扣除在缓存中经过的时间。
+func (c *answerCache) get(key cacheKey, now time.Time) *dnsmessage.Message {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !now.Before(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	msg := entry.msg
+	msg.Answers = agedResources(entry.msg.Answers, elapsed)
+	msg.Authorities = agedResources(entry.msg.Authorities, elapsed)
+	msg.Additionals = agedResources(entry.msg.Additionals, elapsed)
+	return &msg
+}
+
+// put 缓存一条应答。只缓存成功和 NXDOMAIN 的完整应答。
+func (c *answerCache) put(key cacheKey, msg *dnsmessage.Message, now time.Time) {
+	if msg.Truncated || (msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError) {
+		return
+	}
+	ttl := cacheTTL(msg)
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cacheEntry{msg: *msg, stored: now, expires: now.Add(ttl)}
+}
+
+// purge 清空缓存，在上游或静态解析变化时调用。
+func (c *answerCache) purge() {
+	c.mu.Lock()
+	c.entries = make(map[cacheKey]*cacheEntry)
+	c.mu.Unlock()
+}
+
+// evictLocked 先清理过期条目，仍然已满时随机淘汰一条。调用方需持有 mu。
+func (c *answerCache) evictLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		delete(c.entries, key)
+	}
+}
+
+// cacheTTL 返回应答中最小的 TTL (OPT 记录除外)，没有资源记录时使用 negativeCacheTTL。
+func cacheTTL(msg *dnsmessage.Message) time.Duration {
+	minTTL := uint32(0)
+	found := false
+	for _, section := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for _, rr := range section {
+			if rr.Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if !found || rr.Header.TTL < minTTL {
+				minTTL = rr.Header.TTL
+				found = true
+			}
+		}
+	}
+	if !found {
+		return negativeCacheTTL
+	}
+	ttl := time.Duration(minTTL) * time.Second
+	if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+	return ttl
+}
+
+// agedResources 复制资源记录并扣减 TTL，OPT 记录的 TTL 字段另有含义，保持不变。
+func agedResources(rrs []dnsmessage.Resource, elapsed uint32) []dnsmessage.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	aged := make([]dnsmessage.Resource, len(rrs))
+	copy(aged, rrs)
+	for i := range aged {
+		if aged[i].Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		if aged[i].Header.TTL > elapsed {
+			aged[i].Header.TTL -= elapsed
+		} else {
+			aged[i].Header.TTL = 0
+		}
+	}
+	return aged
+}
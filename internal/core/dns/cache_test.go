@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testResource(name string, rrType dnsmessage.Type, ttl uint32) dnsmessage.Resource {
+	header := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: rrType, Class: dnsmessage.ClassINET, TTL: ttl}
+	switch rrType {
+	case dnsmessage.TypeOPT:
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.OPTResource{}}
+	case dnsmessage.TypeNS:
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.NSResource{NS: dnsmessage.MustNewName("ns.example.com.")}}
+	default:
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  dnsmessage.Message
+		want time.Duration
+	}{
+		{
+			name: "minimum across sections",
+			msg: dnsmessage.Message{
+				Answers:     []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 300)},
+				Authorities: []dnsmessage.Resource{testResource("example.com.", dnsmessage.TypeNS, 120)},
+			},
+			want: 120 * time.Second,
+		},
+		{
+			name: "OPT record is ignored",
+			msg: dnsmessage.Message{
+				Answers:     []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 300)},
+				Additionals: []dnsmessage.Resource{testResource(".", dnsmessage.TypeOPT, 0)},
+			},
+			want: 300 * time.Second,
+		},
+		{
+			name: "capped at maxCacheTTL",
+			msg:  dnsmessage.Message{Answers: []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 86400)}},
+			want: maxCacheTTL,
+		},
+		{
+			name: "zero TTL",
+			msg:  dnsmessage.Message{Answers: []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 0)}},
+			want: 0,
+		},
+		{
+			name: "no records",
+			msg:  dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}},
+			want: negativeCacheTTL,
+		},
+	}
+	for _, tt := range tests {
+		if got := cacheTTL(&tt.msg); got != tt.want {
+			t.Errorf("%s: cacheTTL() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 缓存命中的应答 TTL 扣除在缓存中经过的时间，OPT 记录不变，缓存中的原始应答不受影响。
+func TestAnswerCacheAging(t *testing.T) {
+	cache := newAnswerCache(16)
+	key := cacheKey{name: "a.example.com.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}
+	now := time.Unix(1700000000, 0)
+	cache.put(key, &dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true},
+		Answers:     []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 300)},
+		Authorities: []dnsmessage.Resource{testResource("example.com.", dnsmessage.TypeNS, 100)},
+		Additionals: []dnsmessage.Resource{testResource(".", dnsmessage.TypeOPT, 0x8000)},
+	}, now)
+
+	tests := []struct {
+		elapsed   time.Duration
+		hit       bool
+		answer    uint32
+		authority uint32
+	}{
+		{0, true, 300, 100},
+		{30*time.Second + 500*time.Millisecond, true, 270, 70},
+		{99 * time.Second, true, 201, 1},
+		{100 * time.Second, false, 0, 0}, // 过期时间取最小的 TTL
+	}
+	for _, tt := range tests {
+		msg := cache.get(key, now.Add(tt.elapsed))
+		if !tt.hit {
+			if msg != nil {
+				t.Errorf("after %v: expected a miss", tt.elapsed)
+			}
+			continue
+		}
+		if msg == nil {
+			t.Fatalf("after %v: unexpected miss", tt.elapsed)
+		}
+		if got := msg.Answers[0].Header.TTL; got != tt.answer {
+			t.Errorf("after %v: answer TTL = %d, want %d", tt.elapsed, got, tt.answer)
+		}
+		if got := msg.Authorities[0].Header.TTL; got != tt.authority {
+			t.Errorf("after %v: authority TTL = %d, want %d", tt.elapsed, got, tt.authority)
+		}
+		if got := msg.Additionals[0].Header.TTL; got != 0x8000 {
+			t.Errorf("after %v: OPT TTL field changed to %#x", tt.elapsed, got)
+		}
+	}
+
+	// 过期条目在 get 时被删除，之后的查询也不会命中
+	if msg := cache.get(key, now); msg != nil {
+		t.Errorf("expired entry was not removed")
+	}
+}
+
+func TestAgedResourcesFloorsAtZero(t *testing.T) {
+	rrs := []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 10)}
+	aged := agedResources(rrs, 25)
+	if aged[0].Header.TTL != 0 {
+		t.Errorf("aged TTL = %d, want 0", aged[0].Header.TTL)
+	}
+	if rrs[0].Header.TTL != 10 {
+		t.Errorf("agedResources modified its input")
+	}
+	if agedResources(nil, 5) != nil {
+		t.Errorf("agedResources(nil) should be nil")
+	}
+}
+
+func TestAnswerCachePut(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	answer := []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 60)}
+	tests := []struct {
+		name   string
+		msg    dnsmessage.Message
+		cached bool
+	}{
+		{"success", dnsmessage.Message{Answers: answer}, true},
+		{"NXDOMAIN", dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}}, true},
+		{"SERVFAIL", dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}, false},
+		{"truncated", dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}, Answers: answer}, false},
+		{"zero TTL", dnsmessage.Message{Answers: []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 0)}}, false},
+	}
+	for _, tt := range tests {
+		cache := newAnswerCache(16)
+		key := cacheKey{name: "a.example.com.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}
+		cache.put(key, &tt.msg, now)
+		if got := cache.get(key, now) != nil; got != tt.cached {
+			t.Errorf("%s: cached = %v, want %v", tt.name, got, tt.cached)
+		}
+	}
+}
+
+func TestAnswerCacheEviction(t *testing.T) {
+	cache := newAnswerCache(2)
+	now := time.Unix(1700000000, 0)
+	short := &dnsmessage.Message{Answers: []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 10)}}
+	long := &dnsmessage.Message{Answers: []dnsmessage.Resource{testResource("a.example.com.", dnsmessage.TypeA, 600)}}
+	keys := []cacheKey{
+		{name: "expired.example.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET},
+		{name: "live.example.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET},
+		{name: "new.example.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET},
+	}
+	cache.put(keys[0], short, now)
+	cache.put(keys[1], long, now)
+
+	// 已满时先清理过期条目，未过期的条目保留
+	later := now.Add(time.Minute)
+	cache.put(keys[2], long, later)
+	if cache.get(keys[1], later) == nil || cache.get(keys[2], later) == nil {
+		t.Errorf("live entries were evicted while an expired one was available")
+	}
+
+	// 没有过期条目时淘汰一条，条目数不超过上限
+	cache.put(cacheKey{name: "more.example.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}, long, later)
+	if n := len(cache.entries); n > 2 {
+		t.Errorf("cache holds %d entries, want at most 2", n)
+	}
+
+	cache.purge()
+	if n := len(cache.entries); n != 0 {
+		t.Errorf("purge left %d entries", n)
+	}
+}
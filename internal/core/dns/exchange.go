@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"time"
+)
+
+const (
+	// exchangeTimeout 是一次上游查询的最长时间。
+	exchangeTimeout = 5 * time.Second
+	// maxUDPSize 是客户端未声明 EDNS 时 UDP 应答的最大长度 (RFC 1035)。
+	maxUDPSize = 512
+	// maxMessageSize 是 DNS 消息的最大长度，也是 TCP 长度前缀能表示的上限。
+	maxMessageSize = 65535
+)
+
+// writeTCPMessage 以长度前缀 (RFC 1035 4.2.2) 向 TCP 客户端写出一个消息。
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageSize {
+		return fmt.Errorf("DNS message too large: %d bytes", len(msg))
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readTCPMessage 读取 TCP 客户端发来的一个长度前缀的消息。
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// udpSizeLimit 返回客户端经 UDP 能接收的最大应答长度，来自查询中的 EDNS OPT 记录。
+func udpSizeLimit(query *dnsmessage.Message) int {
+	for _, rr := range query.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT && int(rr.Header.Class) > maxUDPSize {
+			return int(rr.Header.Class)
+		}
+	}
+	return maxUDPSize
+}
+
+// truncate 在应答超过 limit 时只保留头部和问题并设置 TC 位，促使客户端改用 TCP 重试。
+func truncate(resp *dnsmessage.Message, packed []byte, limit int) ([]byte, error) {
+	if len(packed) <= limit {
+		return packed, nil
+	}
+	truncated := dnsmessage.Message{Header: resp.Header, Questions: resp.Questions}
+	truncated.Truncated = true
+	return truncated.Pack()
+}
@@ -0,0 +1,470 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+	"liuproxy_go/internal/core/fakeip"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InboundName 是 DNS 查询在路由规则中使用的入口名称。
+const InboundName = "dns"
+
+const (
+	// hostsTTL 是静态解析应答的 TTL。
+	hostsTTL = 60
+	// fakeIPTTL 是 Fake-IP 应答的 TTL。保持很短，避免关闭 Fake-IP 后客户端长期持有不可达的地址。
+	fakeIPTTL = 1
+	// tcpIdleTimeout 是 TCP 客户端两次查询之间允许的最长空闲时间。
+	tcpIdleTimeout = 10 * time.Second
+)
+
+var errQueryRejected = errors.New("query rejected by routing")
+
+// Server 是内置的 DNS 服务器。它在 UDP 和 TCP 上应答局域网客户端的查询，
+// 每个查询按域名经 Dispatcher 选择直连或隧道后端转发，应答会被缓存。
+type Server struct {
+	dispatcher      types.Dispatcher
+	failureReporter types.FailureReporter
+	fakeIP          *fakeip.Pool
+
+	cfg   atomic.Pointer[settings.DNSSettings]
+	hosts atomic.Pointer[map[string][]netip.Addr]
+	cache atomic.Pointer[answerCache]
+	// direct 是直连查询使用的上游，未配置上游时为 nil
+	direct atomic.Pointer[resolver.Upstream]
+
+	// mu 保护监听器的生命周期
+	mu          sync.Mutex
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+	listenAddr  string
+	started     bool
+	closed      bool
+	waitGroup   sync.WaitGroup
+}
+
+// New 创建 DNS 服务器，cfg 为 "dns" 模块的初始配置。fakeIP 为 nil 时不支持 Fake-IP 模式。
+func New(cfg *settings.DNSSettings, dispatcher types.Dispatcher, failureReporter types.FailureReporter, fakeIP *fakeip.Pool) *Server {
+	s := &Server{
+		dispatcher:      dispatcher,
+		failureReporter: failureReporter,
+		fakeIP:          fakeIP,
+	}
+	if err := s.applySettings(cfg); err != nil {
+		logger.Warn().Err(err).Msg("DNS: Invalid settings, the DNS server is disabled.")
+		s.applySettings(nil)
+	}
+	return s
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。
+// 上游和静态解析立即生效，它们变化时清空缓存；监听地址或开关变化时重建监听器。
+func (s *Server) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "dns" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.DNSSettings)
+	if !ok {
+		return fmt.Errorf("dns: received incorrect settings type for dns module")
+	}
+	if err := s.applySettings(cfg); err != nil {
+		return err
+	}
+	logger.Info().Bool("enabled", cfg.Enabled).Str("listen", cfg.Listen).Str("upstream", cfg.Upstream).Int("hosts", len(cfg.Hosts)).Msg("DNS: Settings have been reloaded.")
+
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if started {
+		return s.reconcile()
+	}
+	return nil
+}
+
+// applySettings 应用 cfg，上游地址无法解析时返回错误且不做任何修改。
+func (s *Server) applySettings(cfg *settings.DNSSettings) error {
+	if cfg == nil {
+		cfg = &settings.DNSSettings{}
+	}
+	// 经隧道的上游在每次查询时按路由选中的后端创建，这里只检查它的格式
+	var direct *resolver.Upstream
+	if cfg.Upstream != "" {
+		if _, err := resolver.NewUpstream(cfg.Upstream, nil, true); err != nil {
+			return fmt.Errorf("dns: %w", err)
+		}
+		directUpstream := cfg.DirectUpstream
+		if directUpstream == "" {
+			directUpstream = cfg.Upstream
+		}
+		var dialer net.Dialer
+		var err error
+		if direct, err = resolver.NewUpstream(directUpstream, dialer.DialContext, false); err != nil {
+			return fmt.Errorf("dns: %w", err)
+		}
+	}
+
+	hosts := make(map[string][]netip.Addr, len(cfg.Hosts))
+	for _, h := range cfg.Hosts {
+		if h == nil || h.Domain == "" {
+			continue
+		}
+		name := normalizeName(h.Domain)
+		for _, ip := range h.IPs {
+			addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+			if err != nil {
+				logger.Warn().Str("domain", h.Domain).Str("ip", ip).Msg("DNS: Ignoring invalid static host address.")
+				continue
+			}
+			hosts[name] = append(hosts[name], addr.Unmap())
+		}
+	}
+	prev := s.cfg.Swap(cfg)
+	s.hosts.Store(&hosts)
+	switch cache := s.cache.Load(); {
+	case cache == nil || prev == nil || prev.CacheSize != cfg.CacheSize:
+		s.cache.Store(newAnswerCache(cfg.CacheSize))
+	case prev.Upstream != cfg.Upstream || prev.DirectUpstream != cfg.DirectUpstream || !reflect.DeepEqual(prev.Hosts, cfg.Hosts):
+		cache.purge()
+	}
+	if old := s.direct.Swap(direct); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Start 按配置启动监听。未启用时只记录日志。
+func (s *Server) Start() error {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	return s.reconcile()
+}
+
+// reconcile 使监听器与当前配置保持一致。
+func (s *Server) reconcile() error {
+	cfg := s.cfg.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+
+	wantAddr := ""
+	if cfg.Enabled {
+		wantAddr = cfg.Listen
+	}
+	if wantAddr == s.listenAddr {
+		return nil
+	}
+	s.stopListenersLocked()
+	if wantAddr == "" {
+		logger.Info().Msg("DNS server is disabled.")
+		return nil
+	}
+
+	udpConn, err := net.ListenPacket("udp", wantAddr)
+	if err != nil {
+		return fmt.Errorf("dns server failed to listen on udp %s: %w", wantAddr, err)
+	}
+	tcpListener, err := net.Listen("tcp", wantAddr)
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("dns server failed to listen on tcp %s: %w", wantAddr, err)
+	}
+	s.udpConn, s.tcpListener, s.listenAddr = udpConn, tcpListener, wantAddr
+	logger.Info().Str("listen_addr", udpConn.LocalAddr().String()).Msg(">>> DNS server is listening.")
+
+	s.waitGroup.Add(2)
+	go s.serveUDP(udpConn)
+	go s.serveTCP(tcpListener)
+	return nil
+}
+
+// stopListenersLocked 关闭当前监听器。调用方需持有 mu。
+func (s *Server) stopListenersLocked() {
+	if s.udpConn != nil {
+		s.udpConn.Close()
+		s.udpConn = nil
+	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+		s.tcpListener = nil
+	}
+	s.listenAddr = ""
+}
+
+// Close 关闭监听器并等待正在处理的查询结束。
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.stopListenersLocked()
+	s.mu.Unlock()
+	s.waitGroup.Wait()
+	logger.Info().Msg("DNS server has been shut down")
+}
+
+func (s *Server) serveUDP(conn net.PacketConn) {
+	defer s.waitGroup.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn().Err(err).Msg("DNS: Failed to read UDP query")
+			continue
+		}
+		query := append([]byte(nil), buf[:n]...)
+		s.waitGroup.Add(1)
+		go func() {
+			defer s.waitGroup.Done()
+			if resp := s.handleQuery(client, query, true); resp != nil {
+				_, _ = conn.WriteTo(resp, client)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(listener net.Listener) {
+	defer s.waitGroup.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn().Err(err).Msg("DNS: Failed to accept TCP connection")
+			continue
+		}
+		s.waitGroup.Add(1)
+		go func() {
+			defer s.waitGroup.Done()
+			defer conn.Close()
+			for {
+				conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := s.handleQuery(conn.RemoteAddr(), query, false)
+				if resp == nil {
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(tcpIdleTimeout))
+				if err := writeTCPMessage(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handleQuery 处理一个查询并返回打包好的应答。无法解析的报文返回 nil (丢弃)。
+func (s *Server) handleQuery(client net.Addr, query []byte, overUDP bool) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil || req.Response || len(req.Questions) == 0 {
+		return nil
+	}
+	q := req.Questions[0]
+	name := normalizeName(q.Name.String())
+
+	traceID := uuid.NewString()
+	traceLog := logger.ContextLogger().Str("trace_id", traceID).Str("client_ip", client.String()).Str("name", name).Str("type", q.Type.String()).Logger()
+	ctx := traceLog.WithContext(context.Background())
+	l := logger.Ctx(ctx, "")
+
+	resp := s.resolve(ctx, client, &req, name, query)
+	resp.ID = req.ID
+	resp.Response = true
+	resp.RecursionDesired = req.RecursionDesired
+	resp.RecursionAvailable = true
+
+	packed, err := resp.Pack()
+	if err != nil {
+		l.Warn().Err(err).Msg("DNS: Failed to pack response")
+		return nil
+	}
+	if overUDP {
+		if packed, err = truncate(resp, packed, udpSizeLimit(&req)); err != nil {
+			return nil
+		}
+	}
+	return packed
+}
+
+// resolve 依次尝试静态解析、Fake-IP、缓存和上游，返回未设置 ID 的应答。
+func (s *Server) resolve(ctx context.Context, client net.Addr, req *dnsmessage.Message, name string, query []byte) *dnsmessage.Message {
+	l := logger.Ctx(ctx, "")
+	q := req.Questions[0]
+
+	// 1. 静态解析
+	if addrs, ok := (*s.hosts.Load())[name]; ok {
+		l.Debug().Msg("DNS: Answered from static hosts")
+		return hostsAnswer(q, addrs)
+	}
+
+	// 2. Fake-IP：地址查询不访问上游，连接建立时再按域名路由
+	if (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeAAAA) && s.useFakeIP(ctx, client, name) {
+		return s.fakeIPAnswer(ctx, req, name)
+	}
+
+	// 3. 缓存
+	cache := s.cache.Load()
+	key := cacheKey{name: name, qtype: q.Type, class: q.Class}
+	if cached := cache.get(key, time.Now()); cached != nil {
+		l.Debug().Msg("DNS: Answered from cache")
+		cached.Questions = req.Questions
+		return cached
+	}
+
+	// 4. 按路由转发到上游
+	packed, serverID, err := s.forward(ctx, client, name, query)
+	if err != nil {
+		if errors.Is(err, errQueryRejected) {
+			l.Debug().Msg("DNS: [REJECT] Query refused by routing")
+			return errorAnswer(req, dnsmessage.RCodeRefused)
+		}
+		l.Warn().Err(err).Str("server_id", serverID).Msg("DNS: Upstream query failed")
+		return errorAnswer(req, dnsmessage.RCodeServerFailure)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(packed); err != nil {
+		l.Warn().Err(err).Str("server_id", serverID).Msg("DNS: Malformed upstream response")
+		return errorAnswer(req, dnsmessage.RCodeServerFailure)
+	}
+	cache.put(key, &resp, time.Now())
+	l.Debug().Str("server_id", serverID).Str("rcode", resp.RCode.String()).Int("answers", len(resp.Answers)).Msg("DNS: Answered from upstream")
+	return &resp
+}
+
+// forward 以查询的域名作为路由依据选择后端，并把原始查询发往对应的上游。
+// 上游与 "resolver" 模块一样支持 UDP、TCP、DoT 和 DoH；经隧道发送时 UDP 上游改用 TCP，因为并非所有后端都支持 UDP。
+func (s *Server) forward(ctx context.Context, client net.Addr, name string, query []byte) ([]byte, string, error) {
+	cfg := s.cfg.Load()
+	direct := s.direct.Load()
+	if cfg.Upstream == "" || direct == nil {
+		return nil, "", fmt.Errorf("no upstream configured")
+	}
+
+	tunnel, err := resolver.NewUpstream(cfg.Upstream, nil, true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx = types.WithRoutingContext(ctx, &types.RoutingContext{Inbound: InboundName, SniffedHost: name})
+	backend, serverID, err := s.dispatcher.Dispatch(ctx, client, tunnel.Addr())
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
+	switch serverID {
+	case "REJECT":
+		return nil, serverID, errQueryRejected
+	case "DIRECT":
+		resp, err := direct.Exchange(ctx, query)
+		return resp, serverID, err
+	}
+
+	// 经隧道的上游绑定本次选中的后端，建立连接的结果计入该后端的健康状态
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := backend.DialContext(ctx, network, address)
+		if s.failureReporter != nil {
+			if err == nil {
+				s.failureReporter.ReportSuccess(serverID)
+			} else if ctx.Err() == nil {
+				s.failureReporter.ReportFailure(serverID)
+			}
+		}
+		return conn, err
+	}
+	if tunnel, err = resolver.NewUpstream(cfg.Upstream, dial, true); err != nil {
+		return nil, serverID, err
+	}
+	defer tunnel.Close()
+	resp, err := tunnel.Exchange(ctx, query)
+	return resp, serverID, err
+}
+
+// hostsAnswer 根据静态解析构建应答。域名存在但没有对应地址族时返回空的成功应答，不再查询上游。
+func hostsAnswer(q dnsmessage.Question, addrs []netip.Addr) *dnsmessage.Message {
+	resp := &dnsmessage.Message{
+		Header:    dnsmessage.Header{Authoritative: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: hostsTTL}
+	for _, addr := range addrs {
+		switch {
+		case q.Type == dnsmessage.TypeA && addr.Is4():
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+		case q.Type == dnsmessage.TypeAAAA && addr.Is6():
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+		}
+	}
+	return resp
+}
+
+// useFakeIP 报告是否应以 Fake-IP 应答该域名。命中 fake_ip_filter 的域名和按路由不经隧道 (DIRECT、REJECT) 的域名
+// 按正常流程解析，因为直连的连接需要真实地址。
+func (s *Server) useFakeIP(ctx context.Context, client net.Addr, name string) bool {
+	if !s.fakeIP.Enabled() || s.fakeIP.Filtered(name) {
+		return false
+	}
+	ctx = types.WithRoutingContext(ctx, &types.RoutingContext{Inbound: InboundName, SniffedHost: name})
+	_, serverID, err := s.dispatcher.Dispatch(ctx, client, net.JoinHostPort(name, "0"))
+	if err != nil {
+		// 暂时无法路由时仍分配 Fake-IP，连接建立时会重新路由
+		return true
+	}
+	return serverID != "DIRECT" && serverID != "REJECT"
+}
+
+// fakeIPAnswer 为 A 查询分配 Fake-IP。AAAA 查询返回空的成功应答，使客户端使用 IPv4 建立连接。
+func (s *Server) fakeIPAnswer(ctx context.Context, req *dnsmessage.Message, name string) *dnsmessage.Message {
+	q := req.Questions[0]
+	resp := &dnsmessage.Message{Questions: []dnsmessage.Question{q}}
+	if q.Type != dnsmessage.TypeA {
+		return resp
+	}
+	addr, err := s.fakeIP.Allocate(name)
+	if err != nil {
+		logger.Ctx(ctx, "").Warn().Err(err).Msg("DNS: Failed to allocate fake IP")
+		return errorAnswer(req, dnsmessage.RCodeServerFailure)
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: fakeIPTTL}
+	resp.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}}}
+	logger.Ctx(ctx, "").Debug().Str("fake_ip", addr.String()).Msg("DNS: Answered with fake IP")
+	return resp
+}
+
+func errorAnswer(req *dnsmessage.Message, rcode dnsmessage.RCode) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header:    dnsmessage.Header{RCode: rcode},
+		Questions: req.Questions,
+	}
+}
+
+// normalizeName 将域名转为小写并去掉末尾的点。
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
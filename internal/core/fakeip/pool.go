@@ -0,0 +1,382 @@
+package fakeip
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRange 是未配置 fake_ip_range 时使用的地址段 (RFC 2544 基准测试保留地址)。
+	DefaultRange = "198.18.0.0/15"
+	// maxPoolSize 限制映射表的容量，超出后按 LRU 淘汰并复用地址。
+	maxPoolSize = 65536
+	// saveInterval 是映射表有变化时写回磁盘的间隔。
+	saveInterval = time.Minute
+)
+
+// ErrUnknownFakeIP 表示目标位于 Fake-IP 地址段内，但映射已被淘汰或从未分配。
+var ErrUnknownFakeIP = errors.New("unknown fake IP")
+
+var errDisabled = errors.New("fake IP is not enabled")
+
+type mapping struct {
+	Domain string     `json:"domain"`
+	IP     netip.Addr `json:"ip"`
+}
+
+// Pool 是 Fake-IP 地址池，维护 IP 与域名的双向映射。
+// DNS 服务器为 A 查询分配地址，网关在路由前把这些地址还原为域名。
+// 映射按最近使用排序，地址池用尽时淘汰最久未使用的映射并复用其地址；映射表会持久化到磁盘。
+type Pool struct {
+	mu       sync.Mutex
+	enabled  bool
+	prefix   netip.Prefix
+	first    uint32       // 第一个可分配地址
+	size     int          // 可分配地址数
+	next     int          // 下一个从未分配过的偏移量
+	free     []netip.Addr // 恢复映射后 next 之前未被占用的地址
+	filter   []string     // 不分配 Fake-IP 的域名，规则同路由的 domain 规则
+	lru      *list.List
+	byDomain map[string]*list.Element
+	byIP     map[netip.Addr]*list.Element
+
+	path      string
+	dirty     bool
+	stopChan  chan struct{}
+	closeOnce sync.Once
+	waitGroup sync.WaitGroup
+}
+
+// New 根据 "dns" 模块的配置创建地址池，并从 path 恢复上次保存的映射。path 为空表示不持久化。
+func New(cfg *settings.DNSSettings, path string) *Pool {
+	p := &Pool{path: path, stopChan: make(chan struct{})}
+	if err := p.configure(cfg); err != nil {
+		logger.Warn().Err(err).Msg("FakeIP: Invalid settings, fake IP is disabled.")
+	}
+	if err := p.load(); err != nil {
+		logger.Warn().Err(err).Str("path", path).Msg("FakeIP: Failed to restore mappings.")
+	}
+	return p
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。地址段变化时清空映射表。
+func (p *Pool) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "dns" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.DNSSettings)
+	if !ok {
+		return fmt.Errorf("fakeip: received incorrect settings type for dns module")
+	}
+	return p.configure(cfg)
+}
+
+func (p *Pool) configure(cfg *settings.DNSSettings) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.enabled = false
+	p.filter = nil
+	if cfg != nil {
+		for _, pattern := range cfg.FakeIPFilter {
+			if pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), "."); pattern != "" {
+				p.filter = append(p.filter, pattern)
+			}
+		}
+	}
+	if cfg == nil || !cfg.FakeIP {
+		// 保留已有映射，客户端缓存中的 Fake-IP 在关闭后仍能被还原
+		return nil
+	}
+	rangeStr := cfg.FakeIPRange
+	if rangeStr == "" {
+		rangeStr = DefaultRange
+	}
+	prefix, err := netip.ParsePrefix(rangeStr)
+	if err != nil {
+		return fmt.Errorf("invalid fake_ip_range %q: %w", rangeStr, err)
+	}
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return fmt.Errorf("fake_ip_range %q must be an IPv4 prefix of /30 or larger", rangeStr)
+	}
+
+	if prefix != p.prefix {
+		size := (1 << (32 - prefix.Bits())) - 2 // 去掉网络地址和广播地址
+		if size > maxPoolSize {
+			size = maxPoolSize
+		}
+		p.prefix = prefix
+		p.first = ipToUint32(prefix.Addr()) + 1
+		p.size = size
+		p.reset()
+		logger.Info().Str("range", prefix.String()).Int("size", size).Msg("FakeIP: Address pool configured.")
+	}
+	p.enabled = true
+	return nil
+}
+
+// reset 清空映射表。调用方需持有 mu。
+func (p *Pool) reset() {
+	p.next = 0
+	p.free = nil
+	p.lru = list.New()
+	p.byDomain = make(map[string]*list.Element)
+	p.byIP = make(map[netip.Addr]*list.Element)
+	p.dirty = true
+}
+
+// Enabled 报告是否应为 DNS 查询分配 Fake-IP。
+func (p *Pool) Enabled() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enabled
+}
+
+// Filtered 报告域名是否命中 fake_ip_filter，命中的域名应使用真实地址应答。
+// 以 '.' 开头的规则只匹配子域名，否则匹配自身和所有子域名。
+func (p *Pool) Filtered(domain string) bool {
+	if p == nil {
+		return false
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pattern := range p.filter {
+		if strings.HasPrefix(pattern, ".") {
+			if strings.HasSuffix(domain, pattern) {
+				return true
+			}
+		} else if domain == pattern || strings.HasSuffix(domain, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allocate 返回域名对应的 Fake-IP，没有映射时分配一个新地址。
+func (p *Pool) Allocate(domain string) (netip.Addr, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled {
+		return netip.Addr{}, errDisabled
+	}
+
+	if elem, ok := p.byDomain[domain]; ok {
+		p.lru.MoveToFront(elem)
+		return elem.Value.(*mapping).IP, nil
+	}
+
+	var ip netip.Addr
+	if n := len(p.free); n > 0 {
+		ip = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else if p.next < p.size {
+		ip = uint32ToIP(p.first + uint32(p.next))
+		p.next++
+	} else {
+		// 地址池已满，复用最久未使用的地址
+		oldest := p.lru.Back()
+		old := oldest.Value.(*mapping)
+		p.lru.Remove(oldest)
+		delete(p.byDomain, old.Domain)
+		delete(p.byIP, old.IP)
+		ip = old.IP
+	}
+	p.insert(&mapping{Domain: domain, IP: ip})
+	return ip, nil
+}
+
+// insert 在 LRU 前端插入映射。调用方需持有 mu。
+func (p *Pool) insert(m *mapping) {
+	elem := p.lru.PushFront(m)
+	p.byDomain[m.Domain] = elem
+	p.byIP[m.IP] = elem
+	p.dirty = true
+}
+
+// Domain 返回 Fake-IP 对应的域名。
+func (p *Pool) Domain(ip netip.Addr) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elem, ok := p.byIP[ip.Unmap()]
+	if !ok {
+		return "", false
+	}
+	p.lru.MoveToFront(elem)
+	return elem.Value.(*mapping).Domain, true
+}
+
+// MapTarget 将 host:port 形式的目标中的 Fake-IP 还原为域名。
+// 目标不在地址段内时原样返回；在地址段内但没有映射时返回 ErrUnknownFakeIP，因为 Fake-IP 本身不可达。
+func (p *Pool) MapTarget(target string) (string, error) {
+	if p == nil {
+		return target, nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, nil
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return target, nil
+	}
+	ip = ip.Unmap()
+
+	p.mu.Lock()
+	inRange := p.prefix.IsValid() && p.prefix.Contains(ip)
+	p.mu.Unlock()
+	if !inRange {
+		return target, nil
+	}
+	domain, ok := p.Domain(ip)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownFakeIP, ip)
+	}
+	return net.JoinHostPort(domain, port), nil
+}
+
+// Start 启动定期持久化。
+func (p *Pool) Start() {
+	if p.path == "" {
+		return
+	}
+	p.waitGroup.Add(1)
+	go func() {
+		defer p.waitGroup.Done()
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.save(); err != nil {
+					logger.Warn().Err(err).Msg("FakeIP: Failed to persist mappings.")
+				}
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止定期持久化并最后保存一次映射表。
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stopChan)
+		p.waitGroup.Wait()
+		if err := p.save(); err != nil {
+			logger.Warn().Err(err).Msg("FakeIP: Failed to persist mappings.")
+		}
+	})
+}
+
+// persistedPool 是映射表在磁盘上的格式，Mappings 按从旧到新排列。
+type persistedPool struct {
+	Range    string     `json:"range"`
+	Mappings []*mapping `json:"mappings"`
+}
+
+func (p *Pool) save() error {
+	if p.path == "" {
+		return nil
+	}
+	p.mu.Lock()
+	if !p.dirty || !p.prefix.IsValid() {
+		p.mu.Unlock()
+		return nil
+	}
+	state := persistedPool{Range: p.prefix.String(), Mappings: make([]*mapping, 0, p.lru.Len())}
+	for elem := p.lru.Back(); elem != nil; elem = elem.Prev() {
+		state.Mappings = append(state.Mappings, elem.Value.(*mapping))
+	}
+	p.dirty = false
+	p.mu.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := p.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, p.path)
+}
+
+// load 恢复映射表。只接受与当前地址段一致的文件，地址段变化后旧映射没有意义。
+// 恢复后从最大的已用偏移量之后继续分配，之前被淘汰而空出的地址先于新地址复用。
+func (p *Pool) load() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var state persistedPool
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.prefix.IsValid() || state.Range != p.prefix.String() {
+		return nil
+	}
+	for _, m := range state.Mappings {
+		if m == nil || m.Domain == "" || !p.prefix.Contains(m.IP) {
+			continue
+		}
+		offset := int64(ipToUint32(m.IP)) - int64(p.first)
+		if offset < 0 || offset >= int64(p.size) {
+			continue
+		}
+		if _, dup := p.byIP[m.IP]; dup {
+			continue
+		}
+		if _, dup := p.byDomain[m.Domain]; dup {
+			continue
+		}
+		p.insert(m)
+		if int(offset) >= p.next {
+			p.next = int(offset) + 1
+		}
+	}
+	for offset := p.next - 1; offset >= 0; offset-- {
+		if ip := uint32ToIP(p.first + uint32(offset)); p.byIP[ip] == nil {
+			p.free = append(p.free, ip)
+		}
+	}
+	p.dirty = false
+	logger.Info().Int("mappings", p.lru.Len()).Msg("FakeIP: Restored mappings from disk.")
+	return nil
+}
+
+func ipToUint32(ip netip.Addr) uint32 {
+	b := ip.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func uint32ToIP(v uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return netip.AddrFrom4(b)
+}
@@ -0,0 +1,197 @@
+package fakeip
+
+import (
+	"errors"
+	"liuproxy_go/internal/shared/settings"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPool(t *testing.T, fakeIPRange, path string) *Pool {
+	t.Helper()
+	p := New(&settings.DNSSettings{FakeIP: true, FakeIPRange: fakeIPRange}, path)
+	if !p.Enabled() {
+		t.Fatalf("pool for %s is not enabled", fakeIPRange)
+	}
+	return p
+}
+
+func mustAllocate(t *testing.T, p *Pool, domain string) netip.Addr {
+	t.Helper()
+	ip, err := p.Allocate(domain)
+	if err != nil {
+		t.Fatalf("Allocate(%q) failed: %v", domain, err)
+	}
+	return ip
+}
+
+func TestAllocate(t *testing.T) {
+	// /29 去掉网络和广播地址后可分配 6 个地址
+	p := newTestPool(t, "10.0.0.0/29", "")
+
+	a := mustAllocate(t, p, "a.example")
+	if a != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf("first allocation = %s, want 10.0.0.1", a)
+	}
+	if got := mustAllocate(t, p, "A.Example."); got != a {
+		t.Errorf("same domain in another form got %s, want %s", got, a)
+	}
+	if got := mustAllocate(t, p, "b.example"); got != netip.MustParseAddr("10.0.0.2") {
+		t.Errorf("second allocation = %s, want 10.0.0.2", got)
+	}
+	if domain, ok := p.Domain(netip.MustParseAddr("::ffff:10.0.0.1")); !ok || domain != "a.example" {
+		t.Errorf("Domain(mapped 10.0.0.1) = %q, %v", domain, ok)
+	}
+
+	p = newTestPool(t, "10.0.0.0/29", "")
+	if _, err := p.Allocate("x"); err != nil {
+		t.Fatal(err)
+	}
+	_ = p.configure(&settings.DNSSettings{FakeIP: false})
+	if _, err := p.Allocate("y"); err == nil {
+		t.Errorf("Allocate should fail when fake IP is disabled")
+	}
+	if domain, ok := p.Domain(netip.MustParseAddr("10.0.0.1")); !ok || domain != "x" {
+		t.Errorf("mappings should survive disabling, Domain() = %q, %v", domain, ok)
+	}
+}
+
+// 地址池用尽后淘汰最久未使用的映射并复用其地址。
+func TestAllocateEvictsLeastRecentlyUsed(t *testing.T) {
+	p := newTestPool(t, "10.0.0.0/30", "") // 只有 10.0.0.1 和 10.0.0.2
+
+	a := mustAllocate(t, p, "a.example")
+	b := mustAllocate(t, p, "b.example")
+	p.Domain(a) // 访问 a 使 b 成为最久未使用的映射
+
+	if got := mustAllocate(t, p, "c.example"); got != b {
+		t.Errorf("c.example got %s, want the evicted address %s", got, b)
+	}
+	if domain, ok := p.Domain(b); !ok || domain != "c.example" {
+		t.Errorf("Domain(%s) = %q, %v, want c.example", b, domain, ok)
+	}
+	if got := mustAllocate(t, p, "a.example"); got != a {
+		t.Errorf("a.example should keep %s, got %s", a, got)
+	}
+	if got := mustAllocate(t, p, "b.example"); got != b {
+		// c 刚被分配而 a 刚被访问，此时淘汰的是 c
+		t.Errorf("b.example got %s, want %s", got, b)
+	}
+}
+
+func TestFiltered(t *testing.T) {
+	p := New(&settings.DNSSettings{FakeIP: true, FakeIPFilter: []string{" LAN ", ".local", "time.apple.com.", ""}}, "")
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"lan", true},
+		{"router.lan", true},
+		{"ROUTER.LAN.", true},
+		{"plan", false},
+		{"local", false}, // '.' 开头的规则只匹配子域名
+		{"printer.local", true},
+		{"time.apple.com", true},
+		{"apple.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := p.Filtered(tt.domain); got != tt.want {
+			t.Errorf("Filtered(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+
+	var nilPool *Pool
+	if nilPool.Filtered("lan") {
+		t.Errorf("nil pool should not filter anything")
+	}
+}
+
+func TestMapTarget(t *testing.T) {
+	p := newTestPool(t, "198.18.0.0/15", "")
+	ip := mustAllocate(t, p, "example.com")
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr error
+	}{
+		{ip.String() + ":443", "example.com:443", nil},
+		{"[::ffff:" + ip.String() + "]:80", "example.com:80", nil},
+		{"198.18.9.9:443", "", ErrUnknownFakeIP},
+		{"8.8.8.8:53", "8.8.8.8:53", nil},
+		{"example.org:443", "example.org:443", nil},
+		{"not-a-target", "not-a-target", nil},
+	}
+	for _, tt := range tests {
+		got, err := p.MapTarget(tt.target)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("MapTarget(%q) error = %v, want %v", tt.target, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("MapTarget(%q) = %q, %v, want %q", tt.target, got, err, tt.want)
+		}
+	}
+
+	var nilPool *Pool
+	if got, err := nilPool.MapTarget("198.18.0.1:443"); err != nil || got != "198.18.0.1:443" {
+		t.Errorf("nil pool MapTarget() = %q, %v", got, err)
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.json")
+	p := newTestPool(t, "10.0.0.0/29", path)
+	a := mustAllocate(t, p, "a.example")
+	b := mustAllocate(t, p, "b.example")
+	mustAllocate(t, p, "a.example") // a 变为最近使用
+	p.Close()
+
+	restored := newTestPool(t, "10.0.0.0/29", path)
+	for ip, want := range map[netip.Addr]string{a: "a.example", b: "b.example"} {
+		if domain, ok := restored.Domain(ip); !ok || domain != want {
+			t.Errorf("restored Domain(%s) = %q, %v, want %q", ip, domain, ok, want)
+		}
+	}
+	if got := mustAllocate(t, restored, "c.example"); got != netip.MustParseAddr("10.0.0.3") {
+		t.Errorf("allocation after restore = %s, want 10.0.0.3", got)
+	}
+
+	// 地址段变化后不恢复旧映射
+	other := newTestPool(t, "10.0.1.0/29", path)
+	if _, ok := other.Domain(a); ok {
+		t.Errorf("mappings restored for a different range")
+	}
+}
+
+// 恢复后，最大已用偏移量之前空出的地址先于新地址被分配，且从低到高复用。
+func TestLoadReusesFreedOffsets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.json")
+	state := `{"range":"10.0.0.0/29","mappings":[` +
+		`{"domain":"b.example","ip":"10.0.0.2"},` +
+		`{"domain":"d.example","ip":"10.0.0.4"},` +
+		`{"domain":"dup.example","ip":"10.0.0.4"},` +
+		`{"domain":"outside.example","ip":"10.0.1.1"}]}`
+	if err := os.WriteFile(path, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestPool(t, "10.0.0.0/29", path)
+	if domain, ok := p.Domain(netip.MustParseAddr("10.0.0.4")); !ok || domain != "d.example" {
+		t.Errorf("Domain(10.0.0.4) = %q, %v, want d.example", domain, ok)
+	}
+	for _, want := range []string{"10.0.0.1", "10.0.0.3", "10.0.0.5", "10.0.0.6"} {
+		if got := mustAllocate(t, p, "new-"+want); got != netip.MustParseAddr(want) {
+			t.Errorf("allocation = %s, want %s", got, want)
+		}
+	}
+	// 地址池已满，淘汰最久未使用的 b.example
+	if got := mustAllocate(t, p, "full.example"); got != netip.MustParseAddr("10.0.0.2") {
+		t.Errorf("allocation in a full pool = %s, want 10.0.0.2", got)
+	}
+}
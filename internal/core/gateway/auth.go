@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"liuproxy_go/internal/shared/settings"
+	"net/http"
+	"strings"
+)
+
+// proxyAuth 保存网关认证使用的用户表。为 nil 表示未启用认证。
+type proxyAuth struct {
+	users map[string]string
+}
+
+// newProxyAuth 根据 auth 配置构建用户表。未启用或没有用户时返回 nil。
+func newProxyAuth(cfg *settings.AuthSettings) *proxyAuth {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	users := make(map[string]string, len(cfg.Users))
+	for _, u := range cfg.Users {
+		if u == nil || u.Username == "" {
+			continue
+		}
+		users[u.Username] = u.Password
+	}
+	if len(users) == 0 {
+		log.Warn().Msg("Gateway: Authentication is enabled but no users are configured, all proxy clients will be refused.")
+	}
+	return &proxyAuth{users: users}
+}
+
+// verify 校验用户名和密码，密码比较使用常量时间。
+func (a *proxyAuth) verify(username, password string) bool {
+	expected, ok := a.users[username]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// verifyHTTP 校验 HTTP 请求中的 Proxy-Authorization (Basic)，成功时返回用户名。
+func (a *proxyAuth) verifyHTTP(req *http.Request) (string, error) {
+	header := req.Header.Get("Proxy-Authorization")
+	if header == "" {
+		return "", fmt.Errorf("missing Proxy-Authorization header")
+	}
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", fmt.Errorf("unsupported proxy authorization scheme")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("malformed proxy credentials: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || !a.verify(username, password) {
+		return "", fmt.Errorf("invalid proxy credentials for user %q", username)
+	}
+	return username, nil
+}
@@ -2,166 +2,585 @@ package gateway
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog/log"
 	"io"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/core/fakeip"
+	"liuproxy_go/internal/core/guard"
+	"liuproxy_go/internal/core/limiter"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/core/shaper"
 	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/traffic"
 	"liuproxy_go/internal/shared/types"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// log 是 网关的 logger，它的级别可以通过 "logging" 模块单独设置。
+var log = logger.Component(logger.ComponentGateway)
+
 type Protocol string
 
 const (
-	ProtoSOCKS5  Protocol = "SOCKS5"
-	ProtoHTTP    Protocol = "HTTP"
-	ProtoTLS     Protocol = "TLS"
-	ProtoUnknown Protocol = "UNKNOWN"
+	ProtoSOCKS4    Protocol = "SOCKS4"
+	ProtoSOCKS5    Protocol = "SOCKS5"
+	ProtoSOCKS5UDP Protocol = "SOCKS5_UDP"
+	ProtoHTTP      Protocol = "HTTP"
+	ProtoTLS       Protocol = "TLS"
+	ProtoUnknown   Protocol = "UNKNOWN"
 )
 
 type Gateway struct {
-	listener        net.Listener
 	dispatcher      types.Dispatcher
 	failureReporter types.FailureReporter
 	closeOnce       sync.Once
 	waitGroup       sync.WaitGroup
-	listenPort      int
+	listenPort      int // unified_port，仅在未配置 inbounds 时使用
 	directConn      VirtualStrategy
-	rejectConn      VirtualStrategy
+	rejectConns     map[settings.RejectMode]VirtualStrategy
+
+	// inboundsMu 保护入口表及其生命周期
+	inboundsMu      sync.Mutex
+	inbounds        map[string]*inbound
+	inboundSettings *settings.InboundsSettings
+	started         bool
+	closed          bool
+
+	// 以下配置均可通过 OnSettingsUpdate 热重载，为 nil 表示未启用
+	auth          atomic.Pointer[proxyAuth]
+	proxyProtocol atomic.Pointer[proxyProtocolPolicy]
+	sniffing      atomic.Bool
+	rejectBody    atomic.Pointer[string] // REJECT 规则拒绝 HTTP 请求时 403 应答的正文
+
+	// fakeIP 将发往 Fake-IP 的目标还原为域名，为 nil 表示不启用
+	fakeIP   *fakeip.Pool
+	resolver *resolver.Resolver
+	limiter  *limiter.Limiter
+	guard    *guard.Guard
+	shaper   *shaper.Shaper
+	meter    *traffic.Meter
+	dialer   backendDialer
+
+	// tracker 执行连接的空闲超时和存活时间。所有连接的 context 都派生自 baseCtx，
+	// 排空超时后调用 forceClose 关闭仍未结束的连接
+	tracker    *conntrack.Tracker
+	baseCtx    context.Context
+	forceClose context.CancelFunc
+}
+
+// Deps 是网关的可选协作者，Gateway 和 TransparentGateway 共用，TransparentGateway 不使用 Guard。
+type Deps struct {
+	FakeIP   *fakeip.Pool       // 不为 nil 时，发往 Fake-IP 的流量按对应的域名路由
+	Resolver *resolver.Resolver // 解析直连目标
+	Limiter  *limiter.Limiter   // 执行连接上限，为 nil 时不限制
+	Guard    *guard.Guard       // 握手保护，为 nil 时使用默认配置
+	Shaper   *shaper.Shaper     // 对转发的 TCP 连接限速，为 nil 时不限速
+	Meter    *traffic.Meter     // 统计转发的流量，为 nil 时不统计
+	Tracker  *conntrack.Tracker // 执行连接超时和关闭时的排空，为 nil 时使用默认超时
 }
 
-func New(listenPort int, dispatcher types.Dispatcher, failureReporter types.FailureReporter) *Gateway {
-	return &Gateway{
+// New 创建统一端口网关。initialSettings 提供 "gateway"、"auth" 和 "inbounds" 模块的初始配置，deps 提供可选的协作者。
+func New(listenPort int, initialSettings *settings.RuntimeSettings, dispatcher types.Dispatcher, failureReporter types.FailureReporter, deps Deps) *Gateway {
+	if deps.Guard == nil {
+		deps.Guard = guard.New(nil)
+	}
+	if deps.Tracker == nil {
+		deps.Tracker = conntrack.New(nil, nil, nil)
+	}
+	baseCtx, forceClose := context.WithCancel(context.Background())
+	g := &Gateway{
 		listenPort:      listenPort,
 		dispatcher:      dispatcher,
 		failureReporter: failureReporter,
-		directConn:      NewDirectStrategy(),
-		rejectConn:      NewRejectStrategy(),
+		directConn:      NewDirectStrategy(deps.Resolver, deps.Shaper, deps.Meter),
+		rejectConns:     newRejectStrategies(),
+		inbounds:        make(map[string]*inbound),
+		inboundSettings: initialSettings.Inbounds,
+		fakeIP:          deps.FakeIP,
+		resolver:        deps.Resolver,
+		limiter:         deps.Limiter,
+		guard:           deps.Guard,
+		shaper:          deps.Shaper,
+		meter:           deps.Meter,
+		dialer:          backendDialer{failureReporter: failureReporter, limiter: deps.Limiter, shaper: deps.Shaper, meter: deps.Meter},
+		tracker:         deps.Tracker,
+		baseCtx:         baseCtx,
+		forceClose:      forceClose,
+	}
+	g.auth.Store(newProxyAuth(initialSettings.Auth))
+	g.proxyProtocol.Store(newProxyProtocolPolicy(initialSettings.Gateway))
+	rejectBody := ""
+	if initialSettings.Gateway != nil {
+		g.sniffing.Store(initialSettings.Gateway.Sniffing)
+		rejectBody = initialSettings.Gateway.RejectHTTPBody
 	}
+	g.rejectBody.Store(&rejectBody)
+	return g
 }
 
-func (g *Gateway) Start() error {
-	listenAddr := fmt.Sprintf("0.0.0.0:%d", g.listenPort)
-	listener, err := net.Listen("tcp", listenAddr)
-	if err != nil {
-		return fmt.Errorf("gateway failed to listen on %s: %w", listenAddr, err)
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。
+// 新的配置只对之后建立的连接生效。
+func (g *Gateway) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	switch moduleKey {
+	case "gateway":
+		cfg, ok := newSettings.(*settings.GatewaySettings)
+		if !ok {
+			return fmt.Errorf("gateway: received incorrect settings type for gateway module")
+		}
+		g.proxyProtocol.Store(newProxyProtocolPolicy(cfg))
+		g.sniffing.Store(cfg.Sniffing)
+		g.rejectBody.Store(&cfg.RejectHTTPBody)
+		log.Info().
+			Bool("proxy_protocol", cfg.ProxyProtocol).
+			Strs("trusted_cidrs", cfg.ProxyProtocolTrustedCIDRs).
+			Bool("sniffing", cfg.Sniffing).
+			Msg("Gateway: Gateway settings have been reloaded.")
+	case "auth":
+		cfg, ok := newSettings.(*settings.AuthSettings)
+		if !ok {
+			return fmt.Errorf("gateway: received incorrect settings type for auth module")
+		}
+		g.auth.Store(newProxyAuth(cfg))
+		log.Info().Bool("enabled", cfg.Enabled).Int("users", len(cfg.Users)).Msg("Gateway: Authentication settings have been reloaded.")
+	case "inbounds":
+		cfg, ok := newSettings.(*settings.InboundsSettings)
+		if !ok {
+			return fmt.Errorf("gateway: received incorrect settings type for inbounds module")
+		}
+		g.inboundsMu.Lock()
+		g.inboundSettings = cfg
+		started := g.started
+		g.inboundsMu.Unlock()
+		if started {
+			if err := g.applyInbounds(g.effectiveInbounds(cfg)); err != nil {
+				return fmt.Errorf("gateway: failed to apply inbounds: %w", err)
+			}
+			log.Info().Int("inbounds", len(cfg.Inbounds)).Msg("Gateway: Inbound settings have been reloaded.")
+		}
 	}
-	g.listener = listener
-	logger.Info().Str("listen_addr", listener.Addr().String()).Msg(">>> Gateway is listening on unified port.")
-
-	g.waitGroup.Add(1)
-	go g.acceptLoop()
 	return nil
 }
 
-func (g *Gateway) acceptLoop() {
+// Start 启动所有配置的入口。未配置 inbounds 且 unified_port 为 0 时网关不监听任何端口。
+func (g *Gateway) Start() error {
+	g.inboundsMu.Lock()
+	g.started = true
+	cfg := g.inboundSettings
+	g.inboundsMu.Unlock()
+
+	inbounds := g.effectiveInbounds(cfg)
+	if len(inbounds) == 0 {
+		log.Warn().Msg("Gateway is disabled.")
+		return nil
+	}
+	return g.applyInbounds(inbounds)
+}
+
+func (g *Gateway) acceptLoop(in *inbound) {
 	defer g.waitGroup.Done()
 	for {
-		conn, err := g.listener.Accept()
+		conn, err := in.listener.Accept()
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok && strings.Contains(opErr.Err.Error(), "use of closed network connection") {
-				logger.Info().Msg("Gateway listener is closing.")
+				log.Info().Str("inbound", in.name).Msg("Gateway listener is closing.")
 				return
 			}
-			logger.Warn().Err(err).Msg("Gateway failed to accept connection")
+			log.Warn().Err(err).Str("inbound", in.name).Msg("Gateway failed to accept connection")
 			continue
 		}
 		g.waitGroup.Add(1)
-		go g.handleConnection(conn)
+		go g.handleConnection(conn, in)
 	}
 }
 
-func (g *Gateway) handleConnection(inboundConn net.Conn) {
+func (g *Gateway) handleConnection(conn net.Conn, in *inbound) {
 	defer g.waitGroup.Done()
-	defer inboundConn.Close()
 
 	// 1. 生成 Trace ID 并创建带上下文的 logger
 	traceID := uuid.NewString()
-	l := log.With().Str("trace_id", traceID).Logger()
-	ctx := l.WithContext(context.Background())
+	traceLog := logger.ContextLogger().Str("trace_id", traceID).Str("inbound", in.name).Logger()
+	// 连接从此受空闲超时和最长存活时间约束，关闭条目即关闭入站连接和它的上游连接
+	entry, ctx := g.tracker.Open(traceLog.WithContext(g.baseCtx), traceID, conn)
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	defer entry.Close()
+	inboundConn := entry.Conn()
 	clientIP := inboundConn.RemoteAddr().String()
-	inboundReader := bufio.NewReader(inboundConn)
+	session, ctx := traffic.NewSession(ctx)
+	defer logConnectionEnd(ctx, clientIP, session)
+	entry.SetInbound(in.name, session)
 
-	// 2. 嗅探目标和协议
-	targetDest, proto, _, err := sniffTargetForRouting(inboundConn, inboundReader)
+	// 被封禁的 IP 直接关闭，同一 IP 同时进行中的握手数受限
+	endHandshake, err := g.guard.BeginHandshake(inboundConn.RemoteAddr())
 	if err != nil {
+		l.Debug().Err(err).Str("client_ip", clientIP).Msg("Gateway: Connection refused by guard")
+		return
+	}
+	defer endHandshake()
+	// HTTP 请求头和 TLS ClientHello 必须完整地缓冲在 reader 中
+	maxHeaderBytes := g.guard.MaxHeaderBytes()
+	inboundReader := newInboundReader(inboundConn, maxHeaderBytes)
+
+	// 占用全局和客户端连接配额，超出上限时按配置排队或直接关闭
+	if g.limiter != nil {
+		release, err := g.limiter.AcquireClient(ctx, inboundConn.RemoteAddr())
+		if err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("Gateway: Connection rejected by limits")
+			return
+		}
+		defer release()
+	}
+	// 握手超时从取得连接配额后开始计算，排队的时间不计入
+	handshakeTimeout := g.guard.HandshakeTimeout()
+	inboundConn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+
+	// 2. 检查入口是否允许该协议，在任何握手应答之前拒绝
+	if err := fillBuffer(inboundReader, 1); err != nil {
 		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Could not determine target")
+		g.strike(inboundConn, err)
+		return
+	}
+	firstByte, _ := inboundReader.Peek(1)
+	proto := detectProtocol(firstByte[0])
+	metrics.GatewayAccepted.Inc(in.name, string(proto))
+	if !in.allows(proto) {
+		l.Warn().Str("client_ip", clientIP).Str("proto", string(proto)).Msg("Gateway: Protocol is not allowed on this inbound")
+		return
+	}
+
+	// 3. 嗅探目标和协议
+	targetDest, proto, user, err := sniffTargetForRouting(inboundConn, inboundReader, maxHeaderBytes, g.auth.Load())
+	if err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Could not determine target")
+		g.strike(inboundConn, err)
+		return
+	}
+	// 握手完成，之后的数据读取不再受握手超时约束
+	inboundConn.SetReadDeadline(time.Time{})
+	endHandshake()
+	if proto == ProtoTLS {
+		targetDest = in.tlsTarget(targetDest)
+	}
+	// 客户端使用了 Fake-IP 模式的 DNS 应答，还原为域名后再路由和建立隧道
+	if targetDest, err = g.fakeIP.MapTarget(targetDest); err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Gateway: Could not map fake IP target")
+		return
+	}
+	l.Debug().Str("proto", string(proto)).Str("client_ip", clientIP).Str("user", user).Str("target", targetDest).Msg("Gateway: Sniffed target for routing")
+	entry.SetTarget(string(proto), targetDest)
+	rc := &types.RoutingContext{User: user, Inbound: in.name, Target: in.config.Load().Target}
+	ctx = types.WithRoutingContext(ctx, rc)
+
+	// 普通 HTTP 请求在 keep-alive 连接上逐个路由，没有连接级别的单一目标
+	if proto == ProtoHTTP && !isHttpConnect(inboundReader) {
+		g.serveHttpForward(ctx, inboundConn, inboundReader)
+		return
+	}
+
+	// UDP ASSOCIATE 没有单一目标，路由决策按数据报的目标逐一进行
+	if proto == ProtoSOCKS5UDP {
+		g.handleSocks5UDPAssociate(ctx, inboundConn, inboundReader)
 		return
 	}
-	l.Debug().Str("proto", string(proto)).Str("client_ip", clientIP).Str("target", targetDest).Msg("Gateway: Sniffed target for routing")
 
-	// 3. Dispatcher 获取后端地址，传递 context
-	backendAddr, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), targetDest)
+	// 4. 可选的域名嗅探: 目标为 IP 时先应答客户端，再从首个数据包中取得 SNI 或 Host
+	acknowledged := false
+	if g.sniffing.Load() && proto != ProtoTLS && isIPTarget(targetDest) {
+		if err := acknowledgeClient(proto, inboundConn, inboundReader); err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to acknowledge client before sniffing")
+			return
+		}
+		acknowledged = true
+		if host := sniffPayloadDomain(inboundConn, inboundReader, maxHeaderBytes, handshakeTimeout); host != "" {
+			rc.SniffedHost = host
+			l.Debug().Str("target", targetDest).Str("sniffed_host", host).Msg("Gateway: Sniffed domain from payload")
+		}
+	}
+
+	// 5. Dispatcher 选择策略实例，传递 context
+	backend, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), targetDest)
 	if err != nil {
 		l.Warn().Err(err).Str("client_ip", clientIP).Str("target", targetDest).Msg("Gateway: Dispatcher returned error")
+		entry.SetCloseReason(conntrack.CloseNoRoute)
 		return
 	}
+	if rc.OverrideDestination {
+		_, port, _ := net.SplitHostPort(targetDest)
+		l.Debug().Str("target", targetDest).Str("sniffed_host", rc.SniffedHost).Msg("Gateway: Overriding destination with sniffed domain")
+		targetDest = net.JoinHostPort(rc.SniffedHost, port)
+	}
+	entry.SetRoute(rc, targetDest, serverID)
 
-	// 4. 直接策略或拒绝策略
-	switch backendAddr {
+	// 6. 直接策略或拒绝策略
+	switch serverID {
 	case "DIRECT":
-		targetNetAddr, _ := net.ResolveTCPAddr("tcp", targetDest)
-		g.directConn.Handle(inboundConn, inboundReader, targetNetAddr)
+		// 直连策略自行拨号，这里先乐观地完成与客户端的代理握手
+		if !acknowledged {
+			if err := acknowledgeClient(proto, inboundConn, inboundReader); err != nil {
+				l.Warn().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to acknowledge client for DIRECT")
+				return
+			}
+		}
+		g.directConn.Handle(ctx, inboundConn, inboundReader, targetDest)
 		return
 	case "REJECT":
-		targetNetAddr, _ := net.ResolveTCPAddr("tcp", targetDest)
-		g.rejectConn.Handle(inboundConn, inboundReader, targetNetAddr)
+		// 已应答的客户端无法再收到协议层面的拒绝应答
+		if !acknowledged && rejectModeOf(rc) == settings.RejectModeReply {
+			if err := g.writeRejectReply(proto, inboundConn); err != nil {
+				l.Debug().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to send reject reply")
+			}
+		}
+		g.rejectConns[rejectModeOf(rc)].Handle(ctx, inboundConn, inboundReader, targetDest)
 		return
 	}
 
-	// 5. 根据协议透传
+	// 7. 根据协议透传。已应答的客户端只剩应用层数据，按 TCP 直接转发
+	if acknowledged {
+		g.forwardTCP(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
+		return
+	}
 	switch proto {
+	case ProtoSOCKS4:
+		g.forwardSocks4(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
 	case ProtoSOCKS5:
-		g.forwardSocks5(inboundConn, inboundReader, targetDest, backendAddr, serverID)
+		g.forwardSocks5(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
 	case ProtoHTTP:
-		g.handleHttpProxy(ctx, inboundConn, inboundReader, targetDest, backendAddr, serverID)
+		g.handleHttpConnect(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
 	case ProtoTLS:
-		g.forwardTCP(inboundConn, inboundReader, targetDest, backendAddr, serverID)
+		// SNI 代理: 经策略建立到 SNI:port 的隧道，缓冲中的 ClientHello 随后原样重放
+		g.forwardTCP(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
 	default:
 		l.Warn().Str("client_ip", clientIP).Msg("Unsupported protocol")
 	}
 }
 
-func sniffTargetForRouting(conn net.Conn, reader *bufio.Reader) (target string, ptl Protocol, req *http.Request, err error) {
+// acknowledgeClient 完成与客户端的代理协议握手并回复成功，使后续数据流只包含应用层数据。
+// TLS 和普通 HTTP 请求没有握手阶段，数据保持在 reader 中原样转发。
+func acknowledgeClient(proto Protocol, inboundConn net.Conn, inboundReader *bufio.Reader) error {
+	switch proto {
+	case ProtoSOCKS4:
+		return writeSocks4Reply(inboundConn, socks4ReplyGranted)
+	case ProtoSOCKS5:
+		return writeSocks5Reply(inboundConn, socks5ReplySucceeded)
+	case ProtoHTTP:
+		if isHttpConnect(inboundReader) {
+			if err := discardHttpHeader(inboundReader); err != nil {
+				return err
+			}
+			_, err := inboundConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRejectReply 在代理握手阶段告知客户端目标被规则拒绝，客户端因此不会重试或一直等待。
+// 原始 TLS 连接没有可用的拒绝应答，由 RejectStrategy 直接关闭。
+func (g *Gateway) writeRejectReply(proto Protocol, inboundConn net.Conn) error {
+	switch proto {
+	case ProtoSOCKS4:
+		return writeSocks4Reply(inboundConn, socks4ReplyRejected)
+	case ProtoSOCKS5:
+		return writeSocks5Reply(inboundConn, socks5ReplyNotAllowed)
+	case ProtoHTTP:
+		return writeHttpForbidden(inboundConn, *g.rejectBody.Load())
+	}
+	return nil
+}
+
+// backendDialer 经策略实例建立 TCP 上游连接，Gateway 和 TransparentGateway 共用。
+type backendDialer struct {
+	failureReporter types.FailureReporter
+	limiter         *limiter.Limiter
+	shaper          *shaper.Shaper
+	meter           *traffic.Meter
+}
+
+// dial 通过策略实例建立到目标的连接，并向 failureReporter 报告结果。连接关联到 ctx 所属的跟踪条目，
+// 按客户端 source、ctx 中的认证用户和 serverID 的带宽规则限速，转发的流量计入 meter。
+func (d backendDialer) dial(ctx context.Context, source net.Addr, backend types.TunnelStrategy, network, target, serverID string) (net.Conn, error) {
+	conn, err := dialStrategy(ctx, backend, network, target, serverID, d.failureReporter, d.limiter)
+	if err != nil {
+		return nil, err
+	}
+	return d.meter.WrapConn(ctx, d.shaper.WrapConn(conn, source, routingUser(ctx), serverID), source, serverID), nil
+}
+
+// logConnectionEnd 在入站连接结束时记录它的路由结果、经上游连接转发的字节数和平均吞吐率。
+func logConnectionEnd(ctx context.Context, clientIP string, session *traffic.Session) {
+	stats := session.Stats()
+	duration := session.Duration()
+	serverID, rule := session.Route()
+	seconds := max(duration.Seconds(), 1e-3)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
+		Str("client_ip", clientIP).
+		Str("server_id", serverID).
+		Str("rule", rule).
+		Uint64("bytes_up", stats.Upload).
+		Uint64("bytes_down", stats.Download).
+		Float64("up_rate", float64(stats.Upload)/seconds).
+		Float64("down_rate", float64(stats.Download)/seconds).
+		Dur("duration", duration).
+		Msg("Gateway: Connection closed.")
+}
+
+// routingUser 返回 ctx 中 RoutingContext 的认证用户名，未认证时为空。
+func routingUser(ctx context.Context) string {
+	if rc := types.RoutingContextFrom(ctx); rc != nil {
+		return rc.User
+	}
+	return ""
+}
+
+// dialStrategy 经策略实例拨号。limits 不为 nil 时连接占用该后端的配额，直到连接被关闭；
+// 后端已满时返回 limiter.ErrBackendFull，不计入后端的失败次数。
+func dialStrategy(ctx context.Context, backend types.TunnelStrategy, network, target, serverID string, failureReporter types.FailureReporter, limits *limiter.Limiter) (net.Conn, error) {
+	var release func()
+	if limits != nil {
+		var err error
+		if release, err = limits.AcquireBackend(serverID); err != nil {
+			conntrack.FromContext(ctx).SetCloseReason(conntrack.CloseDialError)
+			return nil, err
+		}
+	}
+	start := time.Now()
+	conn, err := backend.DialContext(ctx, network, target)
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		if ctx.Err() == nil {
+			metrics.DialErrors.Inc(serverID)
+			conntrack.FromContext(ctx).SetCloseReason(conntrack.CloseDialError)
+			if failureReporter != nil {
+				failureReporter.ReportFailure(serverID)
+			}
+		}
+		return nil, err
+	}
+	metrics.TunnelSetupSeconds.Observe(time.Since(start).Seconds(), serverID)
+	if failureReporter != nil {
+		failureReporter.ReportSuccess(serverID)
+	}
+	if release != nil {
+		conn = limiter.WrapConn(conn, release)
+	}
+	return conntrack.FromContext(ctx).Attach(conn, backend), nil
+}
+
+// relay 在客户端与上游连接之间双向转发数据，直到两个方向都结束。
+// inboundReader 可能包含已从客户端预读的数据。返回上行和下行的字节数。
+// 转发期间连接处于 established 阶段，一个方向结束后进入 half-closed 阶段，空闲超时随之改变。
+func relay(ctx context.Context, inboundConn net.Conn, inboundReader io.Reader, outboundConn net.Conn) (int64, int64) {
+	entry := conntrack.FromContext(ctx)
+	entry.SetState(conntrack.StateEstablished)
+	var wg sync.WaitGroup
+	var upBytes, downBytes int64
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		upBytes, _ = io.Copy(outboundConn, inboundReader)
+		if tcpConn, ok := outboundConn.(interface{ CloseWrite() error }); ok {
+			tcpConn.CloseWrite()
+		}
+		entry.SetState(conntrack.StateHalfClosed)
+	}()
+
+	go func() {
+		defer wg.Done()
+		downBytes, _ = io.Copy(inboundConn, outboundConn)
+		if tcpConn, ok := inboundConn.(interface{ CloseWrite() error }); ok {
+			tcpConn.CloseWrite()
+		}
+		entry.SetState(conntrack.StateHalfClosed)
+	}()
+
+	wg.Wait()
+	return upBytes, downBytes
+}
+
+// detectProtocol 根据首字节判断客户端使用的协议。
+func detectProtocol(firstByte byte) Protocol {
+	switch {
+	case firstByte == 0x04: // SOCKS4/SOCKS4a
+		return ProtoSOCKS4
+	case firstByte == 0x05: // SOCKS5
+		return ProtoSOCKS5
+	case firstByte == 0x16: // TLS ClientHello
+		return ProtoTLS
+	case firstByte >= 'A' && firstByte <= 'Z': // HTTP Methods (GET, POST, CONNECT, etc.)
+		return ProtoHTTP
+	default:
+		return ProtoUnknown
+	}
+}
+
+// sniffTargetForRouting 嗅探协议和目标地址。auth 不为 nil 时同时完成代理认证并返回用户名；
+// SOCKS4 和原始 TLS 流量无法携带凭据，启用认证后将被拒绝。HTTP 请求头不能超过 maxHeaderBytes。
+func sniffTargetForRouting(conn net.Conn, reader *bufio.Reader, maxHeaderBytes int, auth *proxyAuth) (target string, ptl Protocol, user string, err error) {
 	// 确保至少有一个字节可供嗅探
-	if err := fillBuffer(conn, reader, 1); err != nil {
-		return "", ProtoUnknown, nil, fmt.Errorf("failed to read initial byte: %w", err)
+	if err := fillBuffer(reader, 1); err != nil {
+		return "", ProtoUnknown, "", fmt.Errorf("failed to read initial byte: %w", err)
 	}
 	firstByte, _ := reader.Peek(1)
 
-	switch {
-	case firstByte[0] == 0x05: // SOCKS5
-		target, err := sniffTargetSocks5(conn, reader)
-		return target, ProtoSOCKS5, nil, err
-	case firstByte[0] == 0x16: // TLS ClientHello
-		host, tlsErr := sniffTargetTLS(conn, reader)
+	switch detectProtocol(firstByte[0]) {
+	case ProtoSOCKS4:
+		// SOCKS4 只有 USERID 没有密码，无法满足网关认证
+		if auth != nil {
+			_ = writeSocks4Reply(conn, socks4ReplyRejected)
+			return "", ProtoSOCKS4, "", fmt.Errorf("SOCKS4 is not accepted while proxy authentication is enabled")
+		}
+		target, err := sniffTargetSocks4(conn, reader)
+		return target, ProtoSOCKS4, "", err
+	case ProtoSOCKS5:
+		target, cmd, user, err := sniffTargetSocks5(conn, reader, auth)
+		if err == nil && cmd == socks5CmdUDPAssociate {
+			return target, ProtoSOCKS5UDP, user, nil
+		}
+		return target, ProtoSOCKS5, user, err
+	case ProtoTLS:
+		if auth != nil {
+			return "", ProtoTLS, "", fmt.Errorf("raw TLS is not accepted while proxy authentication is enabled")
+		}
+		host, tlsErr := sniffTargetTLS(reader)
 		if tlsErr == nil && host != "" {
-			return host, ProtoTLS, nil, nil
+			return host, ProtoTLS, "", nil
+		}
+		return "", ProtoUnknown, "", fmt.Errorf("TLS SNI sniff failed: %w", tlsErr)
+	case ProtoHTTP:
+		host, request, httpErr := sniffTargetHTTP(conn, reader, maxHeaderBytes)
+		if httpErr != nil || host == "" {
+			return "", ProtoUnknown, "", fmt.Errorf("HTTP sniff failed: %w", httpErr)
 		}
-		return "", ProtoUnknown, nil, fmt.Errorf("TLS SNI sniff failed: %w", tlsErr)
-	case firstByte[0] >= 'A' && firstByte[0] <= 'Z': // HTTP Methods (GET, POST, CONNECT, etc.)
-		host, request, httpErr := sniffTargetHTTP(conn, reader)
-		if httpErr == nil && host != "" {
-			return host, ProtoHTTP, request, nil
+		if auth != nil {
+			user, authErr := auth.verifyHTTP(request)
+			if authErr != nil {
+				_ = writeHttpProxyAuthRequired(conn)
+				return "", ProtoHTTP, "", fmt.Errorf("HTTP proxy authentication failed: %w", authErr)
+			}
+			return host, ProtoHTTP, user, nil
 		}
-		return "", ProtoUnknown, nil, fmt.Errorf("HTTP sniff failed: %w", httpErr)
+		return host, ProtoHTTP, "", nil
 	default:
-		return "", ProtoUnknown, nil, fmt.Errorf("could not determine target protocol, initial byte: 0x%02x", firstByte[0])
+		return "", ProtoUnknown, "", fmt.Errorf("could not determine target protocol, initial byte: 0x%02x", firstByte[0])
 	}
 }
 
 // sniffTargetTLS 被动嗅探 TLS ClientHello 中的 SNI (Server Name Indication)
-func sniffTargetTLS(conn net.Conn, reader *bufio.Reader) (string, error) {
+func sniffTargetTLS(reader *bufio.Reader) (string, error) {
 	// 确保缓冲区至少有5个字节 (TLS Record Header)
-	if err := fillBuffer(conn, reader, 5); err != nil {
+	if err := fillBuffer(reader, 5); err != nil {
 		return "", err
 	}
 	header, _ := reader.Peek(5)
@@ -176,9 +595,13 @@ func sniffTargetTLS(conn net.Conn, reader *bufio.Reader) (string, error) {
 
 	recordLen := int(binary.BigEndian.Uint16(header[3:5]))
 	totalHelloLen := 5 + recordLen
+	// 超出协议上限的记录无法完整缓冲，放弃嗅探
+	if totalHelloLen > maxTLSRecordBytes {
+		return "", errTLSRecordTooLarge
+	}
 
-	if err := fillBuffer(conn, reader, totalHelloLen); err != nil {
-		return "", fmt.Errorf("buffer does not contain full TLS ClientHello")
+	if err := fillBuffer(reader, totalHelloLen); err != nil {
+		return "", fmt.Errorf("buffer does not contain full TLS ClientHello: %w", err)
 	}
 
 	data, _ := reader.Peek(totalHelloLen)
@@ -250,139 +673,97 @@ func sniffTargetTLS(conn net.Conn, reader *bufio.Reader) (string, error) {
 	return "", fmt.Errorf("SNI not found")
 }
 
-// forwardTCP 是一个通用的 L4 TCP 转发器
-func (g *Gateway) forwardTCP(inboundConn net.Conn, inboundReader *bufio.Reader, target string, backendAddr string, serverID string) {
-	outboundConn, err := net.Dial("tcp", backendAddr)
+// forwardTCP 是一个通用的 L4 TCP 转发器，inboundReader 中已缓冲的数据 (如 ClientHello) 会先被发往上游
+func (g *Gateway) forwardTCP(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, target string, backend types.TunnelStrategy, serverID string) {
+	outboundConn, err := g.dialer.dial(ctx, inboundConn.RemoteAddr(), backend, "tcp", target, serverID)
 	if err != nil {
-		logger.Error().Err(err).Str("backend_addr", backendAddr).Msg("Gateway: Failed to dial backend for TLS")
-		if g.failureReporter != nil {
-			g.failureReporter.ReportFailure(serverID)
-		}
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).Str("target", target).Str("server_id", serverID).Msg("Gateway: Failed to dial backend")
 		return
 	}
-
-	if g.failureReporter != nil {
-		g.failureReporter.ReportSuccess(serverID)
-	}
 	defer outboundConn.Close()
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-	clientAddr := inboundConn.RemoteAddr().String()
-	remoteAddr := outboundConn.RemoteAddr().String()
-
-	go func() {
-		defer wg.Done()
-		bytesCopied, err := io.Copy(outboundConn, inboundReader)
-		logger.Info().Str("trace", "PIPE-FORWARD-TCP").Str("direction", "Client -> Backend").
-			Str("client_addr", clientAddr).Str("backend_addr", remoteAddr).Int64("bytes", bytesCopied).Err(err).Msg("Pipe finished")
-		if tcp, ok := outboundConn.(*net.TCPConn); ok {
-			tcp.CloseWrite()
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		bytesCopied, err := io.Copy(inboundConn, outboundConn)
-		logger.Info().Str("trace", "PIPE-FORWARD-TCP").Str("direction", "Backend -> Client").
-			Str("client_addr", clientAddr).Str("backend_addr", remoteAddr).Int64("bytes", bytesCopied).Err(err).Msg("Pipe finished")
-		if tcp, ok := inboundConn.(*net.TCPConn); ok {
-			tcp.CloseWrite()
-		}
-	}()
-	wg.Wait()
+	upBytes, downBytes := relay(ctx, inboundConn, inboundReader, outboundConn)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
+		Str("client_addr", inboundConn.RemoteAddr().String()).
+		Str("target", target).
+		Int64("bytes_up", upBytes).
+		Int64("bytes_down", downBytes).
+		Msg("Gateway: TCP session finished.")
 }
 
+// Close 排空网关: 停止接受新连接，等待现有连接结束，超过 drain 超时后强制关闭剩余的连接。drain 为 0 时一直等待。
 func (g *Gateway) Close() {
 	g.closeOnce.Do(func() {
-		if g.listener != nil {
-			g.listener.Close()
+		g.inboundsMu.Lock()
+		g.closed = true
+		for name, in := range g.inbounds {
+			in.listener.Close()
+			delete(g.inbounds, name)
+		}
+		g.inboundsMu.Unlock()
+		drainTimeout := g.tracker.DrainTimeout()
+		if !waitTimeout(&g.waitGroup, drainTimeout) {
+			log.Warn().Dur("drain_timeout", drainTimeout).Msg("Gateway: Drain timed out, closing remaining connections.")
 		}
+		g.forceClose()
 		g.waitGroup.Wait()
 		log.Info().Msg("Gateway has been shut down")
 	})
 }
 
-func fillBuffer(conn net.Conn, reader *bufio.Reader, n int) error {
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-	defer conn.SetReadDeadline(time.Time{})
-	for reader.Buffered() < n {
-		_, err := reader.Peek(1)
-		if err != nil {
-			return err
-		}
+// waitTimeout 等待 wg 归零，最多等待 timeout，返回是否在超时前完成。timeout 为 0 表示一直等待。
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	if timeout <= 0 {
+		wg.Wait()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
 	}
-	return nil
 }
 
-// handleHttpProxy 实现了完整的 HTTP/HTTPS 代理逻辑。
-// 它将 HTTP 请求转换为后端的 SOCKS5 请求。
-func (g *Gateway) handleHttpProxy(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, targetDest, backendAddr, serverID string) {
-	logger.Debug().Str("targetDest:", targetDest).Msg("handleHttpProxy => ")
+// handleHttpConnect 处理 HTTP CONNECT 隧道。
+// 它通过策略实例建立到目标的连接，连接成功后回复 200 并双向透传。
+func (g *Gateway) handleHttpConnect(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, targetDest string, backend types.TunnelStrategy, serverID string) {
 	clientIP := inboundConn.RemoteAddr().String()
 
-	bufferedBytes, _ := inboundReader.Peek(inboundReader.Buffered())
-	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(bufferedBytes)))
+	backendConn, err := g.dialer.dial(ctx, inboundConn.RemoteAddr(), backend, "tcp", targetDest, serverID)
 	if err != nil {
-		logger.Error().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to re-parse HTTP request for proxy logic.")
-		inboundConn.Close()
-		return
-	}
-
-	backendConn, err := dialSocksProxy(backendAddr, targetDest, serverID, g.failureReporter)
-	if err != nil {
-		log.Ctx(ctx).Error().Err(err).
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).
 			Str("client_ip", clientIP).
 			Str("target", targetDest).
-			Str("backend", backendAddr).
 			Str("server_id", serverID).
-			Msg("Gateway: Failed to establish SOCKS tunnel. Reporting failure.")
+			Msg("Gateway: Failed to establish tunnel. Reporting failure.")
 
-		inboundConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
-		inboundConn.Close()
+		_ = writeHttpStatus(inboundConn, http.StatusBadGateway)
 		return
 	}
 	defer backendConn.Close()
 
-	if req.Method == "CONNECT" {
-		b := make([]byte, inboundReader.Buffered())
-		inboundReader.Read(b)
-		_, err = inboundConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
-		if err != nil {
-			logger.Error().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to send CONNECT OK response to client.")
-			return
-		}
-	} else {
-		bytesToWrite := make([]byte, inboundReader.Buffered())
-		n, _ := inboundReader.Read(bytesToWrite)
-		if _, err := backendConn.Write(bytesToWrite[:n]); err != nil {
-			logger.Error().Err(err).
-				Str("client_ip", clientIP).
-				Str("target", targetDest).
-				Msg("Gateway: Failed to forward initial HTTP request through tunnel.")
-			return
-		}
+	// CONNECT 请求头已被嗅探阶段完整缓冲，之后的数据属于隧道，随 reader 一起转发
+	if err := discardHttpHeader(inboundReader); err != nil {
+		return
+	}
+	if _, err := inboundConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).Str("client_ip", clientIP).Msg("Gateway: Failed to send CONNECT OK response to client.")
+		return
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		io.Copy(backendConn, inboundConn)
-		if tcpConn, ok := backendConn.(interface{ CloseWrite() error }); ok {
-			tcpConn.CloseWrite()
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		io.Copy(inboundConn, backendConn)
-		if tcpConn, ok := inboundConn.(interface{ CloseWrite() error }); ok {
-			tcpConn.CloseWrite()
-		}
-	}()
-
-	wg.Wait()
-	logger.Debug().Str("client_ip", clientIP).Str("target", targetDest).Msg("Gateway: HTTP proxy session finished.")
+	upBytes, downBytes := relay(ctx, inboundConn, inboundReader, backendConn)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
+		Str("client_addr", clientIP).
+		Str("target", targetDest).
+		Int64("bytes_up", upBytes).
+		Int64("bytes_down", downBytes).
+		Msg("Gateway: HTTP CONNECT session finished.")
 }
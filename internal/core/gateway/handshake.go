@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// maxTLSRecordBytes 是一个 TLS 记录的最大长度 (5 字节记录头加 16384 字节数据)。
+// 入站 reader 的缓冲区至少为这个大小，使 ClientHello 的首个记录总能完整地缓冲，不受 max_header_bytes 的限制。
+const maxTLSRecordBytes = 5 + 16384
+
+var (
+	// errHandshakeTooLarge 表示 HTTP 请求头超出了 max_header_bytes。
+	errHandshakeTooLarge = errors.New("handshake exceeds the maximum header size")
+	// errTLSRecordTooLarge 表示 TLS 记录的长度超出了协议允许的最大值，此时放弃嗅探，不算违规。
+	errTLSRecordTooLarge = errors.New("TLS record exceeds the maximum record size")
+)
+
+// newInboundReader 为入站连接创建 reader，缓冲区能容纳 maxHeaderBytes 的请求头和一个完整的 TLS 记录。
+func newInboundReader(conn net.Conn, maxHeaderBytes int) *bufio.Reader {
+	return bufio.NewReaderSize(conn, max(maxHeaderBytes, maxTLSRecordBytes))
+}
+
+// fillBuffer 等待 reader 中至少缓冲 n 个字节。读取超时由调用方在连接上设置的握手截止时间决定。
+func fillBuffer(reader *bufio.Reader, n int) error {
+	if n > reader.Size() {
+		return errHandshakeTooLarge
+	}
+	_, err := reader.Peek(n)
+	return err
+}
+
+// peekHttpHeader 等待 reader 中缓冲完整的 HTTP 请求头 (以空行结束) 并返回它，数据保留在 reader 中。
+// 请求头超过 limit 或 reader 的缓冲区大小时返回 errHandshakeTooLarge。
+func peekHttpHeader(reader *bufio.Reader, limit int) ([]byte, error) {
+	limit = min(limit, reader.Size())
+	scanned := 0
+	for {
+		// 超出 limit 的部分即使已经缓冲也不计入请求头
+		data, _ := reader.Peek(min(reader.Buffered(), limit))
+		// 与 net/http 一致，也接受只用 LF 分行的请求头
+		for i := scanned; i < len(data); i++ {
+			if data[i] != '\n' {
+				continue
+			}
+			if bytes.HasPrefix(data[i+1:], []byte("\n")) {
+				return data[:i+2], nil
+			}
+			if bytes.HasPrefix(data[i+1:], []byte("\r\n")) {
+				return data[:i+3], nil
+			}
+		}
+		// 末尾的两个字节可能是尚未收全的空行，下一轮从这里重新检查
+		scanned = max(len(data)-2, 0)
+		if len(data) >= limit {
+			return nil, errHandshakeTooLarge
+		}
+		if _, err := reader.Peek(len(data) + 1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// handshakeViolation 将握手错误归类为违规原因。客户端正常断开和无法嗅探的超长 TLS 记录不算违规，返回空字符串。
+func handshakeViolation(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, errTLSRecordTooLarge):
+		return ""
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "handshake timeout"
+	case errors.Is(err, errHandshakeTooLarge):
+		return "oversized handshake"
+	default:
+		return "malformed handshake"
+	}
+}
+
+// strike 在握手失败属于违规时为客户端记一次违规，累计达到阈值后该 IP 被封禁。
+func (g *Gateway) strike(conn net.Conn, err error) {
+	if reason := handshakeViolation(err); reason != "" {
+		g.guard.Strike(conn.RemoteAddr(), reason)
+	}
+}
@@ -3,24 +3,33 @@ package gateway
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"golang.org/x/net/http/httpguts"
+	"io"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
 	"net"
 	"net/http"
+	"net/textproto"
 	"strings"
+	"time"
 )
 
 // sniffTargetHTTP 嗅探 Host 并确保始终返回 host:port 格式。
-func sniffTargetHTTP(conn net.Conn, reader *bufio.Reader) (string, *http.Request, error) {
-	buffered := reader.Buffered()
-	if buffered == 0 {
-		// 确保至少有一个字节可供读取
-		if _, err := reader.Peek(1); err != nil {
-			return "", nil, fmt.Errorf("failed to peek for http sniff: %w", err)
+// 完整的请求头不能超过 maxHeaderBytes，否则返回 errHandshakeTooLarge。
+func sniffTargetHTTP(conn net.Conn, reader *bufio.Reader, maxHeaderBytes int) (string, *http.Request, error) {
+	data, err := peekHttpHeader(reader, maxHeaderBytes)
+	if err != nil {
+		if errors.Is(err, errHandshakeTooLarge) {
+			_ = writeHttpStatus(conn, http.StatusRequestHeaderFieldsTooLarge)
 		}
-		buffered = reader.Buffered()
+		return "", nil, fmt.Errorf("failed to read http request header: %w", err)
 	}
-
-	data, _ := reader.Peek(buffered)
 	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
 		return "", nil, fmt.Errorf("could not parse HTTP request: %w", err)
@@ -42,3 +51,373 @@ func sniffTargetHTTP(conn net.Conn, reader *bufio.Reader) (string, *http.Request
 
 	return host, req, nil
 }
+
+// discardHttpHeader 从 reader 中取走已缓冲的请求头，紧随其后的数据 (如客户端抢先发送的 TLS ClientHello) 保留在 reader 中。
+func discardHttpHeader(reader *bufio.Reader) error {
+	header, err := peekHttpHeader(reader, reader.Size())
+	if err != nil {
+		return err
+	}
+	_, err = reader.Discard(len(header))
+	return err
+}
+
+// isHttpConnect 判断 reader 中缓冲的请求是否为 CONNECT 请求。
+func isHttpConnect(reader *bufio.Reader) bool {
+	method, _ := reader.Peek(len("CONNECT "))
+	return string(method) == "CONNECT "
+}
+
+// hopByHopHeaders 是 RFC 7230 第 6.1 节定义的逐跳首部，以及常见的非标准 Proxy-Connection，
+// 它们只对当前这一跳有意义，代理转发前必须移除。
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// httpUpstream 是 keep-alive 连接上当前复用的上游连接。目标或路由结果变化时重新拨号。
+type httpUpstream struct {
+	target   string
+	serverID string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// maxBytesBody 记录请求体是否超出了上限。Request.Write 会包装请求体的读取错误，
+// 调用方无法再从返回的错误中识别 http.MaxBytesError。
+type maxBytesBody struct {
+	io.ReadCloser
+	tooLarge bool
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.tooLarge = true
+	}
+	return n, err
+}
+
+// writeHttpStatus 向客户端发送一个不带正文的错误应答，并要求客户端关闭连接。
+func writeHttpStatus(conn net.Conn, code int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
+	return err
+}
+
+// writeHttpForbidden 向客户端发送 403 应答，body 为纯文本正文，可以为空。
+func writeHttpForbidden(conn net.Conn, body string) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// writeHttpProxyAuthRequired 向客户端发送 407 质询。
+func writeHttpProxyAuthRequired(conn net.Conn) error {
+	_, err := conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"liuproxy\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	return err
+}
+
+// httpRequestTarget 返回请求的目标 host:port。absolute-form 以 URL 中的 host 为准，否则使用 Host 首部。
+func httpRequestTarget(req *http.Request) (string, error) {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		return "", fmt.Errorf("HTTP request host is empty")
+	}
+	if req.URL.Scheme != "" && req.URL.Scheme != "http" {
+		return "", fmt.Errorf("unsupported scheme %q in forward proxy request", req.URL.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	}
+	return host, nil
+}
+
+// isUpgradeRequest 判断请求是否要求协议升级 (如 WebSocket)。
+func isUpgradeRequest(header http.Header) bool {
+	return header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(header["Connection"], "Upgrade")
+}
+
+// removeHopByHopHeaders 移除逐跳首部，以及 Connection 首部中列出的其他首部。
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// serveHttpForward 作为 RFC 7230 正向代理处理同一连接上的普通 HTTP 请求。
+// 每个请求都单独经 Dispatcher 路由，请求行从 absolute-form 改写为 origin-form 并移除逐跳首部；
+// 目标和路由结果不变时复用上游连接。升级请求在上游返回 101 后转为双向透传。
+func (g *Gateway) serveHttpForward(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader) {
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	clientIP := inboundConn.RemoteAddr().String()
+	var upstream *httpUpstream
+	defer func() {
+		if upstream != nil {
+			upstream.conn.Close()
+		}
+	}()
+
+	// 首个请求头已完整到达，之后连接上的等待和转发都按 established 阶段的空闲超时计算
+	conntrack.FromContext(ctx).SetState(conntrack.StateEstablished)
+	for {
+		req, err := g.readHttpRequest(inboundConn, inboundReader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				l.Debug().Err(err).Str("client_ip", clientIP).Msg("HTTP: Failed to read request")
+				g.strike(inboundConn, err)
+			}
+			return
+		}
+		if !g.forwardHttpRequest(ctx, inboundConn, inboundReader, req, &upstream) {
+			return
+		}
+	}
+}
+
+// readHttpRequest 读取 keep-alive 连接上的下一个请求。等待请求到来只受连接的空闲超时限制，
+// 但请求头一旦开始传输就必须在 handshake_timeout 内完整到达，且不能超过 max_header_bytes。
+func (g *Gateway) readHttpRequest(inboundConn net.Conn, inboundReader *bufio.Reader) (*http.Request, error) {
+	if _, err := inboundReader.Peek(1); err != nil {
+		return nil, err
+	}
+	inboundConn.SetReadDeadline(time.Now().Add(g.guard.HandshakeTimeout()))
+	defer inboundConn.SetReadDeadline(time.Time{})
+	if _, err := peekHttpHeader(inboundReader, g.guard.MaxHeaderBytes()); err != nil {
+		if errors.Is(err, errHandshakeTooLarge) {
+			_ = writeHttpStatus(inboundConn, http.StatusRequestHeaderFieldsTooLarge)
+		}
+		return nil, err
+	}
+	req, err := http.ReadRequest(inboundReader)
+	if err != nil {
+		_ = writeHttpStatus(inboundConn, http.StatusBadRequest)
+		return nil, err
+	}
+	return req, nil
+}
+
+// forwardHttpRequest 路由并转发一个请求，返回客户端连接是否可以继续处理下一个请求。
+func (g *Gateway) forwardHttpRequest(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, req *http.Request, upstream **httpUpstream) bool {
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	clientIP := inboundConn.RemoteAddr().String()
+
+	// 1. 认证。客户端在 keep-alive 连接上的每个请求都会携带凭据，逐个校验
+	rc := types.RoutingContext{}
+	if base := types.RoutingContextFrom(ctx); base != nil {
+		rc = *base
+	}
+	if auth := g.auth.Load(); auth != nil {
+		user, err := auth.verifyHTTP(req)
+		if err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("HTTP: Proxy authentication failed")
+			_ = writeHttpProxyAuthRequired(inboundConn)
+			return false
+		}
+		rc.User = user
+	}
+	ctx = types.WithRoutingContext(ctx, &rc)
+
+	// 请求体上限: 声明的长度超限时直接拒绝，分块传输的请求体在转发过程中计数
+	if maxBody := g.guard.MaxRequestBodyBytes(); maxBody > 0 && req.Body != http.NoBody {
+		if req.ContentLength > maxBody {
+			l.Warn().Str("client_ip", clientIP).Int64("content_length", req.ContentLength).Msg("HTTP: Request body is too large")
+			_ = writeHttpStatus(inboundConn, http.StatusRequestEntityTooLarge)
+			return false
+		}
+		req.Body = &maxBytesBody{ReadCloser: http.MaxBytesReader(nil, req.Body, maxBody)}
+	}
+
+	target := req.Host
+	var err error
+	if req.Method != http.MethodConnect {
+		if target, err = httpRequestTarget(req); err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("HTTP: Invalid forward proxy request")
+			_ = writeHttpStatus(inboundConn, http.StatusBadRequest)
+			return false
+		}
+	}
+	if target, err = g.fakeIP.MapTarget(target); err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Gateway: Could not map fake IP target")
+		_ = writeHttpStatus(inboundConn, http.StatusBadGateway)
+		return false
+	}
+
+	// 2. 路由并取得上游连接
+	backend, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), target)
+	if err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Str("target", target).Msg("Gateway: Dispatcher returned error")
+		conntrack.FromContext(ctx).SetCloseReason(conntrack.CloseNoRoute)
+		_ = writeHttpStatus(inboundConn, http.StatusBadGateway)
+		return false
+	}
+	conntrack.FromContext(ctx).SetRoute(&rc, target, serverID)
+	if serverID == "REJECT" {
+		mode := rejectModeOf(&rc)
+		if mode == settings.RejectModeReply {
+			l.Debug().Str("client_ip", clientIP).Str("target", target).Msg("HTTP: [REJECT] Rejecting request.")
+			_ = writeHttpForbidden(inboundConn, *g.rejectBody.Load())
+			return false
+		}
+		g.rejectConns[mode].Handle(ctx, inboundConn, inboundReader, target)
+		return false
+	}
+	reused := *upstream != nil && (*upstream).target == target && (*upstream).serverID == serverID
+	if !reused {
+		if *upstream != nil {
+			(*upstream).conn.Close()
+			*upstream = nil
+		}
+		if *upstream, err = g.dialHttpUpstream(ctx, inboundConn.RemoteAddr(), backend, target, serverID); err != nil {
+			l.Error().Err(err).Str("client_ip", clientIP).Str("target", target).Str("server_id", serverID).Msg("HTTP: Failed to dial upstream")
+			_ = writeHttpStatus(inboundConn, http.StatusBadGateway)
+			return false
+		}
+	}
+	up := *upstream
+
+	// 3. keep-alive 中途的 CONNECT 请求，建立隧道后连接不再承载 HTTP 请求
+	if req.Method == http.MethodConnect {
+		if _, err := inboundConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			return false
+		}
+		relay(ctx, inboundConn, inboundReader, up.conn)
+		return false
+	}
+
+	// 4. 改写并发送请求
+	upgrade := ""
+	if isUpgradeRequest(req.Header) {
+		upgrade = req.Header.Get("Upgrade")
+	}
+	removeHopByHopHeaders(req.Header)
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// 避免 Request.Write 补上 Go 的默认 User-Agent
+		req.Header["User-Agent"] = []string{""}
+	}
+
+	resp, err := roundTripHttp(up, req)
+	if err != nil && reused && req.Body == http.NoBody {
+		// 复用的上游连接可能已被对端关闭，没有请求体的请求可以安全地重试一次
+		up.conn.Close()
+		if *upstream, err = g.dialHttpUpstream(ctx, inboundConn.RemoteAddr(), backend, target, serverID); err == nil {
+			up = *upstream
+			resp, err = roundTripHttp(up, req)
+		}
+	}
+	if err != nil {
+		if body, ok := req.Body.(*maxBytesBody); ok && body.tooLarge {
+			l.Warn().Str("client_ip", clientIP).Msg("HTTP: Request body is too large")
+			_ = writeHttpStatus(inboundConn, http.StatusRequestEntityTooLarge)
+			return false
+		}
+		l.Error().Err(err).Str("client_ip", clientIP).Str("target", target).Str("server_id", serverID).Msg("HTTP: Failed to forward request")
+		_ = writeHttpStatus(inboundConn, http.StatusBadGateway)
+		return false
+	}
+	defer resp.Body.Close()
+
+	// 5. 转发应答
+	if resp.StatusCode == http.StatusSwitchingProtocols && upgrade != "" {
+		if err := resp.Write(inboundConn); err != nil {
+			return false
+		}
+		// 上游可能在 101 之后立即发送数据，先交付已缓冲的部分
+		if n := up.reader.Buffered(); n > 0 {
+			buffered, _ := up.reader.Peek(n)
+			if _, err := inboundConn.Write(buffered); err != nil {
+				return false
+			}
+		}
+		upBytes, downBytes := relay(ctx, inboundConn, inboundReader, up.conn)
+		l.Debug().
+			Str("client_addr", clientIP).
+			Str("target", target).
+			Str("upgrade", upgrade).
+			Int64("bytes_up", upBytes).
+			Int64("bytes_down", downBytes).
+			Msg("HTTP: Upgraded session finished.")
+		return false
+	}
+
+	removeHopByHopHeaders(resp.Header)
+	if err := resp.Write(inboundConn); err != nil {
+		l.Debug().Err(err).Str("client_ip", clientIP).Str("target", target).Msg("HTTP: Failed to write response to client")
+		return false
+	}
+	l.Debug().
+		Str("client_addr", clientIP).
+		Str("method", req.Method).
+		Str("target", target).
+		Str("server_id", serverID).
+		Int("status", resp.StatusCode).
+		Msg("HTTP: Request forwarded.")
+	keepAlive := !req.Close && !resp.Close
+	if keepAlive {
+		// 连接还会承载下一个请求，这个请求单独写一条访问记录；最后一个请求随连接关闭记录
+		conntrack.FromContext(ctx).LogRequest()
+	}
+	return keepAlive
+}
+
+// dialHttpUpstream 按路由结果建立到目标的上游连接。DIRECT 由网关直接拨号，同样按带宽规则限速并统计流量。
+func (g *Gateway) dialHttpUpstream(ctx context.Context, source net.Addr, backend types.TunnelStrategy, target, serverID string) (*httpUpstream, error) {
+	var conn net.Conn
+	var err error
+	if serverID == "DIRECT" {
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		start := time.Now()
+		conn, err = g.resolver.DialContext(dialCtx, "tcp", target)
+		cancel()
+		if err != nil {
+			metrics.DialErrors.Inc(serverID)
+			conntrack.FromContext(ctx).SetCloseReason(conntrack.CloseDialError)
+		} else {
+			metrics.TunnelSetupSeconds.Observe(time.Since(start).Seconds(), serverID)
+			conn = g.meter.WrapConn(ctx, g.shaper.WrapConn(conn, source, routingUser(ctx), serverID), source, serverID)
+			conn = conntrack.FromContext(ctx).Attach(conn, nil)
+		}
+	} else {
+		conn, err = g.dialer.dial(ctx, source, backend, "tcp", target, serverID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &httpUpstream{target: target, serverID: serverID, conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// roundTripHttp 在上游连接上发送请求并读取最终应答，中间的 1xx 信息应答 (101 除外) 被跳过。
+func roundTripHttp(up *httpUpstream, req *http.Request) (*http.Response, error) {
+	if err := req.Write(up.conn); err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := http.ReadResponse(up.reader, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []string // 保留下来的首部名，已排序
+	}{
+		{
+			name: "standard hop-by-hop headers",
+			header: http.Header{
+				"Connection":          {"keep-alive"},
+				"Proxy-Connection":    {"keep-alive"},
+				"Keep-Alive":          {"timeout=5"},
+				"Proxy-Authorization": {"Basic dXNlcjpwYXNz"},
+				"Te":                  {"trailers"},
+				"Trailer":             {"Expires"},
+				"Transfer-Encoding":   {"chunked"},
+				"Upgrade":             {"websocket"},
+				"Accept":              {"*/*"},
+				"Host":                {"example.com"},
+			},
+			want: []string{"Accept", "Host"},
+		},
+		{
+			name: "headers listed in Connection",
+			header: http.Header{
+				"Connection": {"X-Trace, close", " X-Debug "},
+				"X-Trace":    {"1"},
+				"X-Debug":    {"1"},
+				"X-Other":    {"1"},
+			},
+			want: []string{"X-Other"},
+		},
+		{
+			name:   "nothing to remove",
+			header: http.Header{"Accept": {"*/*"}, "Cookie": {"a=b"}},
+			want:   []string{"Accept", "Cookie"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removeHopByHopHeaders(tt.header)
+			var got []string
+			for name := range tt.header {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remaining headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpRequestTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		target  string
+		wantErr bool
+	}{
+		{"absolute-form", "GET http://example.com/path HTTP/1.1\r\nHost: other.com\r\n\r\n", "example.com:80", false},
+		{"absolute-form with port", "GET http://example.com:8080/ HTTP/1.1\r\n\r\n", "example.com:8080", false},
+		{"origin-form uses Host", "GET /path HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com:80", false},
+		{"IPv6 host", "GET http://[2001:db8::1]/ HTTP/1.1\r\n\r\n", "[2001:db8::1]:80", false},
+		{"https scheme", "GET https://example.com/ HTTP/1.1\r\n\r\n", "", true},
+		{"missing host", "GET /path HTTP/1.0\r\n\r\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tt.request)))
+			if err != nil {
+				t.Fatalf("failed to parse request: %v", err)
+			}
+			target, err := httpRequestTarget(req)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got target %q", target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+		})
+	}
+}
+
+func TestPeekHttpHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		limit   int
+		header  string
+		wantErr error
+	}{
+		{"CRLF", "GET / HTTP/1.1\r\nHost: a\r\n\r\nbody", 1024, "GET / HTTP/1.1\r\nHost: a\r\n\r\n", nil},
+		{"LF only", "GET / HTTP/1.1\nHost: a\n\nbody", 1024, "GET / HTTP/1.1\nHost: a\n\n", nil},
+		{"exactly at the limit", "GET / HTTP/1.1\r\n\r\n", 18, "GET / HTTP/1.1\r\n\r\n", nil},
+		{"over the limit", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", 20, "", errHandshakeTooLarge},
+		{"incomplete header", "GET / HTTP/1.1\r\nHost: a\r\n", 1024, "", io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 逐字节到达的数据检验空行跨越两次读取的情况
+			reader := bufio.NewReader(iotest.OneByteReader(strings.NewReader(tt.input)))
+			header, err := peekHttpHeader(reader, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(header) != tt.header {
+				t.Errorf("header = %q, want %q", header, tt.header)
+			}
+			// 请求头仍留在 reader 中
+			if reader.Buffered() < len(header) {
+				t.Errorf("header was consumed from the reader")
+			}
+		})
+	}
+}
+
+func TestSniffTargetHTTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		target  string
+		reply   string
+		wantErr bool
+	}{
+		{"CONNECT defaults to 443", "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com:443", "", false},
+		{"CONNECT with port", "CONNECT example.com:8443 HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "example.com:8443", "", false},
+		{"GET defaults to 80", "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com:80", "", false},
+		{"header too large", "GET / HTTP/1.1\r\nHost: example.com\r\nX-Pad: " + strings.Repeat("a", 64) + "\r\n\r\n", "", "HTTP/1.1 431 ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordConn{}
+			reader := bufio.NewReader(strings.NewReader(tt.request))
+			target, _, err := sniffTargetHTTP(conn, reader, 64)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got target %q", target)
+				}
+				if !strings.HasPrefix(conn.written.String(), tt.reply) {
+					t.Errorf("reply = %q, want prefix %q", conn.written.String(), tt.reply)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+		})
+	}
+}
+
+// pipeStrategy 是测试用的后端，DialContext 返回 dial 给出的连接。
+type pipeStrategy struct {
+	dial func() (net.Conn, error)
+}
+
+func (s *pipeStrategy) Initialize() error                               { return nil }
+func (s *pipeStrategy) GetType() string                                 { return "pipe" }
+func (s *pipeStrategy) CloseTunnel()                                    {}
+func (s *pipeStrategy) GetListenerInfo() *types.ListenerInfo            { return nil }
+func (s *pipeStrategy) GetMetrics() *types.Metrics                      { return nil }
+func (s *pipeStrategy) UpdateServer(profile *types.ServerProfile) error { return nil }
+func (s *pipeStrategy) CheckHealth() error                              { return nil }
+func (s *pipeStrategy) SupportsUDP() bool                               { return false }
+func (s *pipeStrategy) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	return s.dial()
+}
+
+// 客户端在 CONNECT 请求头之后立即发送的数据 (如 TLS ClientHello) 属于隧道，必须原样转发给后端。
+func TestHandleHttpConnectForwardsEarlyData(t *testing.T) {
+	const request = "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"
+	const earlyData = "\x16\x03\x01\x00\x05hello"
+
+	client, inbound := net.Pipe()
+	backendSide, upstream := net.Pipe()
+	defer client.Close()
+	defer backendSide.Close()
+	backend := &pipeStrategy{dial: func() (net.Conn, error) { return upstream, nil }}
+
+	go client.Write([]byte(request + earlyData))
+	reader := bufio.NewReader(inbound)
+	target, _, err := sniffTargetHTTP(inbound, reader, 4096)
+	if err != nil || target != "example.com:443" {
+		t.Fatalf("sniffTargetHTTP() = %q, %v", target, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		(&Gateway{}).handleHttpConnect(context.Background(), inbound, reader, target, backend, "server1")
+		inbound.Close()
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	backendSide.SetDeadline(time.Now().Add(5 * time.Second))
+	const established = "HTTP/1.1 200 Connection Established\r\n\r\n"
+	reply := make([]byte, len(established))
+	if _, err := io.ReadFull(client, reply); err != nil || string(reply) != established {
+		t.Fatalf("reply = %q, %v", reply, err)
+	}
+	got := make([]byte, len(earlyData))
+	if _, err := io.ReadFull(backendSide, got); err != nil || string(got) != earlyData {
+		t.Fatalf("backend received %q, %v; want the early data %q", got, err, earlyData)
+	}
+
+	// 隧道双向透传
+	go backendSide.Write([]byte("server hello"))
+	got = make([]byte, len("server hello"))
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "server hello" {
+		t.Fatalf("client received %q, %v", got, err)
+	}
+	client.Close()
+	backendSide.Close()
+	<-done
+}
+
+func TestHandleHttpConnectDialFailure(t *testing.T) {
+	conn := &recordConn{}
+	reader := bufio.NewReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	backend := &pipeStrategy{dial: func() (net.Conn, error) { return nil, errors.New("unreachable") }}
+
+	(&Gateway{}).handleHttpConnect(context.Background(), conn, reader, "example.com:443", backend, "server1")
+	if !strings.HasPrefix(conn.written.String(), "HTTP/1.1 502 ") {
+		t.Errorf("reply = %q, want a 502 response", conn.written.String())
+	}
+}
@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultInboundName 是未配置 inbounds 时，由 unified_port 生成的入口名称。
+const DefaultInboundName = "default"
+
+// TransparentInboundName 是透明代理接收的连接在路由规则中使用的入口名称。
+const TransparentInboundName = "transparent"
+
+// defaultTLSPort 是 SNI 代理在入口未配置 tls_port 时使用的目标端口。
+const defaultTLSPort = 443
+
+// inbound 是一个正在监听的命名入口。
+// 绑定地址变化时需要重建监听器；允许的协议和强制目标可以原地替换。
+type inbound struct {
+	name       string
+	listenAddr string
+	listener   net.Listener
+	config     atomic.Pointer[settings.Inbound]
+}
+
+// allows 报告该入口是否接受指定协议。未配置协议列表时接受全部协议。
+func (in *inbound) allows(proto Protocol) bool {
+	cfg := in.config.Load()
+	if len(cfg.Protocols) == 0 {
+		return true
+	}
+	name := inboundProtocolName(proto)
+	for _, p := range cfg.Protocols {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsTarget 将 ClientHello 中的 SNI 映射为目标地址。
+// 被 DNS 劫持到网关的客户端连接的是网关的端口，真实的目标端口由入口的 tls_port 决定。
+func (in *inbound) tlsTarget(sni string) string {
+	port := in.config.Load().TLSPort
+	if port <= 0 {
+		port = defaultTLSPort
+	}
+	return net.JoinHostPort(sni, strconv.Itoa(port))
+}
+
+// inboundProtocolName 将嗅探得到的协议映射为 inbounds 配置中使用的协议名。
+func inboundProtocolName(proto Protocol) string {
+	switch proto {
+	case ProtoSOCKS4:
+		return "socks4"
+	case ProtoSOCKS5, ProtoSOCKS5UDP:
+		return "socks5"
+	case ProtoHTTP:
+		return "http"
+	case ProtoTLS:
+		return "tls"
+	default:
+		return strings.ToLower(string(proto))
+	}
+}
+
+// resolveListenAddr 将入口配置转换为 host:port。listen 可以是 IP 地址、网卡名或留空 (0.0.0.0)。
+// 使用网卡名时绑定该网卡的第一个地址，优先 IPv4。
+func resolveListenAddr(cfg *settings.Inbound) (string, error) {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return "", fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	host := strings.Trim(cfg.Listen, "[]")
+	switch {
+	case host == "":
+		host = "0.0.0.0"
+	case net.ParseIP(host) != nil:
+	default:
+		iface, err := net.InterfaceByName(host)
+		if err != nil {
+			return "", fmt.Errorf("listen address %q is neither an IP nor an interface: %w", cfg.Listen, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", fmt.Errorf("failed to get addresses of interface %s: %w", iface.Name, err)
+		}
+		var chosen net.IP
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				chosen = ipNet.IP
+				break
+			}
+			if chosen == nil && !ipNet.IP.IsLinkLocalUnicast() {
+				chosen = ipNet.IP
+			}
+		}
+		if chosen == nil {
+			return "", fmt.Errorf("interface %s has no usable address", iface.Name)
+		}
+		host = chosen.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.Port)), nil
+}
+
+// effectiveInbounds 返回实际生效的入口列表。未配置任何入口时回退为 unified_port 上的 "default" 入口。
+func (g *Gateway) effectiveInbounds(cfg *settings.InboundsSettings) []*settings.Inbound {
+	if cfg != nil && len(cfg.Inbounds) > 0 {
+		return cfg.Inbounds
+	}
+	if g.listenPort <= 0 {
+		return nil
+	}
+	return []*settings.Inbound{{Name: DefaultInboundName, Port: g.listenPort}}
+}
+
+// applyInbounds 使正在监听的入口与配置保持一致: 关闭被删除或绑定地址变化的入口，启动新的入口，
+// 其余入口原地更新协议和强制目标。已建立的连接不受影响。
+func (g *Gateway) applyInbounds(cfgs []*settings.Inbound) error {
+	g.inboundsMu.Lock()
+	defer g.inboundsMu.Unlock()
+	if g.closed {
+		return nil
+	}
+
+	var errs []error
+	desired := make(map[string]*settings.Inbound, len(cfgs))
+	desiredAddrs := make(map[string]string, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg == nil || cfg.Name == "" {
+			errs = append(errs, fmt.Errorf("inbound without a name is ignored"))
+			continue
+		}
+		if _, dup := desired[cfg.Name]; dup {
+			errs = append(errs, fmt.Errorf("duplicate inbound name %q is ignored", cfg.Name))
+			continue
+		}
+		addr, err := resolveListenAddr(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inbound %q: %w", cfg.Name, err))
+			continue
+		}
+		if cfg.TLSPort < 0 || cfg.TLSPort > 65535 {
+			errs = append(errs, fmt.Errorf("inbound %q: invalid tls_port %d", cfg.Name, cfg.TLSPort))
+			continue
+		}
+		// 保存副本，监听器持有的配置与配置快照相互独立
+		cfgCopy := *cfg
+		desired[cfg.Name] = &cfgCopy
+		desiredAddrs[cfg.Name] = addr
+	}
+
+	for name, in := range g.inbounds {
+		if addr, ok := desiredAddrs[name]; !ok || addr != in.listenAddr {
+			in.listener.Close()
+			delete(g.inbounds, name)
+		}
+	}
+
+	for name, cfg := range desired {
+		if in, ok := g.inbounds[name]; ok {
+			in.config.Store(cfg)
+			continue
+		}
+		in, err := g.startInbound(cfg, desiredAddrs[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inbound %q: %w", name, err))
+			continue
+		}
+		g.inbounds[name] = in
+	}
+	return errors.Join(errs...)
+}
+
+// startInbound 在 listenAddr 上启动一个入口的监听和 accept 循环。调用方需持有 inboundsMu。
+func (g *Gateway) startInbound(cfg *settings.Inbound, listenAddr string) (*inbound, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("gateway failed to listen on %s: %w", listenAddr, err)
+	}
+	in := &inbound{
+		name:       cfg.Name,
+		listenAddr: listenAddr,
+		// 始终包装监听器，是否解析 PROXY 头由 connPolicy 按当前配置逐连接决定，以支持热重载
+		listener: newProxyProtocolListener(listener, g.connPolicy),
+	}
+	in.config.Store(cfg)
+	log.Info().
+		Str("inbound", cfg.Name).
+		Str("listen_addr", listener.Addr().String()).
+		Strs("protocols", cfg.Protocols).
+		Str("target", cfg.Target).
+		Msg(">>> Gateway inbound is listening.")
+
+	g.waitGroup.Add(1)
+	go g.acceptLoop(in)
+	return in, nil
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"github.com/pires/go-proxyproto"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// proxyProtocolReadTimeout 是等待受信任上游发送 PROXY 头的最长时间。
+const proxyProtocolReadTimeout = 5 * time.Second
+
+// proxyProtocolPolicy 保存允许发送 PROXY 头的上游网段。为 nil 表示未启用。
+type proxyProtocolPolicy struct {
+	trusted []netip.Prefix
+}
+
+// newProxyProtocolPolicy 根据 gateway 配置构建策略。未启用时返回 nil。
+func newProxyProtocolPolicy(cfg *settings.GatewaySettings) *proxyProtocolPolicy {
+	if cfg == nil || !cfg.ProxyProtocol {
+		return nil
+	}
+	policy := &proxyProtocolPolicy{}
+	for _, cidr := range cfg.ProxyProtocolTrustedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			// 允许直接填写单个 IP
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				log.Warn().Err(err).Str("cidr", cidr).Msg("Gateway: Ignoring invalid PROXY protocol trusted CIDR.")
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		policy.trusted = append(policy.trusted, prefix.Masked())
+	}
+	if len(policy.trusted) == 0 {
+		log.Warn().Msg("Gateway: PROXY protocol is enabled but no trusted upstreams are configured, headers will not be accepted from anyone.")
+	}
+	return policy
+}
+
+func (p *proxyProtocolPolicy) trusts(upstream net.Addr) bool {
+	tcpAddr, ok := upstream.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// connPolicy 决定每个新连接如何处理 PROXY 头。
+// 受信任的上游可以发送 PROXY 头 (也可以不发送，以兼容负载均衡器的健康检查)；
+// 其他来源一律按普通连接处理，不解析 PROXY 头，避免客户端伪造源地址。
+func (g *Gateway) connPolicy(opts proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+	policy := g.proxyProtocol.Load()
+	if policy == nil || !policy.trusts(opts.Upstream) {
+		return proxyproto.SKIP, nil
+	}
+	return proxyproto.USE, nil
+}
+
+// proxyProtocolListener 包装 proxyproto.Listener，使其返回的连接保留网关所需的 TCP 语义。
+type proxyProtocolListener struct {
+	inner *proxyproto.Listener
+}
+
+func newProxyProtocolListener(listener net.Listener, policy proxyproto.ConnPolicyFunc) net.Listener {
+	return &proxyProtocolListener{inner: &proxyproto.Listener{
+		Listener:          listener,
+		ConnPolicy:        policy,
+		ReadHeaderTimeout: proxyProtocolReadTimeout,
+	}}
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.inner.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if pc, ok := conn.(*proxyproto.Conn); ok {
+		return &proxyProtocolConn{Conn: pc}, nil
+	}
+	return conn, nil
+}
+
+func (l *proxyProtocolListener) Close() error   { return l.inner.Close() }
+func (l *proxyProtocolListener) Addr() net.Addr { return l.inner.Addr() }
+
+// proxyProtocolConn 的 RemoteAddr 返回 PROXY 头中的真实客户端地址。
+type proxyProtocolConn struct {
+	*proxyproto.Conn
+}
+
+// LocalAddr 返回网关自身的本地地址，而不是 PROXY 头中负载均衡器的前端地址，
+// 以便 UDP ASSOCIATE 等需要在本机绑定地址的功能正常工作。
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	return c.Raw().LocalAddr()
+}
+
+// CloseWrite 支持半关闭，relay 依赖它通知对端数据已发送完毕。
+func (c *proxyProtocolConn) CloseWrite() error {
+	if tcpConn, ok := c.TCPConn(); ok {
+		return tcpConn.CloseWrite()
+	}
+	return nil
+}
+
+// SetLinger 作用于底层 TCP 连接，REJECT 的 "reject-tcp-reset" 方式依赖它发送 RST。
+func (c *proxyProtocolConn) SetLinger(sec int) error {
+	if tcpConn, ok := c.TCPConn(); ok {
+		return tcpConn.SetLinger(sec)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"io"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+func TestProxyProtocolPolicyTrusts(t *testing.T) {
+	policy := newProxyProtocolPolicy(&settings.GatewaySettings{
+		ProxyProtocol:             true,
+		ProxyProtocolTrustedCIDRs: []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8", "not-a-cidr"},
+	})
+	tests := []struct {
+		upstream net.Addr
+		want     bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 1000}, true}, // IPv4 映射地址按 IPv4 匹配
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 1000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.6"), Port: 1000}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fd12::1"), Port: 1000}, true},
+		{&net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1000}, false},
+		{&net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1000}, false},
+	}
+	for _, tt := range tests {
+		if got := policy.trusts(tt.upstream); got != tt.want {
+			t.Errorf("trusts(%s %s) = %v, want %v", tt.upstream.Network(), tt.upstream, got, tt.want)
+		}
+	}
+
+	if p := newProxyProtocolPolicy(&settings.GatewaySettings{ProxyProtocolTrustedCIDRs: []string{"0.0.0.0/0"}}); p != nil {
+		t.Errorf("disabled PROXY protocol should produce a nil policy")
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	v2Header := func() []byte {
+		header := &proxyproto.Header{
+			Version:           2,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.TCPv6,
+			SourceAddr:        &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000},
+			DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+		}
+		data, err := header.Format()
+		if err != nil {
+			t.Fatalf("failed to format PROXY v2 header: %v", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		trusted []string
+		header  []byte
+		remote  string // 期望的 RemoteAddr，为空表示连接的真实源地址
+		data    string // 期望读到的数据
+	}{
+		{
+			name:    "v1 from trusted upstream",
+			trusted: []string{"127.0.0.0/8"},
+			header:  []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 443\r\n"),
+			remote:  "203.0.113.7:51000",
+			data:    "payload",
+		},
+		{
+			name:    "v2 from trusted upstream",
+			trusted: []string{"127.0.0.1"},
+			header:  v2Header(),
+			remote:  "[2001:db8::7]:51000",
+			data:    "payload",
+		},
+		{
+			name:    "trusted upstream without header",
+			trusted: []string{"127.0.0.0/8"},
+			data:    "payload",
+		},
+		{
+			name:    "header from untrusted upstream is passed through",
+			trusted: []string{"10.0.0.0/8"},
+			header:  []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 443\r\n"),
+			data:    "PROXY TCP4 203.0.113.7 10.0.0.1 51000 443\r\npayload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := &Gateway{}
+			g.proxyProtocol.Store(newProxyProtocolPolicy(&settings.GatewaySettings{ProxyProtocol: true, ProxyProtocolTrustedCIDRs: tt.trusted}))
+			listener := newProxyProtocolListener(raw, g.connPolicy)
+			defer listener.Close()
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			go func() {
+				client.Write(append(tt.header, "payload"...))
+				client.(*net.TCPConn).CloseWrite()
+			}()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("data = %q, want %q", data, tt.data)
+			}
+			wantRemote := tt.remote
+			if wantRemote == "" {
+				wantRemote = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, wantRemote)
+			}
+			// 本地地址始终是网关自身监听的地址
+			if got := conn.LocalAddr().String(); got != client.RemoteAddr().String() {
+				t.Errorf("LocalAddr() = %s, want %s", got, client.RemoteAddr())
+			}
+		})
+	}
+}
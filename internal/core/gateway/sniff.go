@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// sniffPayloadTimeout 是等待客户端发送首个数据包的最长时间。
+// 服务器先发言的协议 (如 SSH、SMTP) 不会有客户端数据，超时后按原始目标路由。
+const sniffPayloadTimeout = 300 * time.Millisecond
+
+// isIPTarget 判断 host:port 形式的目标是否为 IP 地址。
+func isIPTarget(target string) bool {
+	host, _, err := net.SplitHostPort(target)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+// sniffPayloadDomain 从客户端的首个数据包中嗅探 TLS ClientHello 的 SNI 或 HTTP Host 首部。
+// 数据保留在 reader 中原样转发；无法识别或超时时返回空字符串。
+// 首个数据包到达后，其余的 ClientHello 或请求头必须在 handshakeTimeout 内收全，请求头不能超过 maxHeaderBytes。
+func sniffPayloadDomain(conn net.Conn, reader *bufio.Reader, maxHeaderBytes int, handshakeTimeout time.Duration) string {
+	defer conn.SetReadDeadline(time.Time{})
+	conn.SetReadDeadline(time.Now().Add(sniffPayloadTimeout))
+	firstByte, err := reader.Peek(1)
+	if err != nil {
+		return ""
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+
+	var host string
+	switch detectProtocol(firstByte[0]) {
+	case ProtoTLS:
+		host, _ = sniffTargetTLS(reader)
+	case ProtoHTTP:
+		if target, _, err := sniffTargetHTTP(conn, reader, maxHeaderBytes); err == nil {
+			host, _, _ = net.SplitHostPort(target)
+		}
+	}
+	// 域名才有意义，Host 首部本身就是 IP 时不做替换
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"strconv"
+)
+
+// SOCKS4 应答码
+const (
+	socks4ReplyGranted  byte = 0x5A
+	socks4ReplyRejected byte = 0x5B
+)
+
+// socks4MaxFieldLen 限制 USERID 和 SOCKS4a 域名的长度，防止客户端无限发送不带结束符的数据。
+const socks4MaxFieldLen = 255
+
+// sniffTargetSocks4 解析 SOCKS4/SOCKS4a 的 CONNECT 请求并从 reader 中取走，应答留给拨号完成之后。
+// SOCKS4a 以 0.0.0.x (x != 0) 作为 DSTIP，真实的域名跟在 USERID 之后。
+func sniffTargetSocks4(conn net.Conn, reader *bufio.Reader) (string, error) {
+	const headerSize = 8
+	if err := fillBuffer(reader, headerSize); err != nil {
+		return "", err
+	}
+	header, _ := reader.Peek(headerSize)
+	if header[0] != 0x04 {
+		return "", fmt.Errorf("not a SOCKS4 request")
+	}
+	if header[1] != 0x01 {
+		_ = writeSocks4Reply(conn, socks4ReplyRejected)
+		return "", fmt.Errorf("unsupported SOCKS4 command: %d", header[1])
+	}
+	port := int(binary.BigEndian.Uint16(header[2:4]))
+	dstIP := net.IPv4(header[4], header[5], header[6], header[7])
+	isSocks4a := header[4] == 0 && header[5] == 0 && header[6] == 0 && header[7] != 0
+
+	// USERID 仅用于兼容，不参与认证
+	_, offset, err := peekNullTerminated(reader, headerSize)
+	if err != nil {
+		return "", err
+	}
+
+	host := dstIP.String()
+	if isSocks4a {
+		var domain string
+		domain, offset, err = peekNullTerminated(reader, offset)
+		if err != nil {
+			return "", err
+		}
+		if domain == "" {
+			_ = writeSocks4Reply(conn, socks4ReplyRejected)
+			return "", fmt.Errorf("SOCKS4a request has an empty domain")
+		}
+		host = domain
+	}
+
+	if _, err := reader.Discard(offset); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// peekNullTerminated 从 offset 开始查找以 0x00 结尾的字段，返回字段内容和结束符之后的偏移量。
+func peekNullTerminated(reader *bufio.Reader, offset int) (string, int, error) {
+	for end := offset; end <= offset+socks4MaxFieldLen; end++ {
+		if err := fillBuffer(reader, end+1); err != nil {
+			return "", 0, err
+		}
+		data, _ := reader.Peek(end + 1)
+		if data[end] == 0x00 {
+			return string(data[offset:end]), end + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("SOCKS4 field exceeds %d bytes", socks4MaxFieldLen)
+}
+
+// writeSocks4Reply 向客户端发送 SOCKS4 应答，DSTPORT 和 DSTIP 字段被忽略，固定为 0。
+func writeSocks4Reply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{0x00, rep, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func (g *Gateway) forwardSocks4(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, target string, backend types.TunnelStrategy, serverID string) {
+	outboundConn, err := g.dialer.dial(ctx, inboundConn.RemoteAddr(), backend, "tcp", target, serverID)
+	if err != nil {
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).Str("target", target).Str("server_id", serverID).Msg("SOCKS4: Failed to dial backend")
+		_ = writeSocks4Reply(inboundConn, socks4ReplyRejected)
+		return
+	}
+	defer outboundConn.Close()
+
+	if err := writeSocks4Reply(inboundConn, socks4ReplyGranted); err != nil {
+		return
+	}
+
+	upBytes, downBytes := relay(ctx, inboundConn, inboundReader, outboundConn)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
+		Str("client_addr", inboundConn.RemoteAddr().String()).
+		Str("target", target).
+		Int64("bytes_up", upBytes).
+		Int64("bytes_down", downBytes).
+		Msg("SOCKS4: Session finished.")
+}
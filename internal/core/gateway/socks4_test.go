@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// recordConn 记录写入的数据，用于检查握手阶段发给客户端的应答。
+type recordConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) { return c.written.Write(p) }
+func (c *recordConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 50000}
+}
+
+func TestSniffTargetSocks4(t *testing.T) {
+	rejected := []byte{0x00, socks4ReplyRejected, 0, 0, 0, 0, 0, 0}
+	tests := []struct {
+		name    string
+		request []byte
+		target  string
+		wantErr bool
+		reply   []byte // 解析失败时应答给客户端的数据
+	}{
+		{
+			name:    "SOCKS4 with IPv4 target",
+			request: []byte("\x04\x01\x00\x50\x01\x02\x03\x04alice\x00"),
+			target:  "1.2.3.4:80",
+		},
+		{
+			name:    "SOCKS4 with empty user ID",
+			request: []byte("\x04\x01\x01\xbb\x0a\x00\x00\x01\x00"),
+			target:  "10.0.0.1:443",
+		},
+		{
+			name:    "SOCKS4a with domain",
+			request: []byte("\x04\x01\x01\xbb\x00\x00\x00\x01\x00example.com\x00"),
+			target:  "example.com:443",
+		},
+		{
+			name:    "SOCKS4a with user ID and domain",
+			request: []byte("\x04\x01\x00\x35\x00\x00\x00\xffbob\x00dns.example\x00"),
+			target:  "dns.example:53",
+		},
+		{
+			name:    "not SOCKS4",
+			request: []byte("\x05\x01\x00\x50\x01\x02\x03\x04\x00"),
+			wantErr: true,
+		},
+		{
+			name:    "BIND is rejected",
+			request: []byte("\x04\x02\x00\x50\x01\x02\x03\x04\x00"),
+			wantErr: true,
+			reply:   rejected,
+		},
+		{
+			name:    "SOCKS4a with empty domain",
+			request: []byte("\x04\x01\x00\x50\x00\x00\x00\x01\x00\x00"),
+			wantErr: true,
+			reply:   rejected,
+		},
+		{
+			name:    "user ID without terminator",
+			request: append([]byte("\x04\x01\x00\x50\x01\x02\x03\x04"), bytes.Repeat([]byte{'a'}, socks4MaxFieldLen+1)...),
+			wantErr: true,
+		},
+		{
+			name:    "truncated header",
+			request: []byte("\x04\x01\x00\x50\x01"),
+			wantErr: true,
+		},
+		{
+			name:    "truncated domain",
+			request: []byte("\x04\x01\x00\x50\x00\x00\x00\x01\x00example"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const payload = "payload"
+			conn := &recordConn{}
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.request), strings.NewReader(payload)))
+			if tt.wantErr {
+				// 截断的请求需要紧接着读到 EOF，因此出错的用例不附带负载
+				reader = bufio.NewReader(bytes.NewReader(tt.request))
+			}
+
+			target, err := sniffTargetSocks4(conn, reader)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got target %q", target)
+				}
+				if !bytes.Equal(conn.written.Bytes(), tt.reply) {
+					t.Errorf("reply = %x, want %x", conn.written.Bytes(), tt.reply)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+			if conn.written.Len() != 0 {
+				t.Errorf("reply written before dialing: %x", conn.written.Bytes())
+			}
+			// 请求被完整取走，之后的数据留给转发
+			rest, _ := io.ReadAll(reader)
+			if string(rest) != payload {
+				t.Errorf("remaining data = %q, want %q", rest, payload)
+			}
+		})
+	}
+}
@@ -2,140 +2,215 @@ package gateway
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/types"
 	"net"
 	"strconv"
-	"sync"
 )
 
-// 嗅探目标
-func sniffTargetSocks5(conn net.Conn, reader *bufio.Reader) (string, error) {
-	err := handleSocks5ClientHandshake(conn, reader)
+// SOCKS5 应答码 (RFC 1928)
+const (
+	socks5ReplySucceeded          byte = 0x00
+	socks5ReplyGeneralFailure     byte = 0x01
+	socks5ReplyNotAllowed         byte = 0x02 // connection not allowed by ruleset
+	socks5ReplyHostUnreachable    byte = 0x04
+	socks5ReplyCommandUnsupported byte = 0x07
+)
+
+// SOCKS5 认证方法
+const (
+	socks5MethodNoAuth       byte = 0x00
+	socks5MethodUserPass     byte = 0x02
+	socks5MethodNoAcceptable byte = 0xFF
+)
+
+// SOCKS5 命令字
+const (
+	socks5CmdConnect      byte = 0x01
+	socks5CmdUDPAssociate byte = 0x03
+)
+
+// 嗅探目标。完成方法协商 (以及启用时的用户名/密码认证) 并从 reader 中取走 CONNECT 或 UDP ASSOCIATE 请求，应答留给后续处理。
+// 返回请求中的目标地址、命令字和认证得到的用户名；对 UDP ASSOCIATE 而言目标是客户端声明的发送地址，通常为 0.0.0.0:0。
+func sniffTargetSocks5(conn net.Conn, reader *bufio.Reader, auth *proxyAuth) (string, byte, string, error) {
+	user, err := handleSocks5ClientHandshake(conn, reader, auth)
 	if err != nil {
-		return "handleSocks5ClientHandshake ", err
+		return "", 0, "", err
 	}
 
 	reqHeaderSize := 4
-	if err := fillBuffer(conn, reader, reqHeaderSize); err != nil {
-		return "", err
+	if err := fillBuffer(reader, reqHeaderSize); err != nil {
+		return "", 0, "", err
 	}
 	reqHeader, _ := reader.Peek(reqHeaderSize)
-	if reqHeader[0] != 0x05 || reqHeader[1] != 0x01 {
-		return "", fmt.Errorf("not a SOCKS5 CONNECT request")
+	if reqHeader[0] != 0x05 {
+		return "", 0, "", fmt.Errorf("not a SOCKS5 request")
+	}
+	cmd := reqHeader[1]
+	if cmd != socks5CmdConnect && cmd != socks5CmdUDPAssociate {
+		_ = writeSocks5Reply(conn, socks5ReplyCommandUnsupported)
+		return "", cmd, "", fmt.Errorf("unsupported SOCKS5 command: %d", cmd)
 	}
 	addrType := reqHeader[3]
 	addrBodyOffset := reqHeaderSize
 	var host string
 	var port int
+	var requestLen int
 	switch addrType {
 	case 0x01: // IPv4
 		peekSize := addrBodyOffset + 4 + 2
-		if err := fillBuffer(conn, reader, peekSize); err != nil {
-			return "", err
+		if err := fillBuffer(reader, peekSize); err != nil {
+			return "", cmd, "", err
 		}
 		fullHeader, _ := reader.Peek(peekSize)
 		host = net.IP(fullHeader[addrBodyOffset : addrBodyOffset+4]).String()
 		port = int(binary.BigEndian.Uint16(fullHeader[addrBodyOffset+4 : addrBodyOffset+6]))
+		requestLen = peekSize
 	case 0x03: // Domain
 		peekSize := addrBodyOffset + 1
-		if err := fillBuffer(conn, reader, peekSize); err != nil {
-			return "", err
+		if err := fillBuffer(reader, peekSize); err != nil {
+			return "", cmd, "", err
 		}
 		lenHeader, _ := reader.Peek(peekSize)
 		domainLen := int(lenHeader[addrBodyOffset])
 		peekSize = addrBodyOffset + 1 + domainLen + 2
-		if err := fillBuffer(conn, reader, peekSize); err != nil {
-			return "", err
+		if err := fillBuffer(reader, peekSize); err != nil {
+			return "", cmd, "", err
 		}
 		fullHeader, _ := reader.Peek(peekSize)
 		host = string(fullHeader[addrBodyOffset+1 : addrBodyOffset+1+domainLen])
 		port = int(binary.BigEndian.Uint16(fullHeader[addrBodyOffset+1+domainLen : addrBodyOffset+1+domainLen+2]))
+		requestLen = peekSize
 	case 0x04: // IPv6
 		peekSize := addrBodyOffset + 16 + 2
-		if err := fillBuffer(conn, reader, peekSize); err != nil {
-			return "", err
+		if err := fillBuffer(reader, peekSize); err != nil {
+			return "", cmd, "", err
 		}
 		fullHeader, _ := reader.Peek(peekSize)
 		host = net.IP(fullHeader[addrBodyOffset : addrBodyOffset+16]).String()
 		port = int(binary.BigEndian.Uint16(fullHeader[addrBodyOffset+16 : addrBodyOffset+18]))
+		requestLen = peekSize
 	default:
-		return "", fmt.Errorf("unsupported SOCKS5 address type: %d", addrType)
+		return "", cmd, "", fmt.Errorf("unsupported SOCKS5 address type: %d", addrType)
+	}
+	if _, err := reader.Discard(requestLen); err != nil {
+		return "", cmd, "", err
 	}
-	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	return net.JoinHostPort(host, strconv.Itoa(port)), cmd, user, nil
 }
 
-func handleSocks5ClientHandshake(conn net.Conn, reader *bufio.Reader) error {
+// handleSocks5ClientHandshake 完成方法协商。启用认证时要求客户端使用用户名/密码方法并校验凭据，返回用户名。
+func handleSocks5ClientHandshake(conn net.Conn, reader *bufio.Reader, auth *proxyAuth) (string, error) {
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(reader, header); err != nil {
-		return err
+		return "", err
 	}
 	nMethods := int(header[1])
 	methods := make([]byte, nMethods)
 	if _, err := io.ReadFull(reader, methods); err != nil {
-		return err
+		return "", err
 	}
-	_, err := conn.Write([]byte{0x05, 0x00})
-	return err
+	if auth == nil {
+		_, err := conn.Write([]byte{0x05, socks5MethodNoAuth})
+		return "", err
+	}
+
+	if bytes.IndexByte(methods, socks5MethodUserPass) < 0 {
+		_, _ = conn.Write([]byte{0x05, socks5MethodNoAcceptable})
+		return "", fmt.Errorf("SOCKS5 client does not offer username/password authentication")
+	}
+	if _, err := conn.Write([]byte{0x05, socks5MethodUserPass}); err != nil {
+		return "", err
+	}
+	return handleSocks5UserPassAuth(conn, reader, auth)
 }
 
-func handleSocks5BackendHandshake(backendConn net.Conn, backendReader *bufio.Reader) error {
-	_, err := backendConn.Write([]byte{0x05, 0x01, 0x00})
+// handleSocks5UserPassAuth 处理 RFC 1929 用户名/密码子协商。
+func handleSocks5UserPassAuth(conn net.Conn, reader *bufio.Reader, auth *proxyAuth) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	if header[0] != 0x01 {
+		return "", fmt.Errorf("unsupported SOCKS5 auth version: %d", header[0])
+	}
+	username := make([]byte, int(header[1]))
+	if _, err := io.ReadFull(reader, username); err != nil {
+		return "", err
+	}
+	passwordLen, err := reader.ReadByte()
 	if err != nil {
-		return err
+		return "", err
 	}
-	resp := make([]byte, 2)
-	if _, err := io.ReadFull(backendReader, resp); err != nil {
-		return err
+	password := make([]byte, int(passwordLen))
+	if _, err := io.ReadFull(reader, password); err != nil {
+		return "", err
+	}
+
+	if !auth.verify(string(username), string(password)) {
+		_, _ = conn.Write([]byte{0x01, 0x01})
+		return "", fmt.Errorf("SOCKS5 authentication failed for user %q", username)
 	}
-	if resp[0] != 0x05 {
-		return fmt.Errorf("not a SOCKS5 CONNECT request")
+	if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+		return "", err
 	}
-	return nil
+	return string(username), nil
 }
 
-func (g *Gateway) forwardSocks5(inboundConn net.Conn, inboundReader *bufio.Reader, target string, backendAddr string, serverID string) {
-	outboundConn, err := net.Dial("tcp", backendAddr)
-	if err != nil {
-		logger.Error().Err(err).Str("backend_addr", backendAddr).Msg("SOCKS5: Failed to dial backend")
-		if g.failureReporter != nil {
-			g.failureReporter.ReportFailure(serverID)
+// writeSocks5Reply 向客户端发送 SOCKS5 应答，BND.ADDR 固定为 0.0.0.0:0。
+func writeSocks5Reply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{0x05, rep, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// writeSocks5ReplyAddr 向客户端发送携带 BND.ADDR 的 SOCKS5 应答，用于告知 UDP 中继地址。
+func writeSocks5ReplyAddr(conn net.Conn, rep byte, bindAddr *net.UDPAddr) error {
+	reply := []byte{0x05, rep, 0x00}
+	reply = appendSocks5Addr(reply, bindAddr.IP.String(), bindAddr.Port)
+	_, err := conn.Write(reply)
+	return err
+}
+
+// appendSocks5Addr 按 SOCKS5 的 ATYP + ADDR + PORT 格式编码地址，IP 以外的 host 编码为域名。
+func appendSocks5Addr(b []byte, host string, port int) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, 0x01)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, 0x04)
+			b = append(b, ip.To16()...)
 		}
-		return
+	} else {
+		b = append(b, 0x03, byte(len(host)))
+		b = append(b, host...)
 	}
-	if g.failureReporter != nil {
-		g.failureReporter.ReportSuccess(serverID)
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+func (g *Gateway) forwardSocks5(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, target string, backend types.TunnelStrategy, serverID string) {
+	outboundConn, err := g.dialer.dial(ctx, inboundConn.RemoteAddr(), backend, "tcp", target, serverID)
+	if err != nil {
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).Str("target", target).Str("server_id", serverID).Msg("SOCKS5: Failed to dial backend")
+		_ = writeSocks5Reply(inboundConn, socks5ReplyHostUnreachable)
+		return
 	}
 	defer outboundConn.Close()
-	outboundReader := bufio.NewReader(outboundConn)
-	if err := handleSocks5BackendHandshake(outboundConn, outboundReader); err != nil {
-		logger.Error().Err(err).Str("backend_addr", backendAddr).Msg("SOCKS5: backend handshake failed")
+
+	if err := writeSocks5Reply(inboundConn, socks5ReplySucceeded); err != nil {
 		return
 	}
-	var wg sync.WaitGroup
-	wg.Add(2)
-	clientAddr := inboundConn.RemoteAddr().String()
-	remoteAddr := outboundConn.RemoteAddr().String()
-
-	go func() {
-		defer wg.Done()
-		bytesCopied, err := io.Copy(outboundConn, inboundReader)
-		logger.Info().Str("trace", "PIPE-FORWARD-SOCKS5").Str("direction", "Client -> Backend").
-			Str("client_addr", clientAddr).Str("backend_addr", remoteAddr).Int64("bytes", bytesCopied).Err(err).Msg("Pipe finished")
-		if tcp, ok := outboundConn.(*net.TCPConn); ok {
-			tcp.CloseWrite()
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		bytesCopied, err := io.Copy(inboundConn, outboundReader)
-		logger.Info().Str("trace", "PIPE-FORWARD-SOCKS5").Str("direction", "Backend -> Client").
-			Str("client_addr", clientAddr).Str("backend_addr", remoteAddr).Int64("bytes", bytesCopied).Err(err).Msg("Pipe finished")
-		if tcp, ok := inboundConn.(*net.TCPConn); ok {
-			tcp.CloseWrite()
-		}
-	}()
-	wg.Wait()
+
+	upBytes, downBytes := relay(ctx, inboundConn, inboundReader, outboundConn)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
+		Str("client_addr", inboundConn.RemoteAddr().String()).
+		Str("target", target).
+		Int64("bytes_up", upBytes).
+		Int64("bytes_down", downBytes).
+		Msg("SOCKS5: Session finished.")
 }
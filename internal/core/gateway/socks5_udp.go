@@ -0,0 +1,307 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// socks5UDPAssociation 是一次 UDP ASSOCIATE 的运行时状态。
+// 客户端把带 SOCKS5 UDP 头的数据报发往 relayConn，每个目标对应 natTable 中的一条流，
+// 流在首个数据报到达时经 Dispatcher 路由，之后复用同一个上游连接直到空闲过期。
+// 每条流在连接表中单独跟踪，结束时写一条访问记录。
+type socks5UDPAssociation struct {
+	gateway   *Gateway
+	relayConn *net.UDPConn
+	natTable  *udpNatTable
+
+	// clientIP 来自 TCP 控制连接，只接受来自该地址的数据报
+	clientIP net.IP
+	// clientAddr 是最近一次收到数据报的源地址，回包发往这里
+	clientAddr atomic.Pointer[net.UDPAddr]
+	// entry 是 TCP 控制连接的跟踪条目。控制连接上没有数据，转发数据报即刷新它的活动时间
+	entry *conntrack.Entry
+}
+
+// handleSocks5UDPAssociate 为客户端在网关所在的地址上打开一个 UDP 中继端口，
+// 并在 TCP 控制连接存活期间转发数据报。控制连接关闭即结束关联。
+func (g *Gateway) handleSocks5UDPAssociate(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader) {
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	localAddr, _ := inboundConn.LocalAddr().(*net.TCPAddr)
+	remoteAddr, _ := inboundConn.RemoteAddr().(*net.TCPAddr)
+	if localAddr == nil || remoteAddr == nil {
+		_ = writeSocks5Reply(inboundConn, socks5ReplyGeneralFailure)
+		return
+	}
+
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localAddr.IP})
+	if err != nil {
+		l.Error().Err(err).Str("client_addr", remoteAddr.String()).Msg("SOCKS5 UDP: Failed to open relay socket")
+		_ = writeSocks5Reply(inboundConn, socks5ReplyGeneralFailure)
+		return
+	}
+	defer relayConn.Close()
+
+	bindAddr := relayConn.LocalAddr().(*net.UDPAddr)
+	if err := writeSocks5ReplyAddr(inboundConn, socks5ReplySucceeded, bindAddr); err != nil {
+		return
+	}
+	l.Debug().Str("client_addr", remoteAddr.String()).Str("relay_addr", bindAddr.String()).Msg("SOCKS5 UDP: Association established")
+
+	assoc := &socks5UDPAssociation{
+		gateway:   g,
+		relayConn: relayConn,
+		natTable:  newUDPNatTable(defaultUDPIdleTimeout),
+		clientIP:  remoteAddr.IP,
+		entry:     conntrack.FromContext(ctx),
+	}
+	assoc.entry.SetState(conntrack.StateEstablished)
+
+	stopChan := make(chan struct{})
+	expiryDone := make(chan struct{})
+	go func() {
+		// 关联随控制连接结束，剩余的流视为正常结束
+		assoc.natTable.runExpiry(stopChan, conntrack.CloseCompleted)
+		close(expiryDone)
+	}()
+	readDone := make(chan struct{})
+	go func() {
+		assoc.readLoop(ctx)
+		close(readDone)
+	}()
+
+	// 控制连接上不应再有数据，读到 EOF 或出错即表示客户端结束了关联
+	_, _ = io.Copy(io.Discard, inboundReader)
+	relayConn.Close()
+	<-readDone
+	close(stopChan)
+	<-expiryDone
+	l.Debug().Str("client_addr", remoteAddr.String()).Msg("SOCKS5 UDP: Association finished.")
+}
+
+// readLoop 读取客户端发来的数据报，按目标查找或创建流并转发到上游。
+func (a *socks5UDPAssociation) readLoop(ctx context.Context) {
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, src, err := a.relayConn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.Warn().Err(err).Msg("SOCKS5 UDP: Failed to read from relay socket")
+			continue
+		}
+		if !src.IP.Equal(a.clientIP) {
+			l.Debug().Str("source", src.String()).Msg("SOCKS5 UDP: Dropping datagram from unassociated source")
+			continue
+		}
+		a.clientAddr.Store(src)
+		a.entry.Touch()
+
+		target, payload, err := parseSocks5UDPDatagram(buf[:n])
+		if err != nil {
+			l.Debug().Err(err).Str("source", src.String()).Msg("SOCKS5 UDP: Dropping malformed datagram")
+			continue
+		}
+
+		flow := a.natTable.get(target)
+		if flow == nil {
+			var flowCtx context.Context
+			flow, flowCtx = a.newFlow(ctx, src, target)
+			a.natTable.put(flow)
+			go a.openFlow(flowCtx, flow)
+		}
+		flow.touch()
+		flow.entry.Touch()
+		if err := flow.send(payload); err != nil {
+			l.Debug().Err(err).Str("target", target).Msg("SOCKS5 UDP: Failed to write datagram upstream, dropping flow")
+			a.natTable.remove(flow)
+		}
+	}
+}
+
+// newFlow 创建发往 target 的流，并以经中继端口回包的 socks5FlowConn 作为入站连接开始跟踪它。
+// 返回流和它的 context，上游由 openFlow 在后台建立。
+func (a *socks5UDPAssociation) newFlow(ctx context.Context, client *net.UDPAddr, target string) (*udpFlow, context.Context) {
+	replyConn := &socks5FlowConn{assoc: a, client: client, done: make(chan struct{})}
+	traceID := uuid.NewString()
+	traceLog := logger.ContextLogger().Str("trace_id", traceID).Str("association_id", a.entry.ID()).Logger()
+	entry, ctx := a.gateway.tracker.Open(traceLog.WithContext(ctx), traceID, replyConn)
+
+	flow := newUDPFlow(target, client, target)
+	flow.replyConn = entry.Conn()
+	flow.entry = entry
+	return flow, ctx
+}
+
+// openFlow 在后台为新流做出路由决策并建立上游连接，期间 readLoop 继续转发其他流的数据报。
+// 被 REJECT、路由到不支持 UDP 的后端或建立失败时流被标记为 rejected，发往该目标的数据报会被丢弃直到流过期。
+// 流的连接配额由关联的控制连接占用，这里不再单独计算。
+func (a *socks5UDPAssociation) openFlow(ctx context.Context, flow *udpFlow) {
+	entry := flow.entry
+	defer entry.Close()
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	client := flow.client.String()
+
+	// 各个流并发路由，每个流使用关联的 RoutingContext 的副本，由 Dispatcher 分别填写
+	rc := &types.RoutingContext{}
+	if base := types.RoutingContextFrom(ctx); base != nil {
+		*rc = *base
+	}
+	ctx = types.WithRoutingContext(ctx, rc)
+	session, ctx := traffic.NewSession(ctx)
+	entry.SetInbound(rc.Inbound, session)
+	entry.SetTarget("UDP", flow.target)
+
+	dialCtx, cancel := context.WithTimeout(ctx, udpDialTimeout)
+	upstream, serverID, err := dialUDPUpstream(dialCtx, a.gateway.dispatcher, a.gateway.fakeIP, a.gateway.resolver, a.gateway.limiter, a.gateway.meter, flow.client, flow.target)
+	cancel()
+	flow.serverID = serverID
+	if err != nil {
+		flow.reject()
+		// 每个被拒绝的目标都会记录一次，客户端可以轻易地制造大量这样的日志，因此只在调试级别输出
+		event := l.Debug().Str("client_addr", client).Str("target", flow.target).Str("server_id", serverID)
+		switch {
+		case errors.Is(err, errUDPRejected):
+			event.Msg("SOCKS5 UDP: [REJECT] Routing rejected target, datagrams will be dropped.")
+		case errors.Is(err, errUDPUnsupported):
+			event.Msg("SOCKS5 UDP: Selected backend does not support UDP, datagrams will be dropped.")
+		default:
+			event.Err(err).Msg("SOCKS5 UDP: Failed to open upstream, datagrams will be dropped.")
+		}
+		return
+	}
+
+	open, err := flow.establish(upstream)
+	if !open {
+		return
+	}
+	if err != nil {
+		l.Debug().Err(err).Str("target", flow.target).Msg("SOCKS5 UDP: Failed to write datagram upstream, dropping flow")
+		a.natTable.remove(flow)
+		return
+	}
+	entry.SetState(conntrack.StateEstablished)
+	l.Debug().Str("client_addr", client).Str("target", flow.target).Str("server_id", serverID).Msg("SOCKS5 UDP: New flow.")
+	a.replyLoop(flow)
+}
+
+// replyLoop 将上游的回包加上 SOCKS5 UDP 头，经流的 replyConn 发回客户端。
+func (a *socks5UDPAssociation) replyLoop(flow *udpFlow) {
+	defer a.natTable.remove(flow)
+
+	host, portStr, _ := net.SplitHostPort(flow.target)
+	port, _ := strconv.Atoi(portStr)
+	header := appendSocks5Addr([]byte{0x00, 0x00, 0x00}, host, port)
+
+	buf := make([]byte, len(header)+udpBufferSize)
+	copy(buf, header)
+	for {
+		n, err := flow.upstream.Read(buf[len(header):])
+		if err != nil {
+			return
+		}
+		flow.touch()
+		a.entry.Touch()
+		if _, err := flow.replyConn.Write(buf[:len(header)+n]); err != nil {
+			return
+		}
+	}
+}
+
+// socks5FlowConn 是 SOCKS5 UDP 流在连接表中的入站连接。写入的是带 SOCKS5 UDP 头的完整数据报，
+// 经中继端口发往客户端最近使用的地址；客户端发来的数据报由 readLoop 分发，因此读取只在关闭后返回。
+type socks5FlowConn struct {
+	assoc  *socks5UDPAssociation
+	client net.Addr
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *socks5FlowConn) Read([]byte) (int, error) {
+	<-c.done
+	return 0, net.ErrClosed
+}
+
+func (c *socks5FlowConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	client := c.assoc.clientAddr.Load()
+	if client == nil {
+		return len(p), nil
+	}
+	return c.assoc.relayConn.WriteToUDP(p, client)
+}
+
+func (c *socks5FlowConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *socks5FlowConn) LocalAddr() net.Addr                { return c.assoc.relayConn.LocalAddr() }
+func (c *socks5FlowConn) RemoteAddr() net.Addr               { return c.client }
+func (c *socks5FlowConn) SetDeadline(t time.Time) error      { return nil }
+func (c *socks5FlowConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *socks5FlowConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// parseSocks5UDPDatagram 解析 SOCKS5 UDP 请求头 (RFC 1928 第 7 节)，返回目标地址和负载。
+// 不支持分片，FRAG 非 0 的数据报将被丢弃。
+func parseSocks5UDPDatagram(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, fmt.Errorf("datagram too short")
+	}
+	if data[2] != 0x00 {
+		return "", nil, fmt.Errorf("fragmented datagrams are not supported (frag=%d)", data[2])
+	}
+
+	var host string
+	offset := 4
+	switch data[3] {
+	case 0x01: // IPv4
+		if len(data) < offset+4+2 {
+			return "", nil, fmt.Errorf("datagram too short for IPv4 address")
+		}
+		host = net.IP(data[offset : offset+4]).String()
+		offset += 4
+	case 0x03: // Domain
+		if len(data) < offset+1 {
+			return "", nil, fmt.Errorf("datagram too short for domain length")
+		}
+		domainLen := int(data[offset])
+		offset++
+		if len(data) < offset+domainLen+2 {
+			return "", nil, fmt.Errorf("datagram too short for domain address")
+		}
+		host = string(data[offset : offset+domainLen])
+		offset += domainLen
+	case 0x04: // IPv6
+		if len(data) < offset+16+2 {
+			return "", nil, fmt.Errorf("datagram too short for IPv6 address")
+		}
+		host = net.IP(data[offset : offset+16]).String()
+		offset += 16
+	default:
+		return "", nil, fmt.Errorf("unsupported SOCKS5 address type: %d", data[3])
+	}
+	port := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	return net.JoinHostPort(host, strconv.Itoa(port)), data[offset:], nil
+}
@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParseSocks5UDPDatagram(t *testing.T) {
+	tests := []struct {
+		name     string
+		datagram []byte
+		target   string
+		payload  string
+		wantErr  bool
+	}{
+		{
+			name:     "IPv4",
+			datagram: []byte("\x00\x00\x00\x01\x08\x08\x08\x08\x00\x35query"),
+			target:   "8.8.8.8:53",
+			payload:  "query",
+		},
+		{
+			name:     "domain",
+			datagram: []byte("\x00\x00\x00\x03\x0bexample.com\x01\xbbdata"),
+			target:   "example.com:443",
+			payload:  "data",
+		},
+		{
+			name:     "IPv6",
+			datagram: append([]byte("\x00\x00\x00\x04"), append(net.ParseIP("2001:db8::1").To16(), "\x11\x94x"...)...),
+			target:   "[2001:db8::1]:4500",
+			payload:  "x",
+		},
+		{
+			name:     "empty payload",
+			datagram: []byte("\x00\x00\x00\x01\x7f\x00\x00\x01\x00\x50"),
+			target:   "127.0.0.1:80",
+			payload:  "",
+		},
+		{name: "too short", datagram: []byte("\x00\x00\x00"), wantErr: true},
+		{name: "fragmented", datagram: []byte("\x00\x00\x01\x01\x08\x08\x08\x08\x00\x35query"), wantErr: true},
+		{name: "unknown address type", datagram: []byte("\x00\x00\x00\x05\x08\x08\x08\x08\x00\x35"), wantErr: true},
+		{name: "truncated IPv4", datagram: []byte("\x00\x00\x00\x01\x08\x08\x08\x08\x00"), wantErr: true},
+		{name: "missing domain length", datagram: []byte("\x00\x00\x00\x03"), wantErr: true},
+		{name: "truncated domain", datagram: []byte("\x00\x00\x00\x03\x0bexample\x00\x35"), wantErr: true},
+		{name: "truncated IPv6", datagram: []byte("\x00\x00\x00\x04\x20\x01\x0d\xb8\x00\x35"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, payload, err := parseSocks5UDPDatagram(tt.datagram)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got target %q", target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+			if string(payload) != tt.payload {
+				t.Errorf("payload = %q, want %q", payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestAppendSocks5Addr(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		want []byte
+	}{
+		{"1.2.3.4", 80, []byte("\x01\x01\x02\x03\x04\x00\x50")},
+		{"::ffff:1.2.3.4", 443, []byte("\x01\x01\x02\x03\x04\x01\xbb")}, // IPv4 映射地址按 IPv4 编码
+		{"2001:db8::1", 53, append(append([]byte{0x04}, net.ParseIP("2001:db8::1").To16()...), 0x00, 0x35)},
+		{"example.com", 8080, []byte("\x03\x0bexample.com\x1f\x90")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := appendSocks5Addr([]byte{0xff}, tt.host, tt.port)
+			if got[0] != 0xff || !bytes.Equal(got[1:], tt.want) {
+				t.Fatalf("appendSocks5Addr(%q, %d) = %x, want ff%x", tt.host, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+// 回包头由 appendSocks5Addr 编码，客户端应能以同样的格式解析回来。
+func TestSocks5UDPHeaderRoundTrip(t *testing.T) {
+	for _, target := range []string{"8.8.8.8:53", "example.com:443", "[2001:db8::1]:4500"} {
+		host, portStr, _ := net.SplitHostPort(target)
+		port, _ := strconv.Atoi(portStr)
+		datagram := append(appendSocks5Addr([]byte{0x00, 0x00, 0x00}, host, port), "reply"...)
+
+		gotTarget, payload, err := parseSocks5UDPDatagram(datagram)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", target, err)
+		}
+		if gotTarget != target || string(payload) != "reply" {
+			t.Errorf("%s: round trip gave target %q, payload %q", target, gotTarget, payload)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/core/fakeip"
+	"liuproxy_go/internal/core/limiter"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/core/shaper"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TransparentModeRedirect = "redirect"
+	TransparentModeTProxy   = "tproxy"
+)
+
+// TransparentGateway 是透明代理入口。
+// redirect 模式下，它接收被 iptables REDIRECT 到本地端口的 TCP 连接，通过 SO_ORIGINAL_DST 恢复原始目标地址；
+// tproxy 模式下，它使用 IP_TRANSPARENT socket 接收 TPROXY 的 TCP 和 UDP 流量，原始目标即 socket 的本地地址。
+// 之后与 Gateway 一样交给 Dispatcher 进行路由决策，因此共享同一套路由规则、粘性会话和虚拟策略。
+type TransparentGateway struct {
+	listener        net.Listener
+	udpConn         *net.UDPConn
+	natTable        *udpNatTable
+	dispatcher      types.Dispatcher
+	failureReporter types.FailureReporter
+	closeOnce       sync.Once
+	stopChan        chan struct{}
+	waitGroup       sync.WaitGroup
+	listenPort      int
+	mode            string
+	directConn      VirtualStrategy
+	rejectConns     map[settings.RejectMode]VirtualStrategy
+	fakeIP          *fakeip.Pool
+	resolver        *resolver.Resolver
+	limiter         *limiter.Limiter
+	shaper          *shaper.Shaper
+	meter           *traffic.Meter
+	dialer          backendDialer
+	tracker         *conntrack.Tracker
+	baseCtx         context.Context
+	forceClose      context.CancelFunc
+}
+
+// NewTransparent 创建一个透明代理入口实例，deps 提供可选的协作者，见 Deps。
+// deps.Tracker 同时跟踪 TCP 连接和 UDP 流。
+func NewTransparent(conf types.TransparentConf, dispatcher types.Dispatcher, failureReporter types.FailureReporter, deps Deps) *TransparentGateway {
+	mode := strings.ToLower(conf.TransparentMode)
+	if mode != TransparentModeTProxy {
+		mode = TransparentModeRedirect
+	}
+	if deps.Tracker == nil {
+		deps.Tracker = conntrack.New(nil, nil, nil)
+	}
+	baseCtx, forceClose := context.WithCancel(context.Background())
+	return &TransparentGateway{
+		listenPort:      conf.TransparentPort,
+		mode:            mode,
+		natTable:        newUDPNatTable(time.Duration(conf.UDPIdleTimeout) * time.Second),
+		dispatcher:      dispatcher,
+		failureReporter: failureReporter,
+		stopChan:        make(chan struct{}),
+		directConn:      NewDirectStrategy(deps.Resolver, deps.Shaper, deps.Meter),
+		rejectConns:     newRejectStrategies(),
+		fakeIP:          deps.FakeIP,
+		resolver:        deps.Resolver,
+		limiter:         deps.Limiter,
+		shaper:          deps.Shaper,
+		meter:           deps.Meter,
+		dialer:          backendDialer{failureReporter: failureReporter, limiter: deps.Limiter, shaper: deps.Shaper, meter: deps.Meter},
+		tracker:         deps.Tracker,
+		baseCtx:         baseCtx,
+		forceClose:      forceClose,
+	}
+}
+
+func (g *TransparentGateway) Start() error {
+	listenAddr := fmt.Sprintf("0.0.0.0:%d", g.listenPort)
+	if g.mode == TransparentModeTProxy {
+		return g.startTProxy(listenAddr)
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("transparent gateway failed to listen on %s: %w", listenAddr, err)
+	}
+	g.listener = listener
+	log.Info().Str("listen_addr", listener.Addr().String()).Msg(">>> Transparent gateway is listening for redirected traffic.")
+
+	g.waitGroup.Add(1)
+	go g.acceptLoop()
+	return nil
+}
+
+// startTProxy 在同一端口上启动 TCP 和 UDP 的 TPROXY 监听。
+func (g *TransparentGateway) startTProxy(listenAddr string) error {
+	listener, err := listenTProxyTCP(listenAddr)
+	if err != nil {
+		return fmt.Errorf("transparent gateway failed to listen (tproxy/tcp) on %s: %w", listenAddr, err)
+	}
+	udpConn, err := listenTProxyUDP(listenAddr)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("transparent gateway failed to listen (tproxy/udp) on %s: %w", listenAddr, err)
+	}
+	g.listener = listener
+	g.udpConn = udpConn
+	log.Info().
+		Str("listen_addr", listener.Addr().String()).
+		Dur("udp_idle_timeout", g.natTable.idleTimeout).
+		Msg(">>> Transparent gateway is listening for TPROXY traffic (TCP + UDP).")
+
+	g.waitGroup.Add(3)
+	go g.acceptLoop()
+	go g.udpLoop()
+	go g.natExpiryLoop()
+	return nil
+}
+
+func (g *TransparentGateway) acceptLoop() {
+	defer g.waitGroup.Done()
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && strings.Contains(opErr.Err.Error(), "use of closed network connection") {
+				log.Info().Msg("Transparent gateway listener is closing.")
+				return
+			}
+			log.Warn().Err(err).Msg("Transparent gateway failed to accept connection")
+			continue
+		}
+		g.waitGroup.Add(1)
+		go g.handleConnection(conn)
+	}
+}
+
+func (g *TransparentGateway) handleConnection(conn net.Conn) {
+	defer g.waitGroup.Done()
+
+	traceID := uuid.NewString()
+	traceLog := logger.ContextLogger().Str("trace_id", traceID).Logger()
+	entry, ctx := g.tracker.Open(traceLog.WithContext(g.baseCtx), traceID, conn)
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	defer entry.Close()
+	inboundConn := entry.Conn()
+	rc := &types.RoutingContext{Inbound: TransparentInboundName}
+	ctx = types.WithRoutingContext(ctx, rc)
+	clientIP := inboundConn.RemoteAddr().String()
+	session, ctx := traffic.NewSession(ctx)
+	defer logConnectionEnd(ctx, clientIP, session)
+	entry.SetInbound(TransparentInboundName, session)
+	metrics.GatewayAccepted.Inc(TransparentInboundName, "TCP")
+
+	if g.limiter != nil {
+		release, err := g.limiter.AcquireClient(ctx, inboundConn.RemoteAddr())
+		if err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("Transparent: Connection rejected by limits")
+			return
+		}
+		defer release()
+	}
+
+	// 1. 恢复原始目标地址: TPROXY 下即为本地地址，REDIRECT 下需要查询 conntrack
+	var originalDst *net.TCPAddr
+	if g.mode == TransparentModeTProxy {
+		originalDst, _ = inboundConn.LocalAddr().(*net.TCPAddr)
+	} else {
+		var err error
+		// SO_ORIGINAL_DST 需要原始的 *net.TCPConn
+		originalDst, err = getOriginalDst(conn)
+		if err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Msg("Transparent: Could not recover original destination")
+			return
+		}
+	}
+	if originalDst == nil {
+		l.Warn().Str("client_ip", clientIP).Msg("Transparent: Could not recover original destination")
+		return
+	}
+
+	// 客户端直接连到了透明端口 (未经重定向)，原始目标就是本机监听地址，继续处理会造成回环
+	if g.isSelfAddress(originalDst) {
+		l.Warn().Str("client_ip", clientIP).Str("original_dst", originalDst.String()).Msg("Transparent: Connection was not redirected, refusing to loop")
+		return
+	}
+
+	targetDest, err := g.fakeIP.MapTarget(originalDst.String())
+	if err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Transparent: Could not map fake IP target")
+		return
+	}
+	l.Debug().Str("client_ip", clientIP).Str("target", targetDest).Msg("Transparent: Recovered original destination")
+	entry.SetTarget("TCP", targetDest)
+
+	// 2. Dispatcher 选择策略实例，与 Gateway 共用同一套决策逻辑
+	backend, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), targetDest)
+	if err != nil {
+		l.Warn().Err(err).Str("client_ip", clientIP).Str("target", targetDest).Msg("Transparent: Dispatcher returned error")
+		entry.SetCloseReason(conntrack.CloseNoRoute)
+		return
+	}
+	entry.SetRoute(rc, targetDest, serverID)
+
+	inboundReader := bufio.NewReader(inboundConn)
+
+	// 3. 直接策略或拒绝策略
+	switch serverID {
+	case "DIRECT":
+		g.directConn.Handle(ctx, inboundConn, inboundReader, targetDest)
+		return
+	case "REJECT":
+		// 透明流量没有代理协议，"reject" 方式直接关闭连接
+		g.rejectConns[rejectModeOf(rc)].Handle(ctx, inboundConn, inboundReader, targetDest)
+		return
+	}
+
+	// 4. 透明流量没有代理协议头，直接通过策略实例建立到原始目标的连接
+	g.forwardTransparent(ctx, inboundConn, inboundReader, targetDest, backend, serverID)
+}
+
+// forwardTransparent 通过策略实例建立到原始目标的隧道，并双向转发数据。
+func (g *TransparentGateway) forwardTransparent(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, targetDest string, backend types.TunnelStrategy, serverID string) {
+	backendConn, err := g.dialer.dial(ctx, inboundConn.RemoteAddr(), backend, "tcp", targetDest, serverID)
+	if err != nil {
+		logger.Ctx(ctx, logger.ComponentGateway).Error().Err(err).
+			Str("client_ip", inboundConn.RemoteAddr().String()).
+			Str("target", targetDest).
+			Str("server_id", serverID).
+			Msg("Transparent: Failed to establish tunnel.")
+		return
+	}
+	defer backendConn.Close()
+
+	relay(ctx, inboundConn, inboundReader, backendConn)
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().Str("target", targetDest).Msg("Transparent: Session finished.")
+}
+
+func (g *TransparentGateway) isSelfAddress(addr *net.TCPAddr) bool {
+	if addr.Port != g.listenPort {
+		return false
+	}
+	if addr.IP.IsLoopback() || addr.IP.IsUnspecified() {
+		return true
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ifaceAddr := range ifaceAddrs {
+		if ipNet, ok := ifaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 与 Gateway.Close 一样排空 TCP 连接。UDP 流没有结束的信号，随监听 socket 一起关闭。
+func (g *TransparentGateway) Close() {
+	g.closeOnce.Do(func() {
+		close(g.stopChan)
+		if g.listener != nil {
+			g.listener.Close()
+		}
+		if g.udpConn != nil {
+			g.udpConn.Close()
+		}
+		drainTimeout := g.tracker.DrainTimeout()
+		if !waitTimeout(&g.waitGroup, drainTimeout) {
+			log.Warn().Dur("drain_timeout", drainTimeout).Msg("Transparent gateway: Drain timed out, closing remaining connections.")
+		}
+		g.forceClose()
+		g.waitGroup.Wait()
+		log.Info().Msg("Transparent gateway has been shut down")
+	})
+}
@@ -0,0 +1,202 @@
+//go:build linux
+
+package gateway
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ip6tSoOriginalDst 对应 linux/netfilter_ipv6/ip6_tables.h 中的 IP6T_SO_ORIGINAL_DST，
+// x/sys/unix 中没有导出该常量。
+const ip6tSoOriginalDst = 80
+
+// getOriginalDst 通过 getsockopt(SO_ORIGINAL_DST / IP6T_SO_ORIGINAL_DST) 获取被 REDIRECT 之前的原始目标地址。
+func getOriginalDst(conn net.Conn) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("original dst: not a TCP connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("original dst: failed to get raw conn: %w", err)
+	}
+
+	isIPv4 := true
+	if localAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok && localAddr.IP.To4() == nil {
+		isIPv4 = false
+	}
+
+	var originalDst *net.TCPAddr
+	var sockErr error
+	ctrlErr := rawConn.Control(func(fd uintptr) {
+		if isIPv4 {
+			// 内核返回的是 struct sockaddr_in，这里借用 IPv6Mreq (16 字节) 作为接收缓冲区
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			raw := mreq.Multiaddr
+			originalDst = &net.TCPAddr{
+				IP:   net.IPv4(raw[4], raw[5], raw[6], raw[7]),
+				Port: int(binary.BigEndian.Uint16(raw[2:4])),
+			}
+			return
+		}
+		// 内核返回的是 struct sockaddr_in6，IPv6MTUInfo 的首字段恰好是 RawSockaddrInet6
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSoOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		portBytes := make([]byte, 2)
+		binary.NativeEndian.PutUint16(portBytes, info.Addr.Port)
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, info.Addr.Addr[:])
+		originalDst = &net.TCPAddr{
+			IP:   ip,
+			Port: int(binary.BigEndian.Uint16(portBytes)),
+		}
+	})
+	if ctrlErr != nil {
+		return nil, fmt.Errorf("original dst: raw conn control failed: %w", ctrlErr)
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("original dst: getsockopt failed: %w", sockErr)
+	}
+	return originalDst, nil
+}
+
+// setTransparentSockopt 为 socket 开启 IP_TRANSPARENT，使其可以接收/绑定非本机地址 (需要 CAP_NET_ADMIN)。
+func setTransparentSockopt(network string, fd uintptr) error {
+	if network == "tcp6" || network == "udp6" {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1); err != nil {
+			return fmt.Errorf("failed to set IPV6_TRANSPARENT: %w", err)
+		}
+	}
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		// 纯 IPv6 socket 上设置 IPv4 选项可能失败，此时以 IPv6 选项为准
+		if network != "tcp6" && network != "udp6" {
+			return fmt.Errorf("failed to set IP_TRANSPARENT: %w", err)
+		}
+	}
+	return nil
+}
+
+// listenTProxyTCP 创建一个接收 TPROXY 流量的 TCP 监听器，连接的 LocalAddr 即为原始目标地址。
+func listenTProxyTCP(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var optErr error
+			if err := c.Control(func(fd uintptr) {
+				optErr = setTransparentSockopt(network, fd)
+			}); err != nil {
+				return err
+			}
+			return optErr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenTProxyUDP 创建一个接收 TPROXY 流量的 UDP socket，并开启 IP_RECVORIGDSTADDR 以获取每个数据报的原始目标地址。
+func listenTProxyUDP(addr string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var optErr error
+			if err := c.Control(func(fd uintptr) {
+				if optErr = setTransparentSockopt(network, fd); optErr != nil {
+					return
+				}
+				if err := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); err != nil && network != "udp6" {
+					optErr = fmt.Errorf("failed to set IP_RECVORIGDSTADDR: %w", err)
+					return
+				}
+				if network == "udp6" {
+					if err := unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1); err != nil {
+						optErr = fmt.Errorf("failed to set IPV6_RECVORIGDSTADDR: %w", err)
+					}
+				}
+			}); err != nil {
+				return err
+			}
+			return optErr
+		},
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// readTProxyUDP 读取一个数据报，并从控制消息中解析出它的原始目标地址。
+func readTProxyUDP(conn *net.UDPConn, buf, oob []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	n, oobn, _, src, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("tproxy: failed to parse control message: %w", err)
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVORIGDSTADDR:
+			if len(msg.Data) < unix.SizeofSockaddrInet4 {
+				continue
+			}
+			dst := &net.UDPAddr{
+				IP:   net.IPv4(msg.Data[4], msg.Data[5], msg.Data[6], msg.Data[7]),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}
+			return n, src, dst, nil
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVORIGDSTADDR:
+			if len(msg.Data) < unix.SizeofSockaddrInet6 {
+				continue
+			}
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, msg.Data[8:24])
+			dst := &net.UDPAddr{
+				IP:   ip,
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}
+			return n, src, dst, nil
+		}
+	}
+	return 0, nil, nil, fmt.Errorf("tproxy: original destination not found in control message")
+}
+
+// dialTProxyReply 创建一个绑定在原始目标地址上的 UDP socket 并连接到客户端，
+// 用它发送的回包在客户端看来来自真实的目标服务器。
+func dialTProxyReply(from, to *net.UDPAddr) (*net.UDPConn, error) {
+	d := net.Dialer{
+		LocalAddr: from,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var optErr error
+			if err := c.Control(func(fd uintptr) {
+				if optErr = setTransparentSockopt(network, fd); optErr != nil {
+					return
+				}
+				// 同一个目标地址可能同时服务多个客户端
+				if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+					optErr = fmt.Errorf("failed to set SO_REUSEADDR: %w", err)
+				}
+			}); err != nil {
+				return err
+			}
+			return optErr
+		},
+	}
+	conn, err := d.Dial("udp", to.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
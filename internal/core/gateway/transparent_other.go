@@ -0,0 +1,29 @@
+//go:build !linux
+
+package gateway
+
+import (
+	"fmt"
+	"net"
+)
+
+// getOriginalDst 在非 Linux 平台上不可用，透明代理依赖 netfilter。
+func getOriginalDst(conn net.Conn) (*net.TCPAddr, error) {
+	return nil, fmt.Errorf("original dst: transparent proxy is only supported on linux")
+}
+
+func listenTProxyTCP(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("tproxy: transparent proxy is only supported on linux")
+}
+
+func listenTProxyUDP(addr string) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("tproxy: transparent proxy is only supported on linux")
+}
+
+func readTProxyUDP(conn *net.UDPConn, buf, oob []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	return 0, nil, nil, fmt.Errorf("tproxy: transparent proxy is only supported on linux")
+}
+
+func dialTProxyReply(from, to *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("tproxy: transparent proxy is only supported on linux")
+}
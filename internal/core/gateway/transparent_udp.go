@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+)
+
+// udpLoop 从 TPROXY UDP socket 读取数据报，按 (客户端, 原始目标) 查找或创建 NAT 流并转发。
+func (g *TransparentGateway) udpLoop() {
+	defer g.waitGroup.Done()
+	buf := make([]byte, udpBufferSize)
+	oob := make([]byte, 1024)
+	for {
+		n, client, target, err := readTProxyUDP(g.udpConn, buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Info().Msg("Transparent gateway UDP listener is closing.")
+				return
+			}
+			log.Warn().Err(err).Msg("Transparent gateway failed to read UDP packet")
+			continue
+		}
+
+		key := client.String() + "->" + target.String()
+		flow := g.natTable.get(key)
+		if flow == nil {
+			var ctx context.Context
+			if flow, ctx, err = g.newUDPFlow(key, client, target); err != nil {
+				log.Error().Err(err).Str("client_ip", client.String()).Str("target", target.String()).Msg("Transparent: Failed to create UDP reply socket.")
+				continue
+			}
+			g.natTable.put(flow)
+			go g.openUDPFlow(ctx, flow)
+		}
+		flow.touch()
+		flow.entry.Touch()
+		if err := flow.send(buf[:n]); err != nil {
+			log.Debug().Err(err).Str("flow", key).Msg("Transparent: Failed to write UDP packet upstream, dropping flow")
+			g.natTable.remove(flow)
+		}
+	}
+}
+
+// newUDPFlow 为新的 UDP 流创建以原始目标的身份回包的 socket，并以它作为入站连接开始跟踪该流。
+// 返回流和它的 context，上游由 openUDPFlow 在后台建立。
+func (g *TransparentGateway) newUDPFlow(key string, client, target *net.UDPAddr) (*udpFlow, context.Context, error) {
+	replyConn, err := dialTProxyReply(target, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	traceID := uuid.NewString()
+	traceLog := logger.ContextLogger().Str("trace_id", traceID).Logger()
+	entry, ctx := g.tracker.Open(traceLog.WithContext(g.baseCtx), traceID, replyConn)
+
+	flow := newUDPFlow(key, client, target.String())
+	flow.replyConn = entry.Conn()
+	flow.entry = entry
+	return flow, ctx, nil
+}
+
+// openUDPFlow 占用连接配额，通过 Dispatcher 为新的 UDP 流做出路由决策并建立上游连接，期间 udpLoop 继续转发其他流的数据报。
+// 失败时流被标记为 rejected，以免对同一个流的后续数据报反复进行路由和拨号。流结束时释放配额并关闭跟踪条目。
+func (g *TransparentGateway) openUDPFlow(ctx context.Context, flow *udpFlow) {
+	entry := flow.entry
+	defer entry.Close()
+	l := logger.Ctx(ctx, logger.ComponentGateway)
+	client := flow.client.String()
+	rc := &types.RoutingContext{Inbound: TransparentInboundName}
+	ctx = types.WithRoutingContext(ctx, rc)
+	session, ctx := traffic.NewSession(ctx)
+	defer logConnectionEnd(ctx, client, session)
+	entry.SetInbound(TransparentInboundName, session)
+	metrics.GatewayAccepted.Inc(TransparentInboundName, "UDP")
+
+	if g.limiter != nil {
+		release, err := g.limiter.AcquireClient(ctx, flow.client)
+		if err != nil {
+			flow.reject()
+			l.Debug().Err(err).Str("client_ip", client).Str("target", flow.target).Msg("Transparent: UDP flow rejected by limits")
+			return
+		}
+		defer release()
+	}
+	entry.SetTarget("UDP", flow.target)
+
+	dialCtx, cancel := context.WithTimeout(ctx, udpDialTimeout)
+	upstream, serverID, err := dialUDPUpstream(dialCtx, g.dispatcher, g.fakeIP, g.resolver, g.limiter, g.meter, flow.client, flow.target)
+	cancel()
+	flow.serverID = serverID
+	if err != nil {
+		flow.reject()
+		if errors.Is(err, errUDPRejected) {
+			l.Debug().Str("client_ip", client).Str("target", flow.target).Msg("Transparent: [REJECT] Dropping UDP flow.")
+		} else {
+			l.Warn().Err(err).
+				Str("client_ip", client).
+				Str("target", flow.target).
+				Str("server_id", serverID).
+				Msg("Transparent: Could not relay UDP flow, dropping it.")
+		}
+		return
+	}
+
+	open, err := flow.establish(upstream)
+	if !open {
+		return
+	}
+	if err != nil {
+		l.Debug().Err(err).Str("flow", flow.key).Msg("Transparent: Failed to write UDP packet upstream, dropping flow")
+		g.natTable.remove(flow)
+		return
+	}
+	entry.SetState(conntrack.StateEstablished)
+	l.Debug().Str("client_ip", client).Str("target", flow.target).Str("server_id", serverID).Msg("Transparent: New UDP flow.")
+	g.udpReplyLoop(flow)
+}
+
+// udpReplyLoop 将上游的回包通过 replyConn 以原始目标的身份发回客户端，直到流被关闭。
+func (g *TransparentGateway) udpReplyLoop(flow *udpFlow) {
+	defer g.natTable.remove(flow)
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, err := flow.upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		flow.touch()
+		if _, err := flow.replyConn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// natExpiryLoop 定期清理空闲的 UDP 流。
+func (g *TransparentGateway) natExpiryLoop() {
+	defer g.waitGroup.Done()
+	g.natTable.runExpiry(g.stopChan, conntrack.CloseShutdown)
+}
@@ -0,0 +1,284 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/core/fakeip"
+	"liuproxy_go/internal/core/limiter"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultUDPIdleTimeout = 60 * time.Second
+	udpBufferSize         = 64 * 1024
+	// udpDialTimeout 是为新流做出路由决策并建立上游连接的最长时间
+	udpDialTimeout = 10 * time.Second
+	// udpPendingPackets 是上游建立期间每个流最多暂存的数据报数，超出的数据报被丢弃
+	udpPendingPackets = 16
+)
+
+var (
+	// errUDPRejected 表示路由规则将该 UDP 流导向了 REJECT
+	errUDPRejected = errors.New("udp flow rejected by routing")
+	// errUDPUnsupported 表示被选中的后端不具备 UDP 转发能力
+	errUDPUnsupported = errors.New("backend does not support udp")
+)
+
+// udpFlow 是 NAT 表中的一条记录，对应一个 (客户端, 目标) 二元组。
+type udpFlow struct {
+	key    string
+	client net.Addr
+	target string
+
+	// replyConn 把上游的回包发回客户端，同时是流在连接表中的入站连接: 透明代理以原始目标的身份回包，
+	// SOCKS5 经中继端口发送带 UDP 头的数据报
+	replyConn net.Conn
+	// entry 是流在连接表中的跟踪条目，流关闭时随之关闭并写入访问记录
+	entry *conntrack.Entry
+	// upstream 对直连是到目标的 UDP 连接，对代理是策略实例提供的 UDP 连接，上游建立之前为 nil
+	upstream net.Conn
+
+	serverID   string
+	lastActive atomic.Int64
+	closeOnce  sync.Once
+
+	mu sync.Mutex
+	// dialing 表示上游仍在建立，期间到达的数据报暂存在 pending 中
+	dialing bool
+	pending [][]byte
+	closed  bool
+}
+
+// newUDPFlow 创建一个上游仍在建立中的流。
+func newUDPFlow(key string, client net.Addr, target string) *udpFlow {
+	flow := &udpFlow{key: key, client: client, target: target, dialing: true}
+	flow.touch()
+	return flow
+}
+
+// send 将客户端的数据报转发到上游。上游仍在建立时复制一份暂存，建立后按到达顺序发出；
+// 暂存已满或流被拒绝时丢弃数据报。
+func (f *udpFlow) send(payload []byte) error {
+	f.mu.Lock()
+	if f.dialing {
+		if len(f.pending) < udpPendingPackets {
+			f.pending = append(f.pending, append([]byte(nil), payload...))
+		}
+		f.mu.Unlock()
+		return nil
+	}
+	upstream := f.upstream
+	f.mu.Unlock()
+	if upstream == nil {
+		return nil
+	}
+	_, err := upstream.Write(payload)
+	return err
+}
+
+// establish 设置建立好的上游连接并发出暂存的数据报，返回 false 表示流已在建立期间被关闭，upstream 随之关闭。
+func (f *udpFlow) establish(upstream net.Conn) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := f.pending
+	f.dialing, f.pending = false, nil
+	if f.closed {
+		upstream.Close()
+		return false, nil
+	}
+	f.upstream = upstream
+	for _, payload := range pending {
+		if _, err := upstream.Write(payload); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// reject 在流被路由拒绝、后端不支持 UDP 或上游建立失败时丢弃暂存的数据报，之后的数据报同样被丢弃直到流过期。
+func (f *udpFlow) reject() {
+	f.mu.Lock()
+	f.dialing, f.pending = false, nil
+	f.mu.Unlock()
+}
+
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+func (f *udpFlow) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, f.lastActive.Load()))
+}
+
+func (f *udpFlow) close() {
+	f.closeOnce.Do(func() {
+		f.mu.Lock()
+		f.closed = true
+		upstream := f.upstream
+		f.mu.Unlock()
+		if upstream != nil {
+			upstream.Close()
+		}
+		if f.replyConn != nil {
+			f.replyConn.Close()
+		}
+		f.entry.Close()
+	})
+}
+
+// udpNatTable 维护所有活跃的 UDP 流，并定期清理空闲的流。
+type udpNatTable struct {
+	mu          sync.Mutex
+	flows       map[string]*udpFlow
+	idleTimeout time.Duration
+}
+
+func newUDPNatTable(idleTimeout time.Duration) *udpNatTable {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPIdleTimeout
+	}
+	return &udpNatTable{
+		flows:       make(map[string]*udpFlow),
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (t *udpNatTable) get(key string) *udpFlow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flows[key]
+}
+
+func (t *udpNatTable) put(flow *udpFlow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flows[flow.key] = flow
+}
+
+func (t *udpNatTable) remove(flow *udpFlow) {
+	t.mu.Lock()
+	if t.flows[flow.key] == flow {
+		delete(t.flows, flow.key)
+	}
+	t.mu.Unlock()
+	flow.close()
+}
+
+// expire 关闭所有空闲超过 idleTimeout 的流，返回被清理的数量。
+func (t *udpNatTable) expire(now time.Time) int {
+	var expired []*udpFlow
+	t.mu.Lock()
+	for key, flow := range t.flows {
+		if flow.idleSince(now) > t.idleTimeout {
+			expired = append(expired, flow)
+			delete(t.flows, key)
+		}
+	}
+	t.mu.Unlock()
+	for _, flow := range expired {
+		flow.entry.SetCloseReason(conntrack.CloseIdleTimeout)
+		flow.close()
+	}
+	return len(expired)
+}
+
+// closeAll 以 reason 关闭所有的流。
+func (t *udpNatTable) closeAll(reason string) {
+	t.mu.Lock()
+	flows := t.flows
+	t.flows = make(map[string]*udpFlow)
+	t.mu.Unlock()
+	for _, flow := range flows {
+		flow.entry.SetCloseReason(reason)
+		flow.close()
+	}
+}
+
+// runExpiry 定期清理空闲的流，直到 stop 被关闭，退出前以 closeReason 关闭所有剩余的流。
+func (t *udpNatTable) runExpiry(stop <-chan struct{}, closeReason string) {
+	ticker := time.NewTicker(t.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			t.closeAll(closeReason)
+			return
+		case now := <-ticker.C:
+			if n := t.expire(now); n > 0 {
+				log.Debug().Int("expired", n).Msg("Expired idle UDP flows.")
+			}
+		}
+	}
+}
+
+// resolveUDPTarget 把 host:port 中的域名解析为 IPv4 地址。隧道的 UDP 中继只支持 IPv4 目标。
+func resolveUDPTarget(ctx context.Context, resolver *resolver.Resolver, target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := resolver.LookupIP(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if addr.Is4() {
+			return net.JoinHostPort(addr.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("udp target %s has no IPv4 address", host)
+}
+
+// dialUDPUpstream 通过 Dispatcher 为一个新的 UDP 流做出路由决策并建立上游连接。
+// 路由到 REJECT 时返回 errUDPRejected，后端不支持 UDP 时返回 errUDPUnsupported。
+// UDP 拨号失败不计入后端的健康状态，因此不向 failureReporter 报告。
+// Fake-IP 目标只在路由和拨号时还原为域名，回包仍以客户端看到的地址作为来源。上游连接转发的流量计入 meter。
+// 经隧道转发时域名目标先由 resolver 解析为 IPv4 地址。
+func dialUDPUpstream(ctx context.Context, dispatcher types.Dispatcher, fakeIP *fakeip.Pool, resolver *resolver.Resolver, limits *limiter.Limiter, meter *traffic.Meter, source net.Addr, target string) (net.Conn, string, error) {
+	target, err := fakeIP.MapTarget(target)
+	if err != nil {
+		return nil, "", err
+	}
+	entry := conntrack.FromContext(ctx)
+	backend, serverID, err := dispatcher.Dispatch(ctx, source, target)
+	if err != nil {
+		entry.SetCloseReason(conntrack.CloseNoRoute)
+		return nil, "", err
+	}
+	entry.SetRoute(types.RoutingContextFrom(ctx), target, serverID)
+
+	switch serverID {
+	case "REJECT":
+		return nil, serverID, errUDPRejected
+	case "DIRECT":
+		upstream, err := resolver.DialContext(ctx, "udp", target)
+		if err != nil {
+			entry.SetCloseReason(conntrack.CloseDialError)
+			return nil, serverID, err
+		}
+		return entry.Attach(meter.WrapConn(ctx, upstream, source, serverID), nil), serverID, nil
+	}
+
+	if !backend.SupportsUDP() {
+		return nil, serverID, errUDPUnsupported
+	}
+	// 隧道的 UDP 回包以来源 IP 标识，域名目标 (SOCKS5 域名地址、还原后的 Fake-IP) 需要先解析
+	dialTarget, err := resolveUDPTarget(ctx, resolver, target)
+	if err != nil {
+		entry.SetCloseReason(conntrack.CloseDialError)
+		return nil, serverID, err
+	}
+	upstream, err := dialStrategy(ctx, backend, "udp", dialTarget, serverID, nil, limits)
+	if err != nil {
+		return nil, serverID, err
+	}
+	return meter.WrapConn(ctx, upstream, source, serverID), serverID, nil
+}
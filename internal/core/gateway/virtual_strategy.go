@@ -2,10 +2,17 @@ package gateway
 
 import (
 	"bufio"
+	"context"
 	"io"
+	"liuproxy_go/internal/core/conntrack"
+	"liuproxy_go/internal/core/resolver"
+	"liuproxy_go/internal/core/shaper"
 	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
 	"net"
-	"sync"
 	"time"
 )
 
@@ -13,79 +20,116 @@ import (
 type VirtualStrategy interface {
 	// Handle 处理一个入站连接，根据策略逻辑决定如何处理。
 	// initialReader 包含了可能已从 inboundConn 中预读的数据。
-	// target 是从流量中嗅探出的原始目标地址。
-	Handle(inboundConn net.Conn, initialReader *bufio.Reader, target net.Addr)
+	// target 是从流量中嗅探出的原始目标地址 (host:port)，host 可以是域名。
+	// ctx 携带连接的 RoutingContext。
+	Handle(ctx context.Context, inboundConn net.Conn, initialReader *bufio.Reader, target string)
 }
 
 // --- DirectStrategy: 实现直连逻辑 ---
 
-type DirectStrategy struct{}
+type DirectStrategy struct {
+	resolver *resolver.Resolver
+	shaper   *shaper.Shaper
+	meter    *traffic.Meter
+}
 
-// NewDirectStrategy 创建一个直连策略实例。
-func NewDirectStrategy() VirtualStrategy {
-	return &DirectStrategy{}
+// NewDirectStrategy 创建一个直连策略实例，目标中的域名由 resolver 解析，而不是系统解析器。
+// 直连流量按 "DIRECT" 服务器的带宽规则由 shaper 限速，并以 "DIRECT" 计入 meter；两者为 nil 时均不生效。
+func NewDirectStrategy(resolver *resolver.Resolver, shaper *shaper.Shaper, meter *traffic.Meter) VirtualStrategy {
+	return &DirectStrategy{resolver: resolver, shaper: shaper, meter: meter}
 }
 
 // Handle 实现了 VirtualStrategy 接口。
-func (s *DirectStrategy) Handle(inboundConn net.Conn, initialReader *bufio.Reader, target net.Addr) {
+func (s *DirectStrategy) Handle(ctx context.Context, inboundConn net.Conn, initialReader *bufio.Reader, targetAddr string) {
 	defer inboundConn.Close()
+	l := logger.Ctx(ctx, logger.ComponentGateway)
 
-	targetAddr := target.String()
-	logger.Debug().
+	l.Debug().
 		Str("client_ip", inboundConn.RemoteAddr().String()).
 		Str("target_addr", targetAddr).
 		Msg("Gateway: [DIRECT] Handling direct connection.")
 
 	// 1. 连接到原始目标地址
-	outboundConn, err := net.DialTimeout(target.Network(), targetAddr, 10*time.Second)
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	start := time.Now()
+	outboundConn, err := s.resolver.DialContext(dialCtx, "tcp", targetAddr)
+	cancel()
 	if err != nil {
-		logger.Error().
+		metrics.DialErrors.Inc("DIRECT")
+		conntrack.FromContext(ctx).SetCloseReason(conntrack.CloseDialError)
+		l.Error().
 			Err(err).
+			Str("client_ip", inboundConn.RemoteAddr().String()).
 			Str("target_addr", targetAddr).
 			Msg("Gateway: [DIRECT] Failed to dial target.")
 		return
 	}
+	metrics.TunnelSetupSeconds.Observe(time.Since(start).Seconds(), "DIRECT")
+	outboundConn = s.shaper.WrapConn(outboundConn, inboundConn.RemoteAddr(), routingUser(ctx), "DIRECT")
+	outboundConn = s.meter.WrapConn(ctx, outboundConn, inboundConn.RemoteAddr(), "DIRECT")
+	outboundConn = conntrack.FromContext(ctx).Attach(outboundConn, nil)
 	defer outboundConn.Close()
 
-	// 2. 双向转发数据
-	var wg sync.WaitGroup
-	wg.Add(2)
+	// 2. 双向转发数据，initialReader 中预读的数据先被发往目标
+	relay(ctx, inboundConn, initialReader, outboundConn)
+}
 
-	go func() {
-		defer wg.Done()
-		// 从 initialReader (包含了预读数据) 拷贝到目标连接
-		io.Copy(outboundConn, initialReader)
-		if tcpConn, ok := outboundConn.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		// 从目标连接拷贝回客户端连接
-		io.Copy(inboundConn, outboundConn)
-		if tcpConn, ok := inboundConn.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
-		}
-	}()
+// --- RejectStrategy: 实现拒绝逻辑 ---
+
+// rejectDropTimeout 是 "reject-drop" 方式保持连接的最长时间，客户端先放弃时连接随之关闭。
+const rejectDropTimeout = 2 * time.Minute
 
-	wg.Wait()
+type RejectStrategy struct {
+	mode settings.RejectMode
 }
 
-// --- RejectStrategy: 实现拒绝逻辑 ---
+// NewRejectStrategy 创建一个拒绝策略实例。协议层面的拒绝应答 (SOCKS 应答码、HTTP 403) 由网关在调用 Handle 之前发送，
+// 策略只负责之后如何断开连接。
+func NewRejectStrategy(mode settings.RejectMode) VirtualStrategy {
+	return &RejectStrategy{mode: mode}
+}
 
-type RejectStrategy struct{}
+// newRejectStrategies 为每种拒绝方式创建一个策略实例。
+func newRejectStrategies() map[settings.RejectMode]VirtualStrategy {
+	return map[settings.RejectMode]VirtualStrategy{
+		settings.RejectModeReply:    NewRejectStrategy(settings.RejectModeReply),
+		settings.RejectModeDrop:     NewRejectStrategy(settings.RejectModeDrop),
+		settings.RejectModeTCPReset: NewRejectStrategy(settings.RejectModeTCPReset),
+	}
+}
 
-// NewRejectStrategy 创建一个拒绝策略实例。
-func NewRejectStrategy() VirtualStrategy {
-	return &RejectStrategy{}
+// rejectModeOf 返回规则选择的拒绝方式，为空或无法识别时按 "reject" 处理。
+func rejectModeOf(rc *types.RoutingContext) settings.RejectMode {
+	if rc == nil {
+		return settings.RejectModeReply
+	}
+	switch mode := settings.RejectMode(rc.RejectMode); mode {
+	case settings.RejectModeDrop, settings.RejectModeTCPReset:
+		return mode
+	default:
+		return settings.RejectModeReply
+	}
 }
 
-// Handle 实现了 VirtualStrategy 接口，直接关闭连接。
-func (s *RejectStrategy) Handle(inboundConn net.Conn, initialReader *bufio.Reader, target net.Addr) {
-	logger.Debug().
+// Handle 实现了 VirtualStrategy 接口，按拒绝方式关闭连接。
+func (s *RejectStrategy) Handle(ctx context.Context, inboundConn net.Conn, initialReader *bufio.Reader, target string) {
+	logger.Ctx(ctx, logger.ComponentGateway).Debug().
 		Str("client_ip", inboundConn.RemoteAddr().String()).
-		Str("target_addr", target.String()).
+		Str("target_addr", target).
+		Str("mode", string(s.mode)).
 		Msg("Gateway: [REJECT] Rejecting connection.")
+
+	switch s.mode {
+	case settings.RejectModeDrop:
+		// 吞掉客户端发送的数据，直到客户端放弃或超时。保持连接是有意为之，不按握手阶段的空闲超时提前关闭
+		conntrack.FromContext(ctx).SetState(conntrack.StateEstablished)
+		inboundConn.SetReadDeadline(time.Now().Add(rejectDropTimeout))
+		io.Copy(io.Discard, initialReader)
+	case settings.RejectModeTCPReset:
+		// SO_LINGER 为 0 时 Close 发送 RST 而不是 FIN
+		if lingerConn, ok := inboundConn.(interface{ SetLinger(sec int) error }); ok {
+			lingerConn.SetLinger(0)
+		}
+	}
 	inboundConn.Close()
 }
@@ -0,0 +1,311 @@
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultMaxHeaderBytes   = 16384
+	// minHeaderBytes 是 max_header_bytes 的下限，过小的值会让正常的请求头也无法通过
+	minHeaderBytes     = 1024
+	defaultBanDuration = 10 * time.Minute
+	// strikeWindow 是累计违规次数的时间窗口，窗口过后重新计数
+	strikeWindow = time.Minute
+)
+
+var (
+	// ErrBanned 表示客户端 IP 正处于封禁期
+	ErrBanned = errors.New("client is banned")
+	// ErrTooManyHandshakes 表示客户端 IP 同时进行中的握手数已达上限
+	ErrTooManyHandshakes = errors.New("too many concurrent handshakes")
+)
+
+// config 是解析后的 "security" 模块配置。
+type config struct {
+	handshakeTimeout    time.Duration
+	maxHeaderBytes      int
+	maxRequestBodyBytes int64
+	maxHandshakesPerIP  int
+	banThreshold        int
+	banDuration         time.Duration
+	trusted             []netip.Prefix
+}
+
+type strikeRecord struct {
+	count       int
+	windowStart time.Time
+}
+
+// Guard 保护网关入口免受慢速握手 (slowloris) 和超大握手的消耗: 它提供握手的超时和大小上限，
+// 限制每个客户端 IP 同时进行中的握手数，并在一分钟内违规达到阈值时自动封禁该 IP。
+type Guard struct {
+	cfg atomic.Pointer[config]
+
+	mu         sync.Mutex
+	handshakes map[string]int
+	strikes    map[string]*strikeRecord
+	bans       map[string]*types.BanInfo
+	lastSweep  time.Time
+}
+
+// New 创建入口保护，cfg 为 "security" 模块的初始配置。
+func New(cfg *settings.SecuritySettings) *Guard {
+	g := &Guard{
+		handshakes: make(map[string]int),
+		strikes:    make(map[string]*strikeRecord),
+		bans:       make(map[string]*types.BanInfo),
+	}
+	if err := g.applySettings(cfg); err != nil {
+		logger.Warn().Err(err).Msg("Guard: Invalid settings, trusted CIDRs are ignored.")
+		g.applySettings(&settings.SecuritySettings{})
+	}
+	return g
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。已有的封禁保持到期满为止。
+func (g *Guard) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "security" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.SecuritySettings)
+	if !ok {
+		return fmt.Errorf("guard: received incorrect settings type for security module")
+	}
+	if err := g.applySettings(cfg); err != nil {
+		return err
+	}
+	c := g.cfg.Load()
+	logger.Info().
+		Dur("handshake_timeout", c.handshakeTimeout).
+		Int("max_header_bytes", c.maxHeaderBytes).
+		Int("max_handshakes_per_ip", c.maxHandshakesPerIP).
+		Int("ban_threshold", c.banThreshold).
+		Dur("ban_duration", c.banDuration).
+		Msg("Guard: Security settings have been reloaded.")
+	return nil
+}
+
+func (g *Guard) applySettings(cfg *settings.SecuritySettings) error {
+	if cfg == nil {
+		cfg = &settings.SecuritySettings{}
+	}
+	c := &config{
+		handshakeTimeout:    time.Duration(cfg.HandshakeTimeout) * time.Second,
+		maxHeaderBytes:      cfg.MaxHeaderBytes,
+		maxRequestBodyBytes: cfg.MaxRequestBodyBytes,
+		maxHandshakesPerIP:  cfg.MaxHandshakesPerIP,
+		banThreshold:        cfg.BanThreshold,
+		banDuration:         time.Duration(cfg.BanDuration) * time.Second,
+	}
+	if c.handshakeTimeout <= 0 {
+		c.handshakeTimeout = defaultHandshakeTimeout
+	}
+	if c.maxHeaderBytes <= 0 {
+		c.maxHeaderBytes = defaultMaxHeaderBytes
+	} else if c.maxHeaderBytes < minHeaderBytes {
+		c.maxHeaderBytes = minHeaderBytes
+	}
+	if c.banDuration <= 0 {
+		c.banDuration = defaultBanDuration
+	}
+	for _, raw := range cfg.TrustedCIDRs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return fmt.Errorf("guard: invalid trusted CIDR %q: %w", raw, err)
+		}
+		c.trusted = append(c.trusted, prefix.Masked())
+	}
+	g.cfg.Store(c)
+	return nil
+}
+
+// HandshakeTimeout 返回完成一次握手的最长时间。
+func (g *Guard) HandshakeTimeout() time.Duration {
+	return g.cfg.Load().handshakeTimeout
+}
+
+// MaxHeaderBytes 返回 HTTP 请求头的最大字节数。
+func (g *Guard) MaxHeaderBytes() int {
+	return g.cfg.Load().maxHeaderBytes
+}
+
+// MaxRequestBodyBytes 返回普通 HTTP 代理请求的请求体上限，0 表示不限制。
+func (g *Guard) MaxRequestBodyBytes() int64 {
+	return g.cfg.Load().maxRequestBodyBytes
+}
+
+// BeginHandshake 在握手开始前检查来自 source 的连接: 被封禁的 IP 返回 ErrBanned，
+// 同时进行中的握手数超限时返回 ErrTooManyHandshakes 并记一次违规。
+// 成功时返回的 done 必须在握手结束 (无论成败) 时调用一次。
+func (g *Guard) BeginHandshake(source net.Addr) (done func(), err error) {
+	ip := clientIP(source)
+	cfg := g.cfg.Load()
+	if cfg.isTrusted(ip) {
+		return func() {}, nil
+	}
+	now := time.Now()
+
+	g.mu.Lock()
+	if ban, ok := g.bans[ip]; ok {
+		if now.Before(ban.ExpiresAt) {
+			g.mu.Unlock()
+			return nil, ErrBanned
+		}
+		delete(g.bans, ip)
+	}
+	if cfg.maxHandshakesPerIP > 0 && g.handshakes[ip] >= cfg.maxHandshakesPerIP {
+		g.strikeLocked(cfg, ip, ErrTooManyHandshakes.Error(), now)
+		g.mu.Unlock()
+		return nil, ErrTooManyHandshakes
+	}
+	g.handshakes[ip]++
+	g.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			if g.handshakes[ip]--; g.handshakes[ip] <= 0 {
+				delete(g.handshakes, ip)
+			}
+			g.mu.Unlock()
+		})
+	}, nil
+}
+
+// Strike 为 source 记一次握手违规，reason 会记录在触发的封禁中。
+func (g *Guard) Strike(source net.Addr, reason string) {
+	ip := clientIP(source)
+	cfg := g.cfg.Load()
+	if cfg.isTrusted(ip) {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.strikeLocked(cfg, ip, reason, time.Now())
+}
+
+// strikeLocked 累计违规次数，达到阈值时封禁该 IP。调用方需持有 mu。
+func (g *Guard) strikeLocked(cfg *config, ip, reason string, now time.Time) {
+	g.sweepLocked(now)
+	if cfg.banThreshold <= 0 {
+		return
+	}
+	record, ok := g.strikes[ip]
+	if !ok || now.Sub(record.windowStart) >= strikeWindow {
+		record = &strikeRecord{windowStart: now}
+		g.strikes[ip] = record
+	}
+	record.count++
+	if record.count < cfg.banThreshold {
+		return
+	}
+	delete(g.strikes, ip)
+	g.bans[ip] = &types.BanInfo{
+		IP:        ip,
+		Reason:    reason,
+		Strikes:   record.count,
+		BannedAt:  now,
+		ExpiresAt: now.Add(cfg.banDuration),
+	}
+	logger.Warn().
+		Str("client_ip", ip).
+		Str("reason", reason).
+		Int("strikes", record.count).
+		Dur("duration", cfg.banDuration).
+		Msg("Guard: Client has been banned after repeated handshake violations.")
+}
+
+// sweepLocked 定期清理过期的封禁和违规记录，避免扫描流量使表无限增长。调用方需持有 mu。
+func (g *Guard) sweepLocked(now time.Time) {
+	if now.Sub(g.lastSweep) < strikeWindow {
+		return
+	}
+	g.lastSweep = now
+	for ip, record := range g.strikes {
+		if now.Sub(record.windowStart) >= strikeWindow {
+			delete(g.strikes, ip)
+		}
+	}
+	for ip, ban := range g.bans {
+		if !now.Before(ban.ExpiresAt) {
+			delete(g.bans, ip)
+		}
+	}
+}
+
+// Bans 返回当前生效的封禁，按封禁时间从新到旧排列。
+func (g *Guard) Bans() []*types.BanInfo {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bans := make([]*types.BanInfo, 0, len(g.bans))
+	for _, ban := range g.bans {
+		if now.Before(ban.ExpiresAt) {
+			banCopy := *ban
+			bans = append(bans, &banCopy)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].BannedAt.After(bans[j].BannedAt) })
+	return bans
+}
+
+// Unban 立即解除对 ip 的封禁并清空其违规记录，返回该 IP 此前是否处于封禁中。
+func (g *Guard) Unban(ip string) bool {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		ip = addr.Unmap().String()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, banned := g.bans[ip]
+	delete(g.bans, ip)
+	delete(g.strikes, ip)
+	return banned
+}
+
+func (c *config) isTrusted(ip string) bool {
+	if len(c.trusted) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 返回源地址中的 IP，IPv4 映射的 IPv6 地址还原为 IPv4。
+func clientIP(source net.Addr) string {
+	if source == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(source.String())
+	if err != nil {
+		host = source.String()
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
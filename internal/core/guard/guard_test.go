@@ -0,0 +1,184 @@
+package guard
+
+import (
+	"errors"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestApplySettingsDefaults(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           *settings.SecuritySettings
+		timeout       time.Duration
+		headerBytes   int
+		banDuration   time.Duration
+		trustedPrefix int
+	}{
+		{"nil settings", nil, defaultHandshakeTimeout, defaultMaxHeaderBytes, defaultBanDuration, 0},
+		{"explicit values", &settings.SecuritySettings{HandshakeTimeout: 3, MaxHeaderBytes: 8192, BanDuration: 60}, 3 * time.Second, 8192, time.Minute, 0},
+		{"header limit below minimum", &settings.SecuritySettings{MaxHeaderBytes: 100}, defaultHandshakeTimeout, minHeaderBytes, defaultBanDuration, 0},
+		{"trusted CIDRs", &settings.SecuritySettings{TrustedCIDRs: []string{"10.0.0.0/8", " ", "fd00::1/8"}}, defaultHandshakeTimeout, defaultMaxHeaderBytes, defaultBanDuration, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(tt.cfg)
+			c := g.cfg.Load()
+			if c.handshakeTimeout != tt.timeout || c.maxHeaderBytes != tt.headerBytes || c.banDuration != tt.banDuration {
+				t.Errorf("config = {%v, %d, %v}, want {%v, %d, %v}",
+					c.handshakeTimeout, c.maxHeaderBytes, c.banDuration, tt.timeout, tt.headerBytes, tt.banDuration)
+			}
+			if len(c.trusted) != tt.trustedPrefix {
+				t.Errorf("trusted prefixes = %v, want %d entries", c.trusted, tt.trustedPrefix)
+			}
+		})
+	}
+
+	g := New(nil)
+	if err := g.OnSettingsUpdate("security", &settings.SecuritySettings{TrustedCIDRs: []string{"bad"}}); err == nil {
+		t.Errorf("invalid trusted CIDR should be rejected")
+	}
+}
+
+// 违规次数在一分钟窗口内达到阈值时封禁，窗口过后重新计数。
+func TestStrikeThreshold(t *testing.T) {
+	g := New(&settings.SecuritySettings{BanThreshold: 3, BanDuration: 60})
+	cfg := g.cfg.Load()
+	const ip = "203.0.113.7"
+	now := time.Now()
+
+	g.mu.Lock()
+	g.strikeLocked(cfg, ip, "bad handshake", now)
+	g.strikeLocked(cfg, ip, "bad handshake", now.Add(10*time.Second))
+	// 窗口已过，前两次违规不再计入
+	g.strikeLocked(cfg, ip, "bad handshake", now.Add(strikeWindow))
+	g.strikeLocked(cfg, ip, "bad handshake", now.Add(strikeWindow+time.Second))
+	_, banned := g.bans[ip]
+	if banned {
+		g.mu.Unlock()
+		t.Fatalf("banned after strikes spread over two windows")
+	}
+	g.strikeLocked(cfg, ip, "header too large", now.Add(strikeWindow+2*time.Second))
+	ban := g.bans[ip]
+	g.mu.Unlock()
+
+	if ban == nil {
+		t.Fatalf("not banned after reaching the threshold")
+	}
+	if ban.IP != ip || ban.Reason != "header too large" || ban.Strikes != 3 {
+		t.Errorf("ban = %+v", ban)
+	}
+	if got := ban.ExpiresAt.Sub(ban.BannedAt); got != time.Minute {
+		t.Errorf("ban duration = %v, want 1m", got)
+	}
+	if bans := g.Bans(); len(bans) != 1 || bans[0].IP != ip {
+		t.Errorf("Bans() = %+v, want the ban for %s", bans, ip)
+	}
+
+	if _, err := g.BeginHandshake(tcpAddr(ip)); !errors.Is(err, ErrBanned) {
+		t.Errorf("BeginHandshake() from a banned IP = %v, want ErrBanned", err)
+	}
+	if _, err := g.BeginHandshake(tcpAddr("::ffff:" + ip)); !errors.Is(err, ErrBanned) {
+		t.Errorf("IPv4-mapped address of a banned IP = %v, want ErrBanned", err)
+	}
+}
+
+func TestStrikeDisabledAndTrusted(t *testing.T) {
+	g := New(&settings.SecuritySettings{BanThreshold: 0})
+	for i := 0; i < 10; i++ {
+		g.Strike(tcpAddr("203.0.113.7"), "bad handshake")
+	}
+	if len(g.Bans()) != 0 {
+		t.Errorf("ban_threshold 0 should never ban")
+	}
+
+	g = New(&settings.SecuritySettings{BanThreshold: 1, TrustedCIDRs: []string{"10.0.0.0/8"}})
+	g.Strike(tcpAddr("10.1.2.3"), "bad handshake")
+	if len(g.Bans()) != 0 {
+		t.Errorf("trusted client was banned")
+	}
+	g.Strike(tcpAddr("203.0.113.7"), "bad handshake")
+	if len(g.Bans()) != 1 {
+		t.Errorf("threshold 1 should ban on the first strike")
+	}
+}
+
+func TestBanExpiryAndUnban(t *testing.T) {
+	g := New(&settings.SecuritySettings{BanThreshold: 1})
+	source := tcpAddr("203.0.113.7")
+	g.Strike(source, "bad handshake")
+
+	// 封禁到期后允许握手，并清除封禁记录
+	g.mu.Lock()
+	g.bans["203.0.113.7"].ExpiresAt = time.Now().Add(-time.Second)
+	g.mu.Unlock()
+	if len(g.Bans()) != 0 {
+		t.Errorf("expired ban is still listed")
+	}
+	done, err := g.BeginHandshake(source)
+	if err != nil {
+		t.Fatalf("BeginHandshake() after expiry = %v", err)
+	}
+	done()
+
+	g.Strike(source, "bad handshake")
+	if !g.Unban("::ffff:203.0.113.7") {
+		t.Errorf("Unban() should report the active ban")
+	}
+	if g.Unban("203.0.113.7") {
+		t.Errorf("second Unban() should report no ban")
+	}
+	if _, err := g.BeginHandshake(source); err != nil {
+		t.Errorf("BeginHandshake() after Unban = %v", err)
+	}
+}
+
+// 同时进行中的握手数超限时拒绝并记违规，done 只释放一次名额。
+func TestBeginHandshakeLimit(t *testing.T) {
+	g := New(&settings.SecuritySettings{MaxHandshakesPerIP: 2, BanThreshold: 2})
+	source := tcpAddr("203.0.113.7")
+
+	done1, err := g.BeginHandshake(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done2, err := g.BeginHandshake(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.BeginHandshake(source); !errors.Is(err, ErrTooManyHandshakes) {
+		t.Fatalf("third handshake = %v, want ErrTooManyHandshakes", err)
+	}
+	if _, err := g.BeginHandshake(tcpAddr("203.0.113.8")); err != nil {
+		t.Errorf("limit should be per IP, got %v", err)
+	}
+
+	done1()
+	done1() // 重复调用不应多释放名额
+	done3, err := g.BeginHandshake(source)
+	if err != nil {
+		t.Fatalf("handshake after release = %v", err)
+	}
+	if _, err := g.BeginHandshake(source); !errors.Is(err, ErrTooManyHandshakes) {
+		t.Fatalf("handshake over the limit = %v, want ErrTooManyHandshakes", err)
+	}
+
+	// 第二次超限达到封禁阈值
+	if bans := g.Bans(); len(bans) != 1 || bans[0].Reason != ErrTooManyHandshakes.Error() {
+		t.Errorf("Bans() = %+v, want one ban for too many handshakes", bans)
+	}
+	done2()
+	done3()
+	g.mu.Lock()
+	remaining := len(g.handshakes)
+	g.mu.Unlock()
+	if remaining != 1 { // 203.0.113.8 的握手未结束
+		t.Errorf("handshake table has %d entries, want 1", remaining)
+	}
+}
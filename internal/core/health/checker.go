@@ -2,6 +2,7 @@ package health
 
 import (
 	"liuproxy_go/internal/shared/logger"
+	promMetrics "liuproxy_go/internal/shared/metrics"
 	"liuproxy_go/internal/shared/types"
 	"sync"
 	"time"
@@ -48,10 +49,12 @@ func (c *Checker) Check(instancesToCheck map[string]types.TunnelStrategy) (map[s
 				latency := time.Since(start)
 
 				if err == nil {
+					promMetrics.HealthCheckSeconds.Observe(latency.Seconds(), serverID, "success")
 					currentHealth = types.StatusUp
 					metrics.Latency = latency.Milliseconds()
 					logFields.Bool("success", true).Int64("latency_ms", metrics.Latency).Msg("HealthCheck: Check passed.")
 				} else {
+					promMetrics.HealthCheckSeconds.Observe(latency.Seconds(), serverID, "failure")
 					currentHealth = types.StatusDown
 					logFields.Bool("success", false).Err(err).Msg("HealthCheck: Check failed.")
 				}
@@ -0,0 +1,266 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrLimitExceeded 表示全局或客户端连接数已达上限，且在排队时间内没有空出配额
+	ErrLimitExceeded = errors.New("connection limit exceeded")
+	// ErrBackendFull 表示后端连接数已达上限
+	ErrBackendFull = errors.New("backend connection limit reached")
+)
+
+// config 是解析后的 "limits" 模块配置，上限为 0 表示不限制。
+type config struct {
+	maxTotal      int64
+	maxPerClient  int64
+	maxPerBackend int64
+	queueTimeout  time.Duration
+}
+
+// Limiter 统计网关的连接数，并执行全局、每客户端 IP 和每后端三个级别的连接上限。
+// 全局和客户端上限在连接进入网关时检查，超出时按配置排队等待或立即拒绝；
+// 后端上限在建立隧道时检查，Dispatcher 通过 BackendSaturated 跳过已满的后端。
+type Limiter struct {
+	// defaultMaxTotal 来自 liuproxy.ini 的 maxConnections，"limits" 模块未配置全局上限时使用
+	defaultMaxTotal int
+	cfg             atomic.Pointer[config]
+
+	mu       sync.Mutex
+	total    int64
+	clients  map[string]int64
+	backends map[string]int64
+	// released 在有配额归还或上限变化时关闭并替换，用于唤醒排队的连接
+	released chan struct{}
+
+	rejected atomic.Uint64
+}
+
+// New 创建连接限制器。cfg 为 "limits" 模块的初始配置，defaultMaxConnections 为 liuproxy.ini 中的 maxConnections。
+func New(cfg *settings.LimitsSettings, defaultMaxConnections int) *Limiter {
+	l := &Limiter{
+		defaultMaxTotal: defaultMaxConnections,
+		clients:         make(map[string]int64),
+		backends:        make(map[string]int64),
+		released:        make(chan struct{}),
+	}
+	l.applySettings(cfg)
+	return l
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。新的上限立即生效，已建立的连接不受影响。
+func (l *Limiter) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "limits" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.LimitsSettings)
+	if !ok {
+		return fmt.Errorf("limiter: received incorrect settings type for limits module")
+	}
+	l.applySettings(cfg)
+	c := l.cfg.Load()
+	logger.Info().
+		Int64("max_connections", c.maxTotal).
+		Int64("max_connections_per_client", c.maxPerClient).
+		Int64("max_connections_per_backend", c.maxPerBackend).
+		Dur("queue_timeout", c.queueTimeout).
+		Msg("Limiter: Connection limits have been reloaded.")
+	return nil
+}
+
+func (l *Limiter) applySettings(cfg *settings.LimitsSettings) {
+	if cfg == nil {
+		cfg = &settings.LimitsSettings{}
+	}
+	maxTotal := cfg.MaxConnections
+	if maxTotal <= 0 {
+		maxTotal = l.defaultMaxTotal
+	}
+	l.cfg.Store(&config{
+		maxTotal:      nonNegative(maxTotal),
+		maxPerClient:  nonNegative(cfg.MaxConnectionsPerClient),
+		maxPerBackend: nonNegative(cfg.MaxConnectionsPerBackend),
+		queueTimeout:  time.Duration(nonNegative(cfg.QueueTimeout)) * time.Millisecond,
+	})
+
+	// 上限可能已经放宽，唤醒排队的连接重新检查
+	l.mu.Lock()
+	l.notifyLocked()
+	l.mu.Unlock()
+}
+
+func nonNegative(n int) int64 {
+	if n < 0 {
+		return 0
+	}
+	return int64(n)
+}
+
+// AcquireClient 为来自 source 的新连接占用全局和客户端配额。超出上限时最多排队 queue_timeout，
+// 仍无配额则返回 ErrLimitExceeded。成功时返回的 release 必须在连接结束时调用一次。
+func (l *Limiter) AcquireClient(ctx context.Context, source net.Addr) (release func(), err error) {
+	client := clientKey(source)
+	var deadline <-chan time.Time
+	for {
+		cfg := l.cfg.Load()
+		l.mu.Lock()
+		if (cfg.maxTotal == 0 || l.total < cfg.maxTotal) && (cfg.maxPerClient == 0 || l.clients[client] < cfg.maxPerClient) {
+			l.total++
+			l.clients[client]++
+			l.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(func() { l.releaseClient(client) }) }, nil
+		}
+		released := l.released
+		full := "global"
+		if cfg.maxTotal == 0 || l.total < cfg.maxTotal {
+			full = "per-client"
+		}
+		l.mu.Unlock()
+
+		if deadline == nil {
+			if cfg.queueTimeout <= 0 {
+				l.rejected.Add(1)
+				return nil, fmt.Errorf("%w: %s", ErrLimitExceeded, full)
+			}
+			timer := time.NewTimer(cfg.queueTimeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-released:
+		case <-deadline:
+			l.rejected.Add(1)
+			return nil, fmt.Errorf("%w: %s, queued for %s", ErrLimitExceeded, full, cfg.queueTimeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *Limiter) releaseClient(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.clients[client]--; l.clients[client] <= 0 {
+		delete(l.clients, client)
+	}
+	l.notifyLocked()
+}
+
+// AcquireBackend 为到 serverID 的新隧道连接占用后端配额，后端已满时返回 ErrBackendFull。
+// 后端上限不排队: Dispatcher 已经跳过了已满的后端，这里只处理两者之间的竞争。
+func (l *Limiter) AcquireBackend(serverID string) (release func(), err error) {
+	cfg := l.cfg.Load()
+	l.mu.Lock()
+	if cfg.maxPerBackend > 0 && l.backends[serverID] >= cfg.maxPerBackend {
+		l.mu.Unlock()
+		l.rejected.Add(1)
+		return nil, fmt.Errorf("%w: %s", ErrBackendFull, serverID)
+	}
+	l.backends[serverID]++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			if l.backends[serverID]--; l.backends[serverID] <= 0 {
+				delete(l.backends, serverID)
+			}
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// BackendConnections 实现了 types.BackendLoad 接口，返回经网关建立到该后端的当前连接数。
+func (l *Limiter) BackendConnections(serverID string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.backends[serverID]
+}
+
+// BackendSaturated 实现了 types.BackendLoad 接口。
+func (l *Limiter) BackendSaturated(serverID string) bool {
+	cfg := l.cfg.Load()
+	if cfg.maxPerBackend == 0 {
+		return false
+	}
+	return l.BackendConnections(serverID) >= cfg.maxPerBackend
+}
+
+// Stats 返回当前连接数及上限的快照。
+func (l *Limiter) Stats() *types.ConnectionStats {
+	cfg := l.cfg.Load()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := &types.ConnectionStats{
+		Total:         l.total,
+		MaxTotal:      cfg.maxTotal,
+		MaxPerClient:  cfg.maxPerClient,
+		MaxPerBackend: cfg.maxPerBackend,
+		Clients:       make(map[string]int64, len(l.clients)),
+		Backends:      make(map[string]int64, len(l.backends)),
+		Rejected:      l.rejected.Load(),
+	}
+	for client, n := range l.clients {
+		stats.Clients[client] = n
+	}
+	for serverID, n := range l.backends {
+		stats.Backends[serverID] = n
+	}
+	return stats
+}
+
+// notifyLocked 唤醒所有排队的连接。调用方需持有 mu。
+func (l *Limiter) notifyLocked() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// clientKey 返回用于客户端上限的键，即源地址中的 IP。
+func clientKey(source net.Addr) string {
+	if source == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(source.String())
+	if err != nil {
+		return source.String()
+	}
+	return host
+}
+
+// releaseConn 在关闭时归还配额，并保留底层连接的半关闭能力。
+type releaseConn struct {
+	net.Conn
+	release func()
+}
+
+// WrapConn 返回一个关闭时调用 release 的连接，用于把后端配额绑定到隧道连接的生命周期。
+func WrapConn(conn net.Conn, release func()) net.Conn {
+	return &releaseConn{Conn: conn, release: release}
+}
+
+func (c *releaseConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// CloseWrite 转发给底层连接，relay 依赖它通知对端数据已发送完毕。
+func (c *releaseConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
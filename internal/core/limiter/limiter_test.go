@@ -0,0 +1,206 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"liuproxy_go/internal/shared/settings"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tcpAddr(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func mustAcquire(t *testing.T, l *Limiter, source net.Addr) func() {
+	t.Helper()
+	release, err := l.AcquireClient(context.Background(), source)
+	if err != nil {
+		t.Fatalf("AcquireClient(%s) failed: %v", source, err)
+	}
+	return release
+}
+
+func TestAcquireClientLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *settings.LimitsSettings
+		dflt    int
+		sources []net.Addr // 依次占用配额的连接
+		next    net.Addr   // 超出上限的连接
+		full    string
+	}{
+		{
+			name:    "global limit",
+			cfg:     &settings.LimitsSettings{MaxConnections: 2},
+			sources: []net.Addr{tcpAddr("10.0.0.1", 1), tcpAddr("10.0.0.2", 1)},
+			next:    tcpAddr("10.0.0.3", 1),
+			full:    "global",
+		},
+		{
+			name:    "per-client limit counts by IP",
+			cfg:     &settings.LimitsSettings{MaxConnectionsPerClient: 2},
+			sources: []net.Addr{tcpAddr("10.0.0.1", 1), tcpAddr("10.0.0.1", 2)},
+			next:    tcpAddr("10.0.0.1", 3),
+			full:    "per-client",
+		},
+		{
+			name:    "maxConnections from liuproxy.ini as fallback",
+			cfg:     nil,
+			dflt:    1,
+			sources: []net.Addr{tcpAddr("10.0.0.1", 1)},
+			next:    tcpAddr("10.0.0.2", 1),
+			full:    "global",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.cfg, tt.dflt)
+			for _, source := range tt.sources {
+				defer mustAcquire(t, l, source)()
+			}
+			_, err := l.AcquireClient(context.Background(), tt.next)
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("AcquireClient() over the limit = %v, want ErrLimitExceeded", err)
+			}
+			if !strings.Contains(err.Error(), tt.full) {
+				t.Errorf("error %q should name the %s limit", err, tt.full)
+			}
+			if got := l.Stats().Rejected; got != 1 {
+				t.Errorf("Rejected = %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestAcquireClientRelease(t *testing.T) {
+	l := New(&settings.LimitsSettings{MaxConnections: 1}, 0)
+	release := mustAcquire(t, l, tcpAddr("10.0.0.1", 1))
+	release()
+	release() // 重复调用不应多归还配额
+
+	stats := l.Stats()
+	if stats.Total != 0 || len(stats.Clients) != 0 {
+		t.Errorf("stats after release = total %d, clients %v", stats.Total, stats.Clients)
+	}
+	defer mustAcquire(t, l, tcpAddr("10.0.0.2", 1))()
+	if _, err := l.AcquireClient(context.Background(), tcpAddr("10.0.0.3", 1)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("double release freed an extra slot: %v", err)
+	}
+}
+
+// 排队的连接在配额归还或上限放宽时获得配额，超过 queue_timeout 仍无配额则被拒绝。
+func TestAcquireClientQueue(t *testing.T) {
+	t.Run("slot released while queued", func(t *testing.T) {
+		l := New(&settings.LimitsSettings{MaxConnections: 1, QueueTimeout: 5000}, 0)
+		release := mustAcquire(t, l, tcpAddr("10.0.0.1", 1))
+		time.AfterFunc(50*time.Millisecond, release)
+
+		start := time.Now()
+		mustAcquire(t, l, tcpAddr("10.0.0.2", 1))()
+		if waited := time.Since(start); waited >= 5*time.Second {
+			t.Errorf("queued for %v, should be woken by the release", waited)
+		}
+	})
+
+	t.Run("limit raised while queued", func(t *testing.T) {
+		l := New(&settings.LimitsSettings{MaxConnections: 1, QueueTimeout: 5000}, 0)
+		defer mustAcquire(t, l, tcpAddr("10.0.0.1", 1))()
+		time.AfterFunc(50*time.Millisecond, func() {
+			l.OnSettingsUpdate("limits", &settings.LimitsSettings{MaxConnections: 2, QueueTimeout: 5000})
+		})
+		mustAcquire(t, l, tcpAddr("10.0.0.2", 1))()
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		l := New(&settings.LimitsSettings{MaxConnections: 1, QueueTimeout: 100}, 0)
+		defer mustAcquire(t, l, tcpAddr("10.0.0.1", 1))()
+
+		start := time.Now()
+		_, err := l.AcquireClient(context.Background(), tcpAddr("10.0.0.2", 1))
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("AcquireClient() = %v, want ErrLimitExceeded", err)
+		}
+		if waited := time.Since(start); waited < 100*time.Millisecond {
+			t.Errorf("rejected after %v, want to queue for 100ms", waited)
+		}
+		if got := l.Stats().Rejected; got != 1 {
+			t.Errorf("Rejected = %d, want 1", got)
+		}
+	})
+
+	t.Run("timeout is not extended by unrelated releases", func(t *testing.T) {
+		l := New(&settings.LimitsSettings{MaxConnectionsPerClient: 1, QueueTimeout: 200}, 0)
+		defer mustAcquire(t, l, tcpAddr("10.0.0.1", 1))()
+		// 其他客户端不断归还配额，排队的连接被唤醒后仍按最初的期限超时
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				release, err := l.AcquireClient(context.Background(), tcpAddr("10.0.0.2", 1))
+				if err == nil {
+					time.Sleep(10 * time.Millisecond)
+					release()
+				}
+			}
+		}()
+
+		start := time.Now()
+		_, err := l.AcquireClient(context.Background(), tcpAddr("10.0.0.1", 2))
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("AcquireClient() = %v, want ErrLimitExceeded", err)
+		}
+		if waited := time.Since(start); waited > 2*time.Second {
+			t.Errorf("queued for %v, the deadline should not restart on wake-ups", waited)
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		l := New(&settings.LimitsSettings{MaxConnections: 1, QueueTimeout: 5000}, 0)
+		defer mustAcquire(t, l, tcpAddr("10.0.0.1", 1))()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		if _, err := l.AcquireClient(ctx, tcpAddr("10.0.0.2", 1)); !errors.Is(err, context.Canceled) {
+			t.Errorf("AcquireClient() = %v, want context.Canceled", err)
+		}
+		if got := l.Stats().Rejected; got != 0 {
+			t.Errorf("canceled connection counted as rejected: %d", got)
+		}
+	})
+}
+
+func TestAcquireBackend(t *testing.T) {
+	l := New(&settings.LimitsSettings{MaxConnectionsPerBackend: 1}, 0)
+	release, err := l.AcquireBackend("server1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.BackendSaturated("server1") || l.BackendSaturated("server2") {
+		t.Errorf("BackendSaturated() does not reflect the per-backend counts")
+	}
+	if _, err := l.AcquireBackend("server1"); !errors.Is(err, ErrBackendFull) {
+		t.Errorf("AcquireBackend() on a full backend = %v, want ErrBackendFull", err)
+	}
+
+	conn := WrapConn(&nopConn{}, release)
+	conn.Close()
+	if n := l.BackendConnections("server1"); n != 0 {
+		t.Errorf("BackendConnections() after Close = %d, want 0", n)
+	}
+	if _, err := l.AcquireBackend("server1"); err != nil {
+		t.Errorf("AcquireBackend() after release = %v", err)
+	}
+}
+
+// nopConn 是一个什么都不做的连接，用于检查 WrapConn 的配额归还。
+type nopConn struct{ net.Conn }
+
+func (c *nopConn) Close() error { return nil }
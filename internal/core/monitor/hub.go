@@ -0,0 +1,215 @@
+package monitor
+
+import (
+	"liuproxy_go/internal/shared/traffic"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 监控事件的类型。
+const (
+	EventOpen       = "open"       // 连接的目标已确定
+	EventRoute      = "route"      // Dispatcher 做出了路由决策
+	EventClose      = "close"      // 连接已关闭，携带最终的流量
+	EventHealth     = "health"     // 服务器的健康状态发生变化
+	EventThroughput = "throughput" // 定期的吞吐量采样
+)
+
+const (
+	// subscriberBuffer 是每个订阅者的事件缓冲，订阅者处理不及时时丢弃新的事件，不阻塞网关
+	subscriberBuffer = 256
+	// sampleInterval 是吞吐量采样的间隔
+	sampleInterval = 2 * time.Second
+)
+
+// Filter 是订阅者在服务端的过滤条件，为空的字段不参与过滤。
+type Filter struct {
+	Client string // 客户端 IP
+	Target string // 目标或嗅探到的域名中包含的字符串，不区分大小写
+	Server string // 服务器 ID，也可以是 "DIRECT" 或 "REJECT"
+}
+
+// apply 返回 ev 经过滤后应推送给订阅者的事件，不匹配时返回 nil。
+// 连接事件需要满足所有条件；健康事件只按服务器过滤；吞吐量采样只保留匹配的客户端和服务器。
+func (f Filter) apply(ev *types.MonitorEvent) *types.MonitorEvent {
+	switch ev.Type {
+	case EventHealth:
+		if f.Server != "" && ev.ServerID != f.Server {
+			return nil
+		}
+		return ev
+	case EventThroughput:
+		if f.Client == "" && f.Server == "" {
+			return ev
+		}
+		report := *ev.Traffic
+		report.Clients = filterStats(report.Clients, f.Client)
+		report.Backends = filterStats(report.Backends, f.Server)
+		filtered := *ev
+		filtered.Traffic = &report
+		return &filtered
+	}
+	conn := ev.Connection
+	if conn == nil {
+		return nil
+	}
+	if f.Client != "" {
+		if host, _, err := net.SplitHostPort(conn.Client); err != nil || host != f.Client {
+			return nil
+		}
+	}
+	if f.Target != "" {
+		target := strings.ToLower(f.Target)
+		if !strings.Contains(strings.ToLower(conn.Target), target) && !strings.Contains(strings.ToLower(conn.SniffedHost), target) {
+			return nil
+		}
+	}
+	if f.Server != "" && conn.ServerID != f.Server {
+		return nil
+	}
+	return ev
+}
+
+// filterStats 只保留 key 对应的统计，key 为空时原样返回。
+func filterStats(stats map[string]types.TrafficStats, key string) map[string]types.TrafficStats {
+	if key == "" {
+		return stats
+	}
+	filtered := make(map[string]types.TrafficStats, 1)
+	if s, ok := stats[key]; ok {
+		filtered[key] = s
+	}
+	return filtered
+}
+
+type subscriber struct {
+	filter Filter
+	events chan *types.MonitorEvent
+}
+
+// Hub 把网关的连接事件、路由决策、健康变化和吞吐量采样广播给所有订阅者 (Web UI 的 Monitor 页面)。
+// 没有订阅者时发布事件几乎没有开销。Hub 的方法都可以在 nil 上调用。
+type Hub struct {
+	meter *traffic.Meter
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	count       atomic.Int32
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+	waitGroup sync.WaitGroup
+}
+
+// New 创建监控中心，meter 提供吞吐量采样，为 nil 时不发送采样。
+func New(meter *traffic.Meter) *Hub {
+	return &Hub{
+		meter:       meter,
+		subscribers: make(map[*subscriber]struct{}),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start 开始定期发送吞吐量采样，只在有订阅者时采样。
+func (h *Hub) Start() {
+	if h == nil || h.meter == nil {
+		return
+	}
+	h.waitGroup.Add(1)
+	go func() {
+		defer h.waitGroup.Done()
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if h.Enabled() {
+					h.Publish(&types.MonitorEvent{Type: EventThroughput, Traffic: h.meter.Stats()})
+				}
+			case <-h.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止采样并关闭所有订阅。
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.closeOnce.Do(func() {
+		close(h.stopChan)
+		h.waitGroup.Wait()
+		h.mu.Lock()
+		for sub := range h.subscribers {
+			close(sub.events)
+			delete(h.subscribers, sub)
+		}
+		h.count.Store(0)
+		h.mu.Unlock()
+	})
+}
+
+// Enabled 报告是否有订阅者。发布方可以据此跳过构造事件的开销。
+func (h *Hub) Enabled() bool {
+	return h != nil && h.count.Load() > 0
+}
+
+// Publish 将事件推送给过滤条件匹配的订阅者。缓冲已满的订阅者会丢失该事件。
+func (h *Hub) Publish(ev *types.MonitorEvent) {
+	if !h.Enabled() {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		filtered := sub.filter.apply(ev)
+		if filtered == nil {
+			continue
+		}
+		select {
+		case sub.events <- filtered:
+		default:
+		}
+	}
+}
+
+// Subscribe 订阅满足 filter 的事件。返回的通道在调用 cancel 或 Hub 关闭后被关闭。
+func (h *Hub) Subscribe(filter Filter) (<-chan *types.MonitorEvent, func()) {
+	sub := &subscriber{filter: filter, events: make(chan *types.MonitorEvent, subscriberBuffer)}
+	if h == nil {
+		close(sub.events)
+		return sub.events, func() {}
+	}
+	h.mu.Lock()
+	select {
+	case <-h.stopChan:
+		close(sub.events)
+	default:
+		h.subscribers[sub] = struct{}{}
+		h.count.Add(1)
+	}
+	h.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, ok := h.subscribers[sub]; ok {
+				delete(h.subscribers, sub)
+				h.count.Add(-1)
+				close(sub.events)
+			}
+		})
+	}
+	return sub.events, cancel
+}
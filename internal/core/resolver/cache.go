@@ -0,0 +1,89 @@
+package resolver
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheSize 是未配置 cache_size 时最多缓存的域名数。
+	defaultCacheSize = 1024
+	// systemTTL 是系统解析器结果的缓存时间，系统解析器不提供 TTL。
+	systemTTL = 60 * time.Second
+	// negativeTTL 是域名不存在或没有地址时的缓存时间。
+	negativeTTL = 30 * time.Second
+	// maxTTL 限制单条结果的缓存时间，避免上游给出过长的 TTL。
+	maxTTL = time.Hour
+)
+
+type cacheKey struct {
+	host   string
+	direct bool // 直连查询与经隧道查询的结果分开缓存，避免被污染的结果进入隧道一侧
+}
+
+type cacheEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// addrCache 按域名缓存解析结果，包括 "域名不存在" 这样的否定结果。
+type addrCache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]*cacheEntry
+	maxEntries int
+}
+
+func newAddrCache(maxEntries int) *addrCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheSize
+	}
+	return &addrCache{entries: make(map[cacheKey]*cacheEntry), maxEntries: maxEntries}
+}
+
+// get 返回未过期的缓存结果，ok 为 false 表示需要重新查询。
+func (c *addrCache) get(key cacheKey, now time.Time) (addrs []netip.Addr, err error, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, nil, false
+	}
+	return entry.addrs, entry.err, true
+}
+
+// put 缓存一条结果，ttl 不大于 0 时不缓存。
+func (c *addrCache) put(key cacheKey, addrs []netip.Addr, err error, ttl time.Duration, now time.Time) {
+	if ttl <= 0 {
+		return
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cacheEntry{addrs: addrs, err: err, expires: now.Add(ttl)}
+}
+
+// evictLocked 先清理过期条目，仍然已满时随机淘汰一条。调用方需持有 mu。
+func (c *addrCache) evictLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,308 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config 是解析后的 "resolver" 模块配置。
+type config struct {
+	direct []upstream // 直连发送查询的上游
+	tunnel []upstream // 经 via 隧道发送查询的上游，未配置 via 时为 nil
+	via    string
+}
+
+// call 是一次进行中的查询，同一域名的并发查询共享它的结果。
+type call struct {
+	done  chan struct{}
+	addrs []netip.Addr
+	err   error
+}
+
+// Resolver 是进程内共享的域名解析器。路由的 dest_ip 规则、直连拨号和 VLESS 服务器地址都经由它解析，
+// 以按 TTL 缓存的结果代替每个连接一次的阻塞查询，并可以绕开被污染的系统 DNS。
+// 查询可以发往 UDP、TCP、DoT 或 DoH 上游，也可以经指定的隧道后端发送；未配置上游时使用系统解析器。
+type Resolver struct {
+	stateProvider types.StateProvider
+
+	cfg   atomic.Pointer[config]
+	cache atomic.Pointer[addrCache]
+
+	inflightMu sync.Mutex
+	inflight   map[cacheKey]*call
+}
+
+// New 创建解析器，cfg 为 "resolver" 模块的初始配置。stateProvider 用于查找 via 指定的隧道后端。
+func New(cfg *settings.ResolverSettings, stateProvider types.StateProvider) *Resolver {
+	r := &Resolver{
+		stateProvider: stateProvider,
+		inflight:      make(map[cacheKey]*call),
+	}
+	if err := r.applySettings(cfg); err != nil {
+		logger.Warn().Err(err).Msg("Resolver: Invalid settings, falling back to the system resolver.")
+		r.applySettings(nil)
+	}
+	return r
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。新的上游立即生效并清空缓存。
+func (r *Resolver) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "resolver" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.ResolverSettings)
+	if !ok {
+		return fmt.Errorf("resolver: received incorrect settings type for resolver module")
+	}
+	if err := r.applySettings(cfg); err != nil {
+		return err
+	}
+	logger.Info().Strs("upstreams", cfg.Upstreams).Str("via", cfg.Via).Msg("Resolver: Settings have been reloaded.")
+	return nil
+}
+
+func (r *Resolver) applySettings(cfg *settings.ResolverSettings) error {
+	if cfg == nil {
+		cfg = &settings.ResolverSettings{}
+	}
+	parsed := &config{via: cfg.Via}
+	var directDialer net.Dialer
+	for _, raw := range cfg.Upstreams {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := newUpstream(raw, directDialer.DialContext, false)
+		if err != nil {
+			return err
+		}
+		parsed.direct = append(parsed.direct, u)
+		if cfg.Via != "" {
+			u, err := newUpstream(raw, r.dialVia(cfg.Via), true)
+			if err != nil {
+				return err
+			}
+			parsed.tunnel = append(parsed.tunnel, u)
+		}
+	}
+	r.cfg.Store(parsed)
+	r.cache.Store(newAddrCache(cfg.CacheSize))
+	return nil
+}
+
+// dialVia 返回经指定后端建立连接的拨号函数。后端在拨号时才查找，以便使用最新的策略实例。
+func (r *Resolver) dialVia(serverID string) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if r.stateProvider == nil {
+			return nil, fmt.Errorf("resolver backend %q is not available", serverID)
+		}
+		state, ok := r.stateProvider.GetServerStates()[serverID]
+		if !ok || state.Instance == nil {
+			return nil, fmt.Errorf("resolver backend %q is not available", serverID)
+		}
+		return state.Instance.DialContext(ctx, network, address)
+	}
+}
+
+// LookupIP 返回域名的地址，IPv4 地址在前。host 本身是 IP 时直接返回。
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookup(ctx, host, false)
+}
+
+// LookupIPDirect 与 LookupIP 相同，但查询总是直连上游。
+// 用于解析隧道服务器自身的地址，经隧道查询这些地址会形成循环依赖。
+func (r *Resolver) LookupIPDirect(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookup(ctx, host, true)
+}
+
+// DialContext 用解析器解析 address 中的域名，并依次尝试各个地址建立连接，供直连使用。
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string, direct bool) ([]netip.Addr, error) {
+	host = strings.TrimSuffix(host, ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if host == "" {
+		return nil, fmt.Errorf("empty host")
+	}
+
+	cfg := r.cfg.Load()
+	if len(cfg.tunnel) == 0 {
+		direct = true
+	}
+	key := cacheKey{host: strings.ToLower(host), direct: direct}
+	cache := r.cache.Load()
+	if addrs, err, ok := cache.get(key, time.Now()); ok {
+		return addrs, err
+	}
+
+	// 合并同一域名的并发查询，查询本身不受单个调用方取消的影响
+	r.inflightMu.Lock()
+	c, ok := r.inflight[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		r.inflight[key] = c
+		r.inflightMu.Unlock()
+
+		qctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), queryTimeout)
+		var ttl time.Duration
+		c.addrs, ttl, c.err = r.resolve(qctx, cfg, key.host, direct)
+		cancel()
+		cache.put(key, c.addrs, c.err, ttl, time.Now())
+
+		r.inflightMu.Lock()
+		delete(r.inflight, key)
+		r.inflightMu.Unlock()
+		close(c.done)
+	} else {
+		r.inflightMu.Unlock()
+	}
+
+	select {
+	case <-c.done:
+		return c.addrs, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve 执行一次实际的查询，返回的 ttl 为 0 表示结果不应被缓存。
+func (r *Resolver) resolve(ctx context.Context, cfg *config, host string, direct bool) ([]netip.Addr, time.Duration, error) {
+	upstreams := cfg.tunnel
+	if direct {
+		upstreams = cfg.direct
+	}
+	if len(upstreams) == 0 {
+		return lookupSystem(ctx, host)
+	}
+
+	var lastErr error
+	for _, u := range upstreams {
+		addrs, ttl, err := queryUpstream(ctx, u, host)
+		if err == nil {
+			return addrs, ttl, nil
+		}
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, negativeTTL, err
+		}
+		logger.Ctx(ctx, "").Debug().Err(err).Str("host", host).Str("upstream", u.String()).Msg("Resolver: Upstream query failed")
+		lastErr = err
+	}
+	return nil, 0, fmt.Errorf("resolve %s: %w", host, lastErr)
+}
+
+// queryUpstream 并行发送 A 和 AAAA 查询，合并结果并取两者中较小的 TTL。
+func queryUpstream(ctx context.Context, u upstream, host string) ([]netip.Addr, time.Duration, error) {
+	type result struct {
+		addrs    []netip.Addr
+		ttl      time.Duration
+		notFound bool
+		err      error
+	}
+	qtypes := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	results := make([]result, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			query, err := buildQuery(host, qtype)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			resp, err := u.exchange(ctx, query)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			res := &results[i]
+			res.addrs, res.ttl, res.notFound, res.err = parseAnswer(query, resp)
+		}()
+	}
+	wg.Wait()
+
+	var addrs []netip.Addr
+	var ttl time.Duration
+	var firstErr error
+	for _, res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		if res.notFound {
+			continue
+		}
+		if len(addrs) == 0 || res.ttl < ttl {
+			ttl = res.ttl
+		}
+		addrs = append(addrs, res.addrs...)
+	}
+	if len(addrs) == 0 {
+		if firstErr != nil {
+			return nil, 0, firstErr
+		}
+		return nil, negativeTTL, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if firstErr != nil {
+		// 只有一种地址族查询成功时结果仍然可用，但不缓存不完整的结果
+		ttl = 0
+	}
+	return addrs, ttl, nil
+}
+
+// lookupSystem 使用系统解析器，结果按 systemTTL 缓存。
+func lookupSystem(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, negativeTTL, err
+		}
+		return nil, 0, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	// 与上游查询的结果一致，IPv4 地址在前
+	sort.SliceStable(addrs, func(i, j int) bool { return addrs[i].Is4() && !addrs[j].Is4() })
+	return addrs, systemTTL, nil
+}
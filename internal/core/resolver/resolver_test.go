@@ -0,0 +1,394 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeAnswer 描述假上游对一种查询类型的应答。
+type fakeAnswer struct {
+	rcode dnsmessage.RCode
+	ttl   uint32
+	addrs []string
+	err   error // 不为 nil 时 exchange 直接返回该错误
+}
+
+// fakeUpstream 按查询类型返回预设的应答，并统计收到的查询数。gate 不为 nil 时，exchange 在其关闭后才返回。
+type fakeUpstream struct {
+	name    string
+	answers map[dnsmessage.Type]fakeAnswer
+	gate    chan struct{}
+	queries atomic.Int32
+}
+
+func (u *fakeUpstream) String() string  { return u.name }
+func (u *fakeUpstream) address() string { return u.name + ":53" }
+
+func (u *fakeUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	u.queries.Add(1)
+	if u.gate != nil {
+		<-u.gate
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	q := msg.Questions[0]
+	answer := u.answers[q.Type]
+	if answer.err != nil {
+		return nil, answer.err
+	}
+	return packResponse(msg.ID, q, answer)
+}
+
+func packResponse(id uint16, q dnsmessage.Question, answer fakeAnswer) ([]byte, error) {
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, Response: true, RCode: answer.rcode},
+		Questions: []dnsmessage.Question{q},
+	}
+	for _, raw := range answer.addrs {
+		addr := netip.MustParseAddr(raw)
+		header := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: answer.ttl}
+		if addr.Is4() {
+			header.Type = dnsmessage.TypeA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+		} else {
+			header.Type = dnsmessage.TypeAAAA
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+		}
+	}
+	return resp.Pack()
+}
+
+func parseAddrs(raw ...string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, s := range raw {
+		addrs = append(addrs, netip.MustParseAddr(s))
+	}
+	return addrs
+}
+
+// newTestResolver 返回直连查询 upstreams 的解析器。
+func newTestResolver(upstreams ...upstream) *Resolver {
+	r := New(nil, nil)
+	r.cfg.Store(&config{direct: upstreams})
+	return r
+}
+
+func TestParseAnswer(t *testing.T) {
+	query, err := buildQuery("example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	msg.Unpack(query)
+	q := msg.Questions[0]
+	pack := func(id uint16, answer fakeAnswer) []byte {
+		resp, err := packResponse(id, q, answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	withCNAME := func() []byte {
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: msg.ID, Response: true},
+			Questions: []dnsmessage.Question{q},
+			Answers: []dnsmessage.Resource{
+				{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 5},
+					Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("edge.example.net.")}},
+				{Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("edge.example.net."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 120},
+					Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 9}}},
+			},
+		}
+		packed, _ := resp.Pack()
+		return packed
+	}
+
+	tests := []struct {
+		name     string
+		resp     []byte
+		addrs    []netip.Addr
+		ttl      time.Duration
+		notFound bool
+		wantErr  bool
+	}{
+		{"addresses with minimum TTL", pack(msg.ID, fakeAnswer{ttl: 300, addrs: []string{"192.0.2.1", "192.0.2.2"}}), parseAddrs("192.0.2.1", "192.0.2.2"), 300 * time.Second, false, false},
+		{"IPv4-mapped AAAA is unmapped", pack(msg.ID, fakeAnswer{ttl: 60, addrs: []string{"::ffff:192.0.2.3", "2001:db8::1"}}), parseAddrs("192.0.2.3", "2001:db8::1"), time.Minute, false, false},
+		{"CNAME TTL is ignored", withCNAME(), parseAddrs("192.0.2.9"), 120 * time.Second, false, false},
+		{"NXDOMAIN", pack(msg.ID, fakeAnswer{rcode: dnsmessage.RCodeNameError}), nil, 0, true, false},
+		{"no data", pack(msg.ID, fakeAnswer{}), nil, 0, true, false},
+		{"SERVFAIL", pack(msg.ID, fakeAnswer{rcode: dnsmessage.RCodeServerFailure}), nil, 0, false, true},
+		{"ID mismatch", pack(msg.ID+1, fakeAnswer{ttl: 60, addrs: []string{"192.0.2.1"}}), nil, 0, false, true},
+		{"malformed", []byte{0x00, 0x01, 0x02}, nil, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, ttl, notFound, err := parseAnswer(query, tt.resp)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", addrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) || ttl != tt.ttl || notFound != tt.notFound {
+				t.Errorf("parseAnswer() = %v, %v, %v, want %v, %v, %v", addrs, ttl, notFound, tt.addrs, tt.ttl, tt.notFound)
+			}
+		})
+	}
+}
+
+func TestQueryUpstream(t *testing.T) {
+	errTimeout := errors.New("i/o timeout")
+	tests := []struct {
+		name     string
+		a, aaaa  fakeAnswer
+		addrs    []netip.Addr
+		ttl      time.Duration
+		notFound bool
+		wantErr  bool
+	}{
+		{
+			name:  "A and AAAA merged, IPv4 first, smaller TTL",
+			a:     fakeAnswer{ttl: 300, addrs: []string{"192.0.2.1"}},
+			aaaa:  fakeAnswer{ttl: 120, addrs: []string{"2001:db8::1"}},
+			addrs: parseAddrs("192.0.2.1", "2001:db8::1"),
+			ttl:   120 * time.Second,
+		},
+		{
+			name:  "no AAAA records",
+			a:     fakeAnswer{ttl: 300, addrs: []string{"192.0.2.1"}},
+			aaaa:  fakeAnswer{},
+			addrs: parseAddrs("192.0.2.1"),
+			ttl:   300 * time.Second,
+		},
+		{
+			name:  "partial result is not cached",
+			a:     fakeAnswer{ttl: 300, addrs: []string{"192.0.2.1"}},
+			aaaa:  fakeAnswer{err: errTimeout},
+			addrs: parseAddrs("192.0.2.1"),
+			ttl:   0,
+		},
+		{
+			name:  "partial result after SERVFAIL",
+			a:     fakeAnswer{rcode: dnsmessage.RCodeServerFailure},
+			aaaa:  fakeAnswer{ttl: 60, addrs: []string{"2001:db8::1"}},
+			addrs: parseAddrs("2001:db8::1"),
+			ttl:   0,
+		},
+		{
+			name:     "NXDOMAIN",
+			a:        fakeAnswer{rcode: dnsmessage.RCodeNameError},
+			aaaa:     fakeAnswer{rcode: dnsmessage.RCodeNameError},
+			ttl:      negativeTTL,
+			notFound: true,
+		},
+		{
+			name:    "error without any address",
+			a:       fakeAnswer{err: errTimeout},
+			aaaa:    fakeAnswer{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &fakeUpstream{name: "fake", answers: map[dnsmessage.Type]fakeAnswer{dnsmessage.TypeA: tt.a, dnsmessage.TypeAAAA: tt.aaaa}}
+			addrs, ttl, err := queryUpstream(context.Background(), u, "example.com")
+			if got := u.queries.Load(); got != 2 {
+				t.Errorf("sent %d queries, want A and AAAA", got)
+			}
+			if tt.notFound {
+				var dnsErr *net.DNSError
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					t.Fatalf("err = %v, want a not-found DNSError", err)
+				}
+				if ttl != tt.ttl {
+					t.Errorf("negative ttl = %v, want %v", ttl, tt.ttl)
+				}
+				return
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", addrs)
+				}
+				if ttl != 0 {
+					t.Errorf("failed query has ttl %v, should not be cached", ttl)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(addrs, tt.addrs) || ttl != tt.ttl {
+				t.Errorf("queryUpstream() = %v, %v, want %v, %v", addrs, ttl, tt.addrs, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestLookupCaching(t *testing.T) {
+	ok := map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA:    {ttl: 300, addrs: []string{"192.0.2.1"}},
+		dnsmessage.TypeAAAA: {ttl: 300, addrs: []string{"2001:db8::1"}},
+	}
+	partial := map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA:    {ttl: 300, addrs: []string{"192.0.2.1"}},
+		dnsmessage.TypeAAAA: {err: errors.New("i/o timeout")},
+	}
+	nxdomain := map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA:    {rcode: dnsmessage.RCodeNameError},
+		dnsmessage.TypeAAAA: {rcode: dnsmessage.RCodeNameError},
+	}
+	tests := []struct {
+		name     string
+		answers  map[dnsmessage.Type]fakeAnswer
+		queries  int32 // 两次查询后上游收到的查询数
+		notFound bool
+	}{
+		{"complete result is cached", ok, 2, false},
+		{"partial result is queried again", partial, 4, false},
+		{"NXDOMAIN is cached", nxdomain, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &fakeUpstream{name: "fake", answers: tt.answers}
+			r := newTestResolver(u)
+			for i := 0; i < 2; i++ {
+				addrs, err := r.LookupIP(context.Background(), "Example.com.")
+				if tt.notFound {
+					var dnsErr *net.DNSError
+					if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+						t.Fatalf("lookup %d: err = %v, want a not-found DNSError", i, err)
+					}
+					continue
+				}
+				if err != nil || len(addrs) == 0 || addrs[0] != netip.MustParseAddr("192.0.2.1") {
+					t.Fatalf("lookup %d = %v, %v", i, addrs, err)
+				}
+			}
+			if got := u.queries.Load(); got != tt.queries {
+				t.Errorf("upstream received %d queries, want %d", got, tt.queries)
+			}
+		})
+	}
+}
+
+// 上游出错时尝试下一个上游，域名不存在则直接返回。
+func TestLookupUpstreamFallback(t *testing.T) {
+	failing := &fakeUpstream{name: "failing", answers: map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA:    {err: errors.New("connection refused")},
+		dnsmessage.TypeAAAA: {err: errors.New("connection refused")},
+	}}
+	working := &fakeUpstream{name: "working", answers: map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA: {ttl: 60, addrs: []string{"192.0.2.1"}},
+	}}
+	addrs, err := newTestResolver(failing, working).LookupIP(context.Background(), "example.com")
+	if err != nil || !reflect.DeepEqual(addrs, parseAddrs("192.0.2.1")) {
+		t.Errorf("LookupIP() = %v, %v, want the second upstream's answer", addrs, err)
+	}
+
+	nx := &fakeUpstream{name: "nx", answers: map[dnsmessage.Type]fakeAnswer{
+		dnsmessage.TypeA:    {rcode: dnsmessage.RCodeNameError},
+		dnsmessage.TypeAAAA: {rcode: dnsmessage.RCodeNameError},
+	}}
+	next := &fakeUpstream{name: "next", answers: working.answers}
+	if _, err := newTestResolver(nx, next).LookupIP(context.Background(), "example.com"); err == nil {
+		t.Errorf("NXDOMAIN from the first upstream should be returned")
+	}
+	if got := next.queries.Load(); got != 0 {
+		t.Errorf("next upstream received %d queries after NXDOMAIN", got)
+	}
+}
+
+func TestLookupIPLiteral(t *testing.T) {
+	u := &fakeUpstream{name: "fake"}
+	r := newTestResolver(u)
+	for host, want := range map[string]string{"192.0.2.1": "192.0.2.1", "::ffff:192.0.2.1": "192.0.2.1", "2001:db8::1": "2001:db8::1"} {
+		addrs, err := r.LookupIP(context.Background(), host)
+		if err != nil || len(addrs) != 1 || addrs[0] != netip.MustParseAddr(want) {
+			t.Errorf("LookupIP(%q) = %v, %v, want %s", host, addrs, err, want)
+		}
+	}
+	if got := u.queries.Load(); got != 0 {
+		t.Errorf("IP literals sent %d queries", got)
+	}
+}
+
+// 同一域名的并发查询共享一次上游查询。
+func TestLookupCoalescesInflight(t *testing.T) {
+	u := &fakeUpstream{
+		name: "slow",
+		gate: make(chan struct{}),
+		answers: map[dnsmessage.Type]fakeAnswer{
+			dnsmessage.TypeA: {ttl: 60, addrs: []string{"192.0.2.1"}},
+		},
+	}
+	r := newTestResolver(u)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	results := make([][]netip.Addr, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = r.LookupIP(context.Background(), "example.com")
+		}()
+	}
+	// 等待第一次查询到达上游，再等其余调用方加入等待
+	for u.queries.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(u.gate)
+	wg.Wait()
+
+	if got := u.queries.Load(); got != 2 {
+		t.Errorf("upstream received %d queries, want a single A/AAAA pair", got)
+	}
+	for i := range results {
+		if errs[i] != nil || !reflect.DeepEqual(results[i], parseAddrs("192.0.2.1")) {
+			t.Errorf("caller %d got %v, %v", i, results[i], errs[i])
+		}
+	}
+
+	// 等待中的调用方可以放弃等待，查询仍然完成并进入缓存
+	u2 := &fakeUpstream{name: "slow", gate: make(chan struct{}), answers: u.answers}
+	r = newTestResolver(u2)
+	leader := make(chan error, 1)
+	go func() {
+		_, err := r.LookupIP(context.Background(), "example.org")
+		leader <- err
+	}()
+	for u2.queries.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := r.LookupIP(ctx, "example.org"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled lookup = %v, want context.Canceled", err)
+	}
+	close(u2.gate)
+	if err := <-leader; err != nil {
+		t.Fatalf("in-flight lookup failed: %v", err)
+	}
+	if addrs, err, ok := r.cache.Load().get(cacheKey{host: "example.org", direct: true}, time.Now()); !ok || err != nil || len(addrs) != 1 {
+		t.Errorf("cached result = %v, %v, %v", addrs, err, ok)
+	}
+	if got := u2.queries.Load(); got != 2 {
+		t.Errorf("upstream received %d queries, want a single A/AAAA pair", got)
+	}
+}
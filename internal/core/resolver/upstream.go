@@ -0,0 +1,292 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// queryTimeout 是一次完整解析 (包括依次尝试多个上游) 的最长时间。
+	queryTimeout = 5 * time.Second
+	// maxMessageSize 是 DNS 消息的最大长度。
+	maxMessageSize = 65535
+)
+
+// DialFunc 建立到上游的连接，可以是直连，也可以经隧道后端。
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// upstream 是一个 DNS 上游，exchange 发送一个查询并返回原始应答，address 返回查询发往的 host:port。
+type upstream interface {
+	exchange(ctx context.Context, query []byte) ([]byte, error)
+	address() string
+	String() string
+}
+
+// Upstream 是可以转发原始查询的 DNS 上游，供内置 DNS 服务器转发客户端的查询。
+type Upstream struct {
+	u upstream
+}
+
+// NewUpstream 解析上游地址 raw，格式与 "resolver" 模块的 upstreams 相同。查询经 dial 建立的连接发送，
+// tunnel 为 true 表示 dial 经隧道后端建立连接，此时 UDP 上游改用 TCP。
+func NewUpstream(raw string, dial DialFunc, tunnel bool) (*Upstream, error) {
+	u, err := newUpstream(strings.TrimSpace(raw), dial, tunnel)
+	if err != nil {
+		return nil, err
+	}
+	return &Upstream{u: u}, nil
+}
+
+// Addr 返回查询发往的 host:port。
+func (u *Upstream) Addr() string { return u.u.address() }
+
+func (u *Upstream) String() string { return u.u.String() }
+
+// Exchange 发送原始查询 query 并返回原始应答。UDP 应答被截断时改用 TCP 重试。
+func (u *Upstream) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return u.u.exchange(ctx, query)
+}
+
+// Close 关闭上游保持的空闲连接 (DoH)。
+func (u *Upstream) Close() {
+	if h, ok := u.u.(*httpsUpstream); ok {
+		h.client.CloseIdleConnections()
+	}
+}
+
+// newUpstream 解析上游地址。tunnel 为 true 表示经隧道发送，此时 UDP 上游改用 TCP，因为并非所有后端都支持 UDP。
+func newUpstream(raw string, dial DialFunc, tunnel bool) (upstream, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "udp://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", raw, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid upstream %q: missing host", raw)
+	}
+	withPort := func(defaultPort string) string {
+		port := u.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return &plainUpstream{raw: raw, addr: withPort("53"), dial: dial, stream: tunnel}, nil
+	case "tcp":
+		return &plainUpstream{raw: raw, addr: withPort("53"), dial: dial, stream: true}, nil
+	case "tls":
+		return &tlsUpstream{raw: raw, addr: withPort("853"), serverName: u.Hostname(), dial: dial}, nil
+	case "https":
+		return &httpsUpstream{
+			url:  u.String(),
+			addr: withPort("443"),
+			client: &http.Client{
+				Timeout: queryTimeout,
+				Transport: &http.Transport{
+					DialContext:         dial,
+					ForceAttemptHTTP2:   true,
+					TLSHandshakeTimeout: queryTimeout,
+					IdleConnTimeout:     30 * time.Second,
+				},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid upstream %q: unsupported scheme %q", raw, u.Scheme)
+	}
+}
+
+// plainUpstream 是普通 DNS 上游 (RFC 1035)，stream 为 true 时使用 TCP。
+type plainUpstream struct {
+	raw    string
+	addr   string
+	dial   DialFunc
+	stream bool
+}
+
+func (u *plainUpstream) String() string  { return u.raw }
+func (u *plainUpstream) address() string { return u.addr }
+
+func (u *plainUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if u.stream {
+		return u.exchangeTCP(ctx, query)
+	}
+	conn, err := u.dial(ctx, "udp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setDeadline(ctx, conn)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略 ID 不匹配的迟到应答
+		if n < 12 || buf[0] != query[0] || buf[1] != query[1] {
+			continue
+		}
+		if buf[2]&0x02 != 0 { // TC
+			return u.exchangeTCP(ctx, query)
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+func (u *plainUpstream) exchangeTCP(ctx context.Context, query []byte) ([]byte, error) {
+	conn, err := u.dial(ctx, "tcp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchangeStream(ctx, conn, query)
+}
+
+// tlsUpstream 是 DNS over TLS 上游 (RFC 7858)。
+type tlsUpstream struct {
+	raw        string
+	addr       string
+	serverName string
+	dial       DialFunc
+}
+
+func (u *tlsUpstream) String() string  { return u.raw }
+func (u *tlsUpstream) address() string { return u.addr }
+
+func (u *tlsUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	rawConn, err := u.dial(ctx, "tcp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(rawConn, &tls.Config{ServerName: u.serverName})
+	defer conn.Close()
+	setDeadline(ctx, conn)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("tls handshake with %s failed: %w", u.addr, err)
+	}
+	return exchangeStream(ctx, conn, query)
+}
+
+// httpsUpstream 是 DNS over HTTPS 上游 (RFC 8484)，使用 POST 发送 application/dns-message。
+type httpsUpstream struct {
+	url    string
+	addr   string
+	client *http.Client
+}
+
+func (u *httpsUpstream) String() string  { return u.url }
+func (u *httpsUpstream) address() string { return u.addr }
+
+func (u *httpsUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+}
+
+// exchangeStream 在流式连接上发送一次长度前缀的查询 (RFC 1035 4.2.2) 并读取应答。
+func exchangeStream(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	setDeadline(ctx, conn)
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+}
+
+// buildQuery 构建一个对 host 的 qtype 查询。
+func buildQuery(host string, qtype dnsmessage.Type) ([]byte, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain name %q: %w", host, err)
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// parseAnswer 从应答中取出地址记录和最小 TTL。域名不存在或没有该类型的地址时 notFound 为 true。
+func parseAnswer(query, packed []byte) (addrs []netip.Addr, ttl time.Duration, notFound bool, err error) {
+	var resp dnsmessage.Message
+	if err := resp.Unpack(packed); err != nil {
+		return nil, 0, false, fmt.Errorf("malformed response: %w", err)
+	}
+	if resp.ID != binary.BigEndian.Uint16(query) {
+		return nil, 0, false, fmt.Errorf("response ID mismatch")
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, true, nil
+	default:
+		return nil, 0, false, fmt.Errorf("server returned %s", resp.RCode)
+	}
+
+	minTTL := uint32(0)
+	for _, rr := range resp.Answers {
+		var addr netip.Addr
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addr = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			addr = netip.AddrFrom16(body.AAAA).Unmap()
+		default:
+			continue
+		}
+		if len(addrs) == 0 || rr.Header.TTL < minTTL {
+			minTTL = rr.Header.TTL
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, time.Duration(minTTL) * time.Second, len(addrs) == 0, nil
+}
@@ -0,0 +1,43 @@
+package shaper
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket 是一个令牌桶，令牌单位为字节，容量为一秒的速率。
+// 取用时令牌可以透支，调用方按返回的时长等待，因此大块数据也能在平均意义上精确限速。
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒，0 表示不限制
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// reserve 取走 n 个令牌，返回令牌补足之前需要等待的时长。
+func (b *bucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// setRate 修改速率，已透支的令牌按新速率偿还。
+func (b *bucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = rate
+	b.tokens = min(b.tokens, rate)
+}
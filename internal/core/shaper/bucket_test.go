@@ -0,0 +1,104 @@
+package shaper
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	b := newBucket(1000)
+	start := b.last
+
+	steps := []struct {
+		name  string
+		at    time.Duration // 相对 start 的时间
+		n     int
+		delay time.Duration
+	}{
+		{"within the initial burst", 0, 500, 0},
+		{"overdraw waits for repayment", 0, 1500, time.Second},
+		{"overdraft repaid after waiting", time.Second, 0, 0},
+		{"refill is capped at one second", 10 * time.Second, 1000, 0},
+		{"bucket empty", 10 * time.Second, 1, time.Millisecond},
+		{"large chunk", 10 * time.Second, 4999, 5 * time.Second},
+	}
+	for _, step := range steps {
+		if got := b.reserve(step.n, start.Add(step.at)); got != step.delay {
+			t.Errorf("%s: reserve(%d) = %v, want %v", step.name, step.n, got, step.delay)
+		}
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := newBucket(0)
+	for i := 0; i < 3; i++ {
+		if got := b.reserve(1<<20, time.Now()); got != 0 {
+			t.Fatalf("unlimited bucket reserve() = %v, want 0", got)
+		}
+	}
+}
+
+func TestBucketSetRate(t *testing.T) {
+	b := newBucket(1000)
+	now := b.last
+
+	// 降低速率时多余的令牌被截断到新容量
+	b.setRate(100)
+	if got := b.reserve(200, now); got != time.Second {
+		t.Errorf("reserve after lowering the rate = %v, want 1s", got)
+	}
+
+	// 已透支的令牌按新速率偿还
+	b.setRate(50)
+	if got := b.reserve(0, now); got != 2*time.Second {
+		t.Errorf("overdraft at the new rate = %v, want 2s", got)
+	}
+
+	// 改为不限速后立即放行
+	b.setRate(0)
+	if got := b.reserve(1000, now); got != 0 {
+		t.Errorf("reserve after removing the limit = %v, want 0", got)
+	}
+}
+
+// 关闭连接会唤醒正在等待令牌的写入，release 只调用一次。
+func TestShapedConnCloseInterruptsWait(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buf := make([]byte, maxChunk)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	up := newBucket(1024)
+	releases := 0
+	conn := &shapedConn{Conn: client, up: []*bucket{up}, closed: make(chan struct{}), release: func() { releases++ }}
+
+	result := make(chan error, 1)
+	go func() {
+		// 一块数据透支 15 KB，按 1 KB/s 需要等待约 15 秒
+		_, err := conn.Write(make([]byte, maxChunk))
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	conn.Close()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Write() = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write() kept waiting after Close()")
+	}
+	if releases != 1 {
+		t.Errorf("release called %d times, want 1", releases)
+	}
+}
@@ -0,0 +1,85 @@
+package shaper
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxChunk 是限速连接单次读写的最大字节数，使数据以较平滑的节奏发送，而不是大块突发后长时间停顿。
+const maxChunk = 16 * 1024
+
+// shapedConn 在读写前后按令牌桶等待。写入方向 (客户端到上游) 计为上传，读取方向计为下载。
+type shapedConn struct {
+	net.Conn
+	up, down []*bucket
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	release   func()
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.wait(c.down, n)
+	}
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxChunk)]
+		if !c.wait(c.up, len(chunk)) {
+			return written, net.ErrClosed
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait 从所有令牌桶取走 n 个令牌并等待最长的那个，连接关闭时提前返回 false。
+func (c *shapedConn) wait(buckets []*bucket, n int) bool {
+	now := time.Now()
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.reserve(n, now))
+	}
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+func (c *shapedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.release()
+	})
+	return err
+}
+
+// CloseWrite 转发给底层连接，relay 依赖它通知对端数据已发送完毕。
+func (c *shapedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
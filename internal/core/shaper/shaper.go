@@ -0,0 +1,314 @@
+package shaper
+
+import (
+	"fmt"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 带宽规则的类型，每个匹配的客户端 IP、用户或后端各自拥有一对上传/下载令牌桶。
+const (
+	RuleTypeSourceIP = "source_ip"
+	RuleTypeUser     = "user"
+	RuleTypeServer   = "server" // 按服务器 ID 或 remarks 匹配，"DIRECT" 表示直连
+)
+
+// kilobyte 是规则中速率的单位 (KB/s)。
+const kilobyte = 1024
+
+type direction int
+
+const (
+	upload direction = iota
+	download
+)
+
+type bucketKey struct {
+	kind string // 规则类型
+	key  string // 客户端 IP、用户名或服务器 ID
+	dir  direction
+}
+
+// sharedBucket 是被若干连接共享的令牌桶，refs 归零时从表中移除。
+type sharedBucket struct {
+	*bucket
+	refs int
+}
+
+// rule 是解析后的带宽规则，速率单位为字节/秒。
+type rule struct {
+	kind     string
+	prefixes []netip.Prefix
+	values   map[string]struct{}
+	upload   float64
+	download float64
+}
+
+// Shaper 按客户端 IP、认证用户和后端服务器对网关的 TCP 连接进行令牌桶限速。
+// 同一对象的所有连接共享令牌桶，一个连接同时受所有匹配对象的限制。
+// 修改速率对已建立的连接立即生效；新增或删除的匹配只影响之后建立的连接。
+type Shaper struct {
+	stateProvider types.StateProvider
+	rules         atomic.Pointer[[]*rule]
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*sharedBucket
+}
+
+// New 创建限速器，cfg 为 "bandwidth" 模块的初始配置。stateProvider 用于按 remarks 匹配服务器。
+func New(cfg *settings.BandwidthSettings, stateProvider types.StateProvider) *Shaper {
+	s := &Shaper{
+		stateProvider: stateProvider,
+		buckets:       make(map[bucketKey]*sharedBucket),
+	}
+	if err := s.applySettings(cfg); err != nil {
+		logger.Warn().Err(err).Msg("Shaper: Invalid settings, bandwidth is not limited.")
+		s.applySettings(nil)
+	}
+	return s
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。
+func (s *Shaper) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "bandwidth" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.BandwidthSettings)
+	if !ok {
+		return fmt.Errorf("shaper: received incorrect settings type for bandwidth module")
+	}
+	if err := s.applySettings(cfg); err != nil {
+		return err
+	}
+	logger.Info().Int("rules", len(cfg.Rules)).Msg("Shaper: Bandwidth limits have been reloaded.")
+	return nil
+}
+
+func (s *Shaper) applySettings(cfg *settings.BandwidthSettings) error {
+	var rules []*rule
+	if cfg != nil {
+		for i, raw := range cfg.Rules {
+			r, err := parseRule(raw)
+			if err != nil {
+				return fmt.Errorf("shaper: invalid bandwidth rule #%d: %w", i+1, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+	s.rules.Store(&rules)
+
+	// 已建立的连接持有的令牌桶改用新的速率。匹配服务器 remarks 需要查询状态，不在持有 mu 时进行
+	s.mu.Lock()
+	live := make(map[bucketKey]*bucket, len(s.buckets))
+	for key, b := range s.buckets {
+		live[key] = b.bucket
+	}
+	s.mu.Unlock()
+	for key, b := range live {
+		var rate float64
+		if r := s.match(rules, key.kind, key.key); r != nil {
+			rate = r.rate(key.dir)
+		}
+		b.setRate(rate)
+	}
+	return nil
+}
+
+func parseRule(raw *settings.BandwidthRule) (*rule, error) {
+	if raw.Upload < 0 || raw.Download < 0 {
+		return nil, fmt.Errorf("rates must not be negative")
+	}
+	r := &rule{
+		kind:     raw.Type,
+		values:   make(map[string]struct{}),
+		upload:   float64(raw.Upload) * kilobyte,
+		download: float64(raw.Download) * kilobyte,
+	}
+	for _, value := range raw.Value {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		switch raw.Type {
+		case RuleTypeSourceIP:
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			r.prefixes = append(r.prefixes, prefix)
+		case RuleTypeUser, RuleTypeServer:
+			r.values[value] = struct{}{}
+		default:
+			return nil, fmt.Errorf("unsupported type %q", raw.Type)
+		}
+	}
+	return r, nil
+}
+
+// parsePrefix 解析 CIDR 或单个 IP 地址。
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (r *rule) rate(dir direction) float64 {
+	if dir == upload {
+		return r.upload
+	}
+	return r.download
+}
+
+// match 返回对该对象生效的第一条规则，没有时返回 nil。
+func (s *Shaper) match(rules []*rule, kind, key string) *rule {
+	if key == "" {
+		return nil
+	}
+	for _, r := range rules {
+		if r.kind != kind {
+			continue
+		}
+		switch kind {
+		case RuleTypeSourceIP:
+			addr, err := netip.ParseAddr(key)
+			if err != nil {
+				continue
+			}
+			for _, prefix := range r.prefixes {
+				if prefix.Contains(addr) {
+					return r
+				}
+			}
+		case RuleTypeServer:
+			if _, ok := r.values[key]; ok {
+				return r
+			}
+			if remarks := s.serverRemarks(key); remarks != "" {
+				if _, ok := r.values[remarks]; ok {
+					return r
+				}
+			}
+		default:
+			if _, ok := r.values[key]; ok {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Shaper) serverRemarks(serverID string) string {
+	if s.stateProvider == nil {
+		return ""
+	}
+	if state, ok := s.stateProvider.GetServerStates()[serverID]; ok && state.Profile != nil {
+		return state.Profile.Remarks
+	}
+	return ""
+}
+
+// WrapConn 按 source 的客户端 IP、user 和 serverID 匹配带宽规则，返回一个写入计为上传、读取计为下载的限速连接。
+// 没有匹配的规则或 s 为 nil 时原样返回 conn。
+func (s *Shaper) WrapConn(conn net.Conn, source net.Addr, user, serverID string) net.Conn {
+	if s == nil {
+		return conn
+	}
+	rules := *s.rules.Load()
+	if len(rules) == 0 {
+		return conn
+	}
+	objects := []bucketKey{
+		{kind: RuleTypeSourceIP, key: clientIP(source)},
+		{kind: RuleTypeUser, key: user},
+		{kind: RuleTypeServer, key: serverID},
+	}
+	matched := make([]*rule, len(objects))
+	for i, obj := range objects {
+		matched[i] = s.match(rules, obj.kind, obj.key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []bucketKey
+	var up, down []*bucket
+	for i, obj := range objects {
+		r := matched[i]
+		if r == nil {
+			continue
+		}
+		if r.upload > 0 {
+			key := bucketKey{kind: obj.kind, key: obj.key, dir: upload}
+			up = append(up, s.acquireLocked(key, r.upload))
+			keys = append(keys, key)
+		}
+		if r.download > 0 {
+			key := bucketKey{kind: obj.kind, key: obj.key, dir: download}
+			down = append(down, s.acquireLocked(key, r.download))
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return conn
+	}
+	return &shapedConn{
+		Conn:    conn,
+		up:      up,
+		down:    down,
+		closed:  make(chan struct{}),
+		release: func() { s.release(keys) },
+	}
+}
+
+// acquireLocked 取得或创建共享的令牌桶并增加引用。调用方需持有 mu。
+func (s *Shaper) acquireLocked(key bucketKey, rate float64) *bucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &sharedBucket{bucket: newBucket(rate)}
+		s.buckets[key] = b
+	}
+	b.refs++
+	return b.bucket
+}
+
+func (s *Shaper) release(keys []bucketKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if b, ok := s.buckets[key]; ok {
+			if b.refs--; b.refs <= 0 {
+				delete(s.buckets, key)
+			}
+		}
+	}
+}
+
+// clientIP 返回源地址中的 IP，IPv4 映射的 IPv6 地址还原为 IPv4。
+func clientIP(source net.Addr) string {
+	if source == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(source.String())
+	if err != nil {
+		host = source.String()
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"crypto/subtle"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/metrics"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// bearerAuthMiddleware 要求请求携带 "Authorization: Bearer <token>"，token 为空时不认证。
+// Prometheus 的 scrape_config 通过 authorization 或 bearer_token_file 提供令牌。
+func bearerAuthMiddleware(next http.Handler, token string) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StartServer 在 [metrics] listen 上提供 /metrics，未配置时不监听。
+func StartServer(wg *sync.WaitGroup, conf types.MetricsConf) {
+	if conf.Listen == "" {
+		logger.Info().Msg("[Metrics] Metrics endpoint is disabled (listen is not set).")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", bearerAuthMiddleware(metrics.Default.Handler(), conf.BearerToken))
+
+	listener, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		logger.Error().Err(err).Str("listen", conf.Listen).Msg("[Metrics] Failed to start metrics endpoint")
+		return
+	}
+	logger.Info().Bool("auth", conf.BearerToken != "").Msgf("SUCCESS: Metrics are served on http://%s/metrics", listener.Addr())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := http.Serve(listener, mux); err != nil && err != http.ErrServerClosed {
+			logger.Error().Err(err).Msg("[Metrics] Metrics server error")
+		}
+	}()
+}
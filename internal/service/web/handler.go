@@ -3,8 +3,10 @@ package web
 import (
 	"encoding/json"
 	"io"
+	"liuproxy_go/internal/core/monitor"
 	"liuproxy_go/internal/shared/globalstate"
 	"liuproxy_go/internal/shared/logger"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -27,6 +29,15 @@ type ServerController interface {
 	UpdateServerProfile(id string, updatedProfile *types.ServerProfile) error
 	DeleteServerProfile(id string) error
 	GetRecentClientIPs() []string
+	GetConnectionStats() *types.ConnectionStats
+	GetTrafficStats() *types.TrafficReport
+	GetBans() []*types.BanInfo
+	Unban(ip string) bool
+	GetConnections() []*types.ConnectionInfo
+	CloseConnection(id string) bool
+	CloseClientConnections(ip string) int
+	CloseServerConnections(serverID string) int
+	SubscribeMonitor(filter monitor.Filter) (<-chan *types.MonitorEvent, func())
 }
 
 type Handler struct {
@@ -132,6 +143,105 @@ func (h *Handler) HandleGetClients(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(availableIPs)
 }
 
+// HandleBans 处理 /api/bans 请求: GET 返回当前被自动封禁的客户端 IP，DELETE ?ip=... 提前解除封禁。
+func (h *Handler) HandleBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.controller.GetBans())
+	case http.MethodDelete:
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			http.Error(w, "Query parameter 'ip' is required", http.StatusBadRequest)
+			return
+		}
+		if !h.controller.Unban(ip) {
+			http.Error(w, "IP is not banned", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message": "Ban lifted successfully"}`))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleConnections 处理 /api/connections 请求: GET 返回当前的连接表，可用 ?client=<IP> 或 ?server=<ID> 过滤；
+// DELETE ?client=<IP> 或 ?server=<ID> 关闭该客户端或该服务器的所有连接。
+func (h *Handler) HandleConnections(w http.ResponseWriter, r *http.Request) {
+	client := r.URL.Query().Get("client")
+	server := r.URL.Query().Get("server")
+	switch r.Method {
+	case http.MethodGet:
+		connections := make([]*types.ConnectionInfo, 0)
+		for _, conn := range h.controller.GetConnections() {
+			if client != "" {
+				if host, _, err := net.SplitHostPort(conn.Client); err != nil || host != client {
+					continue
+				}
+			}
+			if server != "" && conn.ServerID != server {
+				continue
+			}
+			connections = append(connections, conn)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(connections)
+	case http.MethodDelete:
+		var closed int
+		switch {
+		case client != "":
+			closed = h.controller.CloseClientConnections(client)
+		case server != "":
+			closed = h.controller.CloseServerConnections(server)
+		default:
+			http.Error(w, "Query parameter 'client' or 'server' is required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"closed": closed})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleConnection 处理 DELETE /api/connections/{id} 请求，关闭单个连接。
+func (h *Handler) HandleConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/connections/")
+	if id == "" {
+		http.Error(w, "Connection ID is missing in URL path", http.StatusBadRequest)
+		return
+	}
+	if !h.controller.CloseConnection(id) {
+		http.Error(w, "Connection not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"message": "Connection closed successfully"}`))
+}
+
+// HandleStats 处理 GET /api/stats 请求，返回包含按客户端 IP 明细的连接数和流量统计。
+// 公开的 /api/status 只给出总数，客户端的明细只经这个需要认证的 API 提供。
+func (h *Handler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type StatsResponse struct {
+		Connections *types.ConnectionStats `json:"connections"`
+		Traffic     *types.TrafficReport   `json:"traffic"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatsResponse{
+		Connections: h.controller.GetConnectionStats(),
+		Traffic:     h.controller.GetTrafficStats(),
+	})
+}
+
 // --- 旧的/现有的 API ---
 
 // HandleStatus 保持不变
@@ -141,6 +251,8 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		RuntimeInfo  map[string]*types.ListenerInfo `json:"runtimeInfo"`
 		HealthStatus map[string]types.HealthStatus  `json:"healthStatus"`
 		Metrics      map[string]*types.Metrics      `json:"metrics"`
+		Connections  *types.ConnectionStats         `json:"connections"`
+		Traffic      *types.TrafficReport           `json:"traffic"`
 	}
 
 	// 从统一的状态源获取所有服务器状态
@@ -159,11 +271,27 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		metrics[id] = state.Metrics
 	}
 
+	// 状态 API 不需要认证，不公开各客户端 IP 的连接数和流量，明细见 /api/stats
+	connections := h.controller.GetConnectionStats()
+	if connections != nil {
+		aggregate := *connections
+		aggregate.Clients = nil
+		connections = &aggregate
+	}
+	traffic := h.controller.GetTrafficStats()
+	if traffic != nil {
+		aggregate := *traffic
+		aggregate.Clients = nil
+		traffic = &aggregate
+	}
+
 	response := StatusResponse{
 		GlobalStatus: globalstate.GlobalStatus.Get(),
 		RuntimeInfo:  runtimeInfo,
 		HealthStatus: healthStatus,
 		Metrics:      metrics,
+		Connections:  connections,
+		Traffic:      traffic,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"liuproxy_go/internal/core/monitor"
+	"liuproxy_go/internal/shared/logger"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// monitorWriteTimeout 是推送一条事件的最长时间，超时的浏览器连接被关闭
+	monitorWriteTimeout = 10 * time.Second
+	// monitorPingInterval 是心跳间隔，浏览器在 monitorPongTimeout 内没有响应时连接被关闭
+	monitorPingInterval = 30 * time.Second
+	monitorPongTimeout  = 60 * time.Second
+)
+
+// monitorUpgrader 只接受同源的 WebSocket 请求 (gorilla/websocket 的默认检查)。
+var monitorUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// HandleMonitor 处理 GET /api/traffic/live 请求，将连接升级为 WebSocket 并持续推送监控事件。
+// 查询参数 client、target 和 server 是服务端过滤条件，含义见 monitor.Filter。
+func (h *Handler) HandleMonitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := monitor.Filter{
+		Client: r.URL.Query().Get("client"),
+		Target: r.URL.Query().Get("target"),
+		Server: r.URL.Query().Get("server"),
+	}
+	ws, err := monitorUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 已向客户端回复了错误
+		logger.Debug().Err(err).Msg("[Monitor] WebSocket upgrade failed")
+		return
+	}
+	defer ws.Close()
+
+	events, cancel := h.controller.SubscribeMonitor(filter)
+	defer cancel()
+	logger.Debug().Str("remote_addr", r.RemoteAddr).Interface("filter", filter).Msg("[Monitor] Client subscribed")
+
+	// 浏览器不发送数据，读循环只用于处理心跳和发现连接关闭
+	closed := make(chan struct{})
+	ws.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(monitorPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"), time.Now().Add(monitorWriteTimeout))
+				return
+			}
+			ws.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+			if err := ws.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(monitorWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			logger.Debug().Str("remote_addr", r.RemoteAddr).Msg("[Monitor] Client disconnected")
+			return
+		}
+	}
+}
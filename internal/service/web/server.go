@@ -81,6 +81,11 @@ func StartServer(
 	mux.Handle("/api/settings", basicAuthMiddleware(http.HandlerFunc(handler.HandleGetSettings), webUser, webPassword))
 	mux.Handle("/api/settings/", basicAuthMiddleware(http.HandlerFunc(handler.HandleUpdateSettings), webUser, webPassword)) // 捕获 /api/settings/{module}
 	mux.Handle("/api/clients", basicAuthMiddleware(http.HandlerFunc(handler.HandleGetClients), webUser, webPassword))
+	mux.Handle("/api/bans", basicAuthMiddleware(http.HandlerFunc(handler.HandleBans), webUser, webPassword))
+	mux.Handle("/api/stats", basicAuthMiddleware(http.HandlerFunc(handler.HandleStats), webUser, webPassword))
+	mux.Handle("/api/connections", basicAuthMiddleware(http.HandlerFunc(handler.HandleConnections), webUser, webPassword))
+	mux.Handle("/api/connections/", basicAuthMiddleware(http.HandlerFunc(handler.HandleConnection), webUser, webPassword)) // 捕获 /api/connections/{id}
+	mux.Handle("/api/traffic/live", basicAuthMiddleware(http.HandlerFunc(handler.HandleMonitor), webUser, webPassword))    // Monitor 页面的 WebSocket
 
 	// 公开的状态 API
 	mux.HandleFunc("/api/status", handler.HandleStatus)
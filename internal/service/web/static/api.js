@@ -84,6 +84,31 @@ export async function saveSettings(moduleKey, settingsData) {
     }
 }
 
+/**
+ * Fetches the client IPs currently banned by the gateway.
+ * @returns {Promise<object[]>} A list of bans, newest first.
+ */
+export async function fetchBans() {
+    const response = await fetch('/api/bans');
+    if (!response.ok) {
+        const errorText = await response.text();
+        throw new Error(`Failed to fetch bans: ${errorText}`);
+    }
+    return response.json();
+}
+
+/**
+ * Lifts the ban on a client IP.
+ * @param {string} ip - The banned IP address.
+ */
+export async function unbanClient(ip) {
+    const response = await fetch(`/api/bans?ip=${encodeURIComponent(ip)}`, { method: 'DELETE' });
+    if (!response.ok) {
+        const errorText = await response.text();
+        throw new Error(`Failed to lift ban: ${errorText}`);
+    }
+}
+
 /**
  * Fetches the list of available (unconfigured) client IPs from the backend.
  * @returns {Promise<string[]>} A list of IP addresses.
@@ -4,6 +4,7 @@
 import { serversCache, clearLogMessages } from './state.js';
 import { fetchServers, fetchStatus, saveSettings } from './api.js';
 import { initializeSettingsPage, loadSettings, saveRuleToCache, getRoutingSettingsData } from './settings.js';
+import { startMonitor, stopMonitor } from './monitor.js';
 import { 
     form, 
     ruleForm, // Import ruleForm
@@ -22,9 +23,11 @@ document.addEventListener('DOMContentLoaded', () => {
     const mainServers = document.getElementById('main-servers');
     const mainGateway = document.getElementById('main-gateway');
     const mainRouting = document.getElementById('main-routing');
+    const mainMonitor = document.getElementById('main-monitor');
     const navServersLink = document.getElementById('nav-servers');
     const navGatewayLink = document.getElementById('nav-gateway');
     const navRoutingLink = document.getElementById('nav-routing');
+    const navMonitorLink = document.getElementById('nav-monitor');
     const serverListBody = document.getElementById('server-list');
     const addServerBtn = document.getElementById('add-server-btn');
     const cancelBtn = document.getElementById('cancel-btn');
@@ -51,11 +54,16 @@ document.addEventListener('DOMContentLoaded', () => {
         mainServers.style.display = 'none';
         mainGateway.style.display = 'none';
         mainRouting.style.display = 'none';
+        mainMonitor.style.display = 'none';
 
         // Deactivate all nav links
         navServersLink.classList.remove('active');
         navGatewayLink.classList.remove('active');
         navRoutingLink.classList.remove('active');
+        navMonitorLink.classList.remove('active');
+
+        // The monitor stream is only kept open while its page is visible
+        if (pageName !== 'monitor') stopMonitor();
 
         // Show the selected page and activate the corresponding link
         switch (pageName) {
@@ -69,6 +77,11 @@ document.addEventListener('DOMContentLoaded', () => {
                 navRoutingLink.classList.add('active');
                 loadSettings(); // Also load settings for this page
                 break;
+            case 'monitor':
+                mainMonitor.style.display = 'block';
+                navMonitorLink.classList.add('active');
+                startMonitor();
+                break;
             case 'servers':
             default:
                 mainServers.style.display = 'block';
@@ -81,6 +94,7 @@ document.addEventListener('DOMContentLoaded', () => {
     navServersLink.addEventListener('click', (e) => { e.preventDefault(); showPage('servers'); });
     navGatewayLink.addEventListener('click', (e) => { e.preventDefault(); showPage('gateway'); });
     navRoutingLink.addEventListener('click', (e) => { e.preventDefault(); showPage('routing'); });
+    navMonitorLink.addEventListener('click', (e) => { e.preventDefault(); showPage('monitor'); });
 
     addServerBtn.addEventListener('click', () => showDialog());
     cancelBtn.addEventListener('click', (e) => {
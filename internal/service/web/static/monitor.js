@@ -0,0 +1,273 @@
+// This module drives the Monitor page: it subscribes to /api/traffic/live over a WebSocket
+// and shows live connection events, routing decisions, health changes and throughput samples.
+
+import { serversCache } from './state.js';
+import { formatBytes, escapeHTML } from './ui.js';
+
+// --- UI Element References ---
+const clientFilterInput = document.getElementById('monitor-client-filter');
+const targetFilterInput = document.getElementById('monitor-target-filter');
+const serverFilterSelect = document.getElementById('monitor-server-filter');
+const applyBtn = document.getElementById('monitor-apply-btn');
+const pauseBtn = document.getElementById('monitor-pause-btn');
+const clearBtn = document.getElementById('monitor-clear-btn');
+const statusLine = document.getElementById('monitor-status');
+const throughputLine = document.getElementById('monitor-throughput');
+const connectionListBody = document.getElementById('monitor-connection-list');
+const eventsPanel = document.getElementById('monitor-events');
+
+const MAX_EVENTS = 500; // Keep a maximum of 500 lines in the event panel.
+const HEALTH_NAMES = { 0: 'Unknown', 1: 'Up', 2: 'Down' };
+
+// --- State ---
+let socket = null;
+let paused = false;
+let eventLines = [];
+const connections = new Map(); // Active connections keyed by trace_id.
+
+/**
+ * Returns the remarks of a server ID, or the ID itself for DIRECT, REJECT and unknown servers.
+ * @param {string} id
+ * @returns {string}
+ */
+function serverName(id) {
+    const server = serversCache.find(s => s.id === id);
+    return server ? server.remarks : (id || '');
+}
+
+/**
+ * Builds the query string for the server-side filters.
+ * @returns {string}
+ */
+function filterQuery() {
+    const params = new URLSearchParams();
+    if (clientFilterInput.value.trim()) params.set('client', clientFilterInput.value.trim());
+    if (targetFilterInput.value.trim()) params.set('target', targetFilterInput.value.trim());
+    if (serverFilterSelect.value) params.set('server', serverFilterSelect.value);
+    const query = params.toString();
+    return query ? `?${query}` : '';
+}
+
+/**
+ * Fills the server filter with the known servers plus DIRECT and REJECT, keeping the current selection.
+ */
+function populateServerFilter() {
+    const selected = serverFilterSelect.value;
+    serverFilterSelect.innerHTML = '<option value="">All Servers</option>';
+    const options = serversCache.map(s => ({ value: s.id, label: s.remarks }))
+        .concat([{ value: 'DIRECT', label: 'DIRECT' }, { value: 'REJECT', label: 'REJECT' }]);
+    options.forEach(({ value, label }) => {
+        const option = document.createElement('option');
+        option.value = value;
+        option.textContent = label;
+        serverFilterSelect.appendChild(option);
+    });
+    serverFilterSelect.value = selected;
+}
+
+/**
+ * Formats a connection as a single line for the event panel.
+ * @param {object} conn - A ConnectionInfo object.
+ * @returns {string}
+ */
+function describeConnection(conn) {
+    let line = `${conn.client} [${conn.inbound}/${conn.protocol}] -> ${conn.target}`;
+    if (conn.sniffedHost) line += ` (${conn.sniffedHost})`;
+    if (conn.serverId) line += ` via ${serverName(conn.serverId)}`;
+    if (conn.rule) line += ` rule=${conn.rule}`;
+    return line;
+}
+
+/**
+ * Appends a line to the event panel, newest first.
+ * @param {object} event - A MonitorEvent object.
+ */
+function addEventLine(event) {
+    const time = new Date(event.time).toLocaleTimeString();
+    let line;
+    switch (event.type) {
+        case 'open':
+            line = `OPEN   ${describeConnection(event.connection)}`;
+            break;
+        case 'route':
+            line = `ROUTE  ${describeConnection(event.connection)}`;
+            break;
+        case 'close': {
+            const conn = event.connection;
+            line = `CLOSE  ${describeConnection(conn)} ↑${formatBytes(conn.upload)} ↓${formatBytes(conn.download)} ${conn.age.toFixed(1)}s`;
+            if (conn.closeReason) line += ` (${conn.closeReason})`;
+            break;
+        }
+        case 'health':
+            line = `HEALTH ${serverName(event.serverId)} is ${HEALTH_NAMES[event.health || 0]}`;
+            break;
+        default:
+            return;
+    }
+    eventLines.unshift(`[${time}] ${line}`);
+    if (eventLines.length > MAX_EVENTS) {
+        eventLines.length = MAX_EVENTS;
+    }
+    eventsPanel.textContent = eventLines.join('\n');
+}
+
+/**
+ * Renders the active connection table from the connections map.
+ */
+function renderConnections() {
+    connectionListBody.innerHTML = '';
+    const now = Date.now();
+    [...connections.values()]
+        .sort((a, b) => new Date(a.start) - new Date(b.start))
+        .forEach(conn => {
+            const row = document.createElement('tr');
+            const target = conn.sniffedHost ? `${conn.target} (${conn.sniffedHost})` : conn.target;
+            const age = Math.max(0, (now - new Date(conn.start).getTime()) / 1000);
+            row.innerHTML = `
+                <td>${escapeHTML(conn.client)}${conn.user ? `<br>${escapeHTML(conn.user)}` : ''}</td>
+                <td>${escapeHTML(conn.inbound)}/${escapeHTML(conn.protocol)}</td>
+                <td>${escapeHTML(target)}</td>
+                <td>${escapeHTML(conn.rule || '')}</td>
+                <td>${escapeHTML(serverName(conn.serverId))}</td>
+                <td>↑${formatBytes(conn.upload)} ↓${formatBytes(conn.download)}</td>
+                <td>${age.toFixed(0)}s</td>
+                <td class="actions"><button type="button" class="deactivate-btn kill-btn" data-id="${escapeHTML(conn.id)}">Kill</button></td>
+            `;
+            connectionListBody.appendChild(row);
+        });
+}
+
+/**
+ * Shows the latest throughput sample.
+ * @param {object} report - A TrafficReport object.
+ */
+function renderThroughput(report) {
+    const total = report.total || {};
+    let text = `Total ↑${formatBytes(total.uploadRate)}/s ↓${formatBytes(total.downloadRate)}/s`;
+    Object.entries(report.backends || {})
+        .filter(([, stats]) => stats.uploadRate > 0 || stats.downloadRate > 0)
+        .forEach(([id, stats]) => {
+            text += ` | ${serverName(id)} ↑${formatBytes(stats.uploadRate)}/s ↓${formatBytes(stats.downloadRate)}/s`;
+        });
+    throughputLine.textContent = text;
+}
+
+/**
+ * Applies one event from the WebSocket to the page state.
+ * @param {object} event - A MonitorEvent object.
+ */
+function handleEvent(event) {
+    switch (event.type) {
+        case 'open':
+        case 'route':
+            connections.set(event.connection.id, event.connection);
+            break;
+        case 'close':
+            connections.delete(event.connection.id);
+            break;
+        case 'throughput':
+            renderThroughput(event.traffic);
+            return;
+    }
+    if (!paused) {
+        addEventLine(event);
+        renderConnections();
+    }
+}
+
+/**
+ * Loads the connections that are already open and match the client and server filters.
+ */
+async function loadConnections() {
+    const params = new URLSearchParams();
+    if (clientFilterInput.value.trim()) params.set('client', clientFilterInput.value.trim());
+    if (serverFilterSelect.value) params.set('server', serverFilterSelect.value);
+    try {
+        const response = await fetch(`/api/connections?${params.toString()}`);
+        if (!response.ok) throw new Error(response.statusText);
+        const list = await response.json();
+        const target = targetFilterInput.value.trim().toLowerCase();
+        connections.clear();
+        list.filter(conn => !target || conn.target.toLowerCase().includes(target) || (conn.sniffedHost || '').toLowerCase().includes(target))
+            .forEach(conn => connections.set(conn.id, conn));
+        renderConnections();
+    } catch (error) {
+        console.error('Failed to load connections:', error);
+    }
+}
+
+/**
+ * Opens the WebSocket with the current filters, closing any previous one.
+ */
+function connect() {
+    disconnect();
+    const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
+    const ws = new WebSocket(`${scheme}://${window.location.host}/api/traffic/live${filterQuery()}`);
+    socket = ws;
+    statusLine.textContent = 'Connecting...';
+    ws.onopen = () => {
+        statusLine.textContent = 'Connected.';
+        loadConnections();
+    };
+    ws.onmessage = (msg) => handleEvent(JSON.parse(msg.data));
+    ws.onclose = () => {
+        if (socket === ws) {
+            socket = null;
+            statusLine.textContent = 'Disconnected.';
+        }
+    };
+}
+
+/**
+ * Closes the WebSocket, if any.
+ */
+function disconnect() {
+    if (socket) {
+        const ws = socket;
+        socket = null;
+        ws.close();
+        statusLine.textContent = 'Disconnected.';
+    }
+}
+
+/**
+ * Called when the Monitor page becomes visible.
+ */
+export function startMonitor() {
+    populateServerFilter();
+    connect();
+}
+
+/**
+ * Called when the user leaves the Monitor page.
+ */
+export function stopMonitor() {
+    disconnect();
+}
+
+// --- Event Listeners ---
+applyBtn.addEventListener('click', () => connect());
+
+pauseBtn.addEventListener('click', () => {
+    paused = !paused;
+    pauseBtn.textContent = paused ? 'Resume' : 'Pause';
+    if (!paused) renderConnections();
+});
+
+clearBtn.addEventListener('click', () => {
+    eventLines = [];
+    eventsPanel.textContent = '';
+});
+
+connectionListBody.addEventListener('click', async (e) => {
+    const id = e.target.dataset.id;
+    if (!id || !e.target.classList.contains('kill-btn')) return;
+    try {
+        const response = await fetch(`/api/connections/${encodeURIComponent(id)}`, { method: 'DELETE' });
+        if (!response.ok && response.status !== 404) throw new Error(await response.text());
+        connections.delete(id);
+        renderConnections();
+    } catch (error) {
+        alert('Error closing connection: ' + error.message);
+    }
+});
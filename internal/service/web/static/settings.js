@@ -1,14 +1,31 @@
 // ***********  liuproxy_go\internal\web\static\settings.js ***********
 // This module handles all logic for the Settings page.
-import { fetchAllSettings, saveSettings, fetchAvailableClientIPs } from './api.js';
+import { fetchAllSettings, saveSettings, fetchAvailableClientIPs, fetchBans, unbanClient } from './api.js';
 import { updateStatusMessage, showRuleDialog, populateRuleTargetOptions } from './ui.js';
 import { serversCache } from './state.js';
 
 // --- UI Element References ---
 const gatewaySettingsForm = document.getElementById('gateway-settings-form');
+const authSettingsForm = document.getElementById('auth-settings-form');
+const authUsersTextarea = document.getElementById('auth_users');
 const routingSettingsForm = document.getElementById('routing-settings-form');
 const stickyRulesTextarea = document.getElementById('sticky_rules');
+const trustedCidrsTextarea = document.getElementById('proxy_protocol_trusted_cidrs');
 const ruleListBody = document.getElementById('rule-list-body');
+const inboundListBody = document.getElementById('inbound-list-body');
+const dnsSettingsForm = document.getElementById('dns-settings-form');
+const dnsHostsTextarea = document.getElementById('dns_hosts');
+const dnsFakeIpFilterTextarea = document.getElementById('dns_fake_ip_filter');
+const resolverSettingsForm = document.getElementById('resolver-settings-form');
+const resolverUpstreamsTextarea = document.getElementById('resolver_upstreams');
+const limitsSettingsForm = document.getElementById('limits-settings-form');
+const securitySettingsForm = document.getElementById('security-settings-form');
+const securityTrustedCidrsTextarea = document.getElementById('security_trusted_cidrs');
+const banListBody = document.getElementById('ban-list-body');
+const bandwidthRuleListBody = document.getElementById('bandwidth-rule-list-body');
+const timeoutsSettingsForm = document.getElementById('timeouts-settings-form');
+const loggingSettingsForm = document.getElementById('logging-settings-form');
+const accessLogSettingsForm = document.getElementById('access-log-settings-form');
 
 
 // --- State ---
@@ -27,6 +44,36 @@ export async function loadSettings() {
             if (settings.gateway) {
                 populateGatewaySettings(settings.gateway);
             }
+            if (settings.auth) {
+                populateAuthSettings(settings.auth);
+            }
+            if (settings.inbounds) {
+                populateInboundsSettings(settings.inbounds);
+            }
+            if (settings.dns) {
+                populateDnsSettings(settings.dns);
+            }
+            if (settings.resolver) {
+                populateResolverSettings(settings.resolver);
+            }
+            if (settings.limits) {
+                populateLimitsSettings(settings.limits);
+            }
+            if (settings.security) {
+                populateSecuritySettings(settings.security);
+            }
+            if (settings.bandwidth) {
+                populateBandwidthSettings(settings.bandwidth);
+            }
+            if (settings.timeouts) {
+                populateTimeoutsSettings(settings.timeouts);
+            }
+            if (settings.logging) {
+                populateLoggingSettings(settings.logging);
+            }
+            if (settings.access_log) {
+                populateAccessLogSettings(settings.access_log);
+            }
             if (settings.routing) {
                 routingRulesCache = JSON.parse(JSON.stringify(settings.routing.rules || []));
                 renderRulesTable(); // Initial render
@@ -57,6 +104,16 @@ function populateGatewaySettings(gatewaySettings) {
 
     // Set load balancer strategy
     form.elements.load_balancer_strategy.value = gatewaySettings.load_balancer_strategy || 'least_connections';
+
+    // Set PROXY protocol options
+    form.elements.proxy_protocol.checked = !!gatewaySettings.proxy_protocol;
+    trustedCidrsTextarea.value = (gatewaySettings.proxy_protocol_trusted_cidrs || []).join('\n');
+
+    // Set domain sniffing
+    form.elements.sniffing.checked = !!gatewaySettings.sniffing;
+
+    // Set reject response
+    form.elements.reject_http_body.value = gatewaySettings.reject_http_body || '';
 }
 
 /**
@@ -66,11 +123,412 @@ function populateGatewaySettings(gatewaySettings) {
 function getGatewaySettingsData() {
     const formData = new FormData(gatewaySettingsForm);
     const rules = stickyRulesTextarea.value.split('\n').map(rule => rule.trim()).filter(rule => rule);
+    const trustedCidrs = trustedCidrsTextarea.value.split('\n').map(cidr => cidr.trim()).filter(cidr => cidr);
     return {
         sticky_session_mode: formData.get('sticky_session_mode'),
         sticky_session_ttl: parseInt(formData.get('sticky_session_ttl'), 10),
         sticky_rules: rules,
         load_balancer_strategy: formData.get('load_balancer_strategy'),
+        proxy_protocol: gatewaySettingsForm.elements.proxy_protocol.checked,
+        proxy_protocol_trusted_cidrs: trustedCidrs,
+        sniffing: gatewaySettingsForm.elements.sniffing.checked,
+        reject_http_body: gatewaySettingsForm.elements.reject_http_body.value,
+    };
+}
+
+/**
+ * Populates the Proxy Authentication card with data.
+ * @param {object} authSettings - The auth settings object from the API.
+ */
+function populateAuthSettings(authSettings) {
+    authSettingsForm.elements.enabled.checked = !!authSettings.enabled;
+    authUsersTextarea.value = (authSettings.users || [])
+        .map(user => `${user.username}:${user.password}`)
+        .join('\n');
+}
+
+/**
+ * Collects data from the Proxy Authentication card and formats it for the API.
+ * @returns {object} The auth settings object to be sent.
+ */
+function getAuthSettingsData() {
+    const users = authUsersTextarea.value.split('\n')
+        .map(line => line.trim())
+        .filter(line => line)
+        .map(line => {
+            const sep = line.indexOf(':');
+            return sep < 0
+                ? { username: line, password: '' }
+                : { username: line.slice(0, sep), password: line.slice(sep + 1) };
+        });
+    return {
+        enabled: authSettingsForm.elements.enabled.checked,
+        users: users,
+    };
+}
+
+/**
+ * Appends an editable row to the Inbounds table.
+ * @param {object} inbound - The inbound object, or an empty object for a new row.
+ */
+function appendInboundRow(inbound) {
+    const row = document.createElement('tr');
+    row.innerHTML = `
+        <td><input type="text" name="name" placeholder="via-hk"></td>
+        <td><input type="text" name="listen" placeholder="0.0.0.0"></td>
+        <td><input type="number" name="port" min="1" max="65535"></td>
+        <td><input type="text" name="protocols" placeholder="socks5, http"></td>
+        <td><input type="text" name="target" placeholder="DIRECT"></td>
+        <td><input type="number" name="tls_port" min="1" max="65535" placeholder="443"></td>
+        <td class="actions">
+            <button type="button" class="delete-inbound-btn">Remove</button>
+        </td>
+    `;
+    row.querySelector('[name="name"]').value = inbound.name || '';
+    row.querySelector('[name="listen"]').value = inbound.listen || '';
+    row.querySelector('[name="port"]').value = inbound.port || '';
+    row.querySelector('[name="protocols"]').value = (inbound.protocols || []).join(', ');
+    row.querySelector('[name="target"]').value = inbound.target || '';
+    row.querySelector('[name="tls_port"]').value = inbound.tls_port || '';
+    inboundListBody.appendChild(row);
+}
+
+/**
+ * Populates the Inbounds card with data.
+ * @param {object} inboundsSettings - The inbounds settings object from the API.
+ */
+function populateInboundsSettings(inboundsSettings) {
+    inboundListBody.innerHTML = '';
+    (inboundsSettings.inbounds || []).forEach(appendInboundRow);
+}
+
+/**
+ * Collects data from the Inbounds card and formats it for the API.
+ * @returns {object} The inbounds settings object to be sent.
+ */
+function getInboundsSettingsData() {
+    const inbounds = Array.from(inboundListBody.querySelectorAll('tr'))
+        .map(row => ({
+            name: row.querySelector('[name="name"]').value.trim(),
+            listen: row.querySelector('[name="listen"]').value.trim(),
+            port: parseInt(row.querySelector('[name="port"]').value, 10) || 0,
+            protocols: row.querySelector('[name="protocols"]').value.split(',').map(p => p.trim().toLowerCase()).filter(p => p),
+            target: row.querySelector('[name="target"]').value.trim(),
+            tls_port: parseInt(row.querySelector('[name="tls_port"]').value, 10) || 0,
+        }))
+        .filter(inbound => inbound.name || inbound.port);
+    return {
+        inbounds: inbounds,
+    };
+}
+
+/**
+ * Populates the DNS Server card with data.
+ * @param {object} dnsSettings - The dns settings object from the API.
+ */
+function populateDnsSettings(dnsSettings) {
+    const form = dnsSettingsForm;
+    form.elements.enabled.checked = !!dnsSettings.enabled;
+    form.elements.listen.value = dnsSettings.listen || '';
+    form.elements.upstream.value = dnsSettings.upstream || '';
+    form.elements.direct_upstream.value = dnsSettings.direct_upstream || '';
+    form.elements.cache_size.value = dnsSettings.cache_size || '';
+    form.elements.fake_ip.checked = !!dnsSettings.fake_ip;
+    form.elements.fake_ip_range.value = dnsSettings.fake_ip_range || '';
+    dnsFakeIpFilterTextarea.value = (dnsSettings.fake_ip_filter || []).join('\n');
+    dnsHostsTextarea.value = (dnsSettings.hosts || [])
+        .map(host => [host.domain, ...(host.ips || [])].join(' '))
+        .join('\n');
+}
+
+/**
+ * Collects data from the DNS Server card and formats it for the API.
+ * @returns {object} The dns settings object to be sent.
+ */
+function getDnsSettingsData() {
+    const form = dnsSettingsForm;
+    const hosts = dnsHostsTextarea.value.split('\n')
+        .map(line => line.trim().split(/\s+/).filter(field => field))
+        .filter(fields => fields.length > 0)
+        .map(([domain, ...ips]) => ({ domain: domain, ips: ips }));
+    return {
+        enabled: form.elements.enabled.checked,
+        listen: form.elements.listen.value.trim(),
+        upstream: form.elements.upstream.value.trim(),
+        direct_upstream: form.elements.direct_upstream.value.trim(),
+        cache_size: parseInt(form.elements.cache_size.value, 10) || 0,
+        hosts: hosts,
+        fake_ip: form.elements.fake_ip.checked,
+        fake_ip_range: form.elements.fake_ip_range.value.trim(),
+        fake_ip_filter: dnsFakeIpFilterTextarea.value.split('\n').map(domain => domain.trim()).filter(domain => domain),
+    };
+}
+
+/**
+ * Populates the Resolver card with data.
+ * @param {object} resolverSettings - The resolver settings object from the API.
+ */
+function populateResolverSettings(resolverSettings) {
+    const form = resolverSettingsForm;
+    resolverUpstreamsTextarea.value = (resolverSettings.upstreams || []).join('\n');
+    form.elements.via.value = resolverSettings.via || '';
+    form.elements.cache_size.value = resolverSettings.cache_size || '';
+}
+
+/**
+ * Collects data from the Resolver card and formats it for the API.
+ * @returns {object} The resolver settings object to be sent.
+ */
+function getResolverSettingsData() {
+    const form = resolverSettingsForm;
+    return {
+        upstreams: resolverUpstreamsTextarea.value.split('\n').map(upstream => upstream.trim()).filter(upstream => upstream),
+        via: form.elements.via.value.trim(),
+        cache_size: parseInt(form.elements.cache_size.value, 10) || 0,
+    };
+}
+
+/**
+ * Populates the Connection Limits card with data.
+ * @param {object} limitsSettings - The limits settings object from the API.
+ */
+function populateLimitsSettings(limitsSettings) {
+    const form = limitsSettingsForm;
+    form.elements.max_connections.value = limitsSettings.max_connections || '';
+    form.elements.max_connections_per_client.value = limitsSettings.max_connections_per_client || '';
+    form.elements.max_connections_per_backend.value = limitsSettings.max_connections_per_backend || '';
+    form.elements.queue_timeout.value = limitsSettings.queue_timeout || '';
+}
+
+/**
+ * Collects data from the Connection Limits card and formats it for the API.
+ * @returns {object} The limits settings object to be sent.
+ */
+function getLimitsSettingsData() {
+    const form = limitsSettingsForm;
+    return {
+        max_connections: parseInt(form.elements.max_connections.value, 10) || 0,
+        max_connections_per_client: parseInt(form.elements.max_connections_per_client.value, 10) || 0,
+        max_connections_per_backend: parseInt(form.elements.max_connections_per_backend.value, 10) || 0,
+        queue_timeout: parseInt(form.elements.queue_timeout.value, 10) || 0,
+    };
+}
+
+/**
+ * Populates the Security card with data.
+ * @param {object} securitySettings - The security settings object from the API.
+ */
+function populateSecuritySettings(securitySettings) {
+    const form = securitySettingsForm;
+    form.elements.handshake_timeout.value = securitySettings.handshake_timeout || '';
+    form.elements.max_header_bytes.value = securitySettings.max_header_bytes || '';
+    form.elements.max_request_body_bytes.value = securitySettings.max_request_body_bytes || '';
+    form.elements.max_handshakes_per_ip.value = securitySettings.max_handshakes_per_ip || '';
+    form.elements.ban_threshold.value = securitySettings.ban_threshold || '';
+    form.elements.ban_duration.value = securitySettings.ban_duration || '';
+    securityTrustedCidrsTextarea.value = (securitySettings.trusted_cidrs || []).join('\n');
+    loadBans();
+}
+
+/**
+ * Collects data from the Security card and formats it for the API.
+ * @returns {object} The security settings object to be sent.
+ */
+function getSecuritySettingsData() {
+    const form = securitySettingsForm;
+    return {
+        handshake_timeout: parseInt(form.elements.handshake_timeout.value, 10) || 0,
+        max_header_bytes: parseInt(form.elements.max_header_bytes.value, 10) || 0,
+        max_request_body_bytes: parseInt(form.elements.max_request_body_bytes.value, 10) || 0,
+        max_handshakes_per_ip: parseInt(form.elements.max_handshakes_per_ip.value, 10) || 0,
+        ban_threshold: parseInt(form.elements.ban_threshold.value, 10) || 0,
+        ban_duration: parseInt(form.elements.ban_duration.value, 10) || 0,
+        trusted_cidrs: securityTrustedCidrsTextarea.value.split('\n').map(cidr => cidr.trim()).filter(cidr => cidr),
+    };
+}
+
+/**
+ * Fetches the current bans and renders them in the Security card.
+ */
+async function loadBans() {
+    try {
+        const bans = await fetchBans();
+        banListBody.innerHTML = '';
+        if (bans.length === 0) {
+            banListBody.innerHTML = '<tr><td colspan="4">No banned clients.</td></tr>';
+            return;
+        }
+        bans.forEach(ban => {
+            const row = document.createElement('tr');
+            row.innerHTML = `
+                <td></td>
+                <td></td>
+                <td>${new Date(ban.expiresAt).toLocaleString()}</td>
+                <td class="actions">
+                    <button type="button" class="unban-btn">Unban</button>
+                </td>
+            `;
+            row.cells[0].textContent = ban.ip;
+            row.cells[1].textContent = `${ban.reason} (${ban.strikes} strikes)`;
+            row.querySelector('.unban-btn').dataset.ip = ban.ip;
+            banListBody.appendChild(row);
+        });
+    } catch (error) {
+        console.error('Failed to load bans:', error);
+    }
+}
+
+/**
+ * Appends an editable row to the Bandwidth table.
+ * @param {object} rule - The bandwidth rule object, or an empty object for a new row.
+ */
+function appendBandwidthRuleRow(rule) {
+    const row = document.createElement('tr');
+    row.innerHTML = `
+        <td>
+            <select name="type">
+                <option value="source_ip">Client IP</option>
+                <option value="user">User</option>
+                <option value="server">Server</option>
+            </select>
+        </td>
+        <td><input type="text" name="value" placeholder="192.168.1.0/24"></td>
+        <td><input type="number" name="upload" min="0" placeholder="0"></td>
+        <td><input type="number" name="download" min="0" placeholder="0"></td>
+        <td class="actions">
+            <button type="button" class="delete-bandwidth-rule-btn">Remove</button>
+        </td>
+    `;
+    row.querySelector('[name="type"]').value = rule.type || 'source_ip';
+    row.querySelector('[name="value"]').value = (rule.value || []).join(', ');
+    row.querySelector('[name="upload"]').value = rule.upload || '';
+    row.querySelector('[name="download"]').value = rule.download || '';
+    bandwidthRuleListBody.appendChild(row);
+}
+
+/**
+ * Populates the Bandwidth card with data.
+ * @param {object} bandwidthSettings - The bandwidth settings object from the API.
+ */
+function populateBandwidthSettings(bandwidthSettings) {
+    bandwidthRuleListBody.innerHTML = '';
+    (bandwidthSettings.rules || []).forEach(appendBandwidthRuleRow);
+}
+
+/**
+ * Collects data from the Bandwidth card and formats it for the API.
+ * @returns {object} The bandwidth settings object to be sent.
+ */
+function getBandwidthSettingsData() {
+    const rules = Array.from(bandwidthRuleListBody.querySelectorAll('tr'))
+        .map(row => ({
+            type: row.querySelector('[name="type"]').value,
+            value: row.querySelector('[name="value"]').value.split(',').map(v => v.trim()).filter(v => v),
+            upload: parseInt(row.querySelector('[name="upload"]').value, 10) || 0,
+            download: parseInt(row.querySelector('[name="download"]').value, 10) || 0,
+        }))
+        .filter(rule => rule.value.length > 0);
+    return {
+        rules: rules,
+    };
+}
+
+/**
+ * Populates the Timeouts card with data.
+ * @param {object} timeoutsSettings - The timeouts settings object from the API.
+ */
+function populateTimeoutsSettings(timeoutsSettings) {
+    const form = timeoutsSettingsForm;
+    form.elements.handshake.value = timeoutsSettings.handshake || '';
+    form.elements.established.value = timeoutsSettings.established || '';
+    form.elements.half_closed.value = timeoutsSettings.half_closed || '';
+    form.elements.max_lifetime.value = timeoutsSettings.max_lifetime || '';
+    form.elements.drain.value = timeoutsSettings.drain || '';
+}
+
+/**
+ * Collects data from the Timeouts card and formats it for the API.
+ * @returns {object} The timeouts settings object to be sent.
+ */
+function getTimeoutsSettingsData() {
+    const form = timeoutsSettingsForm;
+    return {
+        handshake: parseInt(form.elements.handshake.value, 10) || 0,
+        established: parseInt(form.elements.established.value, 10) || 0,
+        half_closed: parseInt(form.elements.half_closed.value, 10) || 0,
+        max_lifetime: parseInt(form.elements.max_lifetime.value, 10) || 0,
+        drain: parseInt(form.elements.drain.value, 10) || 0,
+    };
+}
+
+/**
+ * Populates the Logging card with data.
+ * @param {object} loggingSettings - The logging settings object from the API.
+ */
+function populateLoggingSettings(loggingSettings) {
+    const form = loggingSettingsForm;
+    form.elements.level.value = loggingSettings.level || '';
+    const components = loggingSettings.components || {};
+    form.querySelectorAll('select[data-component]').forEach(select => {
+        select.value = components[select.dataset.component] || '';
+    });
+    form.elements.format.value = loggingSettings.format || 'console';
+    form.elements.file.value = loggingSettings.file || '';
+    form.elements.max_size.value = loggingSettings.max_size || '';
+    form.elements.max_age.value = loggingSettings.max_age || '';
+    form.elements.max_backups.value = loggingSettings.max_backups || '';
+}
+
+/**
+ * Collects data from the Logging card and formats it for the API.
+ * @returns {object} The logging settings object to be sent.
+ */
+function getLoggingSettingsData() {
+    const form = loggingSettingsForm;
+    const components = {};
+    form.querySelectorAll('select[data-component]').forEach(select => {
+        if (select.value) components[select.dataset.component] = select.value;
+    });
+    return {
+        level: form.elements.level.value,
+        components: components,
+        format: form.elements.format.value,
+        file: form.elements.file.value.trim(),
+        max_size: parseInt(form.elements.max_size.value, 10) || 0,
+        max_age: parseInt(form.elements.max_age.value, 10) || 0,
+        max_backups: parseInt(form.elements.max_backups.value, 10) || 0,
+    };
+}
+
+/**
+ * Populates the Access Log card with data.
+ * @param {object} accessLogSettings - The access log settings object from the API.
+ */
+function populateAccessLogSettings(accessLogSettings) {
+    const form = accessLogSettingsForm;
+    form.elements.enabled.checked = !!accessLogSettings.enabled;
+    form.elements.file.value = accessLogSettings.file || '';
+    form.elements.max_size.value = accessLogSettings.max_size || '';
+    form.elements.max_age.value = accessLogSettings.max_age || '';
+    form.elements.max_backups.value = accessLogSettings.max_backups || '';
+    form.elements.sample_rate.value = accessLogSettings.sample_rate || '';
+    form.elements.omit_target.checked = !!accessLogSettings.omit_target;
+}
+
+/**
+ * Collects data from the Access Log card and formats it for the API.
+ * @returns {object} The access log settings object to be sent.
+ */
+function getAccessLogSettingsData() {
+    const form = accessLogSettingsForm;
+    return {
+        enabled: form.elements.enabled.checked,
+        file: form.elements.file.value.trim(),
+        max_size: parseInt(form.elements.max_size.value, 10) || 0,
+        max_age: parseInt(form.elements.max_age.value, 10) || 0,
+        max_backups: parseInt(form.elements.max_backups.value, 10) || 0,
+        sample_rate: parseFloat(form.elements.sample_rate.value) || 0,
+        omit_target: form.elements.omit_target.checked,
     };
 }
 
@@ -104,7 +562,7 @@ function renderRulesTable() {
             <td>${rule.priority}</td>
             <td>${rule.type}</td>
             <td>${Array.isArray(rule.value) ? rule.value.join(', ') : rule.value}</td>
-            <td>${rule.target}</td>
+            <td>${rule.target === 'REJECT' && rule.reject_mode && rule.reject_mode !== 'reject' ? `REJECT (${rule.reject_mode})` : rule.target}</td>
             <td class="actions">
                 <button type="button" class="edit-rule-btn" data-original-index="${originalIndex}">Edit</button>
                 <button type="button" class="delete-rule-btn" data-original-index="${originalIndex}">Delete</button>
@@ -168,6 +626,152 @@ export function initializeSettingsPage() {
                 e.target.textContent = 'Save Gateway Settings';
                 e.target.disabled = false;
             }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'auth') {
+            const settingsData = getAuthSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('auth', settingsData);
+                updateStatusMessage(`Successfully saved Authentication settings.`);
+            } catch (error) {
+                alert(`Error saving Authentication settings: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Authentication Settings';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'inbounds') {
+            const settingsData = getInboundsSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('inbounds', settingsData);
+                updateStatusMessage(`Successfully saved Inbounds.`);
+            } catch (error) {
+                alert(`Error saving Inbounds: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Inbounds';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'dns') {
+            const settingsData = getDnsSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('dns', settingsData);
+                updateStatusMessage(`Successfully saved DNS settings.`);
+            } catch (error) {
+                alert(`Error saving DNS settings: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save DNS Settings';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'resolver') {
+            const settingsData = getResolverSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('resolver', settingsData);
+                updateStatusMessage(`Successfully saved Resolver settings.`);
+            } catch (error) {
+                alert(`Error saving Resolver settings: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Resolver Settings';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'limits') {
+            const settingsData = getLimitsSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('limits', settingsData);
+                updateStatusMessage(`Successfully saved Connection Limits.`);
+            } catch (error) {
+                alert(`Error saving Connection Limits: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Connection Limits';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'security') {
+            const settingsData = getSecuritySettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('security', settingsData);
+                updateStatusMessage(`Successfully saved Security settings.`);
+            } catch (error) {
+                alert(`Error saving Security settings: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Security Settings';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('unban-btn')) {
+            try {
+                await unbanClient(e.target.dataset.ip);
+                updateStatusMessage(`Lifted ban on ${e.target.dataset.ip}.`);
+            } catch (error) {
+                alert(`Error lifting ban: ${error.message}`);
+            }
+            loadBans();
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'bandwidth') {
+            const settingsData = getBandwidthSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('bandwidth', settingsData);
+                updateStatusMessage(`Successfully saved Bandwidth Limits.`);
+            } catch (error) {
+                alert(`Error saving Bandwidth Limits: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Bandwidth Limits';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'timeouts') {
+            const settingsData = getTimeoutsSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('timeouts', settingsData);
+                updateStatusMessage(`Successfully saved Timeouts.`);
+            } catch (error) {
+                alert(`Error saving Timeouts: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Timeouts';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'logging') {
+            const settingsData = getLoggingSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('logging', settingsData);
+                updateStatusMessage(`Successfully saved Logging.`);
+            } catch (error) {
+                alert(`Error saving Logging: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Logging';
+                e.target.disabled = false;
+            }
+        } else if (e.target.classList.contains('save-btn') && e.target.dataset.module === 'access_log') {
+            const settingsData = getAccessLogSettingsData();
+            e.target.textContent = 'Saving...';
+            e.target.disabled = true;
+            try {
+                await saveSettings('access_log', settingsData);
+                updateStatusMessage(`Successfully saved Access Log.`);
+            } catch (error) {
+                alert(`Error saving Access Log: ${error.message}`);
+            } finally {
+                e.target.textContent = 'Save Access Log';
+                e.target.disabled = false;
+            }
+        } else if (e.target.id === 'add-bandwidth-rule-btn') {
+            appendBandwidthRuleRow({});
+        } else if (e.target.classList.contains('delete-bandwidth-rule-btn')) {
+            e.target.closest('tr').remove();
+        } else if (e.target.id === 'add-inbound-btn') {
+            appendInboundRow({});
+        } else if (e.target.classList.contains('delete-inbound-btn')) {
+            e.target.closest('tr').remove();
         }
     });
 
@@ -55,12 +55,18 @@ export function mergeRuntimeData(healthData) {
     const healthStatus = healthData.healthStatus || {};
     const metrics = healthData.metrics || {};
     const runtimeInfo = healthData.runtimeInfo || {};
+    const connections = healthData.connections || {};
+    const backendConnections = connections.backends || {};
+    const backendTraffic = (healthData.traffic && healthData.traffic.backends) || {};
 
     serversCache.forEach(server => {
         server.health = healthStatus[server.id] || 0; // 0: Unknown, 1: Up, 2: Down
         const serverMetrics = metrics[server.id];
         server.connections = serverMetrics ? serverMetrics.activeConnections : -1;
         server.latency = serverMetrics ? serverMetrics.latency : -1;
+        server.gatewayConnections = backendConnections[server.id] || 0;
+        server.maxGatewayConnections = connections.maxPerBackend || 0;
+        server.traffic = backendTraffic[server.id] || null;
 
         const serverRuntimeInfo = runtimeInfo[server.id];
         if (serverRuntimeInfo && serverRuntimeInfo.Port > 0) {
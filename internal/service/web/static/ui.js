@@ -20,6 +20,22 @@ const ruleDialogTitle = document.getElementById('rule-dialog-title');
 const ruleIndexInput = document.getElementById('rule-index');
 const ruleTargetSelect = document.getElementById('rule-target');
 
+/**
+ * Formats a byte count with a binary unit, e.g. 1536 -> "1.5 KB".
+ * @param {number} bytes
+ * @returns {string}
+ */
+export function formatBytes(bytes) {
+    const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+    let value = bytes || 0;
+    let unit = 0;
+    while (value >= 1024 && unit < units.length - 1) {
+        value /= 1024;
+        unit++;
+    }
+    return `${unit === 0 ? value : value.toFixed(1)} ${units[unit]}`;
+}
+
 /**
  * Renders the server list table based on the current serversCache.
  */
@@ -42,9 +58,15 @@ export function renderServers() {
         if (server.active && server.connections >= 0) {
              details += ` | Conns: ${server.connections}`;
         }
+        if (server.active && server.maxGatewayConnections > 0) {
+            details += ` | Gateway: ${server.gatewayConnections}/${server.maxGatewayConnections}`;
+        }
         if (server.active && server.latency >= 0) {
             details += ` | Latency: ${server.latency}ms`;
         }
+        if (server.traffic) {
+            details += ` | Traffic: ↑${formatBytes(server.traffic.upload)} ↓${formatBytes(server.traffic.download)}`;
+        }
         if (server.type === 'vless') {
             details += ` | SNI: ${escapeHTML(server.sni || 'auto')}`;
         } else if (server.type === 'worker') {
@@ -191,6 +213,8 @@ export function showRuleDialog(rule = null, index = null) {
         ruleForm.elements.type.value = rule.type;
         ruleForm.elements.target.value = rule.target;
         ruleForm.elements.priority.value = rule.priority;
+        ruleForm.elements.override_destination.checked = !!rule.override_destination;
+        ruleForm.elements.reject_mode.value = rule.reject_mode || 'reject';
         // Convert array to newline-separated string for textarea
         if (Array.isArray(rule.value)) {
             ruleForm.elements.value.value = rule.value.join('\n');
@@ -224,6 +248,12 @@ export function getRuleFormData() {
         value: values, // Always an array
         target: formData.get('target'),
     };
+    if (ruleForm.elements.override_destination.checked) {
+        ruleData.override_destination = true;
+    }
+    if (ruleData.target === 'REJECT' && formData.get('reject_mode') !== 'reject') {
+        ruleData.reject_mode = formData.get('reject_mode');
+    }
     const indexStr = formData.get('rule-index');
     return {
         data: ruleData,
@@ -254,7 +284,7 @@ export function getFormServerData() {
     return serverData;
 }
 
-function escapeHTML(str) {
+export function escapeHTML(str) {
     if (str === null || str === undefined) return '';
     return str.toString()
         .replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;')
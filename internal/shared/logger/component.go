@@ -0,0 +1,148 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// 可以单独设置日志级别的组件。组件的日志带有 "component" 字段。
+const (
+	ComponentGateway    = "gateway"
+	ComponentDispatcher = "dispatcher"
+	ComponentGoRemote   = "goremote"
+	ComponentVless      = "vless"
+	ComponentWorker     = "worker"
+	ComponentXrayCore   = "xray_core"
+)
+
+// Components 列出所有可以单独设置级别的组件。
+var Components = []string{
+	ComponentGateway, ComponentDispatcher, ComponentGoRemote,
+	ComponentVless, ComponentWorker, ComponentXrayCore,
+}
+
+// componentHook 为日志加上 "component" 字段并丢弃低于该组件级别的日志，name 为空时只过滤。
+// level 可以在运行时修改，已创建的 logger 立即生效。
+type componentHook struct {
+	name  string
+	level *atomic.Int32
+}
+
+func (h componentHook) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level < zerolog.Level(h.level.Load()) {
+		e.Discard()
+		return
+	}
+	if h.name != "" {
+		e.Str("component", h.name)
+	}
+}
+
+// componentLogger 是缓存的组件 logger，同一组件的 hook 和不带追踪字段的 logger 只创建一次。
+type componentLogger struct {
+	hook   componentHook
+	logger zerolog.Logger
+}
+
+var (
+	// defaultLevel 是未单独设置级别的组件和全局 logger 的级别
+	defaultLevel atomic.Int32
+
+	levelsMu sync.Mutex
+	// componentLevels 保存每个组件的当前级别，overrides 保存单独设置的级别
+	componentLevels = make(map[string]*atomic.Int32)
+	overrides       = make(map[string]zerolog.Level)
+
+	// componentLoggers 在写入时整体替换，Ctx 读取时不需要加锁
+	componentLoggers atomic.Pointer[map[string]*componentLogger]
+)
+
+func init() {
+	defaultLevel.Store(int32(zerolog.InfoLevel))
+	// 组件名为空的 logger 按默认级别过滤
+	hook := componentHook{level: &defaultLevel}
+	componentLoggers.Store(&map[string]*componentLogger{"": {hook: hook, logger: root.Hook(hook)}})
+}
+
+// Component 返回带 "component" 字段、按该组件的级别过滤的 logger。
+// 级别在运行时修改后，之前返回的 logger 也立即生效，因此可以保存在包级变量中。
+func Component(name string) zerolog.Logger {
+	return lookupComponent(name).logger
+}
+
+// lookupComponent 返回组件的缓存 logger，第一次使用时创建。
+func lookupComponent(name string) *componentLogger {
+	if c, ok := (*componentLoggers.Load())[name]; ok {
+		return c
+	}
+
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	loggers := *componentLoggers.Load()
+	if c, ok := loggers[name]; ok {
+		return c
+	}
+	level := new(atomic.Int32)
+	if override, ok := overrides[name]; ok {
+		level.Store(int32(override))
+	} else {
+		level.Store(defaultLevel.Load())
+	}
+	componentLevels[name] = level
+	hook := componentHook{name: name, level: level}
+	c := &componentLogger{hook: hook, logger: root.Hook(hook)}
+
+	updated := make(map[string]*componentLogger, len(loggers)+1)
+	for k, v := range loggers {
+		updated[k] = v
+	}
+	updated[name] = c
+	componentLoggers.Store(&updated)
+	return c
+}
+
+// setLevels 设置默认级别和各组件单独的级别。zerolog 的全局级别设为其中最低的级别，
+// 使所有组件都不需要的日志在构造前就被丢弃。
+func setLevels(level zerolog.Level, componentOverrides map[string]zerolog.Level) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	defaultLevel.Store(int32(level))
+	overrides = componentOverrides
+	lowest := level
+	for name, l := range componentLevels {
+		if override, ok := overrides[name]; ok {
+			l.Store(int32(override))
+		} else {
+			l.Store(int32(level))
+		}
+	}
+	for _, override := range overrides {
+		if override < lowest {
+			lowest = override
+		}
+	}
+	zerolog.SetGlobalLevel(lowest)
+}
+
+// ContextLogger 返回用于构造放入 context 的 logger 的 zerolog.Context，通常加上 trace_id 等追踪字段。
+// 这个 logger 不带组件字段和级别过滤，读取时经 Ctx 加上读取方的组件，
+// 使一个连接经过的各个组件按各自的级别输出带相同追踪字段的日志。
+func ContextLogger() zerolog.Context {
+	return root.With()
+}
+
+// Ctx 返回 ctx 中的 logger，加上组件字段并按该组件的级别过滤；component 为空时按默认级别过滤。
+// ctx 中没有 logger 时直接返回缓存的组件 logger，不带追踪字段。
+func Ctx(ctx context.Context, component string) *zerolog.Logger {
+	c := lookupComponent(component)
+	l := zerolog.Ctx(ctx)
+	if l.GetLevel() == zerolog.Disabled {
+		return &c.logger
+	}
+	hooked := l.Hook(c.hook)
+	return &hooked
+}
@@ -2,8 +2,6 @@ package logger
 
 import (
 	"fmt"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -12,15 +10,12 @@ import (
 	"liuproxy_go/internal/shared/types"
 )
 
-// Init initializes the global logger for both the main application (zerolog)
-// and the internal xray-core library.
+// Init initializes the global logger for the main application with the level from liuproxy.ini.
+// Output goes to stderr in console format until the "logging" settings module is applied.
 func Init(cfg types.LogConf) error {
-	// --- Part 1: Initialize zerolog for the main application ---
-	levelStr := strings.ToLower(cfg.Level)
-	level, err := zerolog.ParseLevel(levelStr)
+	level, err := parseLevel(cfg.Level)
 	if err != nil {
-		level = zerolog.InfoLevel
-		fmt.Printf("Unknown log level '%s', defaulting to 'info' for zerolog\n", levelStr)
+		fmt.Printf("Unknown log level '%s', defaulting to 'info' for zerolog\n", cfg.Level)
 	}
 
 	// Force all timestamps to be in UTC.
@@ -28,22 +23,18 @@ func Init(cfg types.LogConf) error {
 		return time.Now().UTC()
 	}
 
-	consoleWriter := zerolog.ConsoleWriter{
-		Out:        os.Stderr,
-		TimeFormat: "2006-01-02 15:04:05",
-	}
-
-	log.Logger = zerolog.New(consoleWriter).
-		Level(level).
-		With().
-		Timestamp().
-		Logger()
+	setLevels(level, nil)
 
 	Info().Msgf("Main logger (zerolog) initialized with level: %s", level.String())
 
 	return nil
 }
 
+func init() {
+	// The global logger has no component field and follows the default level.
+	log.Logger = root.Hook(componentHook{level: &defaultLevel})
+}
+
 // Event is a wrapper for a zerolog event.
 type Event struct {
 	*zerolog.Event
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"fmt"
+	"liuproxy_go/internal/shared/settings"
+	"liuproxy_go/internal/shared/types"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Module 把 "logging" 模块的配置应用到日志系统，实现了 settings.ConfigurableModule 接口。
+type Module struct {
+	fallback string // liuproxy.ini 的 [log] level，"logging" 模块未设置级别时使用
+
+	mu   sync.Mutex
+	file settings.LoggingSettings // 当前日志文件的路径和轮转参数，相同时不重新打开文件
+}
+
+// NewModule 创建日志配置模块，cfg 为 liuproxy.ini 中的 [log] 配置。
+func NewModule(cfg types.LogConf) *Module {
+	return &Module{fallback: cfg.Level}
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。级别对所有 logger 立即生效。
+func (m *Module) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "logging" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.LoggingSettings)
+	if !ok {
+		return fmt.Errorf("logger: received incorrect settings type for logging module")
+	}
+	if err := m.Apply(cfg); err != nil {
+		return err
+	}
+	Info().Str("level", zerolog.Level(defaultLevel.Load()).String()).Str("file", cfg.File).Msg("Logging settings updated.")
+	return nil
+}
+
+// Apply 按 cfg 设置级别、格式和输出，cfg 中有无法解析的值时不做任何修改。
+func (m *Module) Apply(cfg *settings.LoggingSettings) error {
+	if cfg == nil {
+		cfg = &settings.LoggingSettings{}
+	}
+
+	levelStr := cfg.Level
+	if levelStr == "" {
+		levelStr = m.fallback
+	}
+	level, err := parseLevel(levelStr)
+	if err != nil {
+		return err
+	}
+	componentOverrides := make(map[string]zerolog.Level)
+	for name, s := range cfg.Components {
+		if !isComponent(name) {
+			return fmt.Errorf("logger: unknown component '%s'", name)
+		}
+		if s == "" {
+			continue
+		}
+		l, err := parseLevel(s)
+		if err != nil {
+			return err
+		}
+		componentOverrides[name] = l
+	}
+	format := strings.ToLower(cfg.Format)
+	if format == "" {
+		format = FormatConsole
+	}
+	if format != FormatConsole && format != FormatJSON {
+		return fmt.Errorf("logger: unknown format '%s'", cfg.Format)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cfg.File == "" {
+		out.set(newWriter(os.Stderr, format, true), nil)
+		m.file = settings.LoggingSettings{}
+	} else {
+		out.mu.RLock()
+		file := out.file
+		out.mu.RUnlock()
+		if file == nil || m.file.File != cfg.File || m.file.MaxSize != cfg.MaxSize ||
+			m.file.MaxAge != cfg.MaxAge || m.file.MaxBackups != cfg.MaxBackups {
+			if file, err = OpenRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups); err != nil {
+				return err
+			}
+		}
+		out.set(newWriter(file, format, false), file)
+		m.file = settings.LoggingSettings{File: cfg.File, MaxSize: cfg.MaxSize, MaxAge: cfg.MaxAge, MaxBackups: cfg.MaxBackups}
+	}
+	setLevels(level, componentOverrides)
+	return nil
+}
+
+func parseLevel(s string) (zerolog.Level, error) {
+	level, err := zerolog.ParseLevel(strings.ToLower(s))
+	if err != nil || level == zerolog.NoLevel {
+		return zerolog.InfoLevel, fmt.Errorf("logger: unknown level '%s'", s)
+	}
+	return level, nil
+}
+
+func isComponent(name string) bool {
+	for _, c := range Components {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// 日志格式
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// output 是所有 logger 共享的输出，格式和目标可以在运行时替换。
+type output struct {
+	mu   sync.RWMutex
+	w    io.Writer
+	file *RotatingFile // 当前的日志文件，输出到标准错误时为 nil
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.w.Write(p)
+}
+
+// set 替换输出，并关闭之前的日志文件。
+func (o *output) set(w io.Writer, file *RotatingFile) {
+	o.mu.Lock()
+	old := o.file
+	o.w, o.file = w, file
+	o.mu.Unlock()
+	if old != nil && old != file {
+		old.Close()
+	}
+}
+
+// newWriter 按 format 创建写入 dest 的 writer。
+func newWriter(dest io.Writer, format string, color bool) io.Writer {
+	if format == FormatJSON {
+		return dest
+	}
+	return zerolog.ConsoleWriter{
+		Out:        dest,
+		NoColor:    !color,
+		TimeFormat: "2006-01-02 15:04:05",
+	}
+}
+
+var (
+	out = &output{w: newWriter(os.Stderr, FormatConsole, true)}
+	// root 是所有 logger 的根，不带级别过滤
+	root = zerolog.New(out).With().Timestamp().Logger()
+)
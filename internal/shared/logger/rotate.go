@@ -0,0 +1,161 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// backupTimeFormat 是轮转后文件名中的时间格式，按字典序排列即按时间排列。
+	backupTimeFormat = "20060102-150405.000"
+	// rotateRetryInterval 是轮转失败后再次尝试的间隔，期间继续写入当前文件。
+	rotateRetryInterval = time.Minute
+)
+
+// RotatingFile 是按大小轮转的日志文件，实现了 io.WriteCloser，可以被多个 goroutine 同时写入。
+// 文件超过 maxSize 时被重命名为 "<name>-<时间><ext>" 并重新创建，
+// 轮转后的文件超过 maxAge 或超出 maxBackups 个时被删除。
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	retryAt time.Time // 轮转失败后，在此之前不再尝试
+}
+
+// OpenRotatingFile 以追加方式打开 path。maxSizeMB 为轮转的大小 (MB)，maxAgeDays 为轮转后文件的保留天数，
+// maxBackups 为轮转后文件的保留个数，为 0 时分别表示不按大小轮转、不按时间删除和不按个数删除。
+func OpenRotatingFile(path string, maxSizeMB, maxAgeDays, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.removeBackups()
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write 写入 p，写入后会超过 maxSize 时先轮转。
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize && !time.Now().Before(f.retryAt) {
+		if err := f.rotate(); err != nil {
+			// 日志无法记录自身的错误，只在第一次失败时报告到标准错误，之后继续写入当前文件
+			if f.retryAt.IsZero() {
+				fmt.Fprintf(os.Stderr, "logger: %v, still writing to %s\n", err, f.file.Name())
+			}
+			f.retryAt = time.Now().Add(rotateRetryInterval)
+		} else {
+			f.retryAt = time.Time{}
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close 关闭文件，之后的写入返回 os.ErrClosed。
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate 把当前文件改名为备份并创建新文件。失败时 f.file 仍指向原来的文件 (可能已被改名)，写入不会丢失。
+func (f *RotatingFile) rotate() error {
+	ext := filepath.Ext(f.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), time.Now().UTC().Format(backupTimeFormat), ext)
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+	go f.removeBackups()
+	return nil
+}
+
+// removeBackups 删除超过 maxAge 或超出 maxBackups 个的轮转后文件。
+func (f *RotatingFile) removeBackups() {
+	if f.maxAge <= 0 && f.maxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	// 最新的在前
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	cutoff := time.Now().Add(-f.maxAge)
+	for i, name := range backups {
+		path := filepath.Join(filepath.Dir(f.path), name)
+		expired := false
+		if f.maxBackups > 0 && i >= f.maxBackups {
+			expired = true
+		} else if f.maxAge > 0 {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Before(cutoff) {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readDirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.maxSize = 10 // 以字节为单位测试
+
+	for _, line := range []string{"1234\n", "5678\n", "abcdef\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) failed: %v", line, err)
+		}
+	}
+
+	names := readDirNames(t, dir)
+	if len(names) != 2 || !strings.HasPrefix(names[0], "access-") || names[1] != "access.log" {
+		t.Fatalf("files = %v, want one backup and access.log", names)
+	}
+	backup, _ := os.ReadFile(filepath.Join(dir, names[0]))
+	if string(backup) != "1234\n5678\n" {
+		t.Errorf("backup = %q", backup)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "abcdef\n" {
+		t.Errorf("current file = %q", current)
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(names[0], "access-"), ".log")
+	if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+		t.Errorf("backup name %q has an invalid timestamp: %v", names[0], err)
+	}
+}
+
+// 单次写入超过 maxSize 时不会对空文件反复轮转。
+func TestRotatingFileOversizedWrite(t *testing.T) {
+	dir := t.TempDir()
+	f, err := OpenRotatingFile(filepath.Join(dir, "app.log"), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.maxSize = 4
+
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNames(t, dir); !reflect.DeepEqual(names, []string{"app.log"}) {
+		t.Errorf("files = %v, an empty file should not be rotated", names)
+	}
+}
+
+// 轮转失败时继续写入当前文件，在重试间隔内不再尝试。
+func TestRotatingFileRotationFailureKeepsWriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f, err := OpenRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.maxSize = 10
+
+	if _, err := f.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+	// 文件被外部删除后改名失败，轮转无法进行
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.Write([]byte("second line\n"))
+	if err != nil || n != len("second line\n") {
+		t.Fatalf("Write() after a failed rotation = %d, %v", n, err)
+	}
+	if f.retryAt.Before(time.Now().Add(rotateRetryInterval - time.Second)) {
+		t.Errorf("retryAt = %v, want about %v from now", f.retryAt, rotateRetryInterval)
+	}
+	if f.size != int64(len("first line\nsecond line\n")) {
+		t.Errorf("size = %d, writes should continue to the current file", f.size)
+	}
+
+	// 重试时间到达后再次轮转，成功后恢复正常
+	os.WriteFile(path, nil, 0644)
+	f.retryAt = time.Now().Add(-time.Second)
+	if _, err := f.Write([]byte("third line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !f.retryAt.IsZero() {
+		t.Errorf("retryAt should be cleared after a successful rotation")
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "third line\n" {
+		t.Errorf("current file = %q, want the write after rotation", current)
+	}
+}
+
+func TestRotatingFileRemoveBackups(t *testing.T) {
+	now := time.Now().UTC()
+	backupName := func(age time.Duration) string {
+		return "app-" + now.Add(-age).Format(backupTimeFormat) + ".log"
+	}
+	tests := []struct {
+		name       string
+		maxAge     time.Duration
+		maxBackups int
+		kept       []time.Duration // 保留下来的备份的年龄
+	}{
+		{"by count", 0, 2, []time.Duration{time.Hour, 2 * time.Hour}},
+		{"by age", 36 * time.Hour, 0, []time.Duration{time.Hour, 2 * time.Hour, 24 * time.Hour}},
+		{"by count and age", 36 * time.Hour, 1, []time.Duration{time.Hour}},
+		{"unlimited", 0, 0, []time.Duration{time.Hour, 2 * time.Hour, 24 * time.Hour, 72 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, age := range []time.Duration{time.Hour, 2 * time.Hour, 24 * time.Hour, 72 * time.Hour} {
+				path := filepath.Join(dir, backupName(age))
+				os.WriteFile(path, []byte("old"), 0644)
+				os.Chtimes(path, now.Add(-age), now.Add(-age))
+			}
+			// 不属于本日志的文件不受影响
+			others := []string{"app.log", "app-notes.log", "other-" + now.Format(backupTimeFormat) + ".log"}
+			for _, name := range others {
+				os.WriteFile(filepath.Join(dir, name), nil, 0644)
+			}
+
+			f := &RotatingFile{path: filepath.Join(dir, "app.log"), maxAge: tt.maxAge, maxBackups: tt.maxBackups}
+			f.removeBackups()
+
+			want := append([]string{}, others...)
+			for _, age := range tt.kept {
+				want = append(want, backupName(age))
+			}
+			sort.Strings(want)
+			if got := readDirNames(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+		})
+	}
+}
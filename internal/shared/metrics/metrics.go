@@ -0,0 +1,29 @@
+// Package metrics 收集网关的运行指标，并以 Prometheus 文本格式输出。
+// 事件类的计数器和直方图在这里定义，由各模块直接更新；服务器健康、连接数等状态由 AppServer 在抓取时输出。
+package metrics
+
+// 网关和 Dispatcher 的事件计数。
+var (
+	// GatewayAccepted 按入口和协议统计接受的连接，协议为嗅探结果，透明代理为 "TCP"
+	GatewayAccepted = NewCounterVec("liuproxy_gateway_accepted_connections_total",
+		"Connections accepted by the gateways, by inbound and sniffed protocol.", "inbound", "protocol")
+	// DispatchDecisions 按规则和目标统计路由决策，目标为服务器 ID、"DIRECT" 或 "REJECT"
+	DispatchDecisions = NewCounterVec("liuproxy_dispatch_decisions_total",
+		"Routing decisions made by the dispatcher, by rule and target server ID.", "rule", "target")
+	DispatchErrors = NewCounterVec("liuproxy_dispatch_errors_total",
+		"Dispatch calls that found no usable route.")
+	StickyHits = NewCounterVec("liuproxy_sticky_hits_total",
+		"Dispatches served by an existing sticky session.")
+	StickyMisses = NewCounterVec("liuproxy_sticky_misses_total",
+		"Dispatches subject to sticky sessions that had no usable record and fell back to the load balancer.")
+)
+
+// 每个服务器的事件计数和耗时分布，serverID 为 "DIRECT" 时表示直连。
+var (
+	DialErrors = NewCounterVec("liuproxy_server_dial_errors_total",
+		"Failed attempts to open a connection through a server.", "server_id")
+	TunnelSetupSeconds = NewHistogramVec("liuproxy_tunnel_setup_seconds",
+		"Time taken to open a connection to the target through a server.", DefaultBuckets, "server_id")
+	HealthCheckSeconds = NewHistogramVec("liuproxy_health_check_seconds",
+		"Duration of end-to-end health checks.", DefaultBuckets, "server_id", "result")
+)
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Label 是一个样本的标签。
+type Label struct {
+	Name  string
+	Value string
+}
+
+// collector 是注册表中的一个指标族，抓取时按注册顺序输出。
+type collector interface {
+	collect(w *Writer)
+}
+
+// CollectorFunc 在每次抓取时调用，用于输出由其他模块维护的状态 (如服务器健康、连接数)。
+type CollectorFunc func(w *Writer)
+
+func (f CollectorFunc) collect(w *Writer) { f(w) }
+
+// Registry 保存所有指标族，并以 Prometheus 文本格式 (0.0.4) 输出。
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry 创建一个空的注册表。
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default 是网关各模块注册指标的默认注册表。
+var Default = NewRegistry()
+
+// Register 注册一个在抓取时调用的 CollectorFunc。
+func (r *Registry) Register(f CollectorFunc) {
+	r.add(f)
+}
+
+func (r *Registry) add(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Handler 返回输出注册表中所有指标的 http.Handler。
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		collectors := append([]collector(nil), r.collectors...)
+		r.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writer := &Writer{w: bw}
+		for _, c := range collectors {
+			c.collect(writer)
+		}
+		bw.Flush()
+	})
+}
+
+// Writer 以 Prometheus 文本格式输出指标。
+type Writer struct {
+	w *bufio.Writer
+}
+
+// Header 输出一个指标族的 HELP 和 TYPE 行，typ 为 "counter"、"gauge" 或 "histogram"。
+func (w *Writer) Header(name, help, typ string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// Sample 输出一个样本。
+func (w *Writer) Sample(name string, labels []Label, value float64) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			fmt.Fprintf(w.w, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// labelKey 把标签值拼接为 map 的键。
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// pairLabels 把标签名和值配对，值的个数与名称不符时补空或截断。
+func pairLabels(names, values []string) []Label {
+	labels := make([]Label, len(names))
+	for i, name := range names {
+		labels[i].Name = name
+		if i < len(values) {
+			labels[i].Value = values[i]
+		}
+	}
+	return labels
+}
+
+// sortedKeys 返回 map 的键，按字典序排列，使输出稳定。
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
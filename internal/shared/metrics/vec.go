@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"sync"
+)
+
+// CounterVec 是一组按标签区分的计数器。
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec 创建计数器并注册到 Default。
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	Default.add(c)
+	return c
+}
+
+// Inc 使标签值为 values 的计数器加一。
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 使标签值为 values 的计数器增加 delta。
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := labelKey(values)
+	c.mu.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: append([]string(nil), values...)}
+		c.values[key] = v
+	}
+	v.value += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) collect(w *Writer) {
+	w.Header(c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 没有标签的计数器总是输出，尚未计数时为 0
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		w.Sample(c.name, nil, 0)
+	}
+	for _, key := range sortedKeys(c.values) {
+		v := c.values[key]
+		w.Sample(c.name, pairLabels(c.labels, v.labels), v.value)
+	}
+}
+
+// HistogramVec 是一组按标签区分的直方图。
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // 升序的桶上界，+Inf 桶隐含在末尾
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // 每个桶 (不累积) 的观测数
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets 是以秒为单位的耗时直方图的默认桶上界。
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewHistogramVec 创建直方图并注册到 Default，buckets 为升序的桶上界。
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	Default.add(h)
+	return h
+}
+
+// Observe 记录标签值为 values 的一次观测。
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := labelKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			v.counts[i]++
+			break
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *HistogramVec) collect(w *Writer) {
+	w.Header(h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		labels := pairLabels(h.labels, v.labels)
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += v.counts[i]
+			w.Sample(h.name+"_bucket", append(labels, Label{Name: "le", Value: formatFloat(upper)}), float64(cumulative))
+		}
+		w.Sample(h.name+"_bucket", append(labels, Label{Name: "le", Value: "+Inf"}), float64(v.count))
+		w.Sample(h.name+"_sum", labels, v.sum)
+		w.Sample(h.name+"_count", labels, float64(v.count))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"os"
+	"reflect"
 	"sync"
 	"sync/atomic"
 )
@@ -81,7 +82,8 @@ func (sm *SettingsManager) Update(moduleKey string, newSettingsData json.RawMess
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	// 1. 深拷贝当前的配置，以避免竞态条件
+	// 1. 深拷贝当前的配置，以避免竞态条件。
+	// deepCopy 只复制模块结构体，切片和 map 仍与当前配置共享，由 resetCollections 在反序列化前替换
 	currentSettings := sm.Get()
 	newSettings := deepCopy(currentSettings)
 
@@ -90,6 +92,7 @@ func (sm *SettingsManager) Update(moduleKey string, newSettingsData json.RawMess
 	if targetModule == nil {
 		return fmt.Errorf("unknown settings module: %s", moduleKey)
 	}
+	resetCollections(targetModule)
 	if err := json.Unmarshal(newSettingsData, targetModule); err != nil {
 		return fmt.Errorf("failed to parse JSON for module %s: %w", moduleKey, err)
 	}
@@ -138,14 +141,81 @@ func (sm *SettingsManager) notify(moduleKey string, newSettings interface{}) {
 
 func deepCopy(s *RuntimeSettings) *RuntimeSettings {
 	newS := *s
+	if s.Routing != nil {
+		routingCopy := *s.Routing
+		newS.Routing = &routingCopy
+	}
 	if s.Gateway != nil {
 		gwCopy := *s.Gateway
 		newS.Gateway = &gwCopy
 	}
+	if s.Auth != nil {
+		authCopy := *s.Auth
+		newS.Auth = &authCopy
+	}
+	if s.Inbounds != nil {
+		inboundsCopy := *s.Inbounds
+		newS.Inbounds = &inboundsCopy
+	}
+	if s.DNS != nil {
+		dnsCopy := *s.DNS
+		newS.DNS = &dnsCopy
+	}
+	if s.Resolver != nil {
+		resolverCopy := *s.Resolver
+		newS.Resolver = &resolverCopy
+	}
+	if s.Limits != nil {
+		limitsCopy := *s.Limits
+		newS.Limits = &limitsCopy
+	}
+	if s.Security != nil {
+		securityCopy := *s.Security
+		newS.Security = &securityCopy
+	}
+	if s.Bandwidth != nil {
+		bandwidthCopy := *s.Bandwidth
+		newS.Bandwidth = &bandwidthCopy
+	}
+	if s.Timeouts != nil {
+		timeoutsCopy := *s.Timeouts
+		newS.Timeouts = &timeoutsCopy
+	}
+	if s.AccessLog != nil {
+		accessLogCopy := *s.AccessLog
+		newS.AccessLog = &accessLogCopy
+	}
+	if s.Logging != nil {
+		loggingCopy := *s.Logging
+		newS.Logging = &loggingCopy
+	}
 	// ... 对其他模块执行相同的深度拷贝 ...
 	return &newS
 }
 
+// resetCollections 把模块中的切片和 map 替换为新的空值。
+// json.Unmarshal 会复用已有切片的底层数组和其中的指针元素、把新键合并到已有的 map 中，
+// 这些数据与正在使用的配置快照共享；替换后每次更新都完整替换列表，且不会写入旧快照。
+func resetCollections(module interface{}) {
+	v := reflect.ValueOf(module)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Slice:
+			field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+		case reflect.Map:
+			field.Set(reflect.MakeMap(field.Type()))
+		}
+	}
+}
+
 func getModuleByKey(s *RuntimeSettings, key string) interface{} {
 	switch key {
 	case "gateway":
@@ -154,6 +224,24 @@ func getModuleByKey(s *RuntimeSettings, key string) interface{} {
 		return s.Routing
 	case "logging":
 		return s.Logging
+	case "auth":
+		return s.Auth
+	case "inbounds":
+		return s.Inbounds
+	case "dns":
+		return s.DNS
+	case "resolver":
+		return s.Resolver
+	case "limits":
+		return s.Limits
+	case "security":
+		return s.Security
+	case "bandwidth":
+		return s.Bandwidth
+	case "timeouts":
+		return s.Timeouts
+	case "access_log":
+		return s.AccessLog
 	default:
 		return nil
 	}
@@ -0,0 +1,134 @@
+package settings
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestManager(t *testing.T) *SettingsManager {
+	t.Helper()
+	sm, err := NewSettingsManager(filepath.Join(t.TempDir(), "settings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+func mustUpdate(t *testing.T, sm *SettingsManager, moduleKey, data string) {
+	t.Helper()
+	if err := sm.Update(moduleKey, json.RawMessage(data)); err != nil {
+		t.Fatalf("Update(%s) failed: %v", moduleKey, err)
+	}
+}
+
+// 更新列表不能写入旧快照中的元素，省略的字段也不能沿用同一位置上旧元素的值。
+func TestUpdateReplacesInbounds(t *testing.T) {
+	sm := newTestManager(t)
+	mustUpdate(t, sm, "inbounds", `{"inbounds":[
+		{"name":"a","port":1080,"target":"DIRECT","tls_port":8443,"protocols":["tls"]},
+		{"name":"b","port":1081},
+		{"name":"c","port":1082,"protocols":["socks5"]}]}`)
+	before := sm.Get().Inbounds.Inbounds
+	a, b := *before[0], *before[1]
+
+	// 删除中间的入口
+	mustUpdate(t, sm, "inbounds", `{"inbounds":[{"name":"a","port":1080},{"name":"c","port":1082}]}`)
+
+	if !reflect.DeepEqual(*before[0], a) || !reflect.DeepEqual(*before[1], b) {
+		t.Errorf("update modified inbounds of the previous snapshot: %+v, %+v", *before[0], *before[1])
+	}
+	after := sm.Get().Inbounds.Inbounds
+	want := []Inbound{{Name: "a", Port: 1080}, {Name: "c", Port: 1082}}
+	if len(after) != len(want) {
+		t.Fatalf("got %d inbounds, want %d", len(after), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(*after[i], want[i]) {
+			t.Errorf("inbound %d = %+v, want %+v", i, *after[i], want[i])
+		}
+		if after[i] == before[i] {
+			t.Errorf("inbound %d shares its struct with the previous snapshot", i)
+		}
+	}
+}
+
+func TestUpdateDoesNotAliasPreviousSnapshot(t *testing.T) {
+	tests := []struct {
+		module string
+		first  string
+		second string
+		get    func(*RuntimeSettings) interface{}
+	}{
+		{
+			module: "auth",
+			first:  `{"enabled":true,"users":[{"username":"alice","password":"x"},{"username":"bob","password":"y"}]}`,
+			second: `{"enabled":true,"users":[{"username":"bob","password":"y"}]}`,
+			get:    func(s *RuntimeSettings) interface{} { return s.Auth.Users },
+		},
+		{
+			module: "dns",
+			first:  `{"hosts":[{"domain":"a.lan","ips":["10.0.0.1","10.0.0.2"]}],"fake_ip_filter":["lan","local"]}`,
+			second: `{"hosts":[{"domain":"b.lan","ips":["10.0.0.3"]}],"fake_ip_filter":["home.arpa"]}`,
+			get:    func(s *RuntimeSettings) interface{} { return []interface{}{s.DNS.Hosts, s.DNS.FakeIPFilter} },
+		},
+		{
+			module: "bandwidth",
+			first:  `{"rules":[{"type":"user","value":["alice","bob"],"upload":100},{"type":"source_ip","value":["10.0.0.0/8"]}]}`,
+			second: `{"rules":[{"type":"source_ip","value":["10.0.0.0/8"]}]}`,
+			get:    func(s *RuntimeSettings) interface{} { return s.Bandwidth.Rules },
+		},
+		{
+			module: "routing",
+			first:  `{"rules":[{"priority":10,"type":"domain","value":["a.com","b.com"],"target":"DIRECT"}]}`,
+			second: `{"rules":[{"priority":20,"type":"domain","value":["c.com"],"target":"REJECT"}]}`,
+			get:    func(s *RuntimeSettings) interface{} { return s.Routing.Rules },
+		},
+		{
+			module: "logging",
+			first:  `{"level":"info","components":{"gateway":"debug","dns":"warn"}}`,
+			second: `{"level":"info","components":{"dns":"error"}}`,
+			get:    func(s *RuntimeSettings) interface{} { return s.Logging.Components },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.module, func(t *testing.T) {
+			sm := newTestManager(t)
+			mustUpdate(t, sm, tt.module, tt.first)
+			before := sm.Get()
+			snapshot, _ := json.Marshal(tt.get(before))
+
+			mustUpdate(t, sm, tt.module, tt.second)
+			if got, _ := json.Marshal(tt.get(before)); string(got) != string(snapshot) {
+				t.Errorf("previous snapshot changed from %s to %s", snapshot, got)
+			}
+
+			// 新配置与直接解析请求得到的结果一致，没有残留旧值
+			fresh := createDefaultSettings()
+			module := getModuleByKey(fresh, tt.module)
+			resetCollections(module)
+			json.Unmarshal([]byte(tt.second), module)
+			want, _ := json.Marshal(tt.get(fresh))
+			if got, _ := json.Marshal(tt.get(sm.Get())); string(got) != string(want) {
+				t.Errorf("updated %s = %s, want %s", tt.module, got, want)
+			}
+		})
+	}
+}
+
+// 更新一个模块不影响其他模块中的列表。
+func TestUpdateKeepsOtherModules(t *testing.T) {
+	sm := newTestManager(t)
+	mustUpdate(t, sm, "logging", `{"level":"info","components":{"gateway":"debug"}}`)
+	mustUpdate(t, sm, "auth", `{"enabled":true,"users":[{"username":"alice","password":"x"}]}`)
+	mustUpdate(t, sm, "gateway", `{"sticky_session_mode":"disabled"}`)
+
+	s := sm.Get()
+	if got := s.Logging.Components["gateway"]; got != "debug" {
+		t.Errorf("component level = %q after updating other modules, want debug", got)
+	}
+	if len(s.Auth.Users) != 1 || s.Auth.Users[0].Username != "alice" {
+		t.Errorf("auth users lost after updating other modules: %+v", s.Auth.Users)
+	}
+}
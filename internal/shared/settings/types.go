@@ -7,9 +7,20 @@ const (
 	RuleTypeSourceIP    RuleType = "source_ip"
 	RuleTypeDestIP      RuleType = "dest_ip"
 	RuleTypeDomain      RuleType = "domain"
+	RuleTypeUser        RuleType = "user"        // 按网关认证得到的用户名匹配
+	RuleTypeInbound     RuleType = "inbound"     // 按接收连接的入口名称匹配
 	RuleTypeLoadBalance RuleType = "loadbalance" // 特殊类型，代表默认负载均衡
 )
 
+// RejectMode 定义了 REJECT 规则拒绝连接的方式
+type RejectMode string
+
+const (
+	RejectModeReply    RejectMode = "reject"           // 按协议应答拒绝: SOCKS5 应答码 0x02、SOCKS4 应答码 0x5B、HTTP 403
+	RejectModeDrop     RejectMode = "reject-drop"      // 不应答并保持连接直到超时 (tarpit)
+	RejectModeTCPReset RejectMode = "reject-tcp-reset" // 以 TCP RST 立即断开连接
+)
+
 // ConfigurableModule 是所有希望其配置能被在线管理的模块必须实现的接口。
 // 它定义了一个标准的回调方法，当相关配置发生变更时，SettingsManager会调用此方法。
 type ConfigurableModule interface {
@@ -23,9 +34,18 @@ type ConfigurableModule interface {
 // 它以模块化的方式组织了所有可以在运行时被动态修改的配置。
 // 使用指针类型确保了当JSON文件中缺少某个模块时，对应的字段为nil，而不是一个空的结构体。
 type RuntimeSettings struct {
-	Gateway *GatewaySettings `json:"gateway"`
-	Routing *RoutingSettings `json:"routing"`
-	Logging *LoggingSettings `json:"logging"`
+	Gateway   *GatewaySettings   `json:"gateway"`
+	Routing   *RoutingSettings   `json:"routing"`
+	Logging   *LoggingSettings   `json:"logging"`
+	Auth      *AuthSettings      `json:"auth"`
+	Inbounds  *InboundsSettings  `json:"inbounds"`
+	DNS       *DNSSettings       `json:"dns"`
+	Resolver  *ResolverSettings  `json:"resolver"`
+	Limits    *LimitsSettings    `json:"limits"`
+	Security  *SecuritySettings  `json:"security"`
+	Bandwidth *BandwidthSettings `json:"bandwidth"`
+	Timeouts  *TimeoutsSettings  `json:"timeouts"`
+	AccessLog *AccessLogSettings `json:"access_log"`
 }
 
 // GatewaySettings 对应 settings.json 中的 "gateway" 模块。
@@ -34,6 +54,18 @@ type GatewaySettings struct {
 	StickySessionTTL     int      `json:"sticky_session_ttl"`     // in seconds
 	StickyRules          []string `json:"sticky_rules"`           // list of domains for conditional mode
 	LoadBalancerStrategy string   `json:"load_balancer_strategy"` // e.g., "least_connections", "round_robin"
+
+	// ProxyProtocol 启用后，来自 ProxyProtocolTrustedCIDRs 的连接可以携带 PROXY protocol v1/v2 头，
+	// 网关将使用头中的真实客户端地址进行路由。其他来源的连接按普通连接处理。
+	ProxyProtocol             bool     `json:"proxy_protocol"`
+	ProxyProtocolTrustedCIDRs []string `json:"proxy_protocol_trusted_cidrs"` // e.g., ["10.0.0.0/8"]
+
+	// Sniffing 启用后，SOCKS/HTTP CONNECT 的目标为 IP 时，网关先应答客户端，
+	// 再从首个数据包中嗅探 TLS SNI 或 HTTP Host，用于 domain 规则匹配和粘性会话。
+	Sniffing bool `json:"sniffing"`
+
+	// RejectHTTPBody 是 REJECT 规则以 "reject" 方式拒绝 HTTP 请求时 403 应答的正文，为空时不带正文
+	RejectHTTPBody string `json:"reject_http_body"`
 }
 
 type Rule struct {
@@ -41,6 +73,12 @@ type Rule struct {
 	Type     string   `json:"type"`            // e.g., "domain", "source_ip"
 	Value    []string `json:"value,omitempty"` // e.g., ["*.google.com"], ["192.168.1.0/24", "10.0.0.0/8"]
 	Target   string   `json:"target"`          // Server remarks, or "DIRECT", "REJECT"
+
+	// OverrideDestination 仅对嗅探到域名的连接生效: 规则匹配后向后端发送 "域名:端口" 而不是原始 IP。
+	OverrideDestination bool `json:"override_destination,omitempty"`
+
+	// RejectMode 仅对 REJECT 规则生效，为空时按 "reject" 处理
+	RejectMode string `json:"reject_mode,omitempty"`
 }
 
 // RoutingSettings 对应 settings.json 中的 "routing" 模块。
@@ -48,21 +86,163 @@ type RoutingSettings struct {
 	Rules []*Rule `json:"rules"` // 包含所有路由规则的列表
 }
 
+// AuthUser 是一个可以通过网关认证的代理用户。
+type AuthUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// AuthSettings 对应 settings.json 中的 "auth" 模块。
+// 启用后，统一端口上的 SOCKS5 连接必须使用用户名/密码认证 (RFC 1929)，
+// HTTP 代理请求必须携带 Proxy-Authorization (Basic)。
+type AuthSettings struct {
+	Enabled bool        `json:"enabled"`
+	Users   []*AuthUser `json:"users"`
+}
+
+// Inbound 描述统一网关上的一个命名入口。
+type Inbound struct {
+	Name      string   `json:"name"`
+	Listen    string   `json:"listen"`              // 绑定地址: IPv4/IPv6 地址或网卡名，为空表示 0.0.0.0
+	Port      int      `json:"port"`                // 监听端口
+	Protocols []string `json:"protocols,omitempty"` // 允许的协议: "socks5", "socks4", "http", "tls"，为空表示全部允许
+	Target    string   `json:"target,omitempty"`    // 强制目标: 服务器 remarks 或 "DIRECT" / "REJECT"，为空表示走路由规则
+	TLSPort   int      `json:"tls_port,omitempty"`  // 原始 TLS (SNI 代理) 连接的目标端口，为 0 表示 443
+}
+
+// InboundsSettings 对应 settings.json 中的 "inbounds" 模块。
+// 列表为空时，网关回退为 liuproxy.ini 中 unified_port 上的单个 "default" 入口。
+type InboundsSettings struct {
+	Inbounds []*Inbound `json:"inbounds"`
+}
+
+// DNSHost 是一条静态解析记录，优先于缓存和上游。
+type DNSHost struct {
+	Domain string   `json:"domain"`
+	IPs    []string `json:"ips"` // IPv4 用于 A 查询，IPv6 用于 AAAA 查询
+}
+
+// DNSSettings 对应 settings.json 中的 "dns" 模块。
+// 每个查询按域名经路由规则选择直连或隧道后端转发，入口名称为 "dns"。
+type DNSSettings struct {
+	Enabled        bool       `json:"enabled"`
+	Listen         string     `json:"listen"`          // 同时监听 UDP 和 TCP, e.g., "0.0.0.0:53"
+	Upstream       string     `json:"upstream"`        // 经隧道转发时使用的上游，格式同 resolver 的 upstreams, e.g., "8.8.8.8:53"、"tls://8.8.8.8:853"
+	DirectUpstream string     `json:"direct_upstream"` // 直连时使用的上游, e.g., "223.5.5.5:53"、"https://223.5.5.5/dns-query"，为空时使用 upstream
+	CacheSize      int        `json:"cache_size"`      // 最多缓存的应答数，为 0 时使用默认值
+	Hosts          []*DNSHost `json:"hosts"`
+
+	// FakeIP 启用后，A 查询返回 FakeIPRange 内的地址并记录地址与域名的映射，
+	// 网关 (包括透明代理) 收到发往这些地址的连接时按域名路由，AAAA 查询返回空应答。
+	// 命中 FakeIPFilter 或被路由到 DIRECT 的域名仍按真实地址应答。
+	FakeIP       bool     `json:"fake_ip"`
+	FakeIPRange  string   `json:"fake_ip_range"`  // IPv4 地址段，为空时使用 "198.18.0.0/15"
+	FakeIPFilter []string `json:"fake_ip_filter"` // 不分配 Fake-IP 的域名，规则同路由的 domain 规则, e.g., "lan"、"ntp.org"
+}
+
+// ResolverSettings 对应 settings.json 中的 "resolver" 模块。
+// 路由的 dest_ip 规则、直连拨号和 VLESS 服务器地址都使用这里配置的解析器。
+type ResolverSettings struct {
+	// Upstreams 按顺序尝试，支持 "udp://223.5.5.5:53"、"tcp://8.8.8.8:53"、"tls://1.1.1.1:853"
+	// 和 "https://1.1.1.1/dns-query"，省略协议时按 UDP 处理。为空时使用系统解析器。
+	Upstreams []string `json:"upstreams"`
+	Via       string   `json:"via"`        // 经该服务器 ID 的隧道发送查询，为空时直连上游
+	CacheSize int      `json:"cache_size"` // 最多缓存的域名数，为 0 时使用默认值
+}
+
+// LimitsSettings 对应 settings.json 中的 "limits" 模块，上限为 0 表示不限制。
+type LimitsSettings struct {
+	MaxConnections           int `json:"max_connections"`             // 网关的全局连接上限，为 0 时使用 liuproxy.ini 的 maxConnections
+	MaxConnectionsPerClient  int `json:"max_connections_per_client"`  // 每个客户端 IP 的连接上限
+	MaxConnectionsPerBackend int `json:"max_connections_per_backend"` // 每个后端服务器的隧道连接上限，已满的后端不再被选中
+	QueueTimeout             int `json:"queue_timeout"`               // 超出全局或客户端上限时排队等待的毫秒数，为 0 时立即拒绝
+}
+
+// SecuritySettings 对应 settings.json 中的 "security" 模块，用于保护公网暴露的网关入口。
+// 握手指从接受连接到解析出代理目标 (包括认证) 为止的阶段。
+type SecuritySettings struct {
+	HandshakeTimeout    int   `json:"handshake_timeout"`      // 完成握手的最长秒数，为 0 时使用默认值 10
+	MaxHeaderBytes      int   `json:"max_header_bytes"`       // HTTP 请求头的最大字节数，为 0 时使用默认值 16384
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"` // 普通 HTTP 代理请求的请求体上限，为 0 表示不限制
+	MaxHandshakesPerIP  int   `json:"max_handshakes_per_ip"`  // 每个客户端 IP 同时进行中的握手数上限，为 0 表示不限制
+
+	// BanThreshold 为一分钟内触发封禁的违规次数，为 0 表示不自动封禁。
+	// 违规包括握手超时、请求头过大、握手数超限以及无法解析或认证失败的握手。
+	BanThreshold int      `json:"ban_threshold"`
+	BanDuration  int      `json:"ban_duration"`  // 封禁的秒数，为 0 时使用默认值 600
+	TrustedCIDRs []string `json:"trusted_cidrs"` // 不受握手数上限和封禁约束的网段, e.g., ["127.0.0.0/8"]
+}
+
+// BandwidthRule 为匹配的客户端 IP、认证用户或后端服务器设置上传和下载速率，单位为 KB/s，0 表示不限制。
+// 每个匹配的对象各自拥有令牌桶，由它的所有连接共享；同一类型的规则按顺序取第一条匹配的。
+type BandwidthRule struct {
+	Type     string   `json:"type"`  // "source_ip"、"user" 或 "server"
+	Value    []string `json:"value"` // IP/CIDR、用户名，或服务器 ID / remarks ("DIRECT" 表示直连)
+	Upload   int      `json:"upload"`
+	Download int      `json:"download"`
+}
+
+// BandwidthSettings 对应 settings.json 中的 "bandwidth" 模块，限制经网关转发的 TCP 连接的速率。
+type BandwidthSettings struct {
+	Rules []*BandwidthRule `json:"rules"`
+}
+
+// TimeoutsSettings 对应 settings.json 中的 "timeouts" 模块，限制经网关转发的连接的空闲时间和存活时间，单位均为秒。
+// 空闲超时按连接所处的阶段分别设置，为 0 表示不限制。
+type TimeoutsSettings struct {
+	Handshake   int `json:"handshake"`    // 握手阶段 (代理握手和建立上游连接) 的空闲超时
+	Established int `json:"established"`  // 双向转发数据时的空闲超时
+	HalfClosed  int `json:"half_closed"`  // 一个方向已结束、等待另一方向结束时的空闲超时
+	MaxLifetime int `json:"max_lifetime"` // 连接的最长存活时间，为 0 表示不限制
+	Drain       int `json:"drain"`        // 关闭网关或替换服务器实例时等待现有连接结束的秒数，之后强制关闭，为 0 表示一直等待
+}
+
+// AccessLogSettings 对应 settings.json 中的 "access_log" 模块。
+// 启用后，网关和透明代理的每个结束的 TCP 连接在 File 中写一条 JSON 记录，与调试日志分开。
+type AccessLogSettings struct {
+	Enabled    bool    `json:"enabled"`
+	File       string  `json:"file"`        // 访问日志文件路径, e.g., "logs/access.log"
+	MaxSize    int     `json:"max_size"`    // 文件达到该大小 (MB) 时轮转，为 0 表示不轮转
+	MaxAge     int     `json:"max_age"`     // 轮转后的文件保留的天数，为 0 表示不按时间删除
+	MaxBackups int     `json:"max_backups"` // 轮转后的文件保留的个数，为 0 表示不按个数删除
+	SampleRate float64 `json:"sample_rate"` // 记录的连接比例，取值 (0, 1]，为 0 时记录全部连接
+	OmitTarget bool    `json:"omit_target"` // 不记录目标地址和嗅探到的域名
+}
+
 // LoadBalancerSettings 对应 settings.json 中的 "load_balancer" 模块 (占位符)。
 type LoadBalancerSettings struct {
 	// TODO: 在迭代 4.2 中具体实现
 }
 
-// LoggingSettings 对应 settings.json 中的 "logging" 模块 (占位符)。
+// LoggingSettings 对应 settings.json 中的 "logging" 模块，修改后立即生效。
+// 级别为 "trace"、"debug"、"info"、"warn"、"error" 之一。
 type LoggingSettings struct {
-	// TODO: 在未来迭代中具体实现
+	Level string `json:"level"` // 默认级别，为空时使用 liuproxy.ini 的 [log] level
+
+	// Components 按组件覆盖默认级别，组件为 gateway、dispatcher、goremote、vless、worker 和 xray_core
+	Components map[string]string `json:"components"`
+
+	Format     string `json:"format"`      // "console" 或 "json"，为空时为 "console"
+	File       string `json:"file"`        // 日志文件路径，为空时输出到标准错误
+	MaxSize    int    `json:"max_size"`    // 日志文件达到该大小 (MB) 时轮转，为 0 表示不轮转
+	MaxAge     int    `json:"max_age"`     // 轮转后的文件保留的天数，为 0 表示不按时间删除
+	MaxBackups int    `json:"max_backups"` // 轮转后的文件保留的个数，为 0 表示不按个数删除
 }
 
 func createDefaultSettings() *RuntimeSettings {
 	return &RuntimeSettings{
-		Gateway: &GatewaySettings{StickySessionMode: "disabled", StickySessionTTL: 300, StickyRules: []string{}},
-		Routing: &RoutingSettings{Rules: []*Rule{}},
-		Logging: &LoggingSettings{},
+		Gateway:   &GatewaySettings{StickySessionMode: "disabled", StickySessionTTL: 300, StickyRules: []string{}},
+		Routing:   &RoutingSettings{Rules: []*Rule{}},
+		Logging:   &LoggingSettings{Components: map[string]string{}, Format: "console", MaxSize: 100, MaxAge: 7},
+		Auth:      &AuthSettings{Users: []*AuthUser{}},
+		Inbounds:  &InboundsSettings{Inbounds: []*Inbound{}},
+		DNS:       &DNSSettings{Listen: "0.0.0.0:53", Upstream: "8.8.8.8:53", Hosts: []*DNSHost{}, FakeIPRange: "198.18.0.0/15", FakeIPFilter: []string{"lan", "local", "localdomain", "home.arpa", "ntp.org", "time.windows.com", "time.apple.com"}},
+		Resolver:  &ResolverSettings{Upstreams: []string{}},
+		Limits:    &LimitsSettings{},
+		Security:  &SecuritySettings{HandshakeTimeout: 10, MaxHeaderBytes: 16384, MaxHandshakesPerIP: 16, BanThreshold: 10, BanDuration: 600, TrustedCIDRs: []string{"127.0.0.0/8", "::1/128"}},
+		Bandwidth: &BandwidthSettings{Rules: []*BandwidthRule{}},
+		Timeouts:  &TimeoutsSettings{Handshake: 30, HalfClosed: 30, Drain: 10},
+		AccessLog: &AccessLogSettings{File: "logs/access.log", MaxSize: 100, MaxAge: 30},
 	}
 }
 
@@ -76,4 +256,31 @@ func ensureDefaultModules(s *RuntimeSettings) {
 	if s.Logging == nil {
 		s.Logging = &LoggingSettings{}
 	}
+	if s.Auth == nil {
+		s.Auth = &AuthSettings{Users: []*AuthUser{}}
+	}
+	if s.Inbounds == nil {
+		s.Inbounds = &InboundsSettings{Inbounds: []*Inbound{}}
+	}
+	if s.DNS == nil {
+		s.DNS = &DNSSettings{Listen: "0.0.0.0:53", Upstream: "8.8.8.8:53", Hosts: []*DNSHost{}, FakeIPRange: "198.18.0.0/15", FakeIPFilter: []string{"lan", "local", "localdomain", "home.arpa", "ntp.org", "time.windows.com", "time.apple.com"}}
+	}
+	if s.Resolver == nil {
+		s.Resolver = &ResolverSettings{Upstreams: []string{}}
+	}
+	if s.Limits == nil {
+		s.Limits = &LimitsSettings{}
+	}
+	if s.Security == nil {
+		s.Security = &SecuritySettings{HandshakeTimeout: 10, MaxHeaderBytes: 16384, MaxHandshakesPerIP: 16, BanThreshold: 10, BanDuration: 600, TrustedCIDRs: []string{"127.0.0.0/8", "::1/128"}}
+	}
+	if s.Bandwidth == nil {
+		s.Bandwidth = &BandwidthSettings{Rules: []*BandwidthRule{}}
+	}
+	if s.Timeouts == nil {
+		s.Timeouts = &TimeoutsSettings{Handshake: 30, HalfClosed: 30, Drain: 10}
+	}
+	if s.AccessLog == nil {
+		s.AccessLog = &AccessLogSettings{File: "logs/access.log", MaxSize: 100, MaxAge: 30}
+	}
 }
@@ -0,0 +1,52 @@
+package traffic
+
+import (
+	"net"
+	"sync"
+)
+
+// countedConn 把读写的字节数累加到一组计数器: 写入 (发往上游) 计为上传，读取计为下载。
+type countedConn struct {
+	net.Conn
+	counters  []*Counter
+	closeOnce sync.Once
+	release   func()
+}
+
+// NewConn 返回一个把读写字节数累加到 counters 的连接，写入计为上传，读取计为下载。
+// 用于包装经隧道或直连建立的上游连接。
+func NewConn(conn net.Conn, counters ...*Counter) net.Conn {
+	return &countedConn{Conn: conn, counters: counters}
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for _, counter := range c.counters {
+		counter.AddDownload(n)
+	}
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	for _, counter := range c.counters {
+		counter.AddUpload(n)
+	}
+	return n, err
+}
+
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	if c.release != nil {
+		c.closeOnce.Do(c.release)
+	}
+	return err
+}
+
+// CloseWrite 转发给底层连接，relay 依赖它通知对端数据已发送完毕。
+func (c *countedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
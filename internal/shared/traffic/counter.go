@@ -0,0 +1,68 @@
+package traffic
+
+import (
+	"liuproxy_go/internal/shared/types"
+	"sync/atomic"
+	"time"
+)
+
+// rateWindow 是计算吞吐率的窗口 (秒)，速率为窗口内已结束的各秒的平均值。
+const rateWindow = 5
+
+// slot 记录某一秒内的字节数，按秒数循环复用。
+type slot struct {
+	sec      atomic.Int64
+	up, down atomic.Uint64
+}
+
+// Counter 累计上传和下载的字节数，并按最近 rateWindow 秒估算吞吐率。零值即可使用，所有方法都可以并发调用。
+type Counter struct {
+	up, down atomic.Uint64
+	slots    [rateWindow + 1]slot
+}
+
+// AddUpload 记录 n 字节的上传 (客户端发往目标)。
+func (c *Counter) AddUpload(n int) {
+	if n > 0 {
+		c.up.Add(uint64(n))
+		c.current().up.Add(uint64(n))
+	}
+}
+
+// AddDownload 记录 n 字节的下载 (目标发往客户端)。
+func (c *Counter) AddDownload(n int) {
+	if n > 0 {
+		c.down.Add(uint64(n))
+		c.current().down.Add(uint64(n))
+	}
+}
+
+// current 返回当前这一秒的 slot，进入新的一秒时清零。清零与并发的累加之间的竞争只影响速率估算，不影响累计值。
+func (c *Counter) current() *slot {
+	now := time.Now().Unix()
+	s := &c.slots[now%int64(len(c.slots))]
+	if old := s.sec.Load(); old != now && s.sec.CompareAndSwap(old, now) {
+		s.up.Store(0)
+		s.down.Store(0)
+	}
+	return s
+}
+
+// Stats 返回累计的字节数和最近的吞吐率。
+func (c *Counter) Stats() types.TrafficStats {
+	now := time.Now().Unix()
+	var up, down uint64
+	for i := range c.slots {
+		s := &c.slots[i]
+		if sec := s.sec.Load(); sec < now && sec >= now-rateWindow {
+			up += s.up.Load()
+			down += s.down.Load()
+		}
+	}
+	return types.TrafficStats{
+		Upload:       c.up.Load(),
+		Download:     c.down.Load(),
+		UploadRate:   float64(up) / rateWindow,
+		DownloadRate: float64(down) / rateWindow,
+	}
+}
@@ -0,0 +1,148 @@
+package traffic
+
+import (
+	"context"
+	"liuproxy_go/internal/shared/types"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// 没有活动连接的客户端 IP 的统计在 clientRetention 后移除，使统计表不会因扫描等大量一次性来源而无限增长。
+const (
+	clientRetention = time.Hour
+	sweepInterval   = time.Minute
+)
+
+// clientCounter 是一个客户端 IP 的计数器，refs 是它当前打开的上游连接数。
+type clientCounter struct {
+	Counter
+	refs      int
+	idleSince time.Time
+}
+
+// Meter 统计网关转发的流量，按客户端 IP、后端服务器和路由规则分别累计。
+// Gateway 和 TransparentGateway 共享同一个 Meter。
+type Meter struct {
+	total Counter
+
+	mu        sync.Mutex
+	clients   map[string]*clientCounter
+	backends  map[string]*Counter
+	rules     map[string]*Counter
+	lastSweep time.Time
+}
+
+// New 创建流量统计器。
+func New() *Meter {
+	return &Meter{
+		clients:   make(map[string]*clientCounter),
+		backends:  make(map[string]*Counter),
+		rules:     make(map[string]*Counter),
+		lastSweep: time.Now(),
+	}
+}
+
+// WrapConn 返回一个统计流量的上游连接，写入计为上传，读取计为下载。流量计入客户端 source、服务器 serverID、
+// ctx 中 RoutingContext 记录的路由规则，以及 ctx 携带的 Session。m 为 nil 时原样返回 conn。
+func (m *Meter) WrapConn(ctx context.Context, conn net.Conn, source net.Addr, serverID string) net.Conn {
+	if m == nil {
+		return conn
+	}
+	ip := clientIP(source)
+	var rule string
+	if rc := types.RoutingContextFrom(ctx); rc != nil {
+		rule = rc.Rule
+	}
+
+	m.mu.Lock()
+	m.sweepLocked(time.Now())
+	client, ok := m.clients[ip]
+	if !ok {
+		client = &clientCounter{}
+		m.clients[ip] = client
+	}
+	client.refs++
+	counters := []*Counter{&m.total, &client.Counter, counterLocked(m.backends, serverID)}
+	if rule != "" {
+		counters = append(counters, counterLocked(m.rules, rule))
+	}
+	m.mu.Unlock()
+
+	if session := SessionFromContext(ctx); session != nil {
+		session.setRoute(serverID, rule)
+		counters = append(counters, &session.Counter)
+	}
+	return &countedConn{Conn: conn, counters: counters, release: func() { m.release(ip) }}
+}
+
+// counterLocked 取得或创建 key 的计数器。调用方需持有 mu。
+func counterLocked(counters map[string]*Counter, key string) *Counter {
+	c, ok := counters[key]
+	if !ok {
+		c = &Counter{}
+		counters[key] = c
+	}
+	return c
+}
+
+func (m *Meter) release(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if client, ok := m.clients[ip]; ok {
+		if client.refs--; client.refs <= 0 {
+			client.idleSince = time.Now()
+		}
+	}
+}
+
+// sweepLocked 移除空闲超过 clientRetention 的客户端，最多每 sweepInterval 执行一次。调用方需持有 mu。
+func (m *Meter) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for ip, client := range m.clients {
+		if client.refs <= 0 && now.Sub(client.idleSince) > clientRetention {
+			delete(m.clients, ip)
+		}
+	}
+}
+
+// Stats 返回网关启动以来转发的流量。
+func (m *Meter) Stats() *types.TrafficReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := &types.TrafficReport{
+		Total:    m.total.Stats(),
+		Clients:  make(map[string]types.TrafficStats, len(m.clients)),
+		Backends: make(map[string]types.TrafficStats, len(m.backends)),
+		Rules:    make(map[string]types.TrafficStats, len(m.rules)),
+	}
+	for ip, c := range m.clients {
+		report.Clients[ip] = c.Stats()
+	}
+	for id, c := range m.backends {
+		report.Backends[id] = c.Stats()
+	}
+	for rule, c := range m.rules {
+		report.Rules[rule] = c.Stats()
+	}
+	return report
+}
+
+// clientIP 返回源地址中的 IP，IPv4 映射的 IPv6 地址还原为 IPv4。
+func clientIP(source net.Addr) string {
+	if source == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(source.String())
+	if err != nil {
+		host = source.String()
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
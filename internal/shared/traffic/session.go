@@ -0,0 +1,51 @@
+package traffic
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type sessionKey struct{}
+
+// Session 累计一个入站连接经它的所有上游连接转发的流量，并记录最近一次使用的后端和路由规则。
+// HTTP keep-alive 连接上的请求可能经不同的后端转发，它们的流量都计入同一个 Session。
+type Session struct {
+	Counter
+	start time.Time
+
+	mu       sync.Mutex
+	serverID string
+	rule     string
+}
+
+// NewSession 开始统计一个入站连接的流量。返回的 context 携带该 Session，
+// 经 Meter.WrapConn 包装的上游连接的流量会计入其中。
+func NewSession(ctx context.Context) (*Session, context.Context) {
+	s := &Session{start: time.Now()}
+	return s, context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext 返回 ctx 所属连接的 Session，不存在时返回 nil。
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Duration 返回连接开始至今的时长。
+func (s *Session) Duration() time.Duration {
+	return time.Since(s.start)
+}
+
+// Route 返回最近一次建立上游连接时使用的服务器 ID 和路由规则，尚未建立上游连接时都为空。
+func (s *Session) Route() (serverID, rule string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serverID, s.rule
+}
+
+func (s *Session) setRoute(serverID, rule string) {
+	s.mu.Lock()
+	s.serverID, s.rule = serverID, rule
+	s.mu.Unlock()
+}
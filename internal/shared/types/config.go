@@ -67,10 +67,25 @@ type GatewayConf struct {
 	StickySessionTTL  int    `ini:"sticky_session_ttl"`  // 粘性会话的TTL (秒)
 }
 
+// TransparentConf 包含透明代理入口的配置
+type TransparentConf struct {
+	TransparentPort int    `ini:"port"`             // 接收 iptables 重定向流量的端口，0 表示禁用
+	TransparentMode string `ini:"mode"`             // "redirect" (默认，仅 TCP) 或 "tproxy" (TCP + UDP)
+	UDPIdleTimeout  int    `ini:"udp_idle_timeout"` // tproxy 模式下 UDP 会话的空闲超时 (秒)，0 表示使用默认值
+}
+
+// MetricsConf 包含 Prometheus 指标端点的配置，与 Web UI 分开监听和认证
+type MetricsConf struct {
+	Listen      string `ini:"listen"`       // 监听地址，如 "127.0.0.1:9091"，为空表示禁用
+	BearerToken string `ini:"bearer_token"` // 抓取时需携带的 "Authorization: Bearer" 令牌，为空表示不认证
+}
+
 // Config 是local项目的统一配置结构体 (现在只包含行为配置)
 type Config struct {
-	CommonConf  `ini:"common"`
-	LocalConf   `ini:"local"`
-	LogConf     `ini:"log"`
-	GatewayConf `ini:"Gateway"`
+	CommonConf      `ini:"common"`
+	LocalConf       `ini:"local"`
+	LogConf         `ini:"log"`
+	GatewayConf     `ini:"Gateway"`
+	TransparentConf `ini:"transparent"`
+	MetricsConf     `ini:"metrics"`
 }
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // ListenerInfo holds the runtime listening info of a strategy instance.
 type ListenerInfo struct {
 	Address string
@@ -10,4 +12,70 @@ type ListenerInfo struct {
 type Metrics struct {
 	ActiveConnections int64 `json:"activeConnections"`
 	Latency           int64 `json:"latency"` // Latency in milliseconds (-1 for unknown/failed)
+	TrafficStats            // bytes relayed through the instance by the gateway since it was created
+}
+
+// TrafficStats is the number of bytes relayed for one object and its recent throughput.
+type TrafficStats struct {
+	Upload       uint64  `json:"upload"`       // bytes sent from clients towards their targets
+	Download     uint64  `json:"download"`     // bytes received from targets
+	UploadRate   float64 `json:"uploadRate"`   // bytes per second over the last few seconds
+	DownloadRate float64 `json:"downloadRate"` // bytes per second over the last few seconds
+}
+
+// TrafficReport is the traffic relayed by the gateway since startup, broken down by client IP, backend and routing rule.
+type TrafficReport struct {
+	Total    TrafficStats            `json:"total"`
+	Clients  map[string]TrafficStats `json:"clients,omitempty"` // keyed by client IP, only exposed by the authenticated stats API
+	Backends map[string]TrafficStats `json:"backends"`          // keyed by server ID, "DIRECT" for direct connections
+	Rules    map[string]TrafficStats `json:"rules"`             // keyed by RoutingContext.Rule
+}
+
+// ConnectionStats is a snapshot of the gateway's connection counts and limits (0 means unlimited).
+type ConnectionStats struct {
+	Total         int64            `json:"total"`
+	MaxTotal      int64            `json:"maxTotal"`
+	MaxPerClient  int64            `json:"maxPerClient"`
+	MaxPerBackend int64            `json:"maxPerBackend"`
+	Clients       map[string]int64 `json:"clients,omitempty"` // keyed by client IP, only exposed by the authenticated stats API
+	Backends      map[string]int64 `json:"backends"`          // keyed by server ID
+	Rejected      uint64           `json:"rejected"`          // connections rejected by any limit since startup
+}
+
+// BanInfo describes a client IP that is temporarily refused by the gateway after repeated handshake violations.
+type BanInfo struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"` // the violation that triggered the ban
+	Strikes   int       `json:"strikes"`
+	BannedAt  time.Time `json:"bannedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ConnectionInfo describes one active connection in the gateway's connection table.
+type ConnectionInfo struct {
+	ID           string    `json:"id"`                    // trace_id of the connection, also found in its log lines
+	Client       string    `json:"client"`                // client address (ip:port)
+	Inbound      string    `json:"inbound"`               // name of the inbound that accepted it
+	Protocol     string    `json:"protocol"`              // sniffed inbound protocol, e.g. "SOCKS5", "HTTP", "TLS"
+	User         string    `json:"user,omitempty"`        // authenticated user
+	Target       string    `json:"target"`                // destination the connection is relayed to
+	SniffedHost  string    `json:"sniffedHost,omitempty"` // domain sniffed from the payload of an IP target
+	Rule         string    `json:"rule"`                  // routing rule of the latest decision, see RoutingContext.Rule
+	ServerID     string    `json:"serverId"`              // chosen server ID, "DIRECT" or "REJECT"
+	Upstream     string    `json:"upstream,omitempty"`    // remote address of the latest upstream connection
+	State        string    `json:"state"`                 // "handshake", "established" or "half_closed"
+	Start        time.Time `json:"start"`
+	Age          float64   `json:"age"`                   // seconds since the connection was accepted
+	CloseReason  string    `json:"closeReason,omitempty"` // why the connection was closed, set once it is closed
+	TrafficStats           // bytes relayed through the connection's upstreams
+}
+
+// MonitorEvent is a real-time event pushed to the Web UI's Monitor page.
+type MonitorEvent struct {
+	Type       string          `json:"type"` // "open", "route", "close", "health" or "throughput"
+	Time       time.Time       `json:"time"`
+	Connection *ConnectionInfo `json:"connection,omitempty"` // open, route and close events
+	ServerID   string          `json:"serverId,omitempty"`   // health events
+	Health     HealthStatus    `json:"health,omitempty"`     // health events
+	Traffic    *TrafficReport  `json:"traffic,omitempty"`    // throughput samples
 }
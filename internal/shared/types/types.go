@@ -3,6 +3,7 @@ package types
 import (
 	"context"
 	"net"
+	"net/netip"
 )
 
 // TunnelStrategy 定义了所有策略的通用接口。
@@ -14,6 +15,12 @@ type TunnelStrategy interface {
 	GetMetrics() *Metrics
 	UpdateServer(profile *ServerProfile) error
 	CheckHealth() error
+	// DialContext 在隧道内建立一条到 target (host:port) 的连接，network 为 "tcp" 或 "udp"。
+	// 返回的连接已完成与远端的握手，Gateway 可以直接在其上转发应用层数据，
+	// 不再需要经过策略的本地监听端口和二次 SOCKS5 握手。
+	DialContext(ctx context.Context, network, target string) (net.Conn, error)
+	// SupportsUDP 报告该策略当前能否通过 DialContext("udp", ...) 转发 UDP 数据报。
+	SupportsUDP() bool
 }
 
 // ServerState 封装了与单个服务器相关的所有信息：配置、实例和运行时状态。
@@ -40,10 +47,55 @@ type FailureReporter interface {
 	ReportSuccess(serverID string)
 }
 
+// Resolver 定义了共享的域名解析器，解析结果按 TTL 缓存。
+type Resolver interface {
+	// LookupIP 返回域名的地址，IPv4 地址在前。host 本身是 IP 时直接返回。
+	LookupIP(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// BackendLoad 提供后端的实时连接数，负载均衡据此选择后端并跳过已达到连接上限的后端。
+type BackendLoad interface {
+	// BackendConnections 返回经网关建立到该后端的当前连接数。
+	BackendConnections(serverID string) int64
+	// BackendSaturated 报告该后端是否已达到连接上限。
+	BackendSaturated(serverID string) bool
+}
+
 // Dispatcher 接口定义了路由决策器的核心功能。
 type Dispatcher interface {
-	// Dispatch 接收源地址和目标地址，返回一个选择的后端实例的监听地址。
-	Dispatch(ctx context.Context, source net.Addr, target string) (string, string, error)
+	// Dispatch 接收源地址和目标地址，返回选中的策略实例及其 Server ID。
+	// 对于虚拟策略，返回的策略实例为 nil，Server ID 为 "DIRECT" 或 "REJECT"。
+	Dispatch(ctx context.Context, source net.Addr, target string) (TunnelStrategy, string, error)
+}
+
+// RoutingContext 携带入口处得到的、除源地址和目标地址之外的路由依据，随 context 传给 Dispatcher。
+type RoutingContext struct {
+	User    string // 通过网关认证的用户名，未认证时为空
+	Inbound string // 接收连接的入口名称
+	Target  string // 入口配置的强制目标 (服务器 remarks 或 "DIRECT" / "REJECT")，为空表示按规则路由
+
+	// SniffedHost 是目标为 IP 时从首个数据包中嗅探到的域名，Dispatcher 用它匹配 domain 规则和粘性会话
+	SniffedHost string
+	// OverrideDestination 由 Dispatcher 填写: 匹配的规则要求以 SniffedHost 替换发往后端的目标地址
+	OverrideDestination bool
+	// RejectMode 由 Dispatcher 填写: 匹配的 REJECT 规则选择的拒绝方式，为空表示 "reject"
+	RejectMode string
+	// Rule 由 Dispatcher 填写: 做出路由决策的规则，格式为 "优先级:类型:目标"；
+	// 没有规则匹配时为 "inbound" (入口的强制目标)、"sticky" (粘性会话) 或 "balancer" (负载均衡)
+	Rule string
+}
+
+type routingContextKey struct{}
+
+// WithRoutingContext 返回一个携带 rc 的新 context。
+func WithRoutingContext(ctx context.Context, rc *RoutingContext) context.Context {
+	return context.WithValue(ctx, routingContextKey{}, rc)
+}
+
+// RoutingContextFrom 从 context 中取出 RoutingContext，不存在时返回 nil。
+func RoutingContextFrom(ctx context.Context) *RoutingContext {
+	rc, _ := ctx.Value(routingContextKey{}).(*RoutingContext)
+	return rc
 }
 
 type HealthStatus int
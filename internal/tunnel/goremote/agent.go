@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"liuproxy_go/internal/shared/globalstate"
-	"liuproxy_go/internal/shared/logger"
 	protocol2 "liuproxy_go/internal/shared/protocol"
 	"liuproxy_go/internal/shared/securecrypt"
 	"net"
@@ -67,7 +66,7 @@ func (a *Agent) Start() error {
 		return fmt.Errorf("goremote agent failed to listen on %s: %w", addr, err)
 	}
 	a.listener = listener
-	logger.Info().Str("strategy", "goremote").Str("listen_addr", a.listener.Addr().String()).Msg("Strategy listener started")
+	log.Info().Str("strategy", "goremote").Str("listen_addr", a.listener.Addr().String()).Msg("Strategy listener started")
 
 	a.waitGroup.Add(1)
 	go a.acceptLoop()
@@ -82,7 +81,7 @@ func (a *Agent) acceptLoop() {
 	for {
 		conn, err := a.listener.Accept()
 		if err != nil {
-			logger.Debug().Err(err).Msgf("[GoRemoteAgent] Listener on %s stopped accepting", a.listener.Addr())
+			log.Debug().Err(err).Msgf("[GoRemoteAgent] Listener on %s stopped accepting", a.listener.Addr())
 			return
 		}
 
@@ -92,7 +91,7 @@ func (a *Agent) acceptLoop() {
 			defer a.waitGroup.Done()
 			defer func() {
 				if r := recover(); r != nil {
-					logger.Error().Msgf("[GoRemoteAgent] Panic recovered in connection handler for %s: %v", c.RemoteAddr(), r)
+					log.Error().Msgf("[GoRemoteAgent] Panic recovered in connection handler for %s: %v", c.RemoteAddr(), r)
 				}
 				c.Close()
 				a.activeConnections.Add(-1)
@@ -218,7 +217,7 @@ func (a *Agent) closeConnection(conn net.Conn) {
 func (a *Agent) UpdateServerProfile(profile *types.ServerProfile) {
 	a.connMutex.Lock()
 	defer a.connMutex.Unlock()
-	logger.Info().Str("remarks", profile.Remarks).Msg("[GoRemoteAgent] Updating server profile.")
+	log.Info().Str("remarks", profile.Remarks).Msg("[GoRemoteAgent] Updating server profile.")
 	a.profile = profile
 	if a.currentTunnel != nil {
 		_ = a.currentTunnel.conn.Close()
@@ -230,7 +229,7 @@ func (a *Agent) UpdateServerProfile(profile *types.ServerProfile) {
 func (a *Agent) Close() {
 	a.closeOnce.Do(func() {
 		if a.listener != nil {
-			logger.Info().Str("listen_addr", a.listener.Addr().String()).Msg("[GoRemoteAgent] Closing listener")
+			log.Info().Str("listen_addr", a.listener.Addr().String()).Msg("[GoRemoteAgent] Closing listener")
 			a.listener.Close()
 		}
 		a.connMutex.Lock()
@@ -245,7 +244,7 @@ func (a *Agent) Close() {
 		a.sessionManager.CloseAllSessions()
 
 		a.waitGroup.Wait()
-		logger.Info().Msg("[GoRemoteAgent] Agent fully stopped.")
+		log.Info().Msg("[GoRemoteAgent] Agent fully stopped.")
 	})
 }
 
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"github.com/gorilla/websocket"
 	"liuproxy_go/internal/shared"
-	"liuproxy_go/internal/shared/logger"
 	"net"
 	"net/http"
 	"net/url"
@@ -64,7 +63,7 @@ func Dial(urlStr string) (net.Conn, error) {
 		return nil, err
 	}
 
-	logger.Debug().Msg("[GoRemote Dialer-Debug] SUCCESS: WebSocket connection established.")
+	log.Debug().Msg("[GoRemote Dialer-Debug] SUCCESS: WebSocket connection established.")
 	return shared.NewWebSocketConnAdapter(ws), nil
 }
 
@@ -2,7 +2,6 @@ package goremote
 
 import (
 	"io"
-	"liuproxy_go/internal/shared/logger"
 	"liuproxy_go/internal/shared/protocol"
 	"net"
 	"sync"
@@ -49,14 +48,20 @@ func NewSession(streamID uint16, plainConn net.Conn, agent *Agent, initialData [
 	}
 }
 
-func (sm *SessionManager) NewTCPSession(plainConn net.Conn, targetAddr string, initialData []byte, isSSL bool) *Session {
+// allocateStreamID 分配下一个流 ID。0xFFFF 保留给 UDP 数据。
+func (sm *SessionManager) allocateStreamID() uint16 {
 	streamID := atomic.AddUint32(&sm.nextStreamID, 1)
 	if streamID > 65530 {
 		atomic.StoreUint32(&sm.nextStreamID, 1)
 		streamID = 1
 	}
-	session := NewSession(uint16(streamID), plainConn, sm.agent, initialData, isSSL)
-	sm.sessions.Store(uint16(streamID), session)
+	return uint16(streamID)
+}
+
+func (sm *SessionManager) NewTCPSession(plainConn net.Conn, targetAddr string, initialData []byte, isSSL bool) *Session {
+	streamID := sm.allocateStreamID()
+	session := NewSession(streamID, plainConn, sm.agent, initialData, isSSL)
+	sm.sessions.Store(streamID, session)
 	go session.Start(targetAddr)
 	return session
 }
@@ -119,7 +124,10 @@ func (s *Session) SignalConnectionSuccess() {
 }
 func (s *Session) Close() {
 	s.closeOnce.Do(func() {
-		s.plainConn.Close()
+		// 通过 DialTCP 建立的会话没有本地客户端连接
+		if s.plainConn != nil {
+			s.plainConn.Close()
+		}
 		s.agent.sessionManager.RemoveStreamPipe(s.streamID)
 		_ = s.agent.WritePacket(&protocol.Packet{StreamID: s.streamID, Flag: protocol.FlagControlCloseStream})
 		close(s.doneChan)
@@ -182,7 +190,7 @@ func (ts *TunnelStream) PushDownstreamData(data []byte) {
 	select {
 	case ts.dataChan <- data:
 	default:
-		logger.Debug().Uint16("streamID", ts.streamID).Msg("[TunnelStream] Downstream channel full")
+		log.Debug().Uint16("streamID", ts.streamID).Msg("[TunnelStream] Downstream channel full")
 	}
 }
 func (ts *TunnelStream) Close() error { ts.closeOnce.Do(func() { close(ts.dataChan) }); return nil }
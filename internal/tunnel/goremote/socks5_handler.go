@@ -6,16 +6,19 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"strconv"
+	"time"
 )
 
+// handshakeTimeout 是本地 SOCKS5 握手的最长时间，防止慢速客户端长期占用连接。
+const handshakeTimeout = 10 * time.Second
+
 func (a *Agent) handleSocks5(inboundConn net.Conn, reader *bufio.Reader) {
 	cmd, targetAddr, err := a.HandshakeWithClient(inboundConn, reader)
 	if err != nil {
 		if err != io.EOF {
-			log.Printf("[GoRemote SOCKS5] Handshake failed: %v", err)
+			log.Warn().Err(err).Msg("[GoRemote SOCKS5] Handshake failed")
 		}
 		return
 	}
@@ -63,6 +66,8 @@ func (a *Agent) handleUdpAssociate(clientTcpConn net.Conn) {
 }
 
 func (a *Agent) HandshakeWithClient(conn net.Conn, reader *bufio.Reader) (byte, string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
 	authBuf := make([]byte, 2)
 	if _, err := io.ReadFull(reader, authBuf); err != nil {
 		return 0, "", err
@@ -1,17 +1,23 @@
 package goremote
 
 import (
+	"context"
 	"fmt"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/traffic"
 	"net"
 
 	"liuproxy_go/internal/shared/types"
 )
 
+// log 是 goremote 隧道的 logger，它的级别可以通过 "logging" 模块单独设置。
+var log = logger.Component(logger.ComponentGoRemote)
+
 type GoRemoteStrategy struct {
-	agent  *Agent
-	logger zerolog.Logger
+	agent   *Agent
+	logger  zerolog.Logger
+	traffic traffic.Counter // 经 DialContext 转发的流量
 }
 
 var _ types.TunnelStrategy = (*GoRemoteStrategy)(nil)
@@ -31,6 +37,7 @@ func NewGoRemoteStrategy(cfg *types.Config, profile *types.ServerProfile) (types
 func (s *GoRemoteStrategy) GetMetrics() *types.Metrics {
 	return &types.Metrics{
 		ActiveConnections: s.agent.GetActiveConnections(),
+		TrafficStats:      s.traffic.Stats(),
 	}
 }
 
@@ -80,3 +87,32 @@ func (s *GoRemoteStrategy) CheckHealth() error {
 	_, err := s.agent.GetConnection()
 	return err
 }
+
+// DialContext 在共享隧道内打开一条到目标的流 (TCP) 或进程内 UDP 连接。
+func (s *GoRemoteStrategy) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		conn, err := s.agent.sessionManager.DialTCP(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		s.agent.activeConnections.Add(1)
+		return traffic.NewConn(conn, &s.traffic), nil
+	case "udp", "udp4", "udp6":
+		if !s.SupportsUDP() {
+			return nil, fmt.Errorf("goremote: udp is not supported by worker backend")
+		}
+		conn, err := s.agent.udpManager.DialUDP(target)
+		if err != nil {
+			return nil, err
+		}
+		return traffic.NewConn(conn, &s.traffic), nil
+	default:
+		return nil, fmt.Errorf("goremote: unsupported network '%s'", network)
+	}
+}
+
+// SupportsUDP 通过 FlagUDPData 转发 UDP，远端为 worker 时不可用。
+func (s *GoRemoteStrategy) SupportsUDP() bool {
+	return s.agent.GetCurrentBackendType() != "worker"
+}
@@ -0,0 +1,174 @@
+package goremote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"liuproxy_go/internal/shared/protocol"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxStreamChunk 限制单个数据包的负载大小，协议中包长度字段为 uint16。
+const maxStreamChunk = 16 * 1024
+
+// streamAddr 是隧道内流的虚拟地址，仅用于满足 net.Conn 接口和日志输出。
+type streamAddr string
+
+func (a streamAddr) Network() string { return "goremote" }
+func (a streamAddr) String() string  { return string(a) }
+
+// DialTCP 在共享隧道上打开一条新的 TCP 流，并在远端确认连接成功后返回。
+// 与 NewTCPSession 不同，它不依赖本地客户端连接，调用方直接在返回的 net.Conn 上读写。
+func (sm *SessionManager) DialTCP(ctx context.Context, targetAddr string) (net.Conn, error) {
+	streamID := sm.allocateStreamID()
+	session := NewSession(streamID, nil, sm.agent, nil, false)
+	sm.sessions.Store(streamID, session)
+
+	// 在发送建流请求之前注册管道，避免丢失紧随确认包到达的数据
+	stream := NewTunnelStream(streamID, sm.agent)
+	sm.SetStreamPipe(streamID, stream)
+
+	fail := func(err error) (net.Conn, error) {
+		sm.sessions.Delete(streamID)
+		session.Close()
+		return nil, err
+	}
+
+	metadata := sm.agent.BuildMetadata(1, targetAddr)
+	packet := protocol.Packet{StreamID: streamID, Flag: protocol.FlagControlNewStreamTCP, Payload: metadata}
+	if err := sm.agent.WritePacket(&packet); err != nil {
+		return fail(fmt.Errorf("goremote: failed to open stream to %s: %w", targetAddr, err))
+	}
+
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+	select {
+	case <-session.readyChan:
+	case <-session.doneChan:
+		return nil, fmt.Errorf("goremote: remote closed stream to %s before it was established", targetAddr)
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	case <-timer.C:
+		return fail(fmt.Errorf("goremote: timed out waiting for remote to open stream to %s", targetAddr))
+	}
+
+	return &streamConn{
+		sm:         sm,
+		session:    session,
+		stream:     stream,
+		localAddr:  streamAddr(fmt.Sprintf("stream-%d", streamID)),
+		remoteAddr: streamAddr(targetAddr),
+		closed:     make(chan struct{}),
+	}, nil
+}
+
+// streamConn 将隧道内的一条流包装为 net.Conn。
+type streamConn struct {
+	sm      *SessionManager
+	session *Session
+	stream  *TunnelStream
+
+	readMu  sync.Mutex
+	pending []byte // 上一次 Read 未能放下的剩余数据
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	var timeout <-chan time.Time
+	c.deadlineMu.Lock()
+	deadline := c.readDeadline
+	c.deadlineMu.Unlock()
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data, ok := <-c.stream.dataChan:
+		if !ok {
+			return 0, io.EOF
+		}
+		n := copy(p, data)
+		c.pending = data[n:]
+		return n, nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxStreamChunk {
+			chunk = chunk[:maxStreamChunk]
+		}
+		if _, err := c.stream.Write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close 关闭流并通知远端。
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.sm.sessions.Delete(c.session.streamID)
+		c.session.Close()
+		c.sm.agent.activeConnections.Add(-1)
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *streamConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline 不受支持：写入由共享隧道串行完成。
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
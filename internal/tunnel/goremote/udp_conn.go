@@ -0,0 +1,179 @@
+package goremote
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"liuproxy_go/internal/shared/protocol"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DialUDP 返回一个面向单一目标的进程内 UDP 连接。
+// 每次 Write 发送一个数据报，每次 Read 返回一个来自该目标的数据报，不再需要本地 SOCKS5 UDP 监听。
+// 远端回包只以来源 IP:port 标识，因此目标必须是 IPv4 地址，域名需要由调用方先行解析。
+func (m *UDPManager) DialUDP(targetAddr string) (net.Conn, error) {
+	addrPort, err := netip.ParseAddrPort(targetAddr)
+	if err != nil || !addrPort.Addr().Unmap().Is4() {
+		return nil, fmt.Errorf("goremote: udp target '%s' must be an IPv4 address", targetAddr)
+	}
+	// 与 HandleDownstreamPacket 从回包头还原的来源地址格式一致
+	target := netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()).String()
+	header, err := buildSocks5UDPHeader(target)
+	if err != nil {
+		return nil, err
+	}
+	c := &udpConn{
+		manager:    m,
+		target:     target,
+		header:     header,
+		dataChan:   make(chan []byte, dataChannelSize),
+		closed:     make(chan struct{}),
+		remoteAddr: streamAddr(targetAddr),
+	}
+	m.addDialedConn(c)
+	return c, nil
+}
+
+// udpConn 是 DialUDP 返回的进程内 UDP 连接。
+type udpConn struct {
+	manager    *UDPManager
+	target     string
+	header     []byte
+	dataChan   chan []byte
+	closeOnce  sync.Once
+	closed     chan struct{}
+	remoteAddr net.Addr
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+// push 由下行读循环调用，去掉 SOCKS5 UDP 头后放入接收队列，队列满时丢弃。
+func (c *udpConn) push(packet []byte) {
+	headerLen := socks5UDPHeaderLen(packet)
+	if headerLen <= 0 || headerLen > len(packet) {
+		return
+	}
+	select {
+	case c.dataChan <- packet[headerLen:]:
+	case <-c.closed:
+	default:
+		log.Debug().Str("target", c.target).Msg("[UDPManager] In-process UDP receive queue full, dropping packet")
+	}
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	var timeout <-chan time.Time
+	c.deadlineMu.Lock()
+	deadline := c.readDeadline
+	c.deadlineMu.Unlock()
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-c.dataChan:
+		return copy(p, data), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, io.EOF
+	}
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	payload := make([]byte, 0, len(c.header)+len(p))
+	payload = append(payload, c.header...)
+	payload = append(payload, p...)
+	packet := protocol.Packet{
+		StreamID: 0xFFFF,
+		Flag:     protocol.FlagUDPData,
+		Payload:  payload,
+	}
+	if err := c.manager.agent.WritePacket(&packet); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *udpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.manager.removeDialedConn(c)
+	})
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr  { return streamAddr("udp") }
+func (c *udpConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *udpConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return nil
+}
+
+func (c *udpConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// buildSocks5UDPHeader 构造 SOCKS5 UDP 请求头: RSV(2) FRAG(1) ATYP ADDR PORT。
+func buildSocks5UDPHeader(targetAddr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("goremote: invalid udp target '%s': %w", targetAddr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("goremote: invalid udp target port '%s': %w", portStr, err)
+	}
+	header := []byte{0x00, 0x00, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		header = append(header, 0x01)
+		header = append(header, ip.To4()...)
+	} else if ip == nil && len(host) <= 255 {
+		header = append(header, 0x03, byte(len(host)))
+		header = append(header, host...)
+	} else {
+		// 远端的 UDP 中继只支持 IPv4 与域名
+		return nil, fmt.Errorf("goremote: unsupported udp target address '%s'", targetAddr)
+	}
+	return binary.BigEndian.AppendUint16(header, uint16(port)), nil
+}
+
+// socks5UDPHeaderLen 返回 SOCKS5 UDP 头的长度，无法识别时返回 0。
+func socks5UDPHeaderLen(data []byte) int {
+	if len(data) < 4 {
+		return 0
+	}
+	switch data[3] {
+	case 0x01:
+		return 4 + 4 + 2
+	case 0x03:
+		if len(data) < 5 {
+			return 0
+		}
+		return 5 + int(data[4]) + 2
+	case 0x04:
+		return 4 + 16 + 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,65 @@
+package goremote
+
+import (
+	"testing"
+	"time"
+)
+
+func readWithin(t *testing.T, c *udpConn, d time.Duration) (string, error) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(d))
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	return string(buf[:n]), err
+}
+
+// 同一目标的多个流都能收到回包，后建立的流不会抢走先前流的回包。
+func TestDialUDPSameTargetFlows(t *testing.T) {
+	m := NewUDPManager(nil, 2048)
+	first, err := m.DialUDP("8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.DialUDP("[::ffff:8.8.8.8]:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.DialUDP("1.1.1.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	header, _ := buildSocks5UDPHeader("8.8.8.8:53")
+	m.HandleDownstreamPacket(append(header, "reply"...))
+
+	for i, c := range []*udpConn{first.(*udpConn), second.(*udpConn)} {
+		if got, err := readWithin(t, c, time.Second); err != nil || got != "reply" {
+			t.Errorf("flow %d received %q, %v", i, got, err)
+		}
+	}
+	if got, err := readWithin(t, other.(*udpConn), 50*time.Millisecond); err == nil {
+		t.Errorf("flow to another target received %q", got)
+	}
+
+	// 关闭的流不再接收，其余流不受影响
+	first.Close()
+	m.HandleDownstreamPacket(append(header, "again"...))
+	if got, err := readWithin(t, second.(*udpConn), time.Second); err != nil || got != "again" {
+		t.Errorf("remaining flow received %q, %v", got, err)
+	}
+	second.Close()
+	if n := len(m.dialedConns); n != 1 {
+		t.Errorf("%d targets registered after closing both flows, want 1", n)
+	}
+}
+
+func TestDialUDPRejectsNonIPv4Targets(t *testing.T) {
+	m := NewUDPManager(nil, 2048)
+	for _, target := range []string{"dns.google:53", "[2001:4860:4860::8888]:53", "8.8.8.8"} {
+		if conn, err := m.DialUDP(target); err == nil {
+			conn.Close()
+			t.Errorf("DialUDP(%q) should fail", target)
+		}
+	}
+}
@@ -13,12 +13,40 @@ type UDPManager struct {
 	bufferSize       int
 	sessionMux       sync.Mutex
 	singletonSession *UDPSession
+	// dialedConns 保存通过 DialUDP 建立的进程内 UDP 连接，按目标地址分组。
+	// 远端的回包只带来源地址，无法区分同一目标的多个流，因此回包投递给该目标的每一个连接，
+	// 由上层协议 (如 DNS 的事务 ID) 丢弃不属于自己的应答，而不是让后建立的流抢走先前流的回包。
+	dialedMu    sync.Mutex
+	dialedConns map[string]map[*udpConn]struct{}
 }
 
 func NewUDPManager(agent *Agent, bufferSize int) *UDPManager {
 	return &UDPManager{
-		agent:      agent,
-		bufferSize: bufferSize,
+		agent:       agent,
+		bufferSize:  bufferSize,
+		dialedConns: make(map[string]map[*udpConn]struct{}),
+	}
+}
+
+func (m *UDPManager) addDialedConn(c *udpConn) {
+	m.dialedMu.Lock()
+	defer m.dialedMu.Unlock()
+	conns, ok := m.dialedConns[c.target]
+	if !ok {
+		conns = make(map[*udpConn]struct{})
+		m.dialedConns[c.target] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+func (m *UDPManager) removeDialedConn(c *udpConn) {
+	m.dialedMu.Lock()
+	defer m.dialedMu.Unlock()
+	if conns, ok := m.dialedConns[c.target]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(m.dialedConns, c.target)
+		}
 	}
 }
 
@@ -47,6 +75,20 @@ func (m *UDPManager) HandleDownstreamPacket(payload []byte) {
 	srcHost, srcPort := parseSocks5UDPTarget(payload)
 	srcAddrStr := net.JoinHostPort(srcHost, strconv.Itoa(srcPort))
 
+	// 优先投递给进程内的 UDP 连接
+	m.dialedMu.Lock()
+	conns := make([]*udpConn, 0, len(m.dialedConns[srcAddrStr]))
+	for c := range m.dialedConns[srcAddrStr] {
+		conns = append(conns, c)
+	}
+	m.dialedMu.Unlock()
+	if len(conns) > 0 {
+		for _, c := range conns {
+			c.push(payload)
+		}
+		return
+	}
+
 	session := m.singletonSession
 	if session == nil || !session.IsRunning() {
 		return
@@ -0,0 +1,125 @@
+package vless
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"liuproxy_go/internal/shared/types"
+)
+
+// Dial 建立到远程 VLESS 服务器的新连接，并返回一个到 targetAddr 的 net.Conn。
+// VLESS 请求头随第一次写入一起发送，响应头在第一次读取时解析，与监听器路径的行为一致。
+func Dial(ctx context.Context, profile *types.ServerProfile, targetAddr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("vless: invalid target address '%s': %w", targetAddr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("vless: invalid target port '%s': %w", portStr, err)
+	}
+
+	headerBuf := new(bytes.Buffer)
+	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID); err != nil {
+		return nil, fmt.Errorf("vless: failed to encode request header: %w", err)
+	}
+
+	network := profile.Network
+	if network == "" {
+		network = "ws" // 默认为 ws
+	}
+
+	var remoteConn net.Conn
+	switch network {
+	case "grpc":
+		remoteConn, err = DialVlessGRPC(ctx, profile)
+	case "ws":
+		remoteConn, err = DialVlessWS(ctx, profile)
+	default:
+		return nil, fmt.Errorf("vless: unsupported network type '%s'", network)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vless: failed to dial remote %s: %w", profile.Address, err)
+	}
+
+	return &vlessConn{Conn: remoteConn, header: headerBuf.Bytes()}, nil
+}
+
+// vlessConn 在底层传输连接上延迟处理 VLESS 的请求头和响应头。
+type vlessConn struct {
+	net.Conn
+	header []byte
+
+	writeMu    sync.Mutex
+	headerSent bool
+
+	readMu          sync.Mutex
+	responseDecoded bool
+
+	closeOnce sync.Once
+	onClose   func()
+}
+
+// sendHeader 在尚未发送请求头时发送它，payload 会与请求头合并为一次写入。
+// 调用方必须持有 writeMu。
+func (c *vlessConn) sendHeader(payload []byte) (int, error) {
+	if c.headerSent {
+		return c.Conn.Write(payload)
+	}
+	c.headerSent = true
+	combined := make([]byte, 0, len(c.header)+len(payload))
+	combined = append(combined, c.header...)
+	combined = append(combined, payload...)
+	if _, err := c.Conn.Write(combined); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+func (c *vlessConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.sendHeader(p)
+}
+
+func (c *vlessConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if !c.responseDecoded {
+		// 服务端先发言的协议 (如 SMTP) 中客户端不会先写入，此时单独发送请求头
+		c.writeMu.Lock()
+		_, err := c.sendHeader(nil)
+		c.writeMu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if err := DecodeResponseHeader(c.Conn); err != nil {
+			return 0, err
+		}
+		c.responseDecoded = true
+	}
+	return c.Conn.Read(p)
+}
+
+// CloseWrite 关闭底层连接的写方向 (如果支持)。
+func (c *vlessConn) CloseWrite() error {
+	if wc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return wc.CloseWrite()
+	}
+	return nil
+}
+
+func (c *vlessConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
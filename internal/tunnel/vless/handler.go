@@ -3,7 +3,7 @@ package vless
 import (
 	"bufio"
 	"context"
-	"github.com/rs/zerolog/log"
+	"liuproxy_go/internal/shared/logger"
 	"net"
 
 	"liuproxy_go/internal/shared/types"
@@ -28,7 +28,7 @@ func HandleConnection(
 	case "ws":
 		HandleWSConnection(ctx, clientConn, reader, profile)
 	default:
-		log.Ctx(ctx).Error().Str("network", network).Msg("Unsupported network type for VLESS native strategy")
+		logger.Ctx(ctx, logger.ComponentVless).Error().Str("network", network).Msg("Unsupported network type for VLESS native strategy")
 		clientConn.Close()
 	}
 }
@@ -5,8 +5,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"github.com/rs/zerolog/log"
 	"io"
+	"liuproxy_go/internal/shared/logger"
 	"net"
 	"strconv"
 	"sync"
@@ -29,7 +29,7 @@ func HandleGRPCConnection(
 ) {
 	defer clientConn.Close()
 
-	l := log.Ctx(ctx)
+	l := logger.Ctx(ctx, logger.ComponentVless)
 	l.Debug().Msg("VLESS-NATIVE-GRPC: New connection received, starting SOCKS5 handshake...")
 
 	cmd, targetAddr, err := HandshakeSocks5AndGetResponse(clientConn, reader)
@@ -116,6 +116,8 @@ func DialVlessGRPC(ctx context.Context, profile *types.ServerProfile) (net.Conn,
 	dest := xraynet.TCPDestination(xraynet.ParseAddress(profile.Address), xraynet.Port(profile.Port))
 	streamSettings := &internet.StreamConfig{
 		ProtocolName: "grpc",
+		// 服务器地址由应用注册的解析器解析，而不是系统解析器
+		SocketSettings: &internet.SocketConfig{DomainStrategy: internet.DomainStrategy_USE_IP},
 		TransportSettings: []*internet.TransportConfig{
 			{
 				ProtocolName: "grpc",
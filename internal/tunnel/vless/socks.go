@@ -7,12 +7,19 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"time"
 )
 
+// handshakeTimeout is the deadline for the client to finish the SOCKS5 auth and request phases.
+const handshakeTimeout = 10 * time.Second
+
 // HandshakeSocks5AndGetResponse performs a SOCKS5 handshake for a client connection.
 // It reads the handshake and request, sends back appropriate responses,
 // and returns the command and target address requested by the client.
+// The whole handshake must complete within handshakeTimeout.
 func HandshakeSocks5AndGetResponse(conn net.Conn, reader *bufio.Reader) (byte, string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
 	// 1. Auth Phase
 	authHeader := make([]byte, 2)
 	if _, err := io.ReadFull(reader, authHeader); err != nil {
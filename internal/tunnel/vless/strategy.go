@@ -6,8 +6,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"liuproxy_go/internal/shared/logger"
+	"liuproxy_go/internal/shared/traffic"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -16,6 +16,9 @@ import (
 	"liuproxy_go/internal/shared/types"
 )
 
+// log 是 VLESS 隧道的 logger，它的级别可以通过 "logging" 模块单独设置。
+var log = logger.Component(logger.ComponentVless)
+
 // VlessStrategyNative 现在是一个无状态的监听器和分发器。
 type VlessStrategyNative struct {
 	config            *types.Config
@@ -26,12 +29,13 @@ type VlessStrategyNative struct {
 	waitGroup         sync.WaitGroup
 	activeConnections atomic.Int64
 	logger            zerolog.Logger
-	activeConns       sync.Map // 用于追踪所有活跃的客户端连接
+	activeConns       sync.Map        // 用于追踪所有活跃的客户端连接
+	traffic           traffic.Counter // 经 DialContext 转发的流量
 }
 
 // NewVlessStrategy 是一个工厂函数，根据配置选择使用 xray-core 还是原生实现
 func NewVlessStrategy(cfg *types.Config, profile *types.ServerProfile) (types.TunnelStrategy, error) {
-	logger.Info().Str("implementation", "native").Msg("Creating VLESS strategy")
+	log.Info().Str("implementation", "native").Msg("Creating VLESS strategy")
 
 	// 检查 profile 是否有效
 	if profile == nil {
@@ -96,9 +100,16 @@ func (s *VlessStrategyNative) handleClientConnection(clientConn net.Conn) {
 	defer s.waitGroup.Done()
 	defer s.activeConnections.Add(-1)
 
-	// 将子 logger 注入到 vless 包的处理函数中
-	ctx := s.logger.WithContext(context.Background())
-	HandleConnection(ctx, clientConn, bufio.NewReader(clientConn), s.profile)
+	HandleConnection(s.newContext(), clientConn, bufio.NewReader(clientConn), s.profile)
+}
+
+// newContext 返回携带该服务器字段的 logger 的 context，vless 包的处理函数经 logger.Ctx 读取它。
+func (s *VlessStrategyNative) newContext() context.Context {
+	l := logger.ContextLogger().
+		Str("strategy_type", "vless-native").
+		Str("server_id", s.profile.ID).
+		Str("remarks", s.profile.Remarks).Logger()
+	return l.WithContext(context.Background())
 }
 
 // HandleConnection 现在是一个纯粹的分发器，根据网络类型选择处理器。
@@ -106,8 +117,7 @@ func (s *VlessStrategyNative) HandleConnection(clientConn net.Conn, reader *bufi
 	// This method is kept for compatibility but should be deprecated.
 	// New connections are handled by handleClientConnection.
 	s.logger.Warn().Msg("HandleConnection called directly, this is deprecated.")
-	ctx := s.logger.WithContext(context.Background())
-	HandleConnection(ctx, clientConn, reader, s.profile)
+	HandleConnection(s.newContext(), clientConn, reader, s.profile)
 }
 
 func (s *VlessStrategyNative) GetType() string { return "vless" }
@@ -131,7 +141,7 @@ func (s *VlessStrategyNative) CloseTunnel() {
 func (s *VlessStrategyNative) GetListenerInfo() *types.ListenerInfo { return s.listenerInfo }
 
 func (s *VlessStrategyNative) GetMetrics() *types.Metrics {
-	return &types.Metrics{ActiveConnections: s.activeConnections.Load()}
+	return &types.Metrics{ActiveConnections: s.activeConnections.Load(), TrafficStats: s.traffic.Stats()}
 }
 
 func (s *VlessStrategyNative) UpdateServer(profile *types.ServerProfile) error {
@@ -178,3 +188,22 @@ func (s *VlessStrategyNative) CheckHealth() error {
 	conn.Close()
 	return nil
 }
+
+// DialContext 为每个连接建立一条新的 VLESS 连接。原生实现目前只支持 TCP。
+func (s *VlessStrategyNative) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("vless: unsupported network '%s'", network)
+	}
+	conn, err := Dial(ctx, s.profile, target)
+	if err != nil {
+		return nil, err
+	}
+	s.activeConnections.Add(1)
+	conn.(*vlessConn).onClose = func() { s.activeConnections.Add(-1) }
+	return traffic.NewConn(conn, &s.traffic), nil
+}
+
+// SupportsUDP 返回 false，VLESS 原生 策略目前只转发 TCP。
+func (s *VlessStrategyNative) SupportsUDP() bool { return false }
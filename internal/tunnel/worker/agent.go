@@ -7,10 +7,14 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"time"
 
 	"liuproxy_go/internal/shared/types"
 )
 
+// handshakeTimeout bounds the local SOCKS5 handshake so that a stalled client cannot hold a connection open.
+const handshakeTimeout = 10 * time.Second
+
 // Agent for the worker package is a simplified version.
 // It provides the SOCKS5 handshake method needed by the worker_strategy.
 type Agent struct {
@@ -27,6 +31,8 @@ func NewAgent(cfg *types.Config) *Agent {
 // HandshakeWithClient is the SOCKS5 handshake implementation copied from goremote.
 // It is now a method of the simplified worker.Agent.
 func (a *Agent) HandshakeWithClient(conn net.Conn, reader *bufio.Reader) (byte, string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
 	authBuf := make([]byte, 2)
 	if _, err := io.ReadFull(reader, authBuf); err != nil {
 		return 0, "", err
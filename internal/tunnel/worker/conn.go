@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	protocol2 "liuproxy_go/internal/shared/protocol"
+	"liuproxy_go/internal/shared/securecrypt"
+	"net"
+	"sync"
+)
+
+// workerStreamID 是 Worker 隧道中唯一的流 ID，每条隧道只承载一个连接。
+const workerStreamID uint16 = 1
+
+// maxWorkerChunk 限制单个数据包的负载大小，协议中包长度字段为 uint16。
+const maxWorkerChunk = 16 * 1024
+
+// workerConn 将一条 Worker 隧道包装为 net.Conn:
+// 上行数据加密封包，下行数据为未加密的数据包。
+type workerConn struct {
+	net.Conn
+	cipher *securecrypt.Cipher
+
+	readMu  sync.Mutex
+	pending []byte
+	eof     bool
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	onClose   func()
+}
+
+// dialStream 建立一条新的 Worker 隧道，并请求连接到目标地址。
+func (s *WorkerStrategy) dialStream(ctx context.Context, targetAddr string) (*workerConn, error) {
+	tunnelConn, cipher, err := s.createTunnel()
+	if err != nil {
+		return nil, fmt.Errorf("worker: failed to create tunnel: %w", err)
+	}
+
+	// 在等待远端确认期间响应 ctx 取消
+	stop := context.AfterFunc(ctx, func() { tunnelConn.Close() })
+	defer stop()
+
+	packet := protocol2.Packet{
+		StreamID: workerStreamID,
+		Flag:     protocol2.FlagControlNewStreamTCP,
+		Payload:  buildMetadataForWorker(1, targetAddr),
+	}
+	if err := protocol2.WriteSecurePacket(tunnelConn, &packet, cipher); err != nil {
+		tunnelConn.Close()
+		return nil, fmt.Errorf("worker: failed to write NewStream request: %w", err)
+	}
+	if err := s.waitForSuccess(tunnelConn); err != nil {
+		tunnelConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("worker: did not receive success from worker: %w", err)
+	}
+	return &workerConn{Conn: tunnelConn, cipher: cipher}, nil
+}
+
+func (c *workerConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.pending) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		packet, err := protocol2.ReadUnsecurePacket(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		switch packet.Flag {
+		case protocol2.FlagTCPData:
+			c.pending = packet.Payload
+		case protocol2.FlagControlCloseStream:
+			c.eof = true
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *workerConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxWorkerChunk {
+			chunk = chunk[:maxWorkerChunk]
+		}
+		packet := protocol2.Packet{StreamID: workerStreamID, Flag: protocol2.FlagTCPData, Payload: chunk}
+		if err := protocol2.WriteSecurePacket(c.Conn, &packet, c.cipher); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// CloseWrite 通知 Worker 上行已结束。Worker 收到关闭包后会结束整个流。
+func (c *workerConn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	closePacket := protocol2.Packet{StreamID: workerStreamID, Flag: protocol2.FlagControlCloseStream}
+	return protocol2.WriteSecurePacket(c.Conn, &closePacket, c.cipher)
+}
+
+func (c *workerConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
@@ -3,13 +3,16 @@ package worker
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"io"
 	"liuproxy_go/internal/shared/globalstate"
+	"liuproxy_go/internal/shared/logger"
 	protocol2 "liuproxy_go/internal/shared/protocol"
 	"liuproxy_go/internal/shared/securecrypt"
+	"liuproxy_go/internal/shared/traffic"
 	"net"
 	"net/url"
 	"strconv"
@@ -20,6 +23,9 @@ import (
 	"liuproxy_go/internal/shared/types"
 )
 
+// log 是 worker 隧道的 logger，它的级别可以通过 "logging" 模块单独设置。
+var log = logger.Component(logger.ComponentWorker)
+
 type WorkerStrategy struct {
 	config            *types.Config
 	profile           *types.ServerProfile
@@ -30,6 +36,7 @@ type WorkerStrategy struct {
 	activeConnections atomic.Int64
 	logger            zerolog.Logger
 	activeConns       sync.Map
+	traffic           traffic.Counter // 经 DialContext 转发的流量
 }
 
 // Ensure WorkerStrategy implements TunnelStrategy interface
@@ -113,30 +120,13 @@ func (s *WorkerStrategy) handleClientConnection(plainConn net.Conn) {
 		return
 	}
 
-	tunnelConn, cipher, err := s.createTunnel()
+	tunnelConn, err := s.dialStream(context.Background(), targetAddr)
 	if err != nil {
-		s.logger.Error().Err(err).Msg("[WorkerStrategy] Failed to create tunnel")
+		s.logger.Error().Err(err).Msg("[WorkerStrategy] Failed to open stream")
 		return
 	}
 	defer tunnelConn.Close()
 
-	const streamID uint16 = 1
-	packet := protocol2.Packet{
-		StreamID: streamID,
-		Flag:     protocol2.FlagControlNewStreamTCP,
-		Payload:  buildMetadataForWorker(1, targetAddr),
-	}
-
-	if err := protocol2.WriteSecurePacket(tunnelConn, &packet, cipher); err != nil {
-		s.logger.Error().Err(err).Msg("[WorkerStrategy] Failed to write NewStream request")
-		return
-	}
-
-	if err := s.waitForSuccess(tunnelConn); err != nil {
-		s.logger.Error().Err(err).Msg("[WorkerStrategy] Did not receive success from worker")
-		return
-	}
-
 	_, _ = plainConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
 
 	globalstate.GlobalStatus.Set(fmt.Sprintf("Connected (Worker via %s)", s.profile.Address))
@@ -148,42 +138,36 @@ func (s *WorkerStrategy) handleClientConnection(plainConn net.Conn) {
 	go func() {
 		defer wg.Done()
 		defer tunnelConn.Close()
-		buf := make([]byte, s.config.CommonConf.BufferSize)
-		for {
-			n, err := plainConn.Read(buf)
-			if err != nil {
-				closePacket := protocol2.Packet{StreamID: streamID, Flag: protocol2.FlagControlCloseStream}
-				_ = protocol2.WriteSecurePacket(tunnelConn, &closePacket, cipher)
-				return
-			}
-			packet := protocol2.Packet{StreamID: streamID, Flag: protocol2.FlagTCPData, Payload: buf[:n]}
-			if err := protocol2.WriteSecurePacket(tunnelConn, &packet, cipher); err != nil {
-				return
-			}
-		}
+		io.Copy(tunnelConn, reader)
+		_ = tunnelConn.CloseWrite()
 	}()
 
 	// Downstream (Worker -> Client): Unencrypted
 	go func() {
 		defer wg.Done()
 		defer plainConn.Close()
-		for {
-			packet, err := protocol2.ReadUnsecurePacket(tunnelConn)
-			if err != nil {
-				return
-			}
-			if packet.Flag == protocol2.FlagTCPData {
-				if _, err := plainConn.Write(packet.Payload); err != nil {
-					return
-				}
-			} else if packet.Flag == protocol2.FlagControlCloseStream {
-				return
-			}
-		}
+		io.Copy(plainConn, tunnelConn)
 	}()
 	wg.Wait()
 }
 
+// DialContext 为每个连接建立一条新的 Worker 隧道。Worker 不支持 UDP。
+func (s *WorkerStrategy) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("worker: unsupported network '%s'", network)
+	}
+	conn, err := s.dialStream(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	s.activeConnections.Add(1)
+	conn.onClose = func() { s.activeConnections.Add(-1) }
+	globalstate.GlobalStatus.Set(fmt.Sprintf("Connected (Worker via %s)", s.profile.Address))
+	return traffic.NewConn(conn, &s.traffic), nil
+}
+
 func (s *WorkerStrategy) GetListenerInfo() *types.ListenerInfo {
 	return s.listenerInfo
 }
@@ -191,6 +175,7 @@ func (s *WorkerStrategy) GetListenerInfo() *types.ListenerInfo {
 func (s *WorkerStrategy) GetMetrics() *types.Metrics {
 	return &types.Metrics{
 		ActiveConnections: s.activeConnections.Load(),
+		TrafficStats:      s.traffic.Stats(),
 	}
 }
 
@@ -292,3 +277,6 @@ func buildMetadataForWorker(cmd byte, targetAddr string) []byte {
 	_ = binary.Write(&buf, binary.BigEndian, uint16(port))
 	return buf.Bytes()
 }
+
+// SupportsUDP 返回 false，Worker 策略目前只转发 TCP。
+func (s *WorkerStrategy) SupportsUDP() bool { return false }
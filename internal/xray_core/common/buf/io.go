@@ -9,6 +9,8 @@ import (
 	"time"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 // Reader extends io.Reader with MultiBuffer.
 type Reader interface {
 	// ReadMultiBuffer reads content from underlying reader, and put it into a MultiBuffer.
@@ -52,7 +54,7 @@ func NewReader(reader io.Reader) Reader {
 		if sc, ok := reader.(syscall.Conn); ok {
 			rawConn, err := sc.SyscallConn()
 			if err != nil {
-				logger.Error().Msg("failed to get sysconn")
+				log.Error().Msg("failed to get sysconn")
 			} else {
 				return NewReadVReader(reader, rawConn)
 			}
@@ -8,6 +8,8 @@ import (
 	"strings"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 var (
 
 	// AnyIP is a constant value for any IP in IPv4.
@@ -103,7 +105,7 @@ func IPAddress(ip []byte) Address {
 		}
 		return addr
 	default:
-		logger.Error().Msg(errors.NewError("invalid IP format: ", ip).Error())
+		log.Error().Msg(errors.NewError("invalid IP format: ", ip).Error())
 		return nil
 	}
 }
@@ -2,6 +2,7 @@ package internet
 
 import (
 	"liuproxy_go/internal/xray_core/common/errors"
+	"liuproxy_go/internal/xray_core/common/net"
 	"liuproxy_go/internal/xray_core/common/serial"
 )
 
@@ -13,6 +14,52 @@ var (
 
 const unknownProtocol = "unknown"
 
+const (
+	DomainStrategy_AS_IS      DomainStrategy = 0
+	DomainStrategy_USE_IP     DomainStrategy = 1
+	DomainStrategy_USE_IP4    DomainStrategy = 2
+	DomainStrategy_USE_IP6    DomainStrategy = 3
+	DomainStrategy_USE_IP46   DomainStrategy = 4
+	DomainStrategy_USE_IP64   DomainStrategy = 5
+	DomainStrategy_FORCE_IP   DomainStrategy = 6
+	DomainStrategy_FORCE_IP4  DomainStrategy = 7
+	DomainStrategy_FORCE_IP6  DomainStrategy = 8
+	DomainStrategy_FORCE_IP46 DomainStrategy = 9
+	DomainStrategy_FORCE_IP64 DomainStrategy = 10
+)
+
+// hasStrategy reports whether domain destinations should be resolved before dialing.
+func (s DomainStrategy) hasStrategy() bool {
+	return s != DomainStrategy_AS_IS
+}
+
+// forceIP reports whether a failed lookup fails the dial instead of falling back to the domain.
+func (s DomainStrategy) forceIP() bool {
+	return s >= DomainStrategy_FORCE_IP
+}
+
+// filterIPs orders and filters resolved addresses according to the strategy.
+func (s DomainStrategy) filterIPs(ips []net.IP) []net.IP {
+	var ip4, ip6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ip4 = append(ip4, ip)
+		} else {
+			ip6 = append(ip6, ip)
+		}
+	}
+	switch s {
+	case DomainStrategy_USE_IP4, DomainStrategy_FORCE_IP4:
+		return ip4
+	case DomainStrategy_USE_IP6, DomainStrategy_FORCE_IP6:
+		return ip6
+	case DomainStrategy_USE_IP64, DomainStrategy_FORCE_IP64:
+		return append(ip6, ip4...)
+	default:
+		return append(ip4, ip6...)
+	}
+}
+
 func transportProtocolToString(protocol TransportProtocol) string {
 	switch protocol {
 	case TransportProtocol_TCP:
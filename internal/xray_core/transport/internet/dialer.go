@@ -10,10 +10,20 @@ import (
 // dialFunc is an interface to dial network connection to a specific destination.
 type dialFunc func(ctx context.Context, dest net.Destination, streamSettings *MemoryStreamConfig) (net.Conn, error)
 
+// DomainResolver resolves a domain for DialSystem when the socket config has a DomainStrategy.
+type DomainResolver func(ctx context.Context, domain string) ([]net.IP, error)
+
 var (
 	transportDialerCache = make(map[string]dialFunc)
+	domainResolver       DomainResolver
 )
 
+// RegisterDomainResolver sets the resolver used for DomainStrategy lookups. It must be called before dialing.
+// Without a registered resolver, domain destinations are passed to the system dialer as is.
+func RegisterDomainResolver(resolver DomainResolver) {
+	domainResolver = resolver
+}
+
 // RegisterTransportDialer registers a Dialer with given name.
 func RegisterTransportDialer(protocol string, dialer dialFunc) error {
 	if _, found := transportDialerCache[protocol]; found {
@@ -54,5 +64,36 @@ func DialSystem(ctx context.Context, dest net.Destination, sockopt *SocketConfig
 		src = outbound.Gateway
 	}
 
+	if sockopt != nil && sockopt.DomainStrategy.hasStrategy() && dest.Address.Family().IsDomain() && domainResolver != nil {
+		domain := dest.Address.Domain()
+		ips, err := domainResolver(ctx, domain)
+		if err == nil {
+			ips = sockopt.DomainStrategy.filterIPs(ips)
+		}
+		if len(ips) > 0 {
+			return dialIPs(ctx, src, dest, ips, sockopt)
+		}
+		if sockopt.DomainStrategy.forceIP() {
+			return nil, errors.NewError("failed to resolve ", domain).Base(err)
+		}
+	}
+
 	return effectiveSystemDialer.Dial(ctx, src, dest, sockopt)
 }
+
+// dialIPs tries the resolved addresses in order until one connects, as the system dialer does for a domain.
+func dialIPs(ctx context.Context, src net.Address, dest net.Destination, ips []net.IP, sockopt *SocketConfig) (net.Conn, error) {
+	var lastErr error
+	for _, ip := range ips {
+		dest.Address = net.IPAddress(ip)
+		conn, err := effectiveSystemDialer.Dial(ctx, src, dest, sockopt)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
@@ -20,8 +20,10 @@ import (
 	"liuproxy_go/internal/xray_core/transport/internet/tls"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 func Dial(ctx context.Context, dest net.Destination, streamSettings *internet.MemoryStreamConfig) (net.Conn, error) {
-	logger.Debug().Msgf("creating connection to %s", dest)
+	log.Debug().Msgf("creating connection to %s", dest)
 
 	conn, err := dialgRPC(ctx, dest, streamSettings)
 	if err != nil {
@@ -143,7 +145,7 @@ func dialgRPC(ctx context.Context, dest net.Destination, streamSettings *interne
 
 	client := encoding.NewGRPCServiceClient(conn)
 	if grpcSettings.MultiMode {
-		logger.Debug().Msg("using gRPC multi mode service name: `" + grpcSettings.getServiceName() + "` stream name: `" + grpcSettings.getTunMultiStreamName() + "`")
+		log.Debug().Msg("using gRPC multi mode service name: `" + grpcSettings.getServiceName() + "` stream name: `" + grpcSettings.getTunMultiStreamName() + "`")
 		grpcService, err := client.(encoding.GRPCServiceClientX).TunMultiCustomName(ctx, grpcSettings.getServiceName(), grpcSettings.getTunMultiStreamName())
 		if err != nil {
 			return nil, errors.NewError("Cannot dial gRPC").Base(err)
@@ -151,7 +153,7 @@ func dialgRPC(ctx context.Context, dest net.Destination, streamSettings *interne
 		return encoding.NewMultiHunkConn(grpcService, nil), nil
 	}
 
-	logger.Debug().Msg("using gRPC tun mode service name: `" + grpcSettings.getServiceName() + "` stream name: `" + grpcSettings.getTunStreamName() + "`")
+	log.Debug().Msg("using gRPC tun mode service name: `" + grpcSettings.getServiceName() + "` stream name: `" + grpcSettings.getTunStreamName() + "`")
 	grpcService, err := client.(encoding.GRPCServiceClientX).TunCustomName(ctx, grpcSettings.getServiceName(), grpcSettings.getTunStreamName())
 	if err != nil {
 		return nil, errors.NewError("Cannot dial gRPC").Base(err)
@@ -2,7 +2,6 @@ package reality
 
 import (
 	"io"
-	"liuproxy_go/internal/shared/logger"
 	"net"
 	"os"
 	"time"
@@ -49,7 +48,7 @@ func KeyLogWriterFromConfig(c *Config) io.Writer {
 
 	writer, err := os.OpenFile(c.MasterKeyLog, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
-		logger.Error().Msg("failed to open " + c.MasterKeyLog + " as master key log")
+		log.Error().Msg("failed to open " + c.MasterKeyLog + " as master key log")
 	}
 
 	return writer
@@ -35,6 +35,8 @@ import (
 	"liuproxy_go/internal/xray_core/transport/internet/tls"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 //go:linkname aesgcmPreferred github.com/refraction-networking/utls.aesgcmPreferred
 func aesgcmPreferred(ciphers []uint16) bool
 
@@ -126,7 +128,7 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 		binary.BigEndian.PutUint32(hello.SessionId[4:], uint32(time.Now().Unix()))
 		copy(hello.SessionId[8:], config.ShortId)
 		if config.Show {
-			logger.Error().Msgf("REALITY localAddr: %v\thello.SessionId[:16]: %v\n", localAddr, hello.SessionId[:16])
+			log.Error().Msgf("REALITY localAddr: %v\thello.SessionId[:16]: %v\n", localAddr, hello.SessionId[:16])
 		}
 		publicKey, err := ecdh.X25519().NewPublicKey(config.PublicKey)
 		if err != nil {
@@ -147,7 +149,7 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 			aead, _ = chacha20poly1305.New(uConn.AuthKey)
 		}
 		if config.Show {
-			logger.Error().Msgf("REALITY localAddr: %v\tuConn.AuthKey[:16]: %v\tAEAD: %T\n", localAddr, uConn.AuthKey[:16], aead)
+			log.Error().Msgf("REALITY localAddr: %v\tuConn.AuthKey[:16]: %v\tAEAD: %T\n", localAddr, uConn.AuthKey[:16], aead)
 		}
 		aead.Seal(hello.SessionId[:0], hello.Random[20:], hello.SessionId[:16], hello.Raw)
 		copy(hello.Raw[39:], hello.SessionId)
@@ -156,14 +158,14 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 		return nil, err
 	}
 	if config.Show {
-		logger.Error().Msgf("REALITY localAddr: %v\tuConn.Verified: %v\n", localAddr, uConn.Verified)
+		log.Error().Msgf("REALITY localAddr: %v\tuConn.Verified: %v\n", localAddr, uConn.Verified)
 	}
 	if !uConn.Verified {
 		go func() {
 			client := &http.Client{
 				Transport: &http2.Transport{
 					DialTLSContext: func(ctx context.Context, network, addr string, cfg *gotls.Config) (net.Conn, error) {
-						logger.Error().Msgf("REALITY localAddr: %v\tDialTLSContext\n", localAddr)
+						log.Error().Msgf("REALITY localAddr: %v\tDialTLSContext\n", localAddr)
 						return uConn, nil
 					},
 				},
@@ -197,7 +199,7 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 				}
 				req.Header.Set("User-Agent", fingerprint.Client) // TODO: User-Agent map
 				if first && config.Show {
-					logger.Error().Msgf("REALITY localAddr: %v\treq.UserAgent(): %v\n", localAddr, req.UserAgent())
+					log.Error().Msgf("REALITY localAddr: %v\treq.UserAgent(): %v\n", localAddr, req.UserAgent())
 				}
 				times := 1
 				if !first {
@@ -224,9 +226,9 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 					}
 					req.URL.Path = getPathLocked(paths)
 					if config.Show {
-						logger.Error().Msgf("REALITY localAddr: %v\treq.Referer(): %v\n", localAddr, req.Referer())
-						logger.Error().Msgf("REALITY localAddr: %v\tlen(body): %v\n", localAddr, len(body))
-						logger.Error().Msgf("REALITY localAddr: %v\tlen(paths): %v\n", localAddr, len(paths))
+						log.Error().Msgf("REALITY localAddr: %v\treq.Referer(): %v\n", localAddr, req.Referer())
+						log.Error().Msgf("REALITY localAddr: %v\tlen(body): %v\n", localAddr, len(body))
+						log.Error().Msgf("REALITY localAddr: %v\tlen(paths): %v\n", localAddr, len(paths))
 					}
 					maps.Unlock()
 					if !first {
@@ -10,6 +10,8 @@ import (
 	"liuproxy_go/internal/xray_core/common/net"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 var effectiveSystemDialer SystemDialer = &DefaultSystemDialer{}
 
 type SystemDialer interface {
@@ -44,7 +46,7 @@ func hasBindAddr(sockopt *SocketConfig) bool {
 }
 
 func (d *DefaultSystemDialer) Dial(ctx context.Context, src net.Address, dest net.Destination, sockopt *SocketConfig) (net.Conn, error) {
-	logger.Debug().Msg("dialing to " + dest.String())
+	log.Debug().Msg("dialing to " + dest.String())
 
 	goStdKeepAlive := time.Duration(0)
 	if sockopt != nil && (sockopt.TcpKeepAliveInterval != 0 || sockopt.TcpKeepAliveIdle != 0) {
@@ -63,17 +65,17 @@ func (d *DefaultSystemDialer) Dial(ctx context.Context, src net.Address, dest ne
 		dialer.Control = func(network, address string, c syscall.RawConn) error {
 			for _, ctl := range d.controllers {
 				if err := ctl(network, address, c); err != nil {
-					logger.Error().Msg("failed to apply external controller")
+					log.Error().Msg("failed to apply external controller")
 				}
 			}
 			return c.Control(func(fd uintptr) {
 				if sockopt != nil {
 					if err := applyOutboundSocketOptions(network, address, fd, sockopt); err != nil {
-						logger.Error().Msg("failed to apply socket options")
+						log.Error().Msg("failed to apply socket options")
 					}
 					if dest.Network == net.Network_UDP && hasBindAddr(sockopt) {
 						if err := bindAddr(fd, sockopt.BindAddress, sockopt.BindPort); err != nil {
-							logger.Error().Msgf("failed to bind source address to %d", sockopt.BindAddress)
+							log.Error().Msgf("failed to bind source address to %d", sockopt.BindAddress)
 						}
 					}
 				}
@@ -11,6 +11,8 @@ import (
 	"strings"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 var globalSessionCache = tls.NewLRUClientSessionCache(128)
 
 // ParseCertificate converts a cert.Certificate to Certificate.
@@ -33,7 +35,7 @@ func (c *Config) parseServerName() string {
 func (c *Config) GetTLSConfig(opts ...Option) *tls.Config {
 	root, err := c.getCertPool()
 	if err != nil {
-		logger.Error().Msg("failed to load system root certificate")
+		log.Error().Msg("failed to load system root certificate")
 	}
 
 	if c == nil {
@@ -106,7 +108,7 @@ func (c *Config) GetTLSConfig(opts ...Option) *tls.Config {
 	if len(c.MasterKeyLog) > 0 && c.MasterKeyLog != "none" {
 		writer, err := os.OpenFile(c.MasterKeyLog, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 		if err != nil {
-			logger.Error().Msg("failed to open " + c.MasterKeyLog + " as master key log")
+			log.Error().Msg("failed to open " + c.MasterKeyLog + " as master key log")
 		} else {
 			config.KeyLogWriter = writer
 		}
@@ -15,9 +15,11 @@ import (
 	"liuproxy_go/internal/xray_core/transport/internet/tls"
 )
 
+var log = logger.Component(logger.ComponentXrayCore)
+
 // Dial dials a WebSocket connection to the given destination.
 func Dial(ctx context.Context, dest net.Destination, streamSettings *internet.MemoryStreamConfig) (net.Conn, error) {
-	logger.Debug().Msgf("creating connection to %d", dest)
+	log.Debug().Msgf("creating connection to %d", dest)
 
 	conn, err := dialWebSocket(ctx, dest, streamSettings)
 	if err != nil {